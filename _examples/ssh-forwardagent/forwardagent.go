@@ -12,13 +12,12 @@ func main() {
 	ssh.Handle(func(s ssh.Session) {
 		cmd := exec.Command("ssh-add", "-l")
 		if ssh.AgentRequested(s) {
-			l, err := ssh.NewAgentListener()
+			sock, err := ssh.ForwardAgent(s)
 			if err != nil {
-				log.Fatal(err)
+				log.Println(err)
+				return
 			}
-			defer l.Close()
-			go ssh.ForwardAgentConnections(l, s)
-			cmd.Env = append(s.Environ(), fmt.Sprintf("%s=%s", "SSH_AUTH_SOCK", l.Addr().String()))
+			cmd.Env = append(s.Environ(), fmt.Sprintf("%s=%s", "SSH_AUTH_SOCK", sock))
 		} else {
 			cmd.Env = s.Environ()
 		}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerProvider adapts an OpenTelemetry tracer to ssh.TracerProvider.
+type tracerProvider struct {
+	tracer trace.Tracer
+}
+
+func (p tracerProvider) StartSpan(ctx context.Context, name string) (context.Context, ssh.Span) {
+	ctx, span := p.tracer.Start(ctx, name)
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value any) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	}
+}
+
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
+
+func main() {
+	exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+	if err != nil {
+		log.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	defer provider.Shutdown(context.Background())
+
+	server := &ssh.Server{
+		Addr: ":2222",
+		Handler: func(s ssh.Session) {
+			io.WriteString(s, "Hello world\n")
+		},
+		TracerProvider: tracerProvider{provider.Tracer("github.com/gliderlabs/ssh")},
+	}
+	log.Println("starting ssh server on port 2222...")
+	log.Fatal(server.ListenAndServe())
+}
@@ -4,20 +4,12 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"os/exec"
-	"syscall"
-	"unsafe"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/kr/pty"
 )
 
-func setWinsize(f *os.File, w, h int) {
-	syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCSWINSZ),
-		uintptr(unsafe.Pointer(&struct{ h, w, x, y uint16 }{uint16(h), uint16(w), 0, 0})))
-}
-
 func main() {
 	ssh.Handle(func(s ssh.Session) {
 		cmd := exec.Command("top")
@@ -28,11 +20,7 @@ func main() {
 			if err != nil {
 				panic(err)
 			}
-			go func() {
-				for win := range winCh {
-					setWinsize(f, win.Width, win.Height)
-				}
-			}()
+			go ssh.ForwardWindowChanges(winCh, f)
 			go func() {
 				io.Copy(f, s) // stdin
 			}()
@@ -0,0 +1,76 @@
+package ssh
+
+import "net"
+
+// OffenseKind classifies the offenses passed to an AbuseReporter.
+type OffenseKind int
+
+const (
+	// OffenseAuthFailure is a connection closed without authenticating
+	// after rejected authentication attempts, reported once however many
+	// attempts it made.
+	OffenseAuthFailure OffenseKind = iota + 1
+	// OffensePolicy is a connection closed by policy, such as a client
+	// denied by HASSHCallback or RequireStrictKex, or closed with CloseConn.
+	OffensePolicy
+)
+
+var offenseKindNames = [...]string{
+	OffenseAuthFailure: "auth-failure",
+	OffensePolicy:      "policy",
+}
+
+func (k OffenseKind) String() string {
+	if k <= 0 || int(k) >= len(offenseKindNames) {
+		return "unknown"
+	}
+	return offenseKindNames[k]
+}
+
+// Offense describes misbehavior by a client.
+type Offense struct {
+	Kind   OffenseKind
+	Addr   net.Addr // effective remote address of the client
+	User   string   // user name sent by the client, if any
+	Method string   // last rejected auth method of an OffenseAuthFailure
+	Err    error    // error the connection was closed with, for an OffensePolicy
+}
+
+// AbuseReporter connects a server to a community blocklist, such as CrowdSec
+// or AbuseIPDB, by reporting the offenses of clients and refusing clients
+// the blocklist decided to block. Both methods are called while handling
+// connections, so implementations should queue reports and answer Blocked
+// from a local copy of the decisions rather than calling the blocklist
+// inline.
+type AbuseReporter interface {
+	// Report records an offense by the client of ctx.
+	Report(ctx Context, offense Offense)
+	// Blocked reports whether connections from addr are refused before
+	// the SSH handshake.
+	Blocked(addr net.Addr) bool
+}
+
+// contextKeyAuthFailure holds the method of the last rejected auth attempt,
+// reported as an OffenseAuthFailure if the connection doesn't authenticate.
+var contextKeyAuthFailure = &contextKey{"auth-failure"}
+
+// reportAuthFailure reports an OffenseAuthFailure for a connection whose
+// handshake failed after rejected auth attempts.
+func (srv *Server) reportAuthFailure(ctx Context) {
+	if method, ok := ctx.Value(contextKeyAuthFailure).(string); ok {
+		srv.reportOffense(ctx, Offense{Kind: OffenseAuthFailure, Method: method})
+	}
+}
+
+// reportOffense passes an offense by the client of ctx to the server's
+// AbuseReporter, if any.
+func (srv *Server) reportOffense(ctx Context, offense Offense) {
+	if srv.AbuseReporter == nil {
+		return
+	}
+	offense.Addr = ctx.RemoteAddr()
+	if user, ok := ctx.Value(ContextKeyUser).(string); ok {
+		offense.User = user
+	}
+	srv.AbuseReporter.Report(ctx, offense)
+}
@@ -0,0 +1,220 @@
+package ssh
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type testReporter struct {
+	mu       sync.Mutex
+	offenses []Offense
+	blocked  bool
+}
+
+func (r *testReporter) Report(ctx Context, offense Offense) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offenses = append(r.offenses, offense)
+	r.blocked = true
+}
+
+func (r *testReporter) Blocked(addr net.Addr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.blocked
+}
+
+func TestAbuseReporter(t *testing.T) {
+	t.Parallel()
+	reporter := &testReporter{}
+	srv := &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return password == "secret"
+		},
+		AbuseReporter: reporter,
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	dial := func() error {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "mallory",
+			Auth:            []gossh.AuthMethod{gossh.RetryableAuthMethod(gossh.Password("guess"), 3)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	if err := dial(); err == nil {
+		t.Fatal("Dial with a wrong password succeeded")
+	}
+	// the offense is reported once the server saw the connection end,
+	// once for all its attempts
+	var offenses []Offense
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		reporter.mu.Lock()
+		offenses = reporter.offenses
+		reporter.mu.Unlock()
+		if len(offenses) > 0 {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	reporter.mu.Lock()
+	offenses = reporter.offenses
+	reporter.mu.Unlock()
+	if len(offenses) != 1 {
+		t.Fatalf("offenses = %#v; want one", offenses)
+	}
+	if o := offenses[0]; o.Kind != OffenseAuthFailure || o.User != "mallory" || o.Method != "password" || o.Addr == nil {
+		t.Fatalf("offense = %#v; want password failure of mallory", o)
+	}
+
+	// the reporter now blocks the client, before the handshake
+	if err := dial(); err == nil {
+		t.Fatal("Dial succeeded for a blocked client")
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	if len(reporter.offenses) != 1 {
+		t.Fatalf("offenses = %#v after block; want no new ones", reporter.offenses)
+	}
+}
+
+func TestFailBan(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1700000000, 0)
+	var mu sync.Mutex
+	ban := &FailBan{MaxFailures: 2, Window: time.Minute, BanTime: time.Hour, Clock: func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}}
+	bans := make(chan AuditEvent, 1)
+	srv := &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return password == "secret"
+		},
+		AbuseReporter: ban,
+		AuditCallback: func(ctx Context, ev AuditEvent) {
+			if ev.Type == AuditBan {
+				bans <- ev
+			}
+		},
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	dial := func(password string) error {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "mallory",
+			Auth:            []gossh.AuthMethod{gossh.Password(password)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	dial("guess")
+	if err := dial("secret"); err != nil {
+		t.Fatalf("Dial after one failure = %v; want success", err)
+	}
+	dial("guess")
+	if ev := <-bans; ev.Reason != "2 failed connections within 1m0s, banned for 1h0m0s" {
+		t.Fatalf("ban reason = %#v", ev.Reason)
+	}
+	if err := dial("secret"); err == nil {
+		t.Fatal("Dial of a banned client succeeded")
+	}
+
+	mu.Lock()
+	now = now.Add(time.Hour)
+	mu.Unlock()
+	if err := dial("secret"); err != nil {
+		t.Fatalf("Dial after the ban = %v; want success", err)
+	}
+}
+
+func TestMemoryBanStore(t *testing.T) {
+	t.Parallel()
+	s := &MemoryBanStore{}
+	now := time.Unix(1700000000, 0)
+	s.Fail("192.0.2.1", now, time.Minute)
+	if n := s.Fail("192.0.2.1", now.Add(30*time.Second), time.Minute); n != 2 {
+		t.Fatalf("Fail = %d; want 2", n)
+	}
+	if n := s.Fail("192.0.2.1", now.Add(70*time.Second), time.Minute); n != 2 {
+		t.Fatalf("Fail = %d after the first failure expired; want 2", n)
+	}
+	s.Fail("192.0.2.2", now, time.Minute)
+	s.Fail("192.0.2.3", now.Add(5*time.Minute), time.Minute)
+	if _, ok := s.ips["192.0.2.2"]; ok {
+		t.Fatal("expired failures were not swept")
+	}
+}
+
+// delayReporter holds up the connections it is asked about.
+type delayReporter struct {
+	mu      sync.Mutex
+	waiting int
+	max     int
+}
+
+func (r *delayReporter) Report(ctx Context, offense Offense) {}
+
+func (r *delayReporter) Blocked(addr net.Addr) bool {
+	r.mu.Lock()
+	r.waiting++
+	if r.waiting > r.max {
+		r.max = r.waiting
+	}
+	r.mu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	r.mu.Lock()
+	r.waiting--
+	r.mu.Unlock()
+	return false
+}
+
+func TestAbuseReporterConnLimit(t *testing.T) {
+	t.Parallel()
+	reporter := &delayReporter{}
+	srv := &Server{
+		Handler:       func(s Session) {},
+		AbuseReporter: reporter,
+		MaxConns:      1,
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	// held up connections count against MaxConns
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+				time.Sleep(300 * time.Millisecond)
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	if reporter.max != 1 {
+		t.Fatalf("connections held up at once = %d; want 1", reporter.max)
+	}
+}
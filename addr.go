@@ -0,0 +1,82 @@
+package ssh
+
+import (
+	"net"
+)
+
+// ForwardedConn is implemented by connections relayed by a proxy that
+// reported the addresses of the original connection, such as after reading a
+// PROXY protocol header or accepting a WebSocket with X-Forwarded-For. The
+// RemoteAddr and LocalAddr of the connection are those of the transport from
+// the proxy, while ForwardedAddrs returns the addresses the proxy claims,
+// either of which may be nil if unknown.
+//
+// Claimed addresses are only used if the server's TrustForwardedCallback
+// trusts the proxy, see Context.RemoteAddr.
+type ForwardedConn interface {
+	net.Conn
+	ForwardedAddrs() (remote, local net.Addr)
+}
+
+// NewForwardedConn returns conn as a ForwardedConn claiming to be forwarded
+// from the client at remote to the server address local. It's meant to be
+// used from a ConnCallback or listener wrapper parsing the proxy's protocol.
+func NewForwardedConn(conn net.Conn, remote, local net.Addr) ForwardedConn {
+	return &forwardedConn{conn, remote, local}
+}
+
+type forwardedConn struct {
+	net.Conn
+	remote, local net.Addr
+}
+
+func (c *forwardedConn) ForwardedAddrs() (remote, local net.Addr) {
+	return c.remote, c.local
+}
+
+// TrustedProxies returns a TrustForwardedCallback trusting the addresses
+// claimed by proxies within any of the given CIDR networks, such as
+// "10.0.0.0/8".
+func TrustedProxies(cidrs ...string) (TrustForwardedCallback, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return func(ctx Context, proxy net.Addr) bool {
+		host, _, err := net.SplitHostPort(proxy.String())
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// applyConnAddrs records the transport and effective addresses of conn in
+// ctx, where the effective addresses are the forwarded ones if conn is a
+// ForwardedConn from a trusted proxy.
+func (srv *Server) applyConnAddrs(ctx Context, conn net.Conn) {
+	remote, local := conn.RemoteAddr(), conn.LocalAddr()
+	ctx.SetValue(ContextKeyTransportRemoteAddr, remote)
+	ctx.SetValue(ContextKeyTransportLocalAddr, local)
+	if fc, ok := conn.(ForwardedConn); ok && srv.TrustForwardedCallback != nil && srv.TrustForwardedCallback(ctx, remote) {
+		fwdRemote, fwdLocal := fc.ForwardedAddrs()
+		if fwdRemote != nil {
+			remote = fwdRemote
+		}
+		if fwdLocal != nil {
+			local = fwdLocal
+		}
+	}
+	ctx.SetValue(ContextKeyRemoteAddr, remote)
+	ctx.SetValue(ContextKeyLocalAddr, local)
+}
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+)
+
+func TestForwardedAddrs(t *testing.T) {
+	t.Parallel()
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 50000}
+	trustLoopback, err := TrustedProxies("127.0.0.0/8", "::1/128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, _ := TrustedProxies("192.0.2.0/24")
+
+	for _, tc := range []struct {
+		name    string
+		trust   TrustForwardedCallback
+		trusted bool
+	}{
+		{"trusted", trustLoopback, true},
+		{"untrusted", untrusted, false},
+		{"nil", nil, false},
+	} {
+		addrs := make(chan [2]net.Addr, 1)
+		session, _, cleanup := newTestSession(t, &Server{
+			Handler: func(s Session) {
+				addrs <- [2]net.Addr{s.RemoteAddr(), s.TransportRemoteAddr()}
+			},
+			ConnCallback: func(ctx Context, conn net.Conn) net.Conn {
+				return NewForwardedConn(conn, client, nil)
+			},
+			TrustForwardedCallback: tc.trust,
+		}, nil)
+		if err := session.Run(""); err != nil {
+			t.Fatal(err)
+		}
+		cleanup()
+		got := <-addrs
+		if got[1].String() == client.String() {
+			t.Fatalf("%s: transport address = %s; want the proxy", tc.name, got[1])
+		}
+		want := got[1]
+		if tc.trusted {
+			want = client
+		}
+		if got[0].String() != want.String() {
+			t.Fatalf("%s: RemoteAddr = %s; want %s", tc.name, got[0], want)
+		}
+	}
+}
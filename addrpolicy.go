@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// ErrAddressDenied is returned for forwarded connections to addresses
+// denied by the server's ForwardAddressPolicy.
+var ErrAddressDenied = errors.New("ssh: destination address denied by policy")
+
+// metadataPrefixes are the cloud instance metadata services, which hand out
+// credentials to whoever can reach them.
+var metadataPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("169.254.169.254/32"), // AWS, GCP, Azure and others
+	netip.MustParsePrefix("fd00:ec2::254/128"),  // AWS over IPv6
+	netip.MustParsePrefix("100.100.100.200/32"), // Alibaba Cloud
+}
+
+var (
+	ipv4Compatible = netip.MustParsePrefix("::/96")
+	nat64Prefix    = netip.MustParsePrefix("64:ff9b::/96")
+)
+
+// AddressPolicy restricts the addresses the server connects to for local
+// forwarding, such as direct-tcpip channels, after names are resolved, so
+// clients can't reach the server itself or its cloud metadata service by
+// tunneling through it. It hardens forwarding on top of the
+// LocalPortForwardingCallback, which sees destinations before resolution.
+//
+// Loopback, unspecified, link-local and metadata addresses are denied unless
+// allowed. IPv4-mapped IPv6 addresses are checked as the IPv4 address they
+// map to, and so are NAT64 addresses in 64:ff9b::/96, while the deprecated
+// IPv4-compatible addresses in ::/96 are denied.
+type AddressPolicy struct {
+	AllowLoopback  bool // 127.0.0.0/8, ::1 and the unspecified addresses, which reach the server itself
+	AllowLinkLocal bool // 169.254.0.0/16 and fe80::/10, except metadata services
+	AllowMetadata  bool // cloud metadata services such as 169.254.169.254, even if link-local
+	DenyIPv4       bool // deny all IPv4 destinations
+	DenyIPv6       bool // deny all IPv6 destinations
+
+	// Allow and Deny are CIDR ranges, such as "10.0.0.0/8", allowed or
+	// denied regardless of the other settings. Allow takes precedence.
+	Allow []string
+	Deny  []string
+}
+
+// Check returns an error wrapping ErrAddressDenied if the policy denies ip.
+// A nil policy denies the addresses denied by an empty one.
+func (p *AddressPolicy) Check(ip net.IP) error {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return fmt.Errorf("%w: invalid address %v", ErrAddressDenied, ip)
+	}
+	if p == nil {
+		p = &AddressPolicy{}
+	}
+	if reason := p.deny(addr.Unmap()); reason != "" {
+		return fmt.Errorf("%w: %s address %v", ErrAddressDenied, reason, ip)
+	}
+	return nil
+}
+
+// deny returns why addr is denied, or "" if it is allowed.
+func (p *AddressPolicy) deny(addr netip.Addr) string {
+	if inPrefixes(p.Allow, addr) {
+		return ""
+	}
+	if inPrefixes(p.Deny, addr) {
+		return "denied"
+	}
+	if addr.Is6() && nat64Prefix.Contains(addr) {
+		b := addr.As16()
+		return p.deny(netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}))
+	}
+	switch {
+	case addr.Is4() && p.DenyIPv4:
+		return "IPv4"
+	case addr.Is6() && p.DenyIPv6:
+		return "IPv6"
+	case (addr.IsLoopback() || addr.IsUnspecified()) && !p.AllowLoopback:
+		return "loopback"
+	case addr.Is6() && ipv4Compatible.Contains(addr):
+		return "IPv4-compatible"
+	case containsAddr(metadataPrefixes, addr):
+		if !p.AllowMetadata {
+			return "metadata"
+		}
+		return ""
+	case (addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast()) && !p.AllowLinkLocal:
+		return "link-local"
+	}
+	return ""
+}
+
+func inPrefixes(cidrs []string, addr netip.Addr) bool {
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// control is the net.Dialer Control function checking the address of
+// connections against the policy.
+func (p *AddressPolicy) control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	return p.Check(net.ParseIP(host))
+}
+
+// forwardDialer returns the dialer of forwarded connections, which checks
+// the address connected to against the ForwardAddressPolicy.
+func (srv *Server) forwardDialer() *net.Dialer {
+	return &net.Dialer{Control: srv.ForwardAddressPolicy.control}
+}
@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestAddressPolicy(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		policy  *AddressPolicy
+		ip      string
+		allowed bool
+	}{
+		{nil, "10.1.2.3", true},
+		{nil, "2001:db8::1", true},
+		{nil, "127.0.0.1", false},
+		{nil, "::1", false},
+		{nil, "0.0.0.0", false},
+		{nil, "::ffff:127.0.0.1", false},
+		{nil, "::127.0.0.1", false},
+		{nil, "64:ff9b::a9fe:a9fe", false},
+		{nil, "64:ff9b::a01:203", true},
+		{nil, "169.254.169.254", false},
+		{nil, "169.254.1.1", false},
+		{nil, "fe80::1", false},
+		{nil, "fd00:ec2::254", false},
+		{&AddressPolicy{AllowLoopback: true}, "127.0.0.1", true},
+		{&AddressPolicy{AllowLinkLocal: true}, "169.254.1.1", true},
+		{&AddressPolicy{AllowLinkLocal: true}, "169.254.169.254", false},
+		{&AddressPolicy{AllowMetadata: true}, "169.254.169.254", true},
+		{&AddressPolicy{DenyIPv6: true}, "2001:db8::1", false},
+		{&AddressPolicy{DenyIPv6: true}, "::ffff:10.1.2.3", true},
+		{&AddressPolicy{DenyIPv4: true}, "10.1.2.3", false},
+		{&AddressPolicy{Deny: []string{"10.0.0.0/8"}}, "10.1.2.3", false},
+		{&AddressPolicy{Allow: []string{"127.0.0.53/32"}}, "127.0.0.53", true},
+	} {
+		err := tt.policy.Check(net.ParseIP(tt.ip))
+		if (err == nil) != tt.allowed || err != nil && !errors.Is(err, ErrAddressDenied) {
+			t.Errorf("%+v.Check(%s) = %v; want allowed %v", tt.policy, tt.ip, err, tt.allowed)
+		}
+	}
+}
+
+func TestForwardAddressPolicy(t *testing.T) {
+	t.Parallel()
+	l := sampleSocketServer()
+	defer l.Close()
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		LocalPortForwardingCallback: func(ctx Context, destinationHost string, destinationPort uint32) bool {
+			return true
+		},
+	}, nil)
+	defer cleanup()
+	_, err := client.Dial("tcp", l.Addr().String())
+	var openErr *gossh.OpenChannelError
+	if !errors.As(err, &openErr) || openErr.Reason != gossh.Prohibited {
+		t.Fatalf("Dial to loopback err = %v; want prohibited", err)
+	}
+}
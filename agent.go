@@ -1,9 +1,11 @@
 package ssh
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
 	"path"
 	"sync"
 
@@ -34,7 +36,8 @@ func AgentRequested(sess Session) bool {
 }
 
 // NewAgentListener sets up a temporary Unix socket that can be communicated
-// to the session environment and used for forwarding connections.
+// to the session environment and used for forwarding connections. Closing the
+// listener removes the socket and its temporary directory.
 func NewAgentListener() (net.Listener, error) {
 	dir, err := ioutil.TempDir("", agentTempDir)
 	if err != nil {
@@ -42,22 +45,64 @@ func NewAgentListener() (net.Listener, error) {
 	}
 	l, err := net.Listen("unix", path.Join(dir, agentListenFile))
 	if err != nil {
+		os.RemoveAll(dir)
 		return nil, err
 	}
-	return l, nil
+	return &agentListener{Listener: l, dir: dir}, nil
+}
+
+// agentListener removes its temporary directory when closed.
+type agentListener struct {
+	net.Listener
+	dir string
+}
+
+func (l *agentListener) Close() error {
+	err := l.Listener.Close()
+	os.RemoveAll(l.dir)
+	return err
+}
+
+// ForwardAgent forwards the agent of the client to a new agent listener until
+// the session is closed, returning the socket path to set as
+// SSH_AUTH_SOCK for child processes. It fails if the client didn't request
+// agent forwarding.
+func ForwardAgent(s Session) (string, error) {
+	if !AgentRequested(s) {
+		return "", errors.New("ssh: agent forwarding not requested")
+	}
+	l, err := NewAgentListener()
+	if err != nil {
+		return "", err
+	}
+	spawn(s.Context(), func() { ForwardAgentConnections(l, s) })
+	var closed <-chan struct{}
+	if sess, ok := s.(*session); ok {
+		closed = sess.closed
+	}
+	spawn(s.Context(), func() {
+		select {
+		case <-closed:
+		case <-s.Context().Done():
+		}
+		l.Close()
+	})
+	return l.Addr().String(), nil
 }
 
 // ForwardAgentConnections takes connections from a listener to proxy into the
 // session on the OpenSSH channel for agent connections. It blocks and services
-// connections until the listener stop accepting.
+// connections until the listener stop accepting. The goroutines proxying
+// the connections are tracked by the SSH connection, and end with it.
 func ForwardAgentConnections(l net.Listener, s Session) {
-	sshConn := s.Context().Value(ContextKeyConn).(gossh.Conn)
+	ctx := s.Context()
+	sshConn := ctx.Value(ContextKeyConn).(gossh.Conn)
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			return
 		}
-		go func(conn net.Conn) {
+		spawn(ctx, func() {
 			defer conn.Close()
 			channel, reqs, err := sshConn.OpenChannel(agentChannelType, nil)
 			if err != nil {
@@ -65,19 +110,32 @@ func ForwardAgentConnections(l net.Listener, s Session) {
 			}
 			defer channel.Close()
 			go gossh.DiscardRequests(reqs)
+			done := make(chan struct{})
+			defer close(done)
+			spawn(ctx, func() {
+				// unblock the copy from a local client keeping its
+				// connection open after the SSH connection ended
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			})
 			var wg sync.WaitGroup
 			wg.Add(2)
-			go func() {
+			spawn(ctx, func() {
 				io.Copy(conn, channel)
-				conn.(*net.UnixConn).CloseWrite()
+				if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+				}
 				wg.Done()
-			}()
-			go func() {
+			})
+			spawn(ctx, func() {
 				io.Copy(channel, conn)
 				channel.CloseWrite()
 				wg.Done()
-			}()
+			})
 			wg.Wait()
-		}(conn)
+		})
 	}
 }
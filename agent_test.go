@@ -0,0 +1,91 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestForwardAgent(t *testing.T) {
+	t.Parallel()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	sockets := make(chan string, 1)
+	session, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			sock, err := ForwardAgent(s)
+			if err != nil {
+				fmt.Fprintln(s, err)
+				return
+			}
+			sockets <- sock
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				fmt.Fprintln(s, err)
+				return
+			}
+			defer conn.Close()
+			keys, err := agent.NewClient(conn).List()
+			fmt.Fprintln(s, len(keys), err)
+		},
+	}, nil)
+	defer cleanup()
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "1 <nil>\n" {
+		t.Fatalf("output = %#v; want %#v", string(out), "1 <nil>\n")
+	}
+	sock := <-sockets
+	session.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filepath.Dir(sock)); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("agent socket %s left after the session", sock)
+}
+
+func TestAgentForwardingCallback(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			fmt.Fprint(s, AgentRequested(s))
+		},
+		AgentForwardingCallback: func(ctx Context) bool {
+			return false
+		},
+	}, nil)
+	defer cleanup()
+	if err := agent.RequestAgentForwarding(session); err == nil {
+		t.Fatal("agent forwarding request succeeded; want denied")
+	}
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "false" {
+		t.Fatalf("AgentRequested = %s; want false", out)
+	}
+}
@@ -0,0 +1,165 @@
+package ssh
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// AuditSchemaVersion is the version of the AuditEvent schema. It changes
+// only when fields are renamed, removed or change meaning, not when fields
+// are added.
+const AuditSchemaVersion = 1
+
+// AuditEventType names a security-relevant action.
+type AuditEventType string
+
+const (
+	AuditConnOpen       AuditEventType = "conn.open"          // handshake completed
+	AuditConnClose      AuditEventType = "conn.close"         // connection ended, with Reason
+	AuditAuth           AuditEventType = "auth"               // authentication attempt, with Method
+	AuditSessionStart   AuditEventType = "session.start"      // shell, exec or subsystem request
+	AuditSessionEnd     AuditEventType = "session.end"        // session ended, with its exit
+	AuditLocalForward   AuditEventType = "forward.local"      // direct-tcpip or direct-streamlocal channel
+	AuditReverseForward AuditEventType = "forward.reverse"    // tcpip-forward or streamlocal-forward request
+	AuditAgentForward   AuditEventType = "forward.agent"      // agent forwarding request
+	AuditBan            AuditEventType = "ban"                // client banned by a FailBan, with Reason
+	AuditKeystrokes     AuditEventType = "session.keystrokes" // input of a PTY session, with KeystrokeLogging
+	AuditSharedInput    AuditEventType = "session.shared"     // input sent to a shared session by a collaborator
+)
+
+// AuditOutcome tells whether the action of an AuditEvent was allowed.
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+)
+
+// AuditEvent is a record of a security-relevant action, passed by value to
+// the server's AuditCallback. Fields not relevant to the Type are empty.
+// The JSON names are part of the schema versioned by AuditSchemaVersion.
+type AuditEvent struct {
+	Version int            `json:"version"`
+	Time    time.Time      `json:"time"`
+	Type    AuditEventType `json:"type"`
+	Outcome AuditOutcome   `json:"outcome"`
+
+	// connection, once known
+	SessionID     string `json:"session_id,omitempty"`
+	User          string `json:"user,omitempty"`
+	Impersonator  string `json:"impersonator,omitempty"`
+	RemoteAddr    string `json:"remote_addr,omitempty"`
+	LocalAddr     string `json:"local_addr,omitempty"`
+	ClientVersion string `json:"client_version,omitempty"`
+
+	// Labels are the labels set with SetAuditLabel on the connection.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// AuditConnOpen
+	KeyExchange string `json:"kex,omitempty"`
+	Cipher      string `json:"cipher,omitempty"` // client to server
+	StrictKex   bool   `json:"strict_kex,omitempty"`
+	HASSH       string `json:"hassh,omitempty"`
+
+	// AuditAuth
+	Method string `json:"method,omitempty"`
+
+	// AuditSessionStart and AuditSessionEnd
+	Command    string `json:"command,omitempty"`
+	Subsystem  string `json:"subsystem,omitempty"`
+	Pty        bool   `json:"pty,omitempty"`
+	ExitStatus *int   `json:"exit_status,omitempty"`
+	ExitSignal string `json:"exit_signal,omitempty"`
+
+	// AuditSessionStart: the accepted env requests as NAME=value, which
+	// AcceptEnv should keep from including secrets, and the terminal of
+	// the pty
+	Env     []string `json:"env,omitempty"`
+	Term    string   `json:"term,omitempty"`
+	PtyCols int      `json:"pty_cols,omitempty"`
+	PtyRows int      `json:"pty_rows,omitempty"`
+
+	// AuditKeystrokes: the recorded input and the number of bytes left
+	// out as typed while echo was off
+	Input       string `json:"input,omitempty"`
+	HiddenBytes int    `json:"hidden_bytes,omitempty"`
+
+	// AuditSharedInput: the Input typed, by the user of the event, into
+	// the session of the connection with this ID
+	SharedSessionID string `json:"shared_session_id,omitempty"`
+
+	// forwards: host:port or socket path, and the originator claimed by
+	// the client for local forwards
+	Destination string `json:"destination,omitempty"`
+	Origin      string `json:"origin,omitempty"`
+
+	// why the action failed or the connection ended
+	Reason string `json:"reason,omitempty"`
+}
+
+// audit completes ev with the details of the connection of ctx and passes it
+// to the AuditCallback of its server, if any.
+func audit(ctx Context, ev AuditEvent) {
+	srv, ok := ctx.Value(ContextKeyServer).(*Server)
+	if !ok || srv == nil || srv.AuditCallback == nil {
+		return
+	}
+	ev.Version = AuditSchemaVersion
+	ev.Time = time.Now().UTC()
+	ev.SessionID, _ = ctx.Value(ContextKeySessionID).(string)
+	ev.User, _ = ctx.Value(ContextKeyUser).(string)
+	ev.Impersonator, _ = Impersonator(ctx)
+	ev.ClientVersion, _ = ctx.Value(ContextKeyClientVersion).(string)
+	if addr, ok := ctx.Value(ContextKeyRemoteAddr).(net.Addr); ok {
+		ev.RemoteAddr = addr.String()
+	}
+	if addr, ok := ctx.Value(ContextKeyLocalAddr).(net.Addr); ok {
+		ev.LocalAddr = addr.String()
+	}
+	ev.Labels = AuditLabels(ctx)
+	srv.AuditCallback(ctx, ev)
+}
+
+// auditOutcome returns the outcome of an action that was allowed if ok.
+func auditOutcome(ok bool) AuditOutcome {
+	if ok {
+		return AuditSuccess
+	}
+	return AuditFailure
+}
+
+var contextKeyAuditLabels = &contextKey{"audit-labels"}
+
+// auditLabelsMu serializes SetAuditLabel, which replaces the labels of a
+// connection with a copy so events being built keep theirs.
+var auditLabelsMu sync.Mutex
+
+// SetAuditLabel labels the audit events of the connection of ctx from then
+// on with name and value, such as the tenant or team of the user, so events
+// can be told apart without joining them with other records. An empty value
+// removes the label.
+func SetAuditLabel(ctx Context, name, value string) {
+	auditLabelsMu.Lock()
+	defer auditLabelsMu.Unlock()
+	labels := make(map[string]string)
+	for k, v := range AuditLabels(ctx) {
+		labels[k] = v
+	}
+	if value == "" {
+		delete(labels, name)
+	} else {
+		labels[name] = value
+	}
+	ctx.SetValue(contextKeyAuditLabels, labels)
+}
+
+// AuditLabels returns the labels set with SetAuditLabel on the connection
+// of ctx. The map must not be modified.
+func AuditLabels(ctx Context) map[string]string {
+	labels, _ := ctx.Value(contextKeyAuditLabels).(map[string]string)
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
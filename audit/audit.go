@@ -0,0 +1,196 @@
+// Package audit encodes the audit events of an ssh.Server for log pipelines
+// such as Splunk or Elastic, as JSON Lines or in the Common Event Format
+// (CEF) of ArcSight:
+//
+//	srv.AuditCallback = audit.JSON(logFile)
+//
+// JSON objects use the names of the ssh.AuditEvent schema. CEF lines map
+// its fields to the standard extension keys where one exists, such as suser
+// and src, and to keys prefixed with ssh otherwise. Events are sent to a
+// local or remote syslog daemon with DialSyslog.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// JSON returns an AuditCallback writing each event to w as a line of JSON.
+// Events are written one at a time; write errors are ignored.
+func JSON(w io.Writer) ssh.AuditCallback {
+	var mu sync.Mutex
+	return func(ctx ssh.Context, ev ssh.AuditEvent) {
+		line, err := MarshalJSON(ev)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(line)
+	}
+}
+
+// CEF returns an AuditCallback writing each event to w as a CEF line.
+// Events are written one at a time; write errors are ignored.
+func CEF(w io.Writer) ssh.AuditCallback {
+	var mu sync.Mutex
+	return func(ctx ssh.Context, ev ssh.AuditEvent) {
+		line := MarshalCEF(ev) + "\n"
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, line)
+	}
+}
+
+// MarshalJSON encodes ev as a line of JSON, ending with a newline.
+func MarshalJSON(ev ssh.AuditEvent) ([]byte, error) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// eventNames are the CEF names of the event types.
+var eventNames = map[ssh.AuditEventType]string{
+	ssh.AuditConnOpen:       "Connection opened",
+	ssh.AuditConnClose:      "Connection closed",
+	ssh.AuditAuth:           "Authentication attempt",
+	ssh.AuditSessionStart:   "Session started",
+	ssh.AuditSessionEnd:     "Session ended",
+	ssh.AuditLocalForward:   "Local forwarding",
+	ssh.AuditReverseForward: "Reverse forwarding",
+	ssh.AuditAgentForward:   "Agent forwarding",
+	ssh.AuditBan:            "Client banned",
+	ssh.AuditKeystrokes:     "Keystrokes",
+	ssh.AuditSharedInput:    "Shared session input",
+}
+
+// MarshalCEF encodes ev as a CEF line, without a newline. Failures have
+// severity 5, other events 3.
+func MarshalCEF(ev ssh.AuditEvent) string {
+	name := eventNames[ev.Type]
+	if name == "" {
+		name = string(ev.Type)
+	}
+	severity := 3
+	if ev.Outcome == ssh.AuditFailure {
+		severity = 5
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|gliderlabs|ssh|%d|%s|%s|%d|", ev.Version, cefHeader(string(ev.Type)), cefHeader(name), severity)
+
+	ext := []string{"rt", strconv.FormatInt(ev.Time.UnixNano()/1e6, 10), "outcome", string(ev.Outcome)}
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key, value)
+		}
+	}
+	add("suser", ev.User)
+	host, port := splitAddr(ev.RemoteAddr)
+	add("src", host)
+	add("spt", port)
+	host, port = splitAddr(ev.LocalAddr)
+	add("dst", host)
+	add("dpt", port)
+	add("requestClientApplication", ev.ClientVersion)
+	add("act", ev.Method)
+	add("reason", ev.Reason)
+	add("cs1Label", label(ev.SessionID, "sessionID"))
+	add("cs1", ev.SessionID)
+	add("cs2Label", label(ev.Impersonator, "impersonator"))
+	add("cs2", ev.Impersonator)
+	add("cs3Label", label(ev.Command, "command"))
+	add("cs3", ev.Command)
+	add("cs4Label", label(ev.Subsystem, "subsystem"))
+	add("cs4", ev.Subsystem)
+	add("cs5Label", label(ev.Destination, "forwardDestination"))
+	add("cs5", ev.Destination)
+	add("cs6Label", label(ev.Origin, "forwardOrigin"))
+	add("cs6", ev.Origin)
+	if ev.ExitStatus != nil {
+		add("cn1Label", "exitStatus")
+		add("cn1", strconv.Itoa(*ev.ExitStatus))
+	}
+	add("sshExitSignal", ev.ExitSignal)
+	add("sshKex", ev.KeyExchange)
+	add("sshCipher", ev.Cipher)
+	add("sshHassh", ev.HASSH)
+	if ev.Type == ssh.AuditConnOpen {
+		add("sshStrictKex", strconv.FormatBool(ev.StrictKex))
+	}
+	if ev.Pty {
+		add("sshPty", "true")
+	}
+	add("sshTerm", ev.Term)
+	if ev.PtyCols > 0 || ev.PtyRows > 0 {
+		add("sshPtySize", fmt.Sprintf("%dx%d", ev.PtyCols, ev.PtyRows))
+	}
+	add("sshEnv", strings.Join(ev.Env, " "))
+	add("sshLabels", joinLabels(ev.Labels))
+	add("sshInput", ev.Input)
+	if ev.HiddenBytes > 0 {
+		add("sshHiddenBytes", strconv.Itoa(ev.HiddenBytes))
+	}
+	add("sshSharedSessionID", ev.SharedSessionID)
+	for i := 0; i < len(ext); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(ext[i])
+		b.WriteByte('=')
+		b.WriteString(cefValue(ext[i+1]))
+	}
+	return b.String()
+}
+
+// joinLabels returns labels as name=value pairs separated by commas, sorted
+// by name.
+func joinLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + labels[name]
+	}
+	return strings.Join(names, ",")
+}
+
+// label returns name if the labelled value is set.
+func label(value, name string) string {
+	if value == "" {
+		return ""
+	}
+	return name
+}
+
+func splitAddr(addr string) (host, port string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, ""
+	}
+	return host, port
+}
+
+var (
+	headerEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	valueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(s string) string {
+	return headerEscaper.Replace(s)
+}
+
+func cefValue(s string) string {
+	return valueEscaper.Replace(s)
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+func testEvent() ssh.AuditEvent {
+	status := 0
+	return ssh.AuditEvent{
+		Version:    ssh.AuditSchemaVersion,
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Type:       ssh.AuditSessionEnd,
+		Outcome:    ssh.AuditSuccess,
+		SessionID:  "abcd",
+		User:       "alice",
+		RemoteAddr: "192.0.2.1:51000",
+		LocalAddr:  "198.51.100.2:22",
+		Command:    "echo a=b|c",
+		ExitStatus: &status,
+	}
+}
+
+func TestMarshalCEF(t *testing.T) {
+	t.Parallel()
+	want := `CEF:0|gliderlabs|ssh|1|session.end|Session ended|3|rt=1704164645000 outcome=success suser=alice src=192.0.2.1 spt=51000 dst=198.51.100.2 dpt=22 cs1Label=sessionID cs1=abcd cs3Label=command cs3=echo a\=b|c cn1Label=exitStatus cn1=0`
+	if got := MarshalCEF(testEvent()); got != want {
+		t.Fatalf("MarshalCEF() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMarshalCEFSessionStart(t *testing.T) {
+	t.Parallel()
+	ev := testEvent()
+	ev.Type, ev.ExitStatus = ssh.AuditSessionStart, nil
+	ev.Pty, ev.Term, ev.PtyCols, ev.PtyRows = true, "xterm", 80, 24
+	ev.Env = []string{"LANG=C", "TZ=UTC"}
+	ev.Labels = map[string]string{"team": "db", "tenant": "acme"}
+	want := `CEF:0|gliderlabs|ssh|1|session.start|Session started|3|rt=1704164645000 outcome=success suser=alice src=192.0.2.1 spt=51000 dst=198.51.100.2 dpt=22 cs1Label=sessionID cs1=abcd cs3Label=command cs3=echo a\=b|c sshPty=true sshTerm=xterm sshPtySize=80x24 sshEnv=LANG\=C TZ\=UTC sshLabels=team\=db,tenant\=acme`
+	if got := MarshalCEF(ev); got != want {
+		t.Fatalf("MarshalCEF() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	record := JSON(&buf)
+	record(nil, testEvent())
+	record(nil, testEvent())
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("JSON wrote %d lines; want 2", len(lines))
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(lines[0], &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["type"] != "session.end" || fields["user"] != "alice" || fields["exit_status"] != 0.0 || fields["version"] != 1.0 {
+		t.Fatalf("JSON fields = %#v", fields)
+	}
+	if _, ok := fields["subsystem"]; ok {
+		t.Fatalf("JSON has empty field subsystem: %s", lines[0])
+	}
+}
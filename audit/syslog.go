@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// Facility is a syslog facility.
+type Facility int
+
+// Facilities commonly used by SSH servers.
+const (
+	FacilityDaemon   Facility = 3
+	FacilityAuth     Facility = 4
+	FacilityAuthPriv Facility = 10
+	FacilityLocal0   Facility = 16
+	FacilityLocal1   Facility = 17
+	FacilityLocal2   Facility = 18
+	FacilityLocal3   Facility = 19
+	FacilityLocal4   Facility = 20
+	FacilityLocal5   Facility = 21
+	FacilityLocal6   Facility = 22
+	FacilityLocal7   Facility = 23
+)
+
+// Severities used for the messages, by RFC 5424 value.
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// localSyslogPaths are the sockets of the local syslog daemon, by platform.
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const (
+	// syslogQueue is the number of messages Syslog queues for its writer,
+	// beyond which messages are dropped.
+	syslogQueue = 1024
+
+	// syslogTimeout bounds connecting to the daemon and writing a message.
+	syslogTimeout = 5 * time.Second
+)
+
+// errSyslogQueueFull is the error of writes dropped by a Syslog.
+var errSyslogQueueFull = errors.New("audit: syslog queue full")
+
+// Syslog sends audit and error events to a syslog daemon as RFC 5424
+// messages, like the AUTH messages of sshd. Audit events have the event type
+// as MSGID and the JSON encoding of the event as MSG, with severity warning
+// for failures and info otherwise. Writes are sent as error messages with
+// MSGID "error", so a Syslog serves as the output of a log.Logger.
+//
+// Messages are queued and sent by a goroutine, so a slow or unreachable
+// daemon doesn't hold up connections. Messages are dropped while the queue
+// is full, and when writing them fails again after reconnecting. Connecting
+// and writing time out after 5s. Messages sent over TCP or the stream socket of the local daemon
+// are framed by octet counting (RFC 6587); other transports send one
+// message per datagram.
+type Syslog struct {
+	network  string
+	addr     string
+	facility Facility
+	hostname string
+	tag      string
+	pid      string
+
+	queue chan string
+	stop  chan struct{} // closed to drop the queued messages
+	done  chan struct{} // closed once the writer has stopped
+
+	mu     sync.Mutex
+	closed bool
+
+	conn   net.Conn // used by the writer only
+	stream bool     // whether conn needs framing
+}
+
+// DialSyslog connects to the syslog daemon at addr, with network "udp",
+// "tcp" or an address family of net.Dial, or to the local daemon if network
+// is empty. Messages have the given facility and tag as APP-NAME, the base
+// name of the program if tag is empty.
+func DialSyslog(network, addr string, facility Facility, tag string) (*Syslog, error) {
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	hostname, _ := os.Hostname()
+	s := &Syslog{
+		network:  network,
+		addr:     addr,
+		facility: facility,
+		hostname: hostname,
+		tag:      tag,
+		pid:      strconv.Itoa(os.Getpid()),
+		queue:    make(chan string, syslogQueue),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Syslog) connect() error {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.network != "" {
+		conn, err := net.DialTimeout(s.network, s.addr, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		s.stream = s.network == "tcp" || s.network == "tcp4" || s.network == "tcp6" || s.network == "unix"
+		return nil
+	}
+	for _, path := range localSyslogPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.DialTimeout(network, path, syslogTimeout); err == nil {
+				s.conn = conn
+				s.stream = network == "unix"
+				return nil
+			}
+		}
+	}
+	return errors.New("audit: no local syslog daemon")
+}
+
+// Audit is an ssh.AuditCallback sending ev. Errors are ignored.
+func (s *Syslog) Audit(ctx ssh.Context, ev ssh.AuditEvent) {
+	line, err := MarshalJSON(ev)
+	if err != nil {
+		return
+	}
+	severity := severityInfo
+	if ev.Outcome == ssh.AuditFailure {
+		severity = severityWarning
+	}
+	s.send(severity, ev.Time, string(ev.Type), strings.TrimSuffix(string(line), "\n"))
+}
+
+// Write sends p as an error message, without its trailing newline. It fails
+// only if the message was dropped.
+func (s *Syslog) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if err := s.send(severityError, time.Now(), "error", msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends the queued messages, dropping those left after 5s, and closes
+// the connection to the daemon.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	timer := time.NewTimer(syslogTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+	case <-timer.C:
+		close(s.stop)
+		<-s.done
+	}
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// send queues a message for the writer.
+func (s *Syslog) send(severity int, t time.Time, msgID, msg string) error {
+	line := formatSyslog(int(s.facility)*8+severity, t, s.hostname, s.tag, s.pid, msgID, msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	select {
+	case s.queue <- line:
+		return nil
+	default:
+		return errSyslogQueueFull
+	}
+}
+
+// run writes the queued messages until the Syslog is closed, reconnecting
+// once on a write error before dropping the message.
+func (s *Syslog) run() {
+	defer close(s.done)
+	for line := range s.queue {
+		select {
+		case <-s.stop:
+			continue
+		default:
+		}
+		if err := s.write(line); err != nil {
+			if err = s.connect(); err == nil {
+				s.write(line)
+			}
+		}
+	}
+}
+
+func (s *Syslog) write(line string) error {
+	if s.conn == nil {
+		return net.ErrClosed
+	}
+	if s.stream {
+		line = strconv.Itoa(len(line)) + " " + line
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+// formatSyslog formats an RFC 5424 message without structured data.
+func formatSyslog(pri int, t time.Time, hostname, tag, pid, msgID, msg string) string {
+	return "<" + strconv.Itoa(pri) + ">1 " +
+		t.UTC().Format("2006-01-02T15:04:05.000000Z") + " " +
+		syslogField(hostname, 255) + " " +
+		syslogField(tag, 48) + " " +
+		syslogField(pid, 128) + " " +
+		syslogField(msgID, 32) + " - " + msg
+}
+
+// syslogField returns s as a header field of at most max printable ASCII
+// characters, or the nil value "-" if it is empty.
+func syslogField(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if c := s[i]; c > ' ' && c < 0x7f {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSyslog(t *testing.T) {
+	t.Parallel()
+	got := formatSyslog(38, time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC), "bastion 1", "", "42", "auth", "msg")
+	want := "<38>1 2024-01-02T03:04:05.000006Z bastion1 - 42 auth - msg"
+	if got != want {
+		t.Fatalf("formatSyslog = %#v; want %#v", got, want)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	t.Parallel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := DialSyslog("udp", pc.LocalAddr().String(), FacilityAuth, "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ev := testEvent()
+	s.Audit(nil, ev)
+	log.New(s, "", 0).Print("accept failed")
+	buf := make([]byte, 2048)
+	wants := []string{
+		"<38>1 2024-01-02T03:04:05.000000Z ",
+		"<35>1 ",
+	}
+	for i, want := range wants {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, want) {
+			t.Fatalf("message %d = %#v; want prefix %#v", i, msg, want)
+		}
+		if i == 0 && !strings.Contains(msg, ` sshd `+strconv.Itoa(os.Getpid())+` session.end - {"version":1,`) {
+			t.Fatalf("audit message = %#v; want tag, MSGID and JSON", msg)
+		}
+		if i == 1 && !strings.HasSuffix(msg, " error - accept failed") {
+			t.Fatalf("error message = %#v; want MSGID error without newline", msg)
+		}
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s, err := DialSyslog("tcp", l.Addr().String(), FacilityLocal0, "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s.Write([]byte("one\n"))
+	s.Write([]byte("two\n"))
+	r := bufio.NewReader(conn)
+	for _, word := range []string{"one", "two"} {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Fatalf("frame length %#v: %v", length, err)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(msg), "<131>1 ") || !strings.HasSuffix(string(msg), " error - "+word) {
+			t.Fatalf("message = %#v; want a local0 error %#v", string(msg), word)
+		}
+	}
+}
+
+func TestSyslogUnixStream(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "log")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	s, err := DialSyslog("unix", path, FacilityAuth, "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s.Write([]byte("one\n"))
+	length, err := bufio.NewReader(conn).ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strconv.Atoi(strings.TrimSuffix(length, " ")); err != nil {
+		t.Fatalf("message not framed by its length: %#v", length)
+	}
+}
+
+func TestSyslogStalledDaemon(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s, err := DialSyslog("tcp", l.Addr().String(), FacilityLocal0, "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a daemon accepting the connection but never reading from it
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg := []byte(strings.Repeat("x", 64<<10))
+		for i := 0; i < 2*syslogQueue; i++ {
+			s.Write(msg)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writes blocked on a daemon not reading")
+	}
+	if _, err := s.Write([]byte("more")); err != errSyslogQueueFull {
+		t.Fatalf("Write() = %#v; want %#v", err, errSyslogQueueFull)
+	}
+	// the queued messages are dropped once Close gave up on them
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(4 * syslogTimeout):
+		t.Fatal("Close blocked on a daemon not reading")
+	}
+}
@@ -0,0 +1,193 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestAuditCallback(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var events []AuditEvent
+	closed := make(chan struct{})
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			s.Exit(2)
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return true
+		},
+		AuditCallback: func(ctx Context, ev AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+			if ev.Type == AuditConnClose {
+				close(closed)
+			}
+		},
+	}, nil)
+	if err := session.Run("true"); err == nil {
+		t.Fatal("Run succeeded; want exit status 2")
+	}
+	cleanup()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no conn.close event")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var types []AuditEventType
+	for _, ev := range events {
+		types = append(types, ev.Type)
+		if ev.Version != AuditSchemaVersion || ev.Time.IsZero() || ev.RemoteAddr == "" || ev.User != "testuser" {
+			t.Fatalf("event %#v lacks connection details", ev)
+		}
+	}
+	want := []AuditEventType{AuditAuth, AuditConnOpen, AuditSessionStart, AuditSessionEnd, AuditConnClose}
+	if len(types) != len(want) {
+		t.Fatalf("event types = %v; want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("event types = %v; want %v", types, want)
+		}
+	}
+	if open := events[1]; open.KeyExchange == "" || open.HASSH == "" || !open.StrictKex {
+		t.Fatalf("conn.open = %#v; want crypto details", open)
+	}
+	if auth := events[0]; auth.Method != "password" || auth.Outcome != AuditSuccess {
+		t.Fatalf("auth = %#v; want successful password attempt", auth)
+	}
+	if end := events[3]; end.Command != "true" || end.ExitStatus == nil || *end.ExitStatus != 2 {
+		t.Fatalf("session.end = %#v; want command true with status 2", end)
+	}
+}
+
+func TestAuditSessionStart(t *testing.T) {
+	t.Parallel()
+	starts := make(chan AuditEvent, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler:   func(s Session) {},
+		AcceptEnv: []string{"LANG"},
+		PasswordHandler: func(ctx Context, password string) bool {
+			SetAuditLabel(ctx, "tenant", "acme")
+			return true
+		},
+		AuditCallback: func(ctx Context, ev AuditEvent) {
+			if ev.Type == AuditSessionStart {
+				starts <- ev
+			}
+		},
+	}, nil)
+	defer cleanup()
+	session.Setenv("LANG", "C")
+	session.Setenv("SECRET", "hunter2")
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+	start := <-starts
+	if len(start.Env) != 1 || start.Env[0] != "LANG=C" {
+		t.Fatalf("Env = %#v; want the accepted LANG=C", start.Env)
+	}
+	if !start.Pty || start.Term != "xterm" || start.PtyCols != 80 || start.PtyRows != 24 {
+		t.Fatalf("session.start = %#v; want the xterm pty of 80x24", start)
+	}
+	if start.Labels["tenant"] != "acme" {
+		t.Fatalf("Labels = %#v; want tenant acme", start.Labels)
+	}
+}
+
+// failingSigner is a Signer unable to sign, like an agent refusing to.
+type failingSigner struct{ gossh.Signer }
+
+func (s failingSigner) Sign(rand io.Reader, data []byte) (*gossh.Signature, error) {
+	return nil, errors.New("no private key")
+}
+
+func TestAuditPublicKeyQuery(t *testing.T) {
+	t.Parallel()
+	signer, _ := GenerateSigner(KeyTypeEd25519, 0)
+	var mu sync.Mutex
+	var events []AuditEvent
+	h := &recordHandler{}
+	m := &recordMetrics{}
+	srv := &Server{
+		Handler: func(s Session) {},
+		PublicKeyHandler: func(ctx Context, key PublicKey) bool {
+			return true
+		},
+		Logger:  slog.New(h),
+		Metrics: m,
+		AuditCallback: func(ctx Context, ev AuditEvent) {
+			if ev.Type == AuditAuth {
+				mu.Lock()
+				events = append(events, ev)
+				mu.Unlock()
+			}
+		},
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		signer gossh.Signer
+		ok     bool
+	}{
+		{"query", failingSigner{signer}, false},
+		{"signed", signer, true},
+	} {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "testuser",
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(tt.signer)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: Dial err = %v; want ok %v", tt.name, err, tt.ok)
+		}
+		if err == nil {
+			client.Close()
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].Outcome != AuditSuccess || events[0].Method != "publickey" {
+		t.Fatalf("auth events = %#v; want one publickey success", events)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var logged []map[string]string
+	for _, rec := range h.records {
+		if rec["msg"] == "auth" {
+			logged = append(logged, rec)
+		}
+	}
+	if len(logged) != 1 || logged[0]["ok"] != "true" {
+		t.Fatalf("auth log records = %v; want one successful auth", logged)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var attempts []string
+	for _, call := range m.calls {
+		if strings.HasPrefix(call, "AuthAttempt") {
+			attempts = append(attempts, call)
+		}
+	}
+	if want := []string{"AuthAttempt publickey true"}; fmt.Sprint(attempts) != fmt.Sprint(want) {
+		t.Fatalf("auth metrics = %#v; want %#v", attempts, want)
+	}
+}
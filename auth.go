@@ -0,0 +1,237 @@
+package ssh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// AuthPolicy lists the sequences of auth methods that authenticate a client,
+// like the AuthenticationMethods option of OpenSSH. Each sequence names
+// methods, "publickey", "password" or "keyboard-interactive", that must all
+// succeed in order; completing any one of them is enough. The client is told
+// of partial success after each step, and only offered the methods that can
+// come next. For example, {{"publickey", "keyboard-interactive"},
+// {"publickey", "password"}} requires a key followed by either a one-time
+// code or a password.
+type AuthPolicy [][]string
+
+// next returns the methods that may follow the completed steps done.
+func (p AuthPolicy) next(done []string) []string {
+	var methods []string
+	for _, chain := range p {
+		if len(chain) > len(done) && hasPrefix(chain, done) && !contains(methods, chain[len(done)]) {
+			methods = append(methods, chain[len(done)])
+		}
+	}
+	return methods
+}
+
+// complete reports whether the completed steps done satisfy a sequence.
+func (p AuthPolicy) complete(done []string) bool {
+	for _, chain := range p {
+		if len(chain) == len(done) && hasPrefix(chain, done) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(chain, prefix []string) bool {
+	for i, method := range prefix {
+		if chain[i] != method {
+			return false
+		}
+	}
+	return true
+}
+
+// callbacks returns the auth callbacks of the methods that may follow done,
+// wrapping those of base to report partial success until a sequence is
+// complete. Partial success of the publickey step is reported once the key
+// is verified, by the finish of the steps stored in keySteps.
+func (p AuthPolicy) callbacks(base gossh.ServerAuthCallbacks, done []string, keySteps *[]string) gossh.ServerAuthCallbacks {
+	var next gossh.ServerAuthCallbacks
+	for _, method := range p.next(done) {
+		steps := append(done[:len(done):len(done)], method)
+		finish := p.finish(base, steps, keySteps)
+		switch method {
+		case "password":
+			if cb := base.PasswordCallback; cb != nil {
+				next.PasswordCallback = func(conn gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
+					return finish(cb(conn, password))
+				}
+			}
+		case "publickey":
+			if cb := base.PublicKeyCallback; cb != nil {
+				next.PublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+					*keySteps = steps
+					return cb(conn, key)
+				}
+			}
+		case "keyboard-interactive":
+			if cb := base.KeyboardInteractiveCallback; cb != nil {
+				next.KeyboardInteractiveCallback = func(conn gossh.ConnMetadata, client gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+					return finish(cb(conn, client))
+				}
+			}
+		}
+	}
+	return next
+}
+
+// finish returns a function turning the successful result of the last of
+// steps into a partial success until a sequence is complete.
+func (p AuthPolicy) finish(base gossh.ServerAuthCallbacks, steps []string, keySteps *[]string) func(perms *gossh.Permissions, err error) (*gossh.Permissions, error) {
+	return func(perms *gossh.Permissions, err error) (*gossh.Permissions, error) {
+		if err != nil || p.complete(steps) {
+			return perms, err
+		}
+		// permissions are kept in the context until the last step
+		return nil, &gossh.PartialSuccessError{Next: p.callbacks(base, steps, keySteps)}
+	}
+}
+
+// applyAuthPolicy replaces the auth callbacks of config with those of the
+// first step of srv.AuthPolicy.
+func (srv *Server) applyAuthPolicy(config *gossh.ServerConfig) {
+	if len(srv.AuthPolicy) == 0 {
+		return
+	}
+	base := gossh.ServerAuthCallbacks{
+		PasswordCallback:            config.PasswordCallback,
+		PublicKeyCallback:           config.PublicKeyCallback,
+		KeyboardInteractiveCallback: config.KeyboardInteractiveCallback,
+	}
+	// the steps of the publickey callback called last, whose key the
+	// client goes on to sign with
+	var keySteps []string
+	first := srv.AuthPolicy.callbacks(base, nil, &keySteps)
+	config.NoClientAuth = false
+	config.PasswordCallback = first.PasswordCallback
+	config.PublicKeyCallback = first.PublicKeyCallback
+	config.KeyboardInteractiveCallback = first.KeyboardInteractiveCallback
+	if verified := config.VerifiedPublicKeyCallback; verified != nil {
+		config.VerifiedPublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey, perms *gossh.Permissions, algo string) (*gossh.Permissions, error) {
+			return srv.AuthPolicy.finish(base, keySteps, &keySteps)(verified(conn, key, perms, algo))
+		}
+	}
+}
+
+// decoyContext is the Context passed to auth handlers for unknown users when
+// the server has a DecoyUser.
+type decoyContext struct {
+	Context
+	user string
+}
+
+func (ctx decoyContext) User() string {
+	return ctx.user
+}
+
+func (ctx decoyContext) Value(key interface{}) interface{} {
+	if key == ContextKeyUser {
+		return ctx.user
+	}
+	return ctx.Context.Value(key)
+}
+
+// authContext is the Context passed to auth handlers when the server has an
+// AuthTimeout. It carries the values of the connection, but is done once the
+// timeout elapses.
+type authContext struct {
+	Context
+	done context.Context
+}
+
+func (ctx authContext) Done() <-chan struct{} {
+	return ctx.done.Done()
+}
+
+func (ctx authContext) Err() error {
+	return ctx.done.Err()
+}
+
+func (ctx authContext) Deadline() (time.Time, bool) {
+	return ctx.done.Deadline()
+}
+
+var contextKeyKeyHooks = &contextKey{"key-hooks"}
+
+// keyHooks are the functions a PublicKeyHandler deferred with onKeyVerified
+// while checking a key.
+type keyHooks struct {
+	mu   sync.Mutex
+	fns  []func()
+	done bool
+}
+
+// take returns the deferred functions, ignoring those of handlers still
+// running after the check, such as abandoned ones.
+func (h *keyHooks) take() []func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done = true
+	return h.fns
+}
+
+// keyHooksContext is the Context passed to PublicKeyHandlers, collecting the
+// functions they defer with onKeyVerified.
+type keyHooksContext struct {
+	Context
+	hooks *keyHooks
+}
+
+func (ctx keyHooksContext) Value(key interface{}) interface{} {
+	if key == contextKeyKeyHooks {
+		return ctx.hooks
+	}
+	return ctx.Context.Value(key)
+}
+
+// onKeyVerified defers fn, which records what a PublicKeyHandler granted
+// for the key it accepts, until the client signed with the key. Clients may
+// query any key without holding its private key, such as the certificate of
+// someone else, and then authenticate with another method. Outside of a
+// PublicKeyHandler, fn runs right away.
+func onKeyVerified(ctx Context, fn func()) {
+	hooks, ok := ctx.Value(contextKeyKeyHooks).(*keyHooks)
+	if !ok {
+		fn()
+		return
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if !hooks.done {
+		hooks.fns = append(hooks.fns, fn)
+	}
+}
+
+// authenticate calls an auth handler with a context that is done when the
+// connection is closed or srv.AuthTimeout elapses, so handlers can abort slow
+// backend lookups. If abandon is true, a handler still running at that point
+// is left behind and the attempt is rejected, so it can't hold up the
+// connection.
+func (srv *Server) authenticate(ctx Context, abandon bool, handler func(ctx Context) bool) bool {
+	if srv.AuthTimeout <= 0 {
+		return handler(ctx)
+	}
+	done, cancel := context.WithTimeout(ctx, srv.AuthTimeout)
+	defer cancel()
+	actx := authContext{ctx, done}
+	if !abandon {
+		return handler(actx) && done.Err() == nil
+	}
+	result := make(chan bool, 1)
+	go func() {
+		result <- handler(actx)
+	}()
+	select {
+	case ok := <-result:
+		return ok
+	case <-done.Done():
+		return false
+	}
+}
@@ -0,0 +1,173 @@
+package ssh
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestAuthTimeout(t *testing.T) {
+	t.Parallel()
+	srv := &Server{AuthTimeout: 20 * time.Millisecond}
+	ctx, cancel := newContext(srv)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+	start := time.Now()
+	ok := srv.authenticate(ctx, true, func(ctx Context) bool {
+		<-release
+		return true
+	})
+	if ok {
+		t.Fatal("stalled handler accepted")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("authenticate took %v; want about %v", elapsed, srv.AuthTimeout)
+	}
+
+	var err error
+	ok = srv.authenticate(ctx, false, func(ctx Context) bool {
+		<-ctx.Done()
+		err = ctx.Err()
+		return true
+	})
+	if ok || err != context.DeadlineExceeded {
+		t.Fatalf("authenticate = %v, ctx.Err() = %v; want false, %v", ok, err, context.DeadlineExceeded)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("connection ctx.Err() = %v; want nil", ctx.Err())
+	}
+
+	ok = srv.authenticate(ctx, true, func(ctx Context) bool {
+		ctx.SetValue(ContextKeyUser, "alice")
+		return true
+	})
+	if !ok || ctx.User() != "alice" {
+		t.Fatalf("authenticate = %v, User() = %#v; want true, %#v", ok, ctx.User(), "alice")
+	}
+}
+
+func TestAuthPolicy(t *testing.T) {
+	t.Parallel()
+	signer, err := GenerateSigner(KeyTypeEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		Handler: func(s Session) {},
+		PublicKeyHandler: func(ctx Context, key PublicKey) bool {
+			return KeysEqual(key, signer.PublicKey())
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return password == "secret"
+		},
+		AuthPolicy: AuthPolicy{{"publickey", "password"}},
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name string
+		auth []gossh.AuthMethod
+		ok   bool
+	}{
+		{"publickey", []gossh.AuthMethod{gossh.PublicKeys(signer)}, false},
+		{"password", []gossh.AuthMethod{gossh.Password("secret")}, false},
+		{"publickey,password", []gossh.AuthMethod{gossh.PublicKeys(signer), gossh.Password("secret")}, true},
+		{"publickey,wrong password", []gossh.AuthMethod{gossh.PublicKeys(signer), gossh.Password("guess")}, false},
+	} {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "testuser",
+			Auth:            tt.auth,
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: Dial err = %v; want ok %v", tt.name, err, tt.ok)
+		}
+		if err == nil {
+			client.Close()
+		}
+	}
+}
+
+func TestUserExistsCallback(t *testing.T) {
+	t.Parallel()
+	checked := make(chan string, 1)
+	srv := &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			checked <- ctx.User()
+			return password == "secret"
+		},
+		UserExistsCallback: func(ctx Context) bool {
+			return ctx.User() == "alice"
+		},
+		DecoyUser: "nobody",
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		user, checked string
+		ok            bool
+	}{
+		{"alice", "alice", true},
+		{"bob", "nobody", false},
+	} {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            tt.user,
+			Auth:            []gossh.AuthMethod{gossh.Password("secret")},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: Dial err = %v; want ok %v", tt.user, err, tt.ok)
+		}
+		if err == nil {
+			client.Close()
+		}
+		if got := <-checked; got != tt.checked {
+			t.Fatalf("%s: handler checked user %#v; want %#v", tt.user, got, tt.checked)
+		}
+	}
+}
+
+func TestMaxAuthTries(t *testing.T) {
+	t.Parallel()
+	var tries int32
+	reasons := make(chan CloseReason, 1)
+	srv := &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			atomic.AddInt32(&tries, 1)
+			return false
+		},
+		MaxAuthTries: 2,
+		ConnCloseCallback: func(ctx Context, reason CloseReason, err error) {
+			reasons <- reason
+		},
+	}
+	l := newLocalListener()
+	go srv.serveOnce(l)
+	_, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User: "testuser",
+		Auth: []gossh.AuthMethod{gossh.RetryableAuthMethod(gossh.PasswordCallback(func() (string, error) {
+			return "wrong", nil
+		}), 10)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("Dial succeeded; want auth failure")
+	}
+	if reason := <-reasons; reason != CloseReasonAuthFailures {
+		t.Fatalf("reason = %s; want %s", reason, CloseReasonAuthFailures)
+	}
+	if n := atomic.LoadInt32(&tries); n != 2 {
+		t.Fatalf("tries = %d; want 2", n)
+	}
+}
@@ -0,0 +1,200 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// BanStore holds the failed connections and bans of a FailBan. The in-memory
+// MemoryBanStore serves a single server, while implementations backed by
+// shared storage such as Redis let instances behind a load balancer share
+// bans. Its methods are called while handling connections, and stores that
+// can fail should fail open, treating clients as not banned.
+type BanStore interface {
+	// Fail records a connection by ip failing auth at now and returns the
+	// number of failures by ip within window before now, including this
+	// one.
+	Fail(ip string, now time.Time, window time.Duration) int
+	// Ban bans ip until the given time and forgets its failures.
+	Ban(ip string, until time.Time)
+	// BannedUntil returns the end of the ban on ip, or the zero time if
+	// it isn't banned.
+	BannedUntil(ip string) time.Time
+}
+
+// FailBan is an AbuseReporter temporarily banning client IPs after repeated
+// connections failing auth, like fail2ban or the PerSourcePenalties of
+// OpenSSH. A connection counts once however many attempts it made. Banned
+// clients are refused before the SSH handshake, or delayed if Delay is set.
+// Bans are reported as AuditBan audit events.
+type FailBan struct {
+	MaxFailures int           // failed connections within Window triggering a ban, 5 if zero
+	Window      time.Duration // period failures are counted over, 10 minutes if zero
+	BanTime     time.Duration // length of bans, 1 hour if zero
+
+	// Delay, if positive, holds the connections of banned clients for
+	// that long before handling them as usual instead of refusing them,
+	// slowing down attackers without locking out users behind the same
+	// IP. Delayed connections count against MaxConns and
+	// MaxConnsPerSource, and keep Close and Shutdown waiting.
+	Delay time.Duration
+
+	Store BanStore         // state of the bans, a MemoryBanStore if nil
+	Next  AbuseReporter    // optional reporter also receiving the offenses, such as a community blocklist
+	Clock func() time.Time // time for failures and bans, time.Now if nil
+
+	once sync.Once
+}
+
+func (b *FailBan) init() {
+	b.once.Do(func() {
+		if b.Store == nil {
+			b.Store = &MemoryBanStore{}
+		}
+	})
+}
+
+func (b *FailBan) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock()
+	}
+	return time.Now()
+}
+
+// Report counts the connections failing auth and bans the client once it
+// reached MaxFailures. Offenses are passed on to Next.
+func (b *FailBan) Report(ctx Context, offense Offense) {
+	b.init()
+	if b.Next != nil {
+		b.Next.Report(ctx, offense)
+	}
+	ip := banIP(offense.Addr)
+	if offense.Kind != OffenseAuthFailure || ip == "" {
+		return
+	}
+	max, window, banTime := b.MaxFailures, b.Window, b.BanTime
+	if max <= 0 {
+		max = 5
+	}
+	if window <= 0 {
+		window = 10 * time.Minute
+	}
+	if banTime <= 0 {
+		banTime = time.Hour
+	}
+	now := b.now()
+	if n := b.Store.Fail(ip, now, window); n >= max {
+		b.Store.Ban(ip, now.Add(banTime))
+		audit(ctx, AuditEvent{
+			Type:    AuditBan,
+			Outcome: AuditFailure,
+			Reason:  fmt.Sprintf("%d failed connections within %v, banned for %v", n, window, banTime),
+		})
+	}
+}
+
+// Blocked reports whether addr is banned, or blocked by Next. Banned
+// clients are delayed rather than blocked if Delay is set.
+func (b *FailBan) Blocked(addr net.Addr) bool {
+	b.init()
+	if b.Next != nil && b.Next.Blocked(addr) {
+		return true
+	}
+	ip := banIP(addr)
+	if ip == "" || !b.now().Before(b.Store.BannedUntil(ip)) {
+		return false
+	}
+	if b.Delay > 0 {
+		time.Sleep(b.Delay)
+		return false
+	}
+	return true
+}
+
+// banIP returns the IP of addr as the key of its bans, or "" if it has none.
+func banIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// MemoryBanStore is a BanStore kept in memory. The zero value is ready to use.
+type MemoryBanStore struct {
+	mu    sync.Mutex
+	ips   map[string]*banEntry
+	swept time.Time
+}
+
+type banEntry struct {
+	failures []time.Time
+	until    time.Time
+}
+
+func (s *MemoryBanStore) Fail(ip string, now time.Time, window time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ips == nil {
+		s.ips = make(map[string]*banEntry)
+	}
+	s.sweep(now, window)
+	e := s.ips[ip]
+	if e == nil {
+		e = &banEntry{}
+		s.ips[ip] = e
+	}
+	e.failures = append(recentFailures(e.failures, now, window), now)
+	return len(e.failures)
+}
+
+func (s *MemoryBanStore) Ban(ip string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ips == nil {
+		s.ips = make(map[string]*banEntry)
+	}
+	s.ips[ip] = &banEntry{until: until}
+}
+
+func (s *MemoryBanStore) BannedUntil(ip string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.ips[ip]; e != nil {
+		return e.until
+	}
+	return time.Time{}
+}
+
+// sweep forgets the IPs without recent failures or bans, at most once per
+// window, so the store doesn't grow with every client ever seen.
+func (s *MemoryBanStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.swept) < window {
+		return
+	}
+	s.swept = now
+	for ip, e := range s.ips {
+		e.failures = recentFailures(e.failures, now, window)
+		if len(e.failures) == 0 && !now.Before(e.until) {
+			delete(s.ips, ip)
+		}
+	}
+}
+
+// recentFailures returns the failures within window before now.
+func recentFailures(failures []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) >= window {
+		i++
+	}
+	return failures[i:]
+}
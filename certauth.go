@@ -0,0 +1,115 @@
+package ssh
+
+import (
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ContextKeyCertificate is a context key for use with Contexts in this
+// package. The associated value will be of type *gossh.Certificate, set for
+// users authenticated with a certificate by a CertificateAuthority.
+var ContextKeyCertificate = &contextKey{"certificate"}
+
+// CertificateAuthority authenticates users presenting OpenSSH certificates
+// signed by trusted certificate authorities, like the TrustedUserCAKeys
+// option of OpenSSH. Use its Handle method as the PublicKeyHandler.
+//
+// A certificate is accepted if it is a user certificate signed by one of
+// Keys, currently valid, not revoked, lists one of the principals of the
+// user, and has no critical options besides the supported ones. Once the
+// client signed with it, its critical options, extensions, principals and
+// validity are copied to the Permissions of the connection, and the
+// certificate is stored in the Context under ContextKeyCertificate. Once the
+// certificate expires, the connection is closed along with its sessions and
+// forwards.
+type CertificateAuthority struct {
+	Keys []PublicKey // trusted CA keys
+
+	// Principals returns the principals that may log in as the user of
+	// ctx, like AuthorizedPrincipalsFile. If nil, the user name is the
+	// only principal. Certificates without principals are rejected, as
+	// OpenSSH does for TrustedUserCAKeys.
+	Principals func(ctx Context) []string
+
+	// SupportedCriticalOptions are the critical options understood by the
+	// application. "force-command" and "source-address" are always
+	// supported: sessions run the forced command, see
+	// Permissions.ForceCommand, and the source address is enforced by
+	// golang.org/x/crypto/ssh.
+	SupportedCriticalOptions []string
+
+	IsRevoked   func(cert *gossh.Certificate) bool // optional revocation check, such as a KRL lookup
+	Clock       func() time.Time                   // time for validity checks, time.Now if nil
+	KeyFallback PublicKeyHandler                   // handler for plain keys, which are rejected if nil
+}
+
+// Handle is a PublicKeyHandler accepting certificates of the authority.
+func (ca *CertificateAuthority) Handle(ctx Context, key PublicKey) bool {
+	cert, ok := key.(*gossh.Certificate)
+	if !ok {
+		return ca.KeyFallback != nil && ca.KeyFallback(ctx, key)
+	}
+	if !ca.valid(ctx, cert) {
+		return false
+	}
+
+	// Clients can query the certificates of others, so nothing is granted
+	// until the client proved it holds the certified key.
+	onKeyVerified(ctx, func() {
+		perms := ctx.Permissions()
+		for name, value := range cert.CriticalOptions {
+			perms.setOption(name, value)
+		}
+		for name, value := range cert.Extensions {
+			perms.setExtension(name, value, true)
+		}
+		perms.SetPrincipals(cert.ValidPrincipals...)
+		perms.SetValidity(certValidity(cert))
+		ctx.SetValue(ContextKeyCertificate, cert)
+	})
+	return true
+}
+
+// valid reports whether cert is a currently valid user certificate of the
+// authority for the user of ctx.
+func (ca *CertificateAuthority) valid(ctx Context, cert *gossh.Certificate) bool {
+	if cert.CertType != gossh.UserCert || len(cert.ValidPrincipals) == 0 || !ca.trusted(cert.SignatureKey) {
+		return false
+	}
+	checker := &gossh.CertChecker{
+		SupportedCriticalOptions: append([]string{optionForceCommand, optionSourceAddress}, ca.SupportedCriticalOptions...),
+		IsRevoked:                ca.IsRevoked,
+		Clock:                    ca.Clock,
+	}
+	principals := []string{ctx.User()}
+	if ca.Principals != nil {
+		principals = ca.Principals(ctx)
+	}
+	for _, principal := range principals {
+		if checker.CheckCert(principal, cert) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// certValidity returns the validity of cert as bounds of Permissions.
+func certValidity(cert *gossh.Certificate) (after, before time.Time) {
+	if cert.ValidAfter != 0 {
+		after = time.Unix(int64(cert.ValidAfter), 0)
+	}
+	if cert.ValidBefore != gossh.CertTimeInfinity {
+		before = time.Unix(int64(cert.ValidBefore), 0)
+	}
+	return after, before
+}
+
+func (ca *CertificateAuthority) trusted(key PublicKey) bool {
+	for _, k := range ca.Keys {
+		if KeysEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
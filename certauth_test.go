@@ -0,0 +1,155 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCertificateAuthority(t *testing.T) {
+	t.Parallel()
+	caSigner, _ := GenerateSigner(KeyTypeEd25519, 0)
+	otherCA, _ := GenerateSigner(KeyTypeEd25519, 0)
+	userSigner, _ := GenerateSigner(KeyTypeEd25519, 0)
+	now := time.Now()
+	certSigner := func(ca Signer, mod func(cert *gossh.Certificate)) gossh.Signer {
+		cert := &gossh.Certificate{
+			Key:             userSigner.PublicKey(),
+			CertType:        gossh.UserCert,
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+			Permissions: gossh.Permissions{
+				CriticalOptions: map[string]string{"force-command": "uptime"},
+				Extensions:      map[string]string{PermitPty: ""},
+			},
+		}
+		if mod != nil {
+			mod(cert)
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		signer, err := gossh.NewCertSigner(cert, userSigner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	ca := &CertificateAuthority{Keys: []PublicKey{caSigner.PublicKey()}}
+	srv := &Server{
+		Handler: func(s Session) {
+			ctx := s.Context().(Context)
+			cert, _ := ctx.Value(ContextKeyCertificate).(*gossh.Certificate)
+			perms := s.Permissions()
+			if cert == nil || perms.ForceCommand() != "uptime" || !perms.Permit(PermitPty) {
+				s.Exit(1)
+			}
+			if env := s.Environ(); s.RawCommand() != "uptime" || len(env) != 1 || env[0] != "SSH_ORIGINAL_COMMAND=ls" {
+				s.Exit(2)
+			}
+		},
+		PublicKeyHandler: ca.Handle,
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name   string
+		user   string
+		signer gossh.Signer
+		ok     bool
+	}{
+		{"valid", "alice", certSigner(caSigner, nil), true},
+		{"principal", "bob", certSigner(caSigner, nil), false},
+		{"no principals", "alice", certSigner(caSigner, func(cert *gossh.Certificate) {
+			cert.ValidPrincipals = nil
+		}), false},
+		{"untrusted", "alice", certSigner(otherCA, nil), false},
+		{"plain key", "alice", userSigner, false},
+		{"expired", "alice", certSigner(caSigner, func(cert *gossh.Certificate) {
+			cert.ValidBefore = uint64(now.Add(-time.Minute).Unix())
+		}), false},
+		{"critical option", "alice", certSigner(caSigner, func(cert *gossh.Certificate) {
+			cert.CriticalOptions["verify-required"] = ""
+		}), false},
+		{"host cert", "alice", certSigner(caSigner, func(cert *gossh.Certificate) {
+			cert.CertType = gossh.HostCert
+		}), false},
+	} {
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            tt.user,
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(tt.signer)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: Dial err = %v; want ok %v", tt.name, err, tt.ok)
+		}
+		if err != nil {
+			continue
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Run("ls"); err != nil {
+			t.Fatalf("%s: certificate not exposed to the session: %v", tt.name, err)
+		}
+		client.Close()
+	}
+}
+
+// testConnMetadata is the metadata of a connection authenticating as user.
+type testConnMetadata struct{ user string }
+
+func (m testConnMetadata) User() string          { return m.user }
+func (m testConnMetadata) SessionID() []byte     { return []byte("session") }
+func (m testConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-client") }
+func (m testConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-server") }
+func (m testConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+func (m testConnMetadata) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+}
+
+func TestCertificateAuthorityQuery(t *testing.T) {
+	t.Parallel()
+	caSigner, _ := GenerateSigner(KeyTypeEd25519, 0)
+	userSigner, _ := GenerateSigner(KeyTypeEd25519, 0)
+	cert := &gossh.Certificate{
+		Key:             userSigner.PublicKey(),
+		CertType:        gossh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     gossh.CertTimeInfinity,
+		Permissions:     gossh.Permissions{Extensions: map[string]string{PermitPty: ""}},
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	ca := &CertificateAuthority{Keys: []PublicKey{caSigner.PublicKey()}}
+	srv := &Server{PublicKeyHandler: ca.Handle}
+	ctx, cancel := newContext(srv)
+	defer cancel()
+	config := srv.config(ctx)
+	conn := testConnMetadata{"alice"}
+
+	perms, err := config.PublicKeyCallback(conn, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Value(ContextKeyCertificate) != nil || ctx.Value(ContextKeyPublicKey) != nil || ctx.Permissions().Permit(PermitPty) {
+		t.Fatal("certificate applied to the connection on a query")
+	}
+	if _, err := config.VerifiedPublicKeyCallback(conn, cert, perms, cert.Type()); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Value(ContextKeyCertificate) != cert || ctx.Value(ContextKeyPublicKey) != cert || !ctx.Permissions().Permit(PermitPty) {
+		t.Fatal("certificate not applied to the connection once verified")
+	}
+}
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"errors"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// CertRefreshRequestType is the global request with which clients present a
+// renewed certificate over an open connection. Its payload is the renewed
+// certificate in wire format, as a string.
+const CertRefreshRequestType = "cert-refresh@gliderlabs.com"
+
+// ErrCertRefreshRejected is returned by RefreshCertificate if the server
+// rejected the renewed certificate.
+var ErrCertRefreshRejected = errors.New("ssh: certificate refresh rejected")
+
+type certRefreshRequest struct {
+	Cert []byte
+}
+
+// HandleRefresh is a RequestHandler letting clients authenticated with a
+// certificate of the authority present a renewed one, extending the lifetime
+// of the connection without reconnecting, which suits long-lived reverse
+// tunnels using short-lived certificates. It can be enabled by adding it to
+// the server's RequestHandlers under CertRefreshRequestType.
+//
+// The renewed certificate must be valid for the user, certify the same key
+// and have the same critical options and extensions as the current one, as
+// only the validity of the connection's Permissions is updated. Clients
+// granted other permissions need to reconnect.
+func (ca *CertificateAuthority) HandleRefresh(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
+	current, ok := ctx.Value(ContextKeyCertificate).(*gossh.Certificate)
+	if !ok {
+		return false, nil
+	}
+	var payload certRefreshRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false, nil
+	}
+	key, err := gossh.ParsePublicKey(payload.Cert)
+	if err != nil {
+		return false, nil
+	}
+	cert, ok := key.(*gossh.Certificate)
+	ok = ok && KeysEqual(cert.Key, current.Key) &&
+		equalOptions(cert.CriticalOptions, current.CriticalOptions) &&
+		equalOptions(cert.Extensions, current.Extensions) &&
+		ca.valid(ctx, cert)
+	audit(ctx, AuditEvent{Type: AuditAuth, Outcome: auditOutcome(ok), Method: "cert-refresh"})
+	srv.metrics().AuthAttempt("cert-refresh", ok)
+	if !ok {
+		return false, nil
+	}
+
+	// Sessions may be reading the current permissions, so they are
+	// replaced by a copy rather than changed.
+	perms := &Permissions{&gossh.Permissions{
+		CriticalOptions: copyOptions(ctx.Permissions().CriticalOptions),
+		Extensions:      copyOptions(ctx.Permissions().Extensions),
+		ExtraData:       ctx.Permissions().ExtraData,
+	}}
+	perms.SetValidity(certValidity(cert))
+	ctx.SetValue(ContextKeyPermissions, perms)
+	ctx.SetValue(ContextKeyCertificate, cert)
+	if conn, ok := ctx.Value(contextKeyServerConn).(*serverConn); ok {
+		srv.watchExpiry(ctx, conn)
+	}
+	return true, nil
+}
+
+// RefreshCertificate presents the renewed certificate cert to the server of
+// conn, which must handle CertRefreshRequestType, such as with
+// CertificateAuthority.HandleRefresh.
+func RefreshCertificate(conn gossh.Conn, cert *gossh.Certificate) error {
+	ok, _, err := conn.SendRequest(CertRefreshRequestType, true, gossh.Marshal(&certRefreshRequest{cert.Marshal()}))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCertRefreshRejected
+	}
+	return nil
+}
+
+func equalOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func copyOptions(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
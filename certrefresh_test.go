@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCertRefresh(t *testing.T) {
+	t.Parallel()
+	caSigner, _ := GenerateSigner(KeyTypeEd25519, 0)
+	otherCA, _ := GenerateSigner(KeyTypeEd25519, 0)
+	userSigner, _ := GenerateSigner(KeyTypeEd25519, 0)
+	otherKey, _ := GenerateSigner(KeyTypeEd25519, 0)
+	now := time.Now()
+	newCert := func(ca Signer, key PublicKey, before time.Time, mod func(cert *gossh.Certificate)) *gossh.Certificate {
+		cert := &gossh.Certificate{
+			Key:             key,
+			CertType:        gossh.UserCert,
+			ValidPrincipals: []string{"alice"},
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(before.Unix()),
+			Permissions: gossh.Permissions{
+				Extensions: map[string]string{PermitPty: ""},
+			},
+		}
+		if mod != nil {
+			mod(cert)
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	ca := &CertificateAuthority{Keys: []PublicKey{caSigner.PublicKey()}}
+	srv := &Server{
+		Handler: func(s Session) {
+			_, before := s.Permissions().Validity()
+			s.Write([]byte(strconv.FormatInt(before.Unix(), 10)))
+		},
+		PublicKeyHandler: ca.Handle,
+		RequestHandlers: map[string]RequestHandler{
+			CertRefreshRequestType: ca.HandleRefresh,
+		},
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	signer, err := gossh.NewCertSigner(newCert(caSigner, userSigner.PublicKey(), now.Add(2*time.Second), nil), userSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "alice",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	later := now.Add(time.Hour)
+	for _, tt := range []struct {
+		name string
+		cert *gossh.Certificate
+	}{
+		{"untrusted", newCert(otherCA, userSigner.PublicKey(), later, nil)},
+		{"other key", newCert(caSigner, otherKey.PublicKey(), later, nil)},
+		{"extensions", newCert(caSigner, userSigner.PublicKey(), later, func(cert *gossh.Certificate) {
+			cert.Extensions[PermitPortForwarding] = ""
+		})},
+		{"principal", newCert(caSigner, userSigner.PublicKey(), later, func(cert *gossh.Certificate) {
+			cert.ValidPrincipals = []string{"bob"}
+		})},
+	} {
+		if err := RefreshCertificate(client, tt.cert); err != ErrCertRefreshRejected {
+			t.Fatalf("%s: RefreshCertificate() = %v; want %v", tt.name, err, ErrCertRefreshRejected)
+		}
+	}
+	if err := RefreshCertificate(client, newCert(caSigner, userSigner.PublicKey(), later, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection outlives the first certificate
+	time.Sleep(2500 * time.Millisecond)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), strconv.FormatInt(later.Unix(), 10); got != want {
+		t.Fatalf("valid before = %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PortCheckResult is the outcome of checking a destination with a
+// PortChecker, written to the client as a line of JSON.
+type PortCheckResult struct {
+	Destination string  `json:"destination"`
+	Reachable   bool    `json:"reachable"`
+	LatencyMS   float64 `json:"latency_ms,omitempty"` // time to connect
+	Error       string  `json:"error,omitempty"`
+}
+
+// PortChecker is a SubsystemHandler letting clients test whether the server
+// reaches destinations they may forward to, without forwarding, like
+// netcat -z. It can be enabled by adding its HandleSession method to the
+// server's SubsystemHandlers under "check-port":
+//
+//	ssh -s bastion check-port <<< "db.internal:5432"
+//
+// Each line of input is a destination as host:port, answered with a
+// PortCheckResult. The session exits with status 0 if all destinations were
+// reachable and 1 otherwise. Destinations are checked while local
+// forwarding is enabled, and must be allowed by Allowed and the
+// ForwardAddressPolicy of the server.
+type PortChecker struct {
+	// Allowed decides which destinations may be checked. If nil, the
+	// LocalPortForwardingCallback of the server is used, and nothing may
+	// be checked without one.
+	Allowed LocalPortForwardingCallback
+
+	Timeout time.Duration // per connection attempt, 5 seconds if zero
+}
+
+func (c *PortChecker) HandleSession(s Session) {
+	ctx, _ := s.Context().(Context)
+	allowed := c.Allowed
+	var policy *AddressPolicy
+	if srv, ok := ctx.Value(ContextKeyServer).(*Server); ok {
+		if allowed == nil {
+			allowed = srv.LocalPortForwardingCallback
+		}
+		policy = srv.ForwardAddressPolicy
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	enc := json.NewEncoder(s)
+	status := 0
+	scanner := bufio.NewScanner(s)
+	for scanner.Scan() {
+		dest := strings.TrimSpace(scanner.Text())
+		if dest == "" {
+			continue
+		}
+		result := PortCheckResult{Destination: dest}
+		host, portStr, err := net.SplitHostPort(dest)
+		port, perr := strconv.ParseUint(portStr, 10, 16)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case perr != nil:
+			result.Error = "invalid port " + strconv.Quote(portStr)
+		case !featureEnabled(ctx, FeatureLocalForwarding):
+			result.Error = message(ctx, MessageFeatureDisabled, FeatureLocalForwarding)
+		case allowed == nil || !allowed(ctx, host, uint32(port)):
+			result.Error = message(ctx, MessageForwardDisabled)
+		default:
+			dialer := net.Dialer{Timeout: timeout, Control: policy.control}
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", dest)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			conn.Close()
+			result.Reachable = true
+			result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+		}
+		if !result.Reachable {
+			status = 1
+		}
+		enc.Encode(&result)
+	}
+	s.Exit(status)
+}
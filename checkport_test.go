@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestPortChecker(t *testing.T) {
+	t.Parallel()
+	open := newLocalListener()
+	defer open.Close()
+	closed := newLocalListener()
+	closed.Close()
+	_, client, cleanup := newTestSession(t, &Server{
+		SubsystemHandlers: map[string]SubsystemHandler{
+			"check-port": (&PortChecker{}).HandleSession,
+		},
+		LocalPortForwardingCallback: func(ctx Context, host string, port uint32) bool {
+			return host == "127.0.0.1"
+		},
+		ForwardAddressPolicy: &AddressPolicy{AllowLoopback: true},
+	}, nil)
+	defer cleanup()
+	ch, reqs, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	if ok, err := ch.SendRequest("subsystem", true, gossh.Marshal(&struct{ Name string }{"check-port"})); !ok || err != nil {
+		t.Fatalf("subsystem request = %v, %v; want ok", ok, err)
+	}
+	io.WriteString(ch, strings.Join([]string{
+		open.Addr().String(),
+		closed.Addr().String(),
+		"192.0.2.1:22",
+		"nonsense",
+	}, "\n"))
+	ch.CloseWrite()
+	var results []PortCheckResult
+	scanner := bufio.NewScanner(ch)
+	for scanner.Scan() {
+		var result PortCheckResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	status := -1
+	for req := range reqs {
+		if req.Type == "exit-status" {
+			status = int(binary.BigEndian.Uint32(req.Payload))
+		}
+	}
+	if status != 1 {
+		t.Fatalf("exit status = %d; want 1", status)
+	}
+	if len(results) != 4 {
+		t.Fatalf("results = %#v; want 4", results)
+	}
+	if !results[0].Reachable || results[0].Destination != open.Addr().String() {
+		t.Fatalf("open port result = %#v; want reachable", results[0])
+	}
+	if results[1].Reachable || results[1].Error == "" {
+		t.Fatalf("closed port result = %#v; want an error", results[1])
+	}
+	if results[2].Error != "port forwarding is disabled" {
+		t.Fatalf("denied destination result = %#v; want denial", results[2])
+	}
+	if results[3].Reachable || results[3].Error == "" {
+		t.Fatalf("malformed destination result = %#v; want an error", results[3])
+	}
+}
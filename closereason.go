@@ -0,0 +1,182 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// CloseReason describes why a connection ended.
+type CloseReason int
+
+const (
+	// CloseReasonUnknown is reported while the connection is still open.
+	CloseReasonUnknown CloseReason = iota
+	// CloseReasonClientEOF means the client closed the connection.
+	CloseReasonClientEOF
+	// CloseReasonIdleTimeout means the connection exceeded the server's
+	// IdleTimeout.
+	CloseReasonIdleTimeout
+	// CloseReasonMaxTimeout means the connection exceeded the server's
+	// MaxTimeout.
+	CloseReasonMaxTimeout
+	// CloseReasonPolicy means the connection was closed with CloseConn.
+	CloseReasonPolicy
+	// CloseReasonServerShutdown means the server was closed.
+	CloseReasonServerShutdown
+	// CloseReasonProtocolError means the handshake failed or the client
+	// violated the protocol.
+	CloseReasonProtocolError
+	// CloseReasonNetworkError means the network connection failed.
+	CloseReasonNetworkError
+	// CloseReasonAuthFailures means the client was disconnected after
+	// exceeding the server's MaxAuthTries.
+	CloseReasonAuthFailures
+	// CloseReasonCredentialsExpired means the validity of the connection's
+	// Permissions, such as that of its certificate, ended.
+	CloseReasonCredentialsExpired
+	// CloseReasonHandshakeTimeout means the handshake exceeded the server's
+	// HandshakeTimeout.
+	CloseReasonHandshakeTimeout
+)
+
+var closeReasonNames = [...]string{
+	CloseReasonUnknown:            "unknown",
+	CloseReasonClientEOF:          "client-eof",
+	CloseReasonIdleTimeout:        "idle-timeout",
+	CloseReasonMaxTimeout:         "max-timeout",
+	CloseReasonPolicy:             "policy",
+	CloseReasonServerShutdown:     "server-shutdown",
+	CloseReasonProtocolError:      "protocol-error",
+	CloseReasonNetworkError:       "network-error",
+	CloseReasonAuthFailures:       "auth-failures",
+	CloseReasonCredentialsExpired: "credentials-expired",
+	CloseReasonHandshakeTimeout:   "handshake-timeout",
+}
+
+func (r CloseReason) String() string {
+	if r < 0 || int(r) >= len(closeReasonNames) {
+		return "unknown"
+	}
+	return closeReasonNames[r]
+}
+
+// closeState records the first reason given for closing a connection.
+type closeState struct {
+	mu     sync.Mutex
+	reason CloseReason
+	err    error
+}
+
+func (s *closeState) setCloseReason(reason CloseReason, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == CloseReasonUnknown {
+		s.reason, s.err = reason, err
+	}
+}
+
+func (s *closeState) closeReason() (CloseReason, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason, s.err
+}
+
+// ConnCloseReason returns why the connection of ctx ended along with the
+// underlying error, if any. It returns CloseReasonUnknown while the
+// connection is open.
+func ConnCloseReason(ctx Context) (CloseReason, error) {
+	conn, ok := ctx.Value(contextKeyServerConn).(*serverConn)
+	if !ok {
+		return CloseReasonUnknown, nil
+	}
+	return conn.closeReason()
+}
+
+// CloseConn closes the connection of ctx, reporting CloseReasonPolicy as the
+// reason. It's meant for killing connections that violate a policy.
+func CloseConn(ctx Context) error {
+	conn, ok := ctx.Value(contextKeyServerConn).(*serverConn)
+	if !ok {
+		return nil
+	}
+	conn.setCloseReason(CloseReasonPolicy, nil)
+	return conn.Close()
+}
+
+// noteTimeout records a timeout as the close reason when err is one.
+func (c *serverConn) noteTimeout(err error) {
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		return
+	}
+	if !c.maxDeadline.IsZero() && !time.Now().Before(c.maxDeadline) {
+		c.setCloseReason(CloseReasonMaxTimeout, err)
+	} else {
+		c.setCloseReason(CloseReasonIdleTimeout, err)
+	}
+}
+
+// tooManyAuthFailures reports whether err is the handshake error of a
+// client disconnected for exceeding MaxAuthTries. golang.org/x/crypto/ssh
+// ends the errors of the attempts with its disconnect message.
+func tooManyAuthFailures(err error) bool {
+	var authErr *gossh.ServerAuthError
+	if !errors.As(err, &authErr) || len(authErr.Errors) == 0 {
+		return false
+	}
+	last := authErr.Errors[len(authErr.Errors)-1].Error()
+	return strings.HasPrefix(last, "ssh: disconnect") && strings.Contains(last, "too many authentication failures")
+}
+
+// connClosed records the final close reason of a connection that ended with
+// err and reports it to the ConnCloseCallback.
+func (srv *Server) connClosed(ctx Context, conn *serverConn, err error) {
+	srv.mu.Lock()
+	closing := srv.closing
+	srv.mu.Unlock()
+	switch _, isNetErr := err.(net.Error); {
+	case closing:
+		conn.setCloseReason(CloseReasonServerShutdown, nil)
+	case err == ErrHandshakeTimeout:
+		conn.setCloseReason(CloseReasonHandshakeTimeout, err)
+	case err == nil || err == io.EOF:
+		conn.setCloseReason(CloseReasonClientEOF, nil)
+	case isNetErr:
+		conn.setCloseReason(CloseReasonNetworkError, err)
+	case tooManyAuthFailures(err):
+		conn.setCloseReason(CloseReasonAuthFailures, err)
+	default:
+		conn.setCloseReason(CloseReasonProtocolError, err)
+	}
+	reason, err := conn.closeReason()
+	if reason == CloseReasonPolicy {
+		srv.reportOffense(ctx, Offense{Kind: OffensePolicy, Err: err})
+	}
+	closed := AuditEvent{Type: AuditConnClose, Outcome: AuditSuccess, Reason: reason.String()}
+	if err != nil {
+		closed.Reason += ": " + err.Error()
+	}
+	audit(ctx, closed)
+	if err != nil {
+		srv.logf(ctx, slog.LevelInfo, "connection closed", "reason", reason.String(), "error", err.Error())
+	} else {
+		srv.logf(ctx, slog.LevelInfo, "connection closed", "reason", reason.String())
+	}
+	srv.metrics().ConnClosed(reason, time.Since(conn.opened))
+	if conn.span != nil {
+		conn.span.SetAttribute("ssh.close_reason", reason.String())
+		if err != nil {
+			conn.span.RecordError(err)
+		}
+		conn.span.End()
+	}
+	if srv.ConnCloseCallback != nil {
+		srv.ConnCloseCallback(ctx, reason, err)
+	}
+}
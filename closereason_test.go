@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnCloseReason(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		srv  *Server
+		want CloseReason
+	}{
+		{
+			name: "client-eof",
+			srv:  &Server{Handler: func(s Session) {}},
+			want: CloseReasonClientEOF,
+		},
+		{
+			name: "idle-timeout",
+			srv: &Server{
+				Handler:     func(s Session) { <-s.Context().Done() },
+				IdleTimeout: 50 * time.Millisecond,
+			},
+			want: CloseReasonIdleTimeout,
+		},
+		{
+			name: "policy",
+			srv: &Server{
+				Handler: func(s Session) { CloseConn(s.Context().(Context)) },
+			},
+			want: CloseReasonPolicy,
+		},
+	} {
+		reasons := make(chan CloseReason, 1)
+		tc.srv.ConnCloseCallback = func(ctx Context, reason CloseReason, err error) {
+			if r, _ := ConnCloseReason(ctx); r != reason {
+				t.Errorf("%s: ConnCloseReason = %s; want %s", tc.name, r, reason)
+			}
+			reasons <- reason
+		}
+		session, _, cleanup := newTestSession(t, tc.srv, nil)
+		session.Run("")
+		if tc.want == CloseReasonClientEOF {
+			cleanup()
+		}
+		select {
+		case reason := <-reasons:
+			if reason != tc.want {
+				t.Fatalf("%s: reason = %s; want %s", tc.name, reason, tc.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: connection not closed", tc.name)
+		}
+		cleanup()
+	}
+}
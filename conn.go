@@ -3,20 +3,75 @@ package ssh
 import (
 	"context"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type serverConn struct {
 	net.Conn
+	flowStats
+	closeState
 
 	idleTimeout   time.Duration
 	maxDeadline   time.Time
 	closeCanceler context.CancelFunc
+	kex           *kexSniffer
+	expiry        expiry
+	opened        time.Time // start of the handshake
+	spanCtx       context.Context
+	span          Span
+
+	// handlers tracks the goroutines started for the connection, which
+	// HandleConn waits for before returning.
+	handlers sync.WaitGroup
+
+	// opening counts the channel handlers running, which may not have
+	// accepted their channel yet.
+	opening int32
+
+	// sessions counts the session channels being handled, for MaxSessions.
+	sessions int32
+
+	// forwards counts the reverse forwards listening for the connection,
+	// which keep it from being closed as idle on shutdown.
+	forwards int32
+}
+
+// spawn runs fn in a new goroutine tracked by the connection of ctx, so
+// shutting down the server waits for it.
+func spawn(ctx context.Context, fn func()) {
+	conn, ok := ctx.Value(contextKeyServerConn).(*serverConn)
+	if !ok {
+		go fn()
+		return
+	}
+	conn.handlers.Add(1)
+	go func() {
+		defer conn.handlers.Done()
+		fn()
+	}()
+}
+
+// trackForward counts a reverse forward of the connection of ctx until the
+// returned function is called.
+func trackForward(ctx Context) (release func()) {
+	conn, ok := ctx.Value(contextKeyServerConn).(*serverConn)
+	if !ok {
+		return func() {}
+	}
+	atomic.AddInt32(&conn.forwards, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt32(&conn.forwards, -1) })
+	}
 }
 
 func (c *serverConn) Write(p []byte) (n int, err error) {
 	c.updateDeadline()
+	defer c.track(time.Now())
 	n, err = c.Conn.Write(p)
+	c.noteTimeout(err)
 	if _, isNetErr := err.(net.Error); isNetErr && c.closeCanceler != nil {
 		c.closeCanceler()
 	}
@@ -26,6 +81,10 @@ func (c *serverConn) Write(p []byte) (n int, err error) {
 func (c *serverConn) Read(b []byte) (n int, err error) {
 	c.updateDeadline()
 	n, err = c.Conn.Read(b)
+	if c.kex != nil {
+		n, err = c.kex.observe(b, n, err)
+	}
+	c.noteTimeout(err)
 	if _, isNetErr := err.(net.Error); isNetErr && c.closeCanceler != nil {
 		c.closeCanceler()
 	}
@@ -0,0 +1,86 @@
+package ssh
+
+import (
+	"net"
+	"net/netip"
+)
+
+var (
+	contextKeyConnSource  = &contextKey{"conn-source"}
+	contextKeyConnCounted = &contextKey{"conn-counted"}
+)
+
+// connSource returns the source addr is counted under for
+// MaxConnsPerSource, the network of its IP with the prefix length of the
+// server's SourceBlockIPv4 or SourceBlockIPv6, or "" if it has no IP.
+func (srv *Server) connSource(addr net.Addr) string {
+	ip := banIP(addr)
+	if ip == "" {
+		return ""
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	a = a.Unmap()
+	bits := srv.SourceBlockIPv6
+	if bits <= 0 || bits > 128 {
+		bits = 128
+	}
+	if a.Is4() {
+		bits = srv.SourceBlockIPv4
+		if bits <= 0 || bits > 32 {
+			bits = 32
+		}
+	}
+	prefix, err := a.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// acquireConn counts the connection of ctx against MaxConns and the
+// MaxConnsPerSource of its source, returning the name of the limit it
+// exceeds, or "" if it is within both.
+func (srv *Server) acquireConn(ctx Context) string {
+	var source string
+	if srv.MaxConnsPerSource > 0 {
+		source = srv.connSource(ctx.RemoteAddr())
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.MaxConns > 0 && srv.open >= srv.MaxConns {
+		return "MaxConns"
+	}
+	if source != "" && srv.sources[source] >= srv.MaxConnsPerSource {
+		return "MaxConnsPerSource"
+	}
+	srv.open++
+	ctx.SetValue(contextKeyConnCounted, true)
+	if source != "" {
+		if srv.sources == nil {
+			srv.sources = make(map[string]int)
+		}
+		srv.sources[source]++
+		ctx.SetValue(contextKeyConnSource, source)
+	}
+	return ""
+}
+
+// releaseConn stops counting the connection of ctx.
+func (srv *Server) releaseConn(ctx Context) {
+	if counted, _ := ctx.Value(contextKeyConnCounted).(bool); !counted {
+		return
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.open--
+	source, ok := ctx.Value(contextKeyConnSource).(string)
+	if !ok {
+		return
+	}
+	if srv.sources[source]--; srv.sources[source] <= 0 {
+		delete(srv.sources, source)
+	}
+}
@@ -0,0 +1,94 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestConnSource(t *testing.T) {
+	t.Parallel()
+	srv := &Server{SourceBlockIPv4: 24, SourceBlockIPv6: 64}
+	for _, tt := range []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 22}, "192.0.2.0/24"},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.7"), Port: 22}, "192.0.2.0/24"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 22}, "2001:db8::/64"},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, ""},
+	} {
+		if got := srv.connSource(tt.addr); got != tt.want {
+			t.Fatalf("connSource(%v) = %q; want %q", tt.addr, got, tt.want)
+		}
+	}
+	if got, want := (&Server{}).connSource(&net.TCPAddr{IP: net.ParseIP("192.0.2.7")}), "192.0.2.7/32"; got != want {
+		t.Fatalf("connSource() = %q; want %q", got, want)
+	}
+}
+
+func TestMaxConnsPerSource(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		Handler:           func(s Session) {},
+		MaxConnsPerSource: 1,
+		PasswordHandler: func(ctx Context, password string) bool {
+			return true
+		},
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	dial := func() (*gossh.Client, error) {
+		return gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "testuser",
+			Auth:            []gossh.AuthMethod{gossh.Password("testpass")},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+	}
+	first, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := dial(); err == nil {
+		second.Close()
+		t.Fatal("second connection from the source accepted")
+	}
+	first.Close()
+	// the slot is freed once the server is done with the first connection
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		third, err := dial()
+		if err == nil {
+			third.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dial after the first connection ended: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		Handler:  func(s Session) {},
+		MaxConns: 1,
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	config := &gossh.ClientConfig{User: "testuser", HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	first, err := gossh.Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if second, err := gossh.Dial("tcp", l.Addr().String(), config); err == nil {
+		second.Close()
+		t.Fatal("second connection accepted")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"net"
 	"sync"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -44,6 +45,14 @@ var (
 	// The associated value will be of type net.Addr.
 	ContextKeyRemoteAddr = &contextKey{"remote-addr"}
 
+	// ContextKeyTransportLocalAddr is a context key for use with Contexts in this package.
+	// The associated value will be of type net.Addr.
+	ContextKeyTransportLocalAddr = &contextKey{"transport-local-addr"}
+
+	// ContextKeyTransportRemoteAddr is a context key for use with Contexts in this package.
+	// The associated value will be of type net.Addr.
+	ContextKeyTransportRemoteAddr = &contextKey{"transport-remote-addr"}
+
 	// ContextKeyServer is a context key for use with Contexts in this package.
 	// The associated value will be of type *Server.
 	ContextKeyServer = &contextKey{"ssh-server"}
@@ -78,12 +87,23 @@ type Context interface {
 	// ServerVersion returns the version reported by the server.
 	ServerVersion() string
 
-	// RemoteAddr returns the remote address for this connection.
+	// RemoteAddr returns the effective remote address for this connection:
+	// the client address claimed by a trusted proxy if the connection is a
+	// ForwardedConn accepted by the server's TrustForwardedCallback, and the
+	// transport address otherwise.
 	RemoteAddr() net.Addr
 
-	// LocalAddr returns the local address for this connection.
+	// LocalAddr returns the effective local address for this connection,
+	// following the same rules as RemoteAddr.
 	LocalAddr() net.Addr
 
+	// TransportRemoteAddr returns the address of the peer of the network
+	// connection, which is the proxy for forwarded connections.
+	TransportRemoteAddr() net.Addr
+
+	// TransportLocalAddr returns the local address of the network connection.
+	TransportLocalAddr() net.Addr
+
 	// Permissions returns the Permissions object used for this connection.
 	Permissions() *Permissions
 
@@ -94,11 +114,15 @@ type Context interface {
 type sshContext struct {
 	context.Context
 	*sync.Mutex
+
+	// values guards Context, which SetValue replaces while handlers of the
+	// connection may read it.
+	values sync.RWMutex
 }
 
 func newContext(srv *Server) (*sshContext, context.CancelFunc) {
 	innerCtx, cancel := context.WithCancel(context.Background())
-	ctx := &sshContext{innerCtx, &sync.Mutex{}}
+	ctx := &sshContext{Context: innerCtx, Mutex: &sync.Mutex{}}
 	ctx.SetValue(ContextKeyServer, srv)
 	perms := &Permissions{&gossh.Permissions{}}
 	ctx.SetValue(ContextKeyPermissions, perms)
@@ -115,14 +139,44 @@ func applyConnMetadata(ctx Context, conn gossh.ConnMetadata) {
 	ctx.SetValue(ContextKeyClientVersion, string(conn.ClientVersion()))
 	ctx.SetValue(ContextKeyServerVersion, string(conn.ServerVersion()))
 	ctx.SetValue(ContextKeyUser, conn.User())
-	ctx.SetValue(ContextKeyLocalAddr, conn.LocalAddr())
-	ctx.SetValue(ContextKeyRemoteAddr, conn.RemoteAddr())
+	// addresses are usually applied by the server when accepting the
+	// connection, since they may have been forwarded
+	if ctx.Value(ContextKeyRemoteAddr) == nil {
+		ctx.SetValue(ContextKeyTransportLocalAddr, conn.LocalAddr())
+		ctx.SetValue(ContextKeyTransportRemoteAddr, conn.RemoteAddr())
+		ctx.SetValue(ContextKeyLocalAddr, conn.LocalAddr())
+		ctx.SetValue(ContextKeyRemoteAddr, conn.RemoteAddr())
+	}
 }
 
 func (ctx *sshContext) SetValue(key, value interface{}) {
+	ctx.values.Lock()
+	defer ctx.values.Unlock()
 	ctx.Context = context.WithValue(ctx.Context, key, value)
 }
 
+func (ctx *sshContext) inner() context.Context {
+	ctx.values.RLock()
+	defer ctx.values.RUnlock()
+	return ctx.Context
+}
+
+func (ctx *sshContext) Value(key interface{}) interface{} {
+	return ctx.inner().Value(key)
+}
+
+func (ctx *sshContext) Done() <-chan struct{} {
+	return ctx.inner().Done()
+}
+
+func (ctx *sshContext) Err() error {
+	return ctx.inner().Err()
+}
+
+func (ctx *sshContext) Deadline() (time.Time, bool) {
+	return ctx.inner().Deadline()
+}
+
 func (ctx *sshContext) User() string {
 	return ctx.Value(ContextKeyUser).(string)
 }
@@ -147,6 +201,14 @@ func (ctx *sshContext) LocalAddr() net.Addr {
 	return ctx.Value(ContextKeyLocalAddr).(net.Addr)
 }
 
+func (ctx *sshContext) TransportRemoteAddr() net.Addr {
+	return ctx.Value(ContextKeyTransportRemoteAddr).(net.Addr)
+}
+
+func (ctx *sshContext) TransportLocalAddr() net.Addr {
+	return ctx.Value(ContextKeyTransportLocalAddr).(net.Addr)
+}
+
 func (ctx *sshContext) Permissions() *Permissions {
 	return ctx.Value(ContextKeyPermissions).(*Permissions)
 }
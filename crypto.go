@@ -0,0 +1,68 @@
+package ssh
+
+import gossh "golang.org/x/crypto/ssh"
+
+// CryptoParams are the algorithms negotiated for a connection.
+type CryptoParams struct {
+	KeyExchange    string
+	HostKey        string
+	ClientToServer CryptoDirection
+	ServerToClient CryptoDirection
+}
+
+// CryptoDirection are the algorithms protecting the data sent in one
+// direction. MAC is unused by AEAD ciphers such as aes128-gcm@openssh.com.
+type CryptoDirection struct {
+	Cipher string
+	MAC    string
+}
+
+// Insecure returns the negotiated algorithms that golang.org/x/crypto/ssh
+// considers insecure, such as SHA-1 based key exchanges, so operators can
+// alert on weak negotiations.
+func (p CryptoParams) Insecure() []string {
+	insecure := gossh.InsecureAlgorithms()
+	var names []string
+	add := func(name string, list []string) {
+		for _, algo := range list {
+			if name == algo {
+				names = append(names, name)
+				return
+			}
+		}
+	}
+	add(p.KeyExchange, insecure.KeyExchanges)
+	add(p.HostKey, insecure.HostKeys)
+	for _, dir := range []CryptoDirection{p.ClientToServer, p.ServerToClient} {
+		add(dir.Cipher, insecure.Ciphers)
+		add(dir.MAC, insecure.MACs)
+	}
+	return names
+}
+
+// ConnCrypto returns the algorithms currently used by the connection of ctx,
+// which change if the connection rekeys. It reports false before the
+// handshake completed.
+func ConnCrypto(ctx Context) (CryptoParams, bool) {
+	conn, ok := ctx.Value(ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return CryptoParams{}, false
+	}
+	meta, ok := conn.Conn.(gossh.AlgorithmsConnMetadata)
+	if !ok {
+		return CryptoParams{}, false
+	}
+	algos := meta.Algorithms()
+	return CryptoParams{
+		KeyExchange: algos.KeyExchange,
+		HostKey:     algos.HostKey,
+		ClientToServer: CryptoDirection{
+			Cipher: algos.Read.Cipher,
+			MAC:    algos.Read.MAC,
+		},
+		ServerToClient: CryptoDirection{
+			Cipher: algos.Write.Cipher,
+			MAC:    algos.Write.MAC,
+		},
+	}, true
+}
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"reflect"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestConnCrypto(t *testing.T) {
+	t.Parallel()
+	params := make(chan CryptoParams, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			p, _ := ConnCrypto(s.Context().(Context))
+			params <- p
+		},
+	}, &gossh.ClientConfig{
+		Config: gossh.Config{
+			KeyExchanges: []string{"curve25519-sha256"},
+			Ciphers:      []string{"aes256-ctr"},
+			MACs:         []string{"hmac-sha2-256-etm@openssh.com"},
+		},
+		User: "testuser",
+	})
+	defer cleanup()
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	got := <-params
+	dir := CryptoDirection{Cipher: "aes256-ctr", MAC: "hmac-sha2-256-etm@openssh.com"}
+	if got.KeyExchange != "curve25519-sha256" || got.ClientToServer != dir || got.ServerToClient != dir || got.HostKey == "" {
+		t.Fatalf("ConnCrypto() = %#v", got)
+	}
+	if insecure := got.Insecure(); len(insecure) != 0 {
+		t.Fatalf("Insecure() = %#v; want none", insecure)
+	}
+}
+
+func TestCryptoParamsInsecure(t *testing.T) {
+	t.Parallel()
+	p := CryptoParams{
+		KeyExchange:    "diffie-hellman-group1-sha1",
+		HostKey:        "ssh-ed25519",
+		ClientToServer: CryptoDirection{Cipher: "aes128-ctr", MAC: "hmac-sha1-96"},
+		ServerToClient: CryptoDirection{Cipher: "aes128-ctr", MAC: "hmac-sha2-256"},
+	}
+	want := []string{"diffie-hellman-group1-sha1", "hmac-sha1-96"}
+	if got := p.Insecure(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Insecure() = %#v; want %#v", got, want)
+	}
+}
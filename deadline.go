@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"sync"
+	"time"
+)
+
+// replyWithin runs a callback answering a request. If the request wants a
+// reply and the callback doesn't return within timeout, it is left running
+// and the request is answered with a failure, so a stalled callback can't
+// hold up the requests after it. A callback returning after that calls
+// late with its result, if not nil, to undo what the client was told
+// failed. A timeout of zero waits indefinitely.
+func replyWithin(timeout time.Duration, wantReply bool, fn func() (bool, []byte), late func(ok bool, payload []byte)) (bool, []byte) {
+	if timeout <= 0 || !wantReply {
+		return fn()
+	}
+	type reply struct {
+		ok      bool
+		payload []byte
+	}
+	var mu sync.Mutex
+	timedOut := false
+	result := make(chan reply, 1)
+	go func() {
+		ok, payload := fn()
+		mu.Lock()
+		replied := timedOut
+		if !replied {
+			result <- reply{ok, payload}
+		}
+		mu.Unlock()
+		if replied && late != nil {
+			late(ok, payload)
+		}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r.ok, r.payload
+	case <-timer.C:
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case r := <-result:
+		// returned as the timer fired
+		return r.ok, r.payload
+	default:
+	}
+	timedOut = true
+	return false, nil
+}
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReplyWithin(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	defer close(release)
+	ok, payload := replyWithin(20*time.Millisecond, true, func() (bool, []byte) {
+		<-release
+		return true, []byte("late")
+	}, nil)
+	if ok || payload != nil {
+		t.Fatalf("replyWithin() = %v, %#v; want false, nil", ok, payload)
+	}
+
+	ok, payload = replyWithin(time.Second, true, func() (bool, []byte) {
+		return true, []byte("ok")
+	}, nil)
+	if !ok || !bytes.Equal(payload, []byte("ok")) {
+		t.Fatalf("replyWithin() = %v, %#v; want true, %#v", ok, payload, []byte("ok"))
+	}
+
+	// Requests without a reply have nothing to answer, so they aren't cut
+	// short.
+	ok, _ = replyWithin(time.Millisecond, false, func() (bool, []byte) {
+		time.Sleep(20 * time.Millisecond)
+		return true, nil
+	}, nil)
+	if !ok {
+		t.Fatal("request without reply timed out")
+	}
+
+	// Callbacks returning after the reply hand their result to late.
+	late := make(chan []byte, 1)
+	ok, _ = replyWithin(time.Millisecond, true, func() (bool, []byte) {
+		time.Sleep(20 * time.Millisecond)
+		return true, []byte("late")
+	}, func(ok bool, payload []byte) {
+		late <- payload
+	})
+	if ok {
+		t.Fatal("late callback answered")
+	}
+	if payload := <-late; !bytes.Equal(payload, []byte("late")) {
+		t.Fatalf("late payload = %#v; want %#v", payload, []byte("late"))
+	}
+}
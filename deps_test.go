@@ -0,0 +1,46 @@
+package ssh
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// coreDependencies are the only packages outside the standard library the
+// core package may import; integrations needing more go in packages of their
+// own.
+var coreDependencies = map[string]bool{
+	"golang.org/x/crypto/ssh":     true,
+	"github.com/anmitsu/go-shlex": true,
+}
+
+func TestCoreDependencies(t *testing.T) {
+	t.Parallel()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			// standard library paths have no dot in their first element
+			if !strings.Contains(strings.Split(path, "/")[0], ".") {
+				continue
+			}
+			if !coreDependencies[path] {
+				t.Errorf("%s imports %s; move the integration to its own package", name, path)
+			}
+		}
+	}
+}
@@ -35,11 +35,23 @@ server's behavior is by creating a custom Server:
   log.Fatal(s.ListenAndServe())
 
 This package automatically handles basic SSH requests like setting environment
-variables, requesting PTY, and changing window size. These requests are
-processed, responded to, and any relevant state is updated. This state is then
-exposed to you via the Session interface.
-
-The one big feature missing from the Session abstraction is signals. This was
-started, but not completed. Pull Requests welcome!
+variables, requesting PTY, changing window size and sending signals. These
+requests are processed, responded to, and any relevant state is updated. This
+state is then exposed to you via the Session interface.
+
+This package only depends on golang.org/x/crypto and the standard library,
+besides a shell word splitter. Integrations with heavier requirements live in
+their own packages, imported only by the servers using them:
+
+  audit      JSON Lines, CEF and syslog output for Server.AuditCallback
+  sftp       the SFTP subsystem, registered in Server.SubsystemHandlers
+  vfs        filesystem backends and policy wrappers for file transfer
+  vfs/s3fs   a vfs backend for S3-compatible object storage
+  sshdump    recording and decoding of protocol messages
+  sshtest    test utilities for servers and handlers
+
+New integrations, such as metrics exporters or authentication against PAM,
+follow the same rule: the core exposes the callbacks or interfaces they plug
+into, and the integration is a package of its own.
 */
 package ssh
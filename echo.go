@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// maxKeystroke is the largest read from a PTY session counted as a
+// keystroke. Larger reads are pastes, whose echo takes longer.
+const maxKeystroke = 8
+
+// defaultEchoSample is the number of keystrokes per latency sample when
+// Server.EchoLatencySample is zero.
+const defaultEchoSample = 10
+
+// echoMeter samples the time between keystrokes read from a PTY session and
+// the next output, which for interactive programs is their echo.
+type echoMeter struct {
+	enabled int32
+
+	mu      sync.Mutex
+	sample  int
+	count   int
+	pending time.Time
+	samples int64
+	total   time.Duration
+
+	report func(time.Duration)
+}
+
+// enable starts measuring one in sample keystrokes, calling report with
+// each latency if not nil.
+func (m *echoMeter) enable(sample int, report func(time.Duration)) {
+	m.mu.Lock()
+	if sample <= 0 {
+		sample = defaultEchoSample
+	}
+	m.sample, m.report = sample, report
+	m.mu.Unlock()
+	atomic.StoreInt32(&m.enabled, 1)
+}
+
+func (m *echoMeter) read(n int) {
+	if n == 0 || n > maxKeystroke || atomic.LoadInt32(&m.enabled) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.pending.IsZero() {
+		return
+	}
+	m.count++
+	if m.count%m.sample == 0 {
+		m.pending = time.Now()
+	}
+}
+
+func (m *echoMeter) write() {
+	if atomic.LoadInt32(&m.enabled) == 0 {
+		return
+	}
+	m.mu.Lock()
+	if m.pending.IsZero() {
+		m.mu.Unlock()
+		return
+	}
+	d := time.Since(m.pending)
+	m.pending = time.Time{}
+	m.samples++
+	m.total += d
+	report := m.report
+	m.mu.Unlock()
+	if report != nil {
+		report(d)
+	}
+}
+
+func (m *echoMeter) stats() (int64, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.samples, m.total
+}
+
+// measureEcho starts sampling the echo latency of the PTY session on ch.
+func measureEcho(ctx Context, ch gossh.Channel) {
+	sc, ok := ch.(*statsChannel)
+	srv, _ := ctx.Value(ContextKeyServer).(*Server)
+	if !ok || srv == nil {
+		return
+	}
+	var report func(time.Duration)
+	if cb := srv.EchoLatencyCallback; cb != nil {
+		report = func(d time.Duration) { cb(ctx, d) }
+	}
+	sc.echo.enable(srv.EchoLatencySample, report)
+}
@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestEchoLatency(t *testing.T) {
+	t.Parallel()
+	latencies := make(chan time.Duration, 10)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			buf := make([]byte, 1)
+			for i := 0; i < 3; i++ {
+				if _, err := s.Read(buf); err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+				s.Write(buf)
+			}
+		},
+		EchoLatencySample: 1,
+		EchoLatencyCallback: func(ctx Context, latency time.Duration) {
+			latencies <- latency
+		},
+	}, nil)
+	defer cleanup()
+	if err := session.RequestPty("xterm", 24, 80, nil); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	for i := 0; i < 3; i++ {
+		stdin.Write([]byte("x"))
+		if _, err := io.ReadFull(stdout, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if latency := <-latencies; latency < 20*time.Millisecond || latency > time.Second {
+			t.Fatalf("latency = %v; want about 20ms", latency)
+		}
+	}
+}
+
+func TestEchoLatencyWithoutPty(t *testing.T) {
+	t.Parallel()
+	called := make(chan struct{}, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			buf := make([]byte, 1)
+			s.Read(buf)
+			s.Write(buf)
+		},
+		EchoLatencySample: 1,
+		EchoLatencyCallback: func(ctx Context, latency time.Duration) {
+			called <- struct{}{}
+		},
+	}, nil)
+	defer cleanup()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go stdin.Write([]byte("x"))
+	if _, err := session.Output(""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-called:
+		t.Fatal("EchoLatencyCallback called without a PTY")
+	default:
+	}
+}
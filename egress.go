@@ -0,0 +1,212 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// EgressRule allows or denies forwarding to the destinations it matches. A
+// rule matches a destination if each of its non-empty lists has a matching
+// entry.
+type EgressRule struct {
+	Deny bool // deny rather than allow matching destinations
+
+	// Users are path.Match patterns of the users the rule applies to.
+	Users []string
+
+	// Hosts are CIDR ranges such as "10.0.0.0/8", IP addresses, or
+	// path.Match patterns of host names such as "*.internal". Ranges and
+	// addresses only match destinations given as IP addresses, and
+	// patterns only host names, matched without regard to case or a
+	// trailing dot.
+	Hosts []string
+
+	// Ports are ports or ranges of ports such as "8000-8999".
+	Ports []string
+}
+
+// EgressPolicy is an ordered list of rules deciding where clients may
+// forward connections to, like the egress firewall of a bastion. The first
+// matching rule decides and destinations matching none are denied. Compile
+// it into an EgressFilter to use it as the LocalPortForwardingCallback of a
+// server.
+//
+// Note that a client can name a destination instead of giving its address,
+// so rules denying ranges of addresses should be combined with rules
+// denying the names that resolve to them.
+type EgressPolicy []EgressRule
+
+// ParseEgressPolicy parses a policy written one rule per line, as
+//
+//	allow|deny [user USERS] [to HOSTS] [port PORTS]
+//
+// where each list is separated by commas. Blank lines and lines starting
+// with # are ignored. For example:
+//
+//	# admins reach everything internal, others the web servers
+//	deny to 169.254.169.254
+//	allow user admin-* to 10.0.0.0/8,*.internal
+//	allow to 10.1.0.0/16 port 80,443,8000-8999
+func ParseEgressPolicy(text string) (EgressPolicy, error) {
+	var policy EgressPolicy
+	for n, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var rule EgressRule
+		switch fields[0] {
+		case "allow":
+		case "deny":
+			rule.Deny = true
+		default:
+			return nil, fmt.Errorf("ssh: egress policy line %d: unknown action %q", n+1, fields[0])
+		}
+		for i := 1; i < len(fields); i += 2 {
+			if i+1 == len(fields) {
+				return nil, fmt.Errorf("ssh: egress policy line %d: missing list after %q", n+1, fields[i])
+			}
+			list := strings.Split(fields[i+1], ",")
+			switch fields[i] {
+			case "user":
+				rule.Users = append(rule.Users, list...)
+			case "to":
+				rule.Hosts = append(rule.Hosts, list...)
+			case "port":
+				rule.Ports = append(rule.Ports, list...)
+			default:
+				return nil, fmt.Errorf("ssh: egress policy line %d: unknown keyword %q", n+1, fields[i])
+			}
+		}
+		policy = append(policy, rule)
+	}
+	if _, err := policy.Compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// EgressFilter is a compiled EgressPolicy.
+type EgressFilter struct {
+	rules []egressRule
+}
+
+type egressRule struct {
+	deny  bool
+	users []string
+	nets  []*net.IPNet
+	names []string
+	ports [][2]uint32
+	hosts bool // whether the rule lists hosts
+}
+
+// Compile checks the patterns, ranges and ports of the policy and returns
+// its filter.
+func (p EgressPolicy) Compile() (*EgressFilter, error) {
+	f := &EgressFilter{}
+	for i, r := range p {
+		rule := egressRule{deny: r.Deny, hosts: len(r.Hosts) > 0}
+		for _, user := range r.Users {
+			if _, err := path.Match(user, ""); err != nil {
+				return nil, fmt.Errorf("ssh: egress rule %d: bad user pattern %q", i+1, user)
+			}
+			rule.users = append(rule.users, user)
+		}
+		for _, host := range r.Hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				rule.nets = append(rule.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			} else if _, n, err := net.ParseCIDR(host); err == nil {
+				rule.nets = append(rule.nets, n)
+			} else if _, err := path.Match(host, ""); err != nil || strings.Contains(host, "/") {
+				return nil, fmt.Errorf("ssh: egress rule %d: bad host %q", i+1, host)
+			} else {
+				rule.names = append(rule.names, canonicalHost(host))
+			}
+		}
+		for _, port := range r.Ports {
+			lo, hi, found := strings.Cut(port, "-")
+			if !found {
+				hi = lo
+			}
+			from, err1 := strconv.ParseUint(lo, 10, 16)
+			to, err2 := strconv.ParseUint(hi, 10, 16)
+			if err1 != nil || err2 != nil || from > to {
+				return nil, fmt.Errorf("ssh: egress rule %d: bad port %q", i+1, port)
+			}
+			rule.ports = append(rule.ports, [2]uint32{uint32(from), uint32(to)})
+		}
+		f.rules = append(f.rules, rule)
+	}
+	return f, nil
+}
+
+// Allowed reports whether user may forward connections to host and port.
+func (f *EgressFilter) Allowed(user, host string, port uint32) bool {
+	for _, rule := range f.rules {
+		if rule.matches(user, host, port) {
+			return !rule.deny
+		}
+	}
+	return false
+}
+
+// LocalPortForwarding is a LocalPortForwardingCallback applying the filter
+// to the user of ctx.
+func (f *EgressFilter) LocalPortForwarding(ctx Context, host string, port uint32) bool {
+	return f.Allowed(ctx.User(), host, port)
+}
+
+func (r *egressRule) matches(user, host string, port uint32) bool {
+	if len(r.users) > 0 && !matchAny(r.users, user) {
+		return false
+	}
+	if r.hosts {
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		switch {
+		case ip != nil && !r.containsIP(ip):
+			return false
+		case ip == nil && !matchAny(r.names, canonicalHost(host)):
+			return false
+		}
+	}
+	if len(r.ports) == 0 {
+		return true
+	}
+	for _, ports := range r.ports {
+		if port >= ports[0] && port <= ports[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *egressRule) containsIP(ip net.IP) bool {
+	for _, n := range r.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHost returns the host name as matched by the host patterns, so
+// "DB.internal." can't get around a rule for "db.internal".
+func canonicalHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
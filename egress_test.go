@@ -0,0 +1,77 @@
+package ssh
+
+import "testing"
+
+func TestEgressPolicy(t *testing.T) {
+	t.Parallel()
+	policy, err := ParseEgressPolicy(`
+		# metadata service is off limits
+		deny to 169.254.169.254
+		allow user admin-* to 10.0.0.0/8,*.Internal
+		allow to 10.1.0.0/16,2001:db8::/32 port 80,443,8000-8999
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := policy.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		user, host string
+		port       uint32
+		want       bool
+	}{
+		{"admin-alice", "169.254.169.254", 80, false},
+		{"admin-alice", "10.9.8.7", 22, true},
+		{"admin-alice", "db.internal", 5432, true},
+		{"admin-alice", "example.com", 443, false},
+		{"bob", "10.9.8.7", 443, false},
+		{"bob", "10.1.2.3", 443, true},
+		{"bob", "10.1.2.3", 8080, true},
+		{"bob", "10.1.2.3", 22, false},
+		{"bob", "[2001:db8::1]", 80, true},
+		{"bob", "db.internal", 80, false},
+	} {
+		if got := filter.Allowed(tt.user, tt.host, tt.port); got != tt.want {
+			t.Errorf("Allowed(%s, %s, %d) = %v; want %v", tt.user, tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestEgressPolicyHostNames(t *testing.T) {
+	t.Parallel()
+	policy, err := ParseEgressPolicy(`
+		deny to metadata.internal
+		allow to *
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := policy.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"metadata.internal", "Metadata.Internal", "metadata.internal."} {
+		if filter.Allowed("bob", host, 80) {
+			t.Fatalf("Allowed(%#v) = true; want false", host)
+		}
+	}
+}
+
+func TestEgressPolicyErrors(t *testing.T) {
+	t.Parallel()
+	for _, text := range []string{
+		"permit to 10.0.0.0/8",
+		"allow to",
+		"allow from 10.0.0.0/8",
+		"allow to 10.0.0.0/33",
+		"allow port 9000-8000",
+		"allow port 70000",
+		"allow user [",
+	} {
+		if _, err := ParseEgressPolicy(text); err == nil {
+			t.Errorf("ParseEgressPolicy(%#v) succeeded; want an error", text)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrCredentialsExpired is the close error of connections that outlived the
+// validity of their Permissions, such as that of a user certificate.
+var ErrCredentialsExpired = errors.New("ssh: credentials expired")
+
+// expiry closes a connection once the validity of its permissions ends.
+type expiry struct {
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// watchExpiry bounds the connection of ctx, and so its sessions and
+// forwards, by the end of the validity of its Permissions, as set by a
+// CertificateAuthority from the valid-before time of the certificate.
+// Setting a new validity, for example after the client presented a renewed
+// certificate, takes effect on the next call.
+func (srv *Server) watchExpiry(ctx Context, conn *serverConn) {
+	_, before := ctx.Permissions().Validity()
+	conn.expiry.mu.Lock()
+	defer conn.expiry.mu.Unlock()
+	if conn.expiry.timer != nil {
+		conn.expiry.timer.Stop()
+		conn.expiry.timer = nil
+	}
+	if before.IsZero() {
+		return
+	}
+	conn.expiry.timer = time.AfterFunc(time.Until(before), func() {
+		srv.expire(ctx, conn)
+	})
+}
+
+// stopExpiry stops watching the expiry of conn once it has ended.
+func (c *serverConn) stopExpiry() {
+	c.expiry.mu.Lock()
+	defer c.expiry.mu.Unlock()
+	if c.expiry.timer != nil {
+		c.expiry.timer.Stop()
+	}
+}
+
+// expire tells the sessions of the connection of ctx that its credentials
+// expired and closes it.
+func (srv *Server) expire(ctx Context, conn *serverConn) {
+	msg := message(ctx, MessageCredentialsExpired)
+	srv.mu.Lock()
+	var sessions []*statsChannel
+	for c := range srv.channels {
+		if c.ctx == ctx && c.chanType == "session" {
+			sessions = append(sessions, c)
+		}
+	}
+	srv.mu.Unlock()
+	stderrs := make([]io.Writer, len(sessions))
+	for i, c := range sessions {
+		stderrs[i] = c.Stderr()
+	}
+	writeNotice(msg, stderrs...)
+	conn.setCloseReason(CloseReasonCredentialsExpired, ErrCredentialsExpired)
+	conn.Close()
+}
@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialsExpiry(t *testing.T) {
+	t.Parallel()
+	reasons := make(chan CloseReason, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			<-s.Context().Done()
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			ctx.Permissions().SetValidity(time.Time{}, time.Now().Add(2*time.Second))
+			return true
+		},
+		ConnCloseCallback: func(ctx Context, reason CloseReason, err error) {
+			reasons <- reason
+		},
+	}, nil)
+	defer cleanup()
+	stdin, w := io.Pipe()
+	defer w.Close()
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stderr = &stderr
+	start := time.Now()
+	if err := session.Run(""); err == nil {
+		t.Fatal("session outlived the credentials")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("session closed after %v; want about 2s", d)
+	}
+	if got, want := stderr.String(), defaultMessages[MessageCredentialsExpired]; !strings.Contains(got, want) {
+		t.Fatalf("stderr = %q; want %q", got, want)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != CloseReasonCredentialsExpired {
+			t.Fatalf("reason = %s; want %s", reason, CloseReasonCredentialsExpired)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnCloseCallback not called")
+	}
+}
+
+func TestCredentialsExpiryStalledClient(t *testing.T) {
+	t.Parallel()
+	reasons := make(chan CloseReason, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			// fill the window of the channel
+			buf := make([]byte, 32<<10)
+			for {
+				if _, err := s.Write(buf); err != nil {
+					return
+				}
+			}
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			ctx.Permissions().SetValidity(time.Time{}, time.Now().Add(time.Second))
+			return true
+		},
+		ConnCloseCallback: func(ctx Context, reason CloseReason, err error) {
+			reasons <- reason
+		},
+	}, nil)
+	defer cleanup()
+	if _, err := session.StdoutPipe(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != CloseReasonCredentialsExpired {
+			t.Fatalf("reason = %s; want %s", reason, CloseReasonCredentialsExpired)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection of a client not reading outlived the credentials")
+	}
+}
@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// defaultQuantum is the number of bytes a queue of a FairScheduler writes
+// per turn by default.
+const defaultQuantum = 32 * 1024
+
+// FairScheduler shares the outbound bandwidth of a server among the users
+// writing to channels, so bulk transfers can't starve interactive sessions
+// once the bandwidth is saturated. Writes are paced to Rate and split into
+// chunks of Quantum bytes, and the queues of the users take turns writing a
+// chunk, so a user waits for at most one chunk of each other user. The
+// channels of a user also take turns among themselves.
+//
+// Set it as the Scheduler of a server, which schedules the session,
+// direct-tcpip and forwarded-tcpip channels. A FairScheduler may be shared
+// by several servers, and must not be copied after first use.
+type FairScheduler struct {
+	Rate    int                      // bytes per second shared by all channels, no scheduling if zero
+	Quantum int                      // bytes written per turn, 32KB if zero
+	Key     func(ctx Context) string // queue of the channels of a connection, ctx.User() if nil
+
+	mu      sync.Mutex
+	queues  map[string][]*fairWaiter
+	order   []string  // keys of the queues, in the order of their turns
+	running bool      // whether run gives turns
+	free    time.Time // when the bandwidth used by the previous turns is free
+}
+
+// fairWaiter is a write waiting for its turn.
+type fairWaiter struct {
+	n        int
+	turn     chan struct{}
+	canceled bool
+}
+
+func (s *FairScheduler) quantum() int {
+	if s.Quantum > 0 {
+		return s.Quantum
+	}
+	return defaultQuantum
+}
+
+func (s *FairScheduler) key(ctx Context) string {
+	if s.Key != nil {
+		return s.Key(ctx)
+	}
+	return ctx.User()
+}
+
+// wait blocks until the queue key gets its turn to write n bytes, or done
+// is closed.
+func (s *FairScheduler) wait(key string, n int, done <-chan struct{}) bool {
+	w := &fairWaiter{n: n, turn: make(chan struct{}, 1)}
+	s.mu.Lock()
+	if s.queues == nil {
+		s.queues = make(map[string][]*fairWaiter)
+	}
+	if len(s.queues[key]) == 0 {
+		s.order = append(s.order, key)
+	}
+	s.queues[key] = append(s.queues[key], w)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+	s.mu.Unlock()
+	select {
+	case <-w.turn:
+		return true
+	case <-done:
+		s.mu.Lock()
+		w.canceled = true
+		s.mu.Unlock()
+		return false
+	}
+}
+
+// pop removes the waiter whose turn is next, or returns nil if there is
+// none.
+func (s *FairScheduler) pop() *fairWaiter {
+	for len(s.order) > 0 {
+		key := s.order[0]
+		s.order = s.order[1:]
+		queue := s.queues[key]
+		w := queue[0]
+		if len(queue) > 1 {
+			s.queues[key] = queue[1:]
+			s.order = append(s.order, key)
+		} else {
+			delete(s.queues, key)
+		}
+		if !w.canceled {
+			return w
+		}
+	}
+	return nil
+}
+
+// run gives the waiters their turns, paced to the rate, until none are left.
+func (s *FairScheduler) run() {
+	for {
+		s.mu.Lock()
+		w := s.pop()
+		if w == nil {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		free := s.free
+		s.mu.Unlock()
+		if d := time.Until(free); d > 0 {
+			time.Sleep(d)
+		} else {
+			free = time.Now()
+		}
+		s.mu.Lock()
+		s.free = free.Add(time.Duration(w.n) * time.Second / time.Duration(s.Rate))
+		s.mu.Unlock()
+		w.turn <- struct{}{}
+	}
+}
+
+// channel returns ch with its writes scheduled, or ch if s doesn't schedule.
+func (s *FairScheduler) channel(ctx Context, ch gossh.Channel) gossh.Channel {
+	if s == nil || s.Rate <= 0 {
+		return ch
+	}
+	return &fairChannel{Channel: ch, s: s, key: s.key(ctx), done: ctx.Done()}
+}
+
+// write writes p to w in chunks, waiting for the turn of each.
+func (s *FairScheduler) write(w io.Writer, key string, done <-chan struct{}, p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := s.quantum()
+		if n > len(p) {
+			n = len(p)
+		}
+		if !s.wait(key, n, done) {
+			return written, io.ErrClosedPipe
+		}
+		m, err := w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// fairChannel is a channel whose writes are scheduled by a FairScheduler.
+type fairChannel struct {
+	gossh.Channel
+	s    *FairScheduler
+	key  string
+	done <-chan struct{}
+}
+
+func (c *fairChannel) Write(p []byte) (int, error) {
+	return c.s.write(c.Channel, c.key, c.done, p)
+}
+
+func (c *fairChannel) Stderr() io.ReadWriter {
+	return &fairStderr{c.Channel.Stderr(), c}
+}
+
+type fairStderr struct {
+	io.ReadWriter
+	c *fairChannel
+}
+
+func (s *fairStderr) Write(p []byte) (int, error) {
+	return s.c.s.write(s.ReadWriter, s.c.key, s.c.done, p)
+}
@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFairSchedulerTurns(t *testing.T) {
+	t.Parallel()
+	s := &FairScheduler{Rate: 1 << 20, Quantum: 64 * 1024}
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for s.wait("bulk", s.quantum(), done) {
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	// ten bulk writers queued in order would take 640ms
+	start := time.Now()
+	if !s.wait("interactive", 1, done) {
+		t.Fatal("wait() = false; want true")
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Fatalf("interactive wait = %v; want at most %v", d, 300*time.Millisecond)
+	}
+}
+
+func TestFairSchedulerRate(t *testing.T) {
+	t.Parallel()
+	s := &FairScheduler{Rate: 1 << 20, Quantum: 16 * 1024}
+	var buf bytes.Buffer
+	start := time.Now()
+	n, err := s.write(&buf, "user", nil, make([]byte, 256*1024))
+	if n != 256*1024 || err != nil {
+		t.Fatalf("write() = %#v, %#v; want %#v, nil", n, err, 256*1024)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("write took %v; want at least %v", d, 200*time.Millisecond)
+	}
+}
+
+func TestFairSchedulerSession(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			s.Write(make([]byte, 100*1024))
+			s.Stderr().Write([]byte("done"))
+		},
+		Scheduler: &FairScheduler{Rate: 10 << 20, Quantum: 8 * 1024},
+	}, nil)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Len() != 100*1024 || stderr.String() != "done" {
+		t.Fatalf("output = %#v, %#v; want %#v, %#v", stdout.Len(), stderr.String(), 100*1024, "done")
+	}
+}
@@ -0,0 +1,79 @@
+package ssh
+
+// Feature names a protocol feature that can be turned off at runtime with
+// DisableFeature. X11 forwarding isn't implemented by this package, so it is
+// always refused and has no feature.
+type Feature string
+
+// Features of the server, all enabled by default. Each only takes effect if
+// the handler implementing it is registered and its callbacks allow the
+// request.
+const (
+	FeatureLocalForwarding   Feature = "local-forwarding"   // channels of DirectTCPIPHandler and DirectStreamLocalHandler
+	FeatureReverseForwarding Feature = "reverse-forwarding" // requests of ForwardedTCPHandler and ForwardedStreamLocalHandler
+	FeatureTunnels           Feature = "tunnels"            // tcpip-forward requests of TunnelRegistry
+	FeatureAgentForwarding   Feature = "agent-forwarding"   // auth-agent-req@openssh.com session requests
+	FeatureSFTP              Feature = "sftp"               // the "sftp" subsystem
+)
+
+// knownFeatures are the features reported by Features.
+var knownFeatures = []Feature{
+	FeatureLocalForwarding,
+	FeatureReverseForwarding,
+	FeatureTunnels,
+	FeatureAgentForwarding,
+	FeatureSFTP,
+}
+
+// EnableFeature enables f again after DisableFeature.
+func (srv *Server) EnableFeature(f Feature) {
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.disabled, f)
+}
+
+// DisableFeature refuses new requests for f, on all connections, until
+// EnableFeature is called. Channels are rejected as prohibited and requests
+// are answered with a failure, with MessageFeatureDisabled where the
+// protocol carries a message. Forwardings and sessions already established
+// keep running.
+func (srv *Server) DisableFeature(f Feature) {
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.disabled == nil {
+		srv.disabled = make(map[Feature]bool)
+	}
+	srv.disabled[f] = true
+}
+
+// FeatureEnabled reports whether f is enabled.
+func (srv *Server) FeatureEnabled(f Feature) bool {
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return !srv.disabled[f]
+}
+
+// Features reports whether each feature of the package is enabled, along
+// with any other feature disabled by name.
+func (srv *Server) Features() map[Feature]bool {
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	features := make(map[Feature]bool, len(knownFeatures))
+	for _, f := range knownFeatures {
+		features[f] = true
+	}
+	for f := range srv.disabled {
+		features[f] = false
+	}
+	return features
+}
+
+// featureEnabled reports whether f is enabled on the server of ctx.
+func featureEnabled(ctx Context, f Feature) bool {
+	srv, ok := ctx.Value(ContextKeyServer).(*Server)
+	return !ok || srv.FeatureEnabled(f)
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	t.Parallel()
+	l := sampleSocketServer()
+	defer l.Close()
+	srv := &Server{
+		Handler: func(s Session) {},
+		LocalPortForwardingCallback: func(ctx Context, destinationHost string, destinationPort uint32) bool {
+			return true
+		},
+		ForwardAddressPolicy: &AddressPolicy{AllowLoopback: true},
+	}
+	srv.DisableFeature(FeatureLocalForwarding)
+	srv.DisableFeature(FeatureAgentForwarding)
+	session, client, cleanup := newTestSession(t, srv, nil)
+	defer cleanup()
+
+	_, err := client.Dial("tcp", l.Addr().String())
+	if err == nil || !strings.Contains(err.Error(), "local-forwarding is disabled") {
+		t.Fatalf("Dial err = %v; want local-forwarding is disabled", err)
+	}
+	if ok, err := session.SendRequest(agentRequestType, true, nil); err != nil || ok {
+		t.Fatalf("agent request = %v, %v; want false", ok, err)
+	}
+
+	features := srv.Features()
+	if features[FeatureLocalForwarding] || !features[FeatureSFTP] {
+		t.Fatalf("Features() = %#v; want local-forwarding disabled, sftp enabled", features)
+	}
+	srv.EnableFeature(FeatureLocalForwarding)
+	if !srv.FeatureEnabled(FeatureLocalForwarding) {
+		t.Fatalf("FeatureEnabled(%q) = false after EnableFeature", FeatureLocalForwarding)
+	}
+	conn, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,119 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/anmitsu/go-shlex"
+)
+
+// gitShellCommands are the commands a GitShell permits, mapped to whether they
+// modify the repository.
+var gitShellCommands = map[string]bool{
+	"git-upload-pack":    false,
+	"git-upload-archive": false,
+	"git-receive-pack":   true,
+}
+
+// GitAccessCallback is a hook for allowing access to a repository. write is
+// true for pushes.
+type GitAccessCallback func(ctx Context, repo string, write bool) bool
+
+// GitShell is a Handler reproducing the behavior of git-shell: only
+// git-upload-pack, git-upload-archive and git-receive-pack may be run, on a
+// single repository path that is resolved inside Root. Interactive shells are
+// refused. Use GitShellOnly to also deny PTYs, shells and forwarding on the
+// server.
+type GitShell struct {
+	Root           string            // directory repository paths are resolved against
+	AccessCallback GitAccessCallback // callback for allowing repository access, allows all if nil
+}
+
+// GitShellOnly returns a functional option that configures the server to
+// serve only git commands with g. Shell and PTY requests are refused, and
+// port, Unix socket and agent forwarding are disabled.
+func GitShellOnly(g *GitShell) Option {
+	return func(srv *Server) error {
+		srv.Handler = g.HandleSession
+		srv.PtyCallback = func(ctx Context, pty Pty) bool {
+			return false
+		}
+		srv.SessionRequestCallback = func(sess Session, requestType string) bool {
+			return requestType == "exec"
+		}
+		disableForwarding(srv)
+		return nil
+	}
+}
+
+func (g *GitShell) HandleSession(s Session) {
+	if s.RawCommand() == "" {
+		ctx, _ := s.Context().(Context)
+		fmt.Fprintln(s.Stderr(), message(ctx, MessageGitShell))
+		s.Exit(128)
+		return
+	}
+	cmd, err := g.Command(s)
+	if err != nil {
+		fmt.Fprintf(s.Stderr(), "fatal: %v\n", err)
+		s.Exit(128)
+		return
+	}
+	runCommand(s, cmd)
+}
+
+// Command returns the git command that HandleSession runs for the session.
+func (g *GitShell) Command(s Session) (*exec.Cmd, error) {
+	args, err := shlex.Split(s.RawCommand(), true)
+	if err != nil || len(args) == 0 {
+		return nil, errors.New("unrecognized command")
+	}
+	// "git upload-pack" is equivalent to "git-upload-pack"
+	if args[0] == "git" && len(args) > 1 {
+		args = append([]string{"git-" + args[1]}, args[2:]...)
+	}
+	write, ok := gitShellCommands[args[0]]
+	if !ok || len(args) != 2 {
+		return nil, fmt.Errorf("unrecognized command '%s'", s.RawCommand())
+	}
+	repo, err := sanitizeRepoPath(args[1])
+	if err != nil {
+		return nil, err
+	}
+	ctx, _ := s.Context().(Context)
+	if g.AccessCallback != nil && !g.AccessCallback(ctx, repo, write) {
+		return nil, errors.New("access denied")
+	}
+	cmd := exec.Command(args[0], filepath.Join(g.Root, filepath.FromSlash(repo)))
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	for _, kv := range s.Environ() {
+		if strings.HasPrefix(kv, "GIT_PROTOCOL=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	return cmd, nil
+}
+
+// sanitizeRepoPath returns repo as a clean relative path, refusing paths that
+// escape the root or could be mistaken for options.
+func sanitizeRepoPath(repo string) (string, error) {
+	repo = strings.TrimPrefix(repo, "~/")
+	if repo == "" || strings.ContainsRune(repo, 0) || strings.Contains(repo, "\\") {
+		return "", errors.New("invalid repository path")
+	}
+	for _, elem := range strings.Split(repo, "/") {
+		if elem == ".." || strings.HasPrefix(elem, "-") {
+			return "", errors.New("invalid repository path")
+		}
+	}
+	clean := strings.TrimPrefix(path.Clean("/"+repo), "/")
+	if clean == "" {
+		return "", errors.New("invalid repository path")
+	}
+	return clean, nil
+}
@@ -0,0 +1,43 @@
+package ssh
+
+import (
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSanitizeRepoPath(t *testing.T) {
+	t.Parallel()
+	for repo, want := range map[string]string{
+		"project.git":       "project.git",
+		"/srv/project.git":  "srv/project.git",
+		"~/project.git":     "project.git",
+		"team//project.git": "team/project.git",
+		"../etc":            "",
+		"a/../../etc":       "",
+		"--upload-pack=sh":  "",
+		"":                  "",
+	} {
+		got, err := sanitizeRepoPath(repo)
+		if want == "" && err == nil {
+			t.Errorf("sanitizeRepoPath(%#v) = %#v; want error", repo, got)
+		}
+		if want != "" && got != want {
+			t.Errorf("sanitizeRepoPath(%#v) = %#v, %v; want %#v", repo, got, err, want)
+		}
+	}
+}
+
+func TestGitShellRefusesCommands(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSessionWithOptions(t, &Server{}, nil, GitShellOnly(&GitShell{Root: "/nonexistent"}))
+	defer cleanup()
+	err := session.Run("ls /")
+	e, ok := err.(*gossh.ExitError)
+	if !ok {
+		t.Fatalf("expected ExitError but got %T", err)
+	}
+	if e.ExitStatus() != 128 {
+		t.Fatalf("exit-status = %#v; want %#v", e.ExitStatus(), 128)
+	}
+}
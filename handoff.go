@@ -0,0 +1,218 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The environment variables of the handoff protocol, set by Handoff for the
+// new process:
+//
+//	GLIDERLABS_SSH_LISTEN_FDS    number of listeners, passed as fds 3, 4, ...
+//	GLIDERLABS_SSH_LISTEN_ADDRS  comma separated addresses of the listeners
+//	GLIDERLABS_SSH_READY_FD      fd to write "ready\n" to once serving
+//
+// The new process takes over the listeners with InheritedListeners, serves
+// them and then calls HandoffReady. Until then the old process keeps
+// accepting connections on the same sockets, so none are refused. Once
+// ready, the old process stops accepting and drains or closes its
+// connections. If the new process exits or closes the fd without reporting
+// ready, the old process carries on serving as before.
+const (
+	EnvListenFDs   = "GLIDERLABS_SSH_LISTEN_FDS"
+	EnvListenAddrs = "GLIDERLABS_SSH_LISTEN_ADDRS"
+	EnvReadyFD     = "GLIDERLABS_SSH_READY_FD"
+)
+
+// handoffReady is written to the ready fd by the new process.
+const handoffReady = "ready\n"
+
+// Handoff configures the process started by Server.Handoff.
+type Handoff struct {
+	Path   string    // executable to run, the current one if empty
+	Args   []string  // arguments, not including the program name
+	Env    []string  // additional environment variables
+	Stdout io.Writer // standard output of the process, discarded if nil
+	Stderr io.Writer // standard error of the process, discarded if nil
+
+	// Drain waits for the sessions of the old process to end after the
+	// new process is ready, like Shutdown, instead of closing them.
+	Drain bool
+}
+
+// Handoff starts a new process taking over the listeners of the server, for
+// upgrading the binary without downtime, following the protocol described
+// at EnvListenFDs. Once the new process reported being ready, the server
+// stops serving like Shutdown with ctx, or like Close unless h.Drain is
+// set. ctx also bounds the wait for the new process, which is killed if it
+// isn't ready in time.
+//
+// Only listeners with a File method, such as those of TCP and Unix sockets,
+// can be handed off. Handoff returns the new process, which is left
+// running if the server fails to stop.
+func (srv *Server) Handoff(ctx context.Context, h *Handoff) (*os.Process, error) {
+	srv.init()
+	files, addrs, err := srv.listenerFiles()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	path := h.Path
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			return nil, err
+		}
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(path, h.Args...)
+	cmd.Stdout, cmd.Stderr = h.Stdout, h.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(handoffEnviron(), h.Env...)
+	cmd.Env = append(cmd.Env,
+		EnvListenFDs+"="+strconv.Itoa(len(files)),
+		EnvListenAddrs+"="+strings.Join(addrs, ","),
+		EnvReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+	go cmd.Wait()
+
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(handoffReady))
+		_, err := io.ReadFull(ready, buf)
+		if err == nil && string(buf) != handoffReady {
+			err = fmt.Errorf("ssh: handoff: unexpected message %q", buf)
+		} else if err != nil {
+			err = fmt.Errorf("ssh: handoff: new process not ready: %v", err)
+		}
+		result <- err
+	}()
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	if h.Drain {
+		return cmd.Process, srv.Shutdown(ctx)
+	}
+	return cmd.Process, srv.Close()
+}
+
+// listenerFiles duplicates the files of the listeners of the server, in the
+// order of their addresses.
+func (srv *Server) listenerFiles() ([]*os.File, []string, error) {
+	srv.mu.Lock()
+	listeners := make([]net.Listener, 0, len(srv.listeners))
+	for ln := range srv.listeners {
+		listeners = append(listeners, ln)
+	}
+	srv.mu.Unlock()
+	if len(listeners) == 0 {
+		return nil, nil, ErrServerNotServing
+	}
+	sort.Slice(listeners, func(i, j int) bool {
+		return listeners[i].Addr().String() < listeners[j].Addr().String()
+	})
+	var files []*os.File
+	var addrs []string
+	for _, ln := range listeners {
+		filer, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return files, nil, fmt.Errorf("ssh: handoff: listener %s has no file", ln.Addr())
+		}
+		f, err := filer.File()
+		if err != nil {
+			return files, nil, err
+		}
+		files = append(files, f)
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return files, addrs, nil
+}
+
+// handoffEnviron returns the environment of the process without the
+// variables of the handoff protocol.
+func handoffEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if name != EnvListenFDs && name != EnvListenAddrs && name != EnvReadyFD {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// InheritedListeners returns the listeners handed off to this process by
+// Server.Handoff, in the order of their addresses, or none if the process
+// wasn't started by it. The variables of the protocol are removed from the
+// environment, so the listeners are only taken over once.
+func InheritedListeners() ([]net.Listener, error) {
+	count := os.Getenv(EnvListenFDs)
+	if count == "" {
+		return nil, nil
+	}
+	os.Unsetenv(EnvListenFDs)
+	os.Unsetenv(EnvListenAddrs)
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("ssh: handoff: invalid %s %q", EnvListenFDs, count)
+	}
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(3+i), "listener")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// HandoffReady tells the process that called Server.Handoff that this one
+// serves the inherited listeners, so it can stop. It does nothing if the
+// process wasn't started by Handoff, and only reports once.
+func HandoffReady() error {
+	fd := os.Getenv(EnvReadyFD)
+	if fd == "" {
+		return nil
+	}
+	os.Unsetenv(EnvReadyFD)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("ssh: handoff: invalid %s %q", EnvReadyFD, fd)
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	_, err = io.WriteString(f, handoffReady)
+	return err
+}
@@ -0,0 +1,120 @@
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package ssh
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// TestHandoffHelper is the new process started by TestHandoff.
+func TestHandoffHelper(t *testing.T) {
+	if os.Getenv("GLIDERLABS_SSH_HANDOFF_HELPER") == "" {
+		t.Skip("helper process")
+	}
+	listeners, err := InheritedListeners()
+	if err != nil || len(listeners) != 1 {
+		t.Fatalf("InheritedListeners() = %#v, %#v; want one listener", listeners, err)
+	}
+	done := make(chan struct{})
+	srv := &Server{Handler: func(s Session) {
+		io.WriteString(s, "new")
+		close(done)
+	}}
+	go srv.Serve(listeners[0])
+	if err := HandoffReady(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+	}
+	srv.Shutdown(context.Background())
+}
+
+func TestHandoff(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	srv := &Server{Handler: func(s Session) {
+		<-release
+		io.WriteString(s, "old")
+	}}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	addr := l.Addr().String()
+
+	// a session of the old process, drained by the handoff
+	old, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	oldSession, err := old.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldOut := make(chan string, 1)
+	go func() {
+		out, _ := oldSession.Output("")
+		old.Close()
+		oldOut <- string(out)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		close(release)
+	}()
+	proc, err := srv.Handoff(ctx, &Handoff{
+		Path:   os.Args[0],
+		Args:   []string{"-test.run=TestHandoffHelper"},
+		Env:    []string{"GLIDERLABS_SSH_HANDOFF_HELPER=1"},
+		Stderr: os.Stderr,
+		Drain:  true,
+	})
+	if err != nil {
+		t.Fatalf("Handoff() = %#v; want nil", err)
+	}
+	defer proc.Kill()
+	if out := <-oldOut; out != "old" {
+		t.Fatalf("old output = %#v; want %#v", out, "old")
+	}
+
+	client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := session.Output(""); err != nil || string(out) != "new" {
+		t.Fatalf("new output = %#v, %#v; want %#v", string(out), err, "new")
+	}
+}
+
+func TestHandoffNotReady(t *testing.T) {
+	t.Parallel()
+	srv := &Server{Handler: func(s Session) {}}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	waitServing(srv)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := srv.Handoff(ctx, &Handoff{Path: "/bin/true"}); err == nil {
+		t.Fatal("Handoff() = nil; want error")
+	}
+	if err := srv.Healthy(); err != nil {
+		t.Fatalf("Healthy() = %#v; want nil", err)
+	}
+}
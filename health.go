@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ErrServerNotServing is returned by Healthy when the server has no
+// listener to accept connections on.
+var ErrServerNotServing = errors.New("ssh: Server not serving")
+
+// selfTestUser is the user the self-test authenticates as.
+const selfTestUser = "gliderlabs-selftest"
+
+// Healthy reports whether the server accepts connections. It returns
+// ErrServerClosed once Close or Shutdown was called, and ErrServerNotServing
+// while no Serve call is running. Servers fed only through HandleConn should
+// use SelfTest instead.
+func (srv *Server) Healthy() error {
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closing {
+		return ErrServerClosed
+	}
+	select {
+	case <-srv.getDoneChanLocked():
+		return ErrServerClosed
+	default:
+	}
+	if len(srv.listeners) == 0 {
+		return ErrServerNotServing
+	}
+	return nil
+}
+
+// SelfTest performs an SSH handshake and runs a trivial session against the
+// current configuration of the server, over a loopback connection. It
+// exercises the host keys, the ServerConfigCallback and the connection,
+// channel and request middleware, but authenticates with a key trusted for
+// this test only and replaces the Handler and session callbacks, so it has
+// no effect on users. ctx bounds how long the test may take.
+func (srv *Server) SelfTest(ctx context.Context) error {
+	srv.init()
+	cfg := *srv.current()
+	cfg.HostSigners = append([]Signer(nil), cfg.HostSigners...)
+	if err := cfg.ensureHostSigner(); err != nil {
+		return err
+	}
+	clientKey, err := GenerateSigner(KeyTypeEd25519, 0)
+	if err != nil {
+		return err
+	}
+	cfg.PasswordHandler = nil
+	cfg.KeyboardInteractiveHandler = nil
+	cfg.AuthMiddleware = nil
+	cfg.ImpersonationCallback = nil
+	cfg.PublicKeyHandler = func(ctx Context, key PublicKey) bool {
+		return ctx.User() == selfTestUser && KeysEqual(key, clientKey.PublicKey())
+	}
+	cfg.Handler = func(s Session) {}
+	cfg.PtyCallback = nil
+	cfg.SessionRequestCallback = nil
+	cfg.SessionStartCallback = nil
+	cfg.SessionEndCallback = nil
+	cfg.ConnCloseCallback = nil
+	cfg.ChannelHandlers = map[string]ChannelHandler{"session": DefaultSessionHandler}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer clientConn.Close()
+	serverConn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	l.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		clientConn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			clientConn.Close()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(done)
+		sctx, cancel := newContext(&cfg)
+		defer cancel()
+		cfg.handshake(sctx, serverConn, cancel)
+	}()
+	err = selfTestClient(clientConn, clientKey)
+	clientConn.Close()
+	<-done
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// selfTestClient runs the client side of the self-test over conn.
+func selfTestClient(conn net.Conn, key Signer) error {
+	c, chans, reqs, err := gossh.NewClientConn(conn, "selftest", &gossh.ClientConfig{
+		User:            selfTestUser,
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(key)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), // the server is this process
+	})
+	if err != nil {
+		return fmt.Errorf("ssh: self-test handshake: %w", err)
+	}
+	client := gossh.NewClient(c, chans, reqs)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("ssh: self-test session: %w", err)
+	}
+	defer session.Close()
+	if err := session.Run("true"); err != nil {
+		return fmt.Errorf("ssh: self-test session: %w", err)
+	}
+	return nil
+}
+
+// HealthHandler returns an HTTP handler for health checks, such as for the
+// HTTPHandler of the server. It answers 200 OK while the server is Healthy
+// and, if selfTest is set, passes SelfTest within timeout, and 503 Service
+// Unavailable otherwise.
+func (srv *Server) HealthHandler(selfTest bool, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := srv.Healthy()
+		if err == nil && selfTest {
+			ctx := r.Context()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			err = srv.SelfTest(ctx)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// waitServing waits for up to a second for srv to accept connections.
+func waitServing(srv *Server) {
+	deadline := time.Now().Add(time.Second)
+	for srv.Healthy() != nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	t.Parallel()
+	srv := &Server{Handler: func(s Session) {}}
+	if err := srv.Healthy(); err != ErrServerNotServing {
+		t.Fatalf("Healthy() = %#v; want %#v", err, ErrServerNotServing)
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	waitServing(srv)
+	if err := srv.Healthy(); err != nil {
+		t.Fatalf("Healthy() = %#v; want nil", err)
+	}
+	srv.Close()
+	if err := srv.Healthy(); err != ErrServerClosed {
+		t.Fatalf("Healthy() = %#v; want %#v", err, ErrServerClosed)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Parallel()
+	var handled, authenticated bool
+	srv := &Server{
+		Handler: func(s Session) { handled = true },
+		PasswordHandler: func(ctx Context, password string) bool {
+			authenticated = true
+			return true
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.SelfTest(ctx); err != nil {
+		t.Fatalf("SelfTest() = %#v; want nil", err)
+	}
+	if handled || authenticated {
+		t.Fatalf("handled, authenticated = %#v, %#v; want false, false", handled, authenticated)
+	}
+}
+
+func TestSelfTestFailure(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		ChannelMiddleware: []ChannelMiddleware{func(next ChannelHandler) ChannelHandler {
+			return func(srv *Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx Context) {
+				newChan.Reject(gossh.Prohibited, "broken")
+			}
+		}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.SelfTest(ctx); err == nil {
+		t.Fatal("SelfTest() = nil; want error")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+	srv := &Server{Handler: func(s Session) {}}
+	rec := httptest.NewRecorder()
+	srv.HealthHandler(true, time.Second).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("code = %#v; want %#v", rec.Code, http.StatusServiceUnavailable)
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	waitServing(srv)
+	rec = httptest.NewRecorder()
+	srv.HealthHandler(true, 5*time.Second).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("code = %#v; want %#v: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
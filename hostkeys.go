@@ -0,0 +1,50 @@
+package ssh
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// versionTimeout is how long the server waits for the client's version
+// string before choosing host keys without it.
+const versionTimeout = 2 * time.Second
+
+// maxVersionLen is the maximum length of a version line, including CR LF,
+// allowed by RFC 4253 Section 4.2.
+const maxVersionLen = 255
+
+// readClientVersion reads the version line sent by the client, returning a
+// net.Conn replaying it. Most clients send their version right after
+// connecting; for those waiting for the server's version first, the version
+// is empty after versionTimeout.
+func readClientVersion(conn net.Conn) (net.Conn, string) {
+	var buf []byte
+	b := make([]byte, 1)
+	conn.SetReadDeadline(time.Now().Add(versionTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for len(buf) < maxVersionLen {
+		if _, err := conn.Read(b); err != nil {
+			break
+		}
+		buf = append(buf, b[0])
+		if b[0] == '\n' {
+			version := bytes.TrimRight(buf, "\r\n")
+			if bytes.HasPrefix(version, []byte("SSH-")) {
+				return &prefixConn{conn, buf}, string(version)
+			}
+			break
+		}
+	}
+	return &prefixConn{conn, buf}, ""
+}
+
+// hostSigners returns the host keys to offer on the connection of ctx.
+func (srv *Server) hostSigners(ctx Context) []Signer {
+	if srv.HostSignersCallback != nil {
+		if signers := srv.HostSignersCallback(ctx); len(signers) > 0 {
+			return signers
+		}
+	}
+	return srv.HostSigners
+}
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestReadClientVersion(t *testing.T) {
+	t.Parallel()
+	server, client := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("SSH-2.0-PuTTY_Release_0.62\r\nrest"))
+	conn, version := readClientVersion(server)
+	if version != "SSH-2.0-PuTTY_Release_0.62" {
+		t.Fatalf("version = %#v; want %#v", version, "SSH-2.0-PuTTY_Release_0.62")
+	}
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "SSH-2.0-PuTTY_Release_0.62\r\nrest" {
+		t.Fatalf("replayed %q", buf)
+	}
+}
+
+func TestHostSignersCallback(t *testing.T) {
+	t.Parallel()
+	legacy, err := generateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make(chan string, 1)
+	srv := &Server{
+		Handler: func(s Session) {},
+		HostSignersCallback: func(ctx Context) []Signer {
+			versions <- ctx.ClientVersion()
+			return []Signer{legacy}
+		},
+	}
+	var hostKey PublicKey
+	session, _, cleanup := newTestSession(t, srv, &gossh.ClientConfig{
+		User:          "testuser",
+		ClientVersion: "SSH-2.0-Legacy_1.0",
+		HostKeyCallback: func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			hostKey = key
+			return nil
+		},
+	})
+	defer cleanup()
+	session.Run("")
+	if v := <-versions; v != "SSH-2.0-Legacy_1.0" {
+		t.Fatalf("ClientVersion = %#v; want %#v", v, "SSH-2.0-Legacy_1.0")
+	}
+	if !KeysEqual(hostKey, legacy.PublicKey()) {
+		t.Fatal("host key not chosen by HostSignersCallback")
+	}
+}
+
+func TestAddHostKey(t *testing.T) {
+	t.Parallel()
+	srv := &Server{Handler: func(s Session) {}}
+	keys := map[KeyType]Signer{}
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeECDSA, KeyTypeRSA, KeyTypeEd25519} {
+		signer, err := GenerateSigner(keyType, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[keyType] = signer
+		srv.AddHostKey(signer)
+	}
+	if len(srv.HostSigners) != 3 {
+		t.Fatalf("len(HostSigners) = %d; want 3", len(srv.HostSigners))
+	}
+	if srv.HostSigners[0] != keys[KeyTypeEd25519] {
+		t.Fatal("second Ed25519 key didn't replace the first")
+	}
+
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	for algo, want := range map[string]Signer{
+		gossh.KeyAlgoED25519:   keys[KeyTypeEd25519],
+		gossh.KeyAlgoECDSA256:  keys[KeyTypeECDSA],
+		gossh.KeyAlgoRSASHA256: keys[KeyTypeRSA],
+		gossh.KeyAlgoRSASHA512: keys[KeyTypeRSA],
+	} {
+		var hostKey PublicKey
+		_, _, cleanup := newClientSession(t, l.Addr().String(), &gossh.ClientConfig{
+			User:              "testuser",
+			HostKeyAlgorithms: []string{algo},
+			HostKeyCallback: func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+				hostKey = key
+				return nil
+			},
+		})
+		cleanup()
+		if !KeysEqual(hostKey, want.PublicKey()) {
+			t.Fatalf("host key for %s = %s; want %s", algo, hostKey.Type(), want.PublicKey().Type())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sniffTimeout is how long the server waits for the first bytes from the
+// client to tell SSH from HTTP when Server.HTTPHandler is set.
+const sniffTimeout = 2 * time.Second
+
+// sniffConn reads the first bytes sent by the client and reports whether
+// they start an SSH version exchange. Clients that wait for the server to
+// speak first are assumed to be SSH clients after sniffTimeout. The returned
+// net.Conn replays the bytes read.
+func sniffConn(conn net.Conn) (net.Conn, bool, error) {
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	n, err := io.ReadFull(conn, buf)
+	conn.SetReadDeadline(time.Time{})
+	if ne, ok := err.(net.Error); err != nil && !(ok && ne.Timeout()) {
+		return nil, false, err
+	}
+	isSSH := n == 0 || string(buf[:n]) == "SSH-"[:n]
+	return &prefixConn{conn, buf[:n]}, isSSH, nil
+}
+
+// prefixConn is a net.Conn returning prefix before the data read from Conn.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// serveHTTP hands conn to the server's HTTP server, starting it on first
+// use.
+func (srv *Server) serveHTTP(conn net.Conn) {
+	srv.mu.Lock()
+	if srv.httpListener == nil {
+		srv.httpListener = &connListener{
+			conns: make(chan net.Conn),
+			done:  make(chan struct{}),
+			addr:  conn.LocalAddr(),
+		}
+		srv.httpServer = &http.Server{Handler: srv.HTTPHandler}
+		go srv.httpServer.Serve(srv.httpListener)
+	}
+	l := srv.httpListener
+	srv.mu.Unlock()
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// shutdownHTTP shuts down the HTTP server, if it was started. It closes
+// active HTTP connections immediately unless ctx is non-nil, in which case
+// it waits for them to become idle until ctx is done.
+func (srv *Server) shutdownHTTP(ctx context.Context) error {
+	srv.mu.Lock()
+	hs := srv.httpServer
+	srv.mu.Unlock()
+	if hs == nil {
+		return nil
+	}
+	if ctx == nil {
+		return hs.Close()
+	}
+	return hs.Shutdown(ctx)
+}
+
+// connListener is a net.Listener accepting the connections sent on conns.
+type connListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	addr  net.Addr
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("ssh: HTTP listener closed")
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,73 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestSniffConn(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		data  string
+		isSSH bool
+	}{
+		{"SSH-2.0-OpenSSH_9.6\r\n", true},
+		{"GET /healthz HTTP/1.1\r\n", false},
+	} {
+		server, client := net.Pipe()
+		go client.Write([]byte(tc.data))
+		conn, isSSH, err := sniffConn(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isSSH != tc.isSSH {
+			t.Fatalf("%q: isSSH = %v; want %v", tc.data, isSSH, tc.isSSH)
+		}
+		buf := make([]byte, len(tc.data))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != tc.data {
+			t.Fatalf("replayed %q; want %q", buf, tc.data)
+		}
+		client.Close()
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, "ssh")
+		},
+		HTTPHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}),
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("body = %#v; want %#v", string(body), "ok")
+	}
+
+	session, _, cleanup := newClientSession(t, l.Addr().String(), nil)
+	defer cleanup()
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "ssh" {
+		t.Fatalf("output = %#v; want %#v", string(out), "ssh")
+	}
+}
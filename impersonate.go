@@ -0,0 +1,62 @@
+package ssh
+
+import "strings"
+
+// ImpersonationSeparator separates the authenticating user from the user to
+// act as in login names such as "admin:alice". Colons are not valid in POSIX
+// user names, so the syntax can't collide with real accounts.
+const ImpersonationSeparator = ":"
+
+var (
+	// ContextKeyImpersonator is a context key for use with Contexts in this package.
+	// The associated value will be of type string.
+	ContextKeyImpersonator = &contextKey{"impersonator"}
+
+	contextKeyImpersonationTarget = &contextKey{"impersonation-target"}
+)
+
+// Impersonator returns the user who authenticated the connection of ctx when
+// it acts as another user. Once the connection is established, ctx.User()
+// returns the impersonated user while authentication handlers see the
+// impersonator. Audit records should include both.
+func Impersonator(ctx Context) (string, bool) {
+	admin, ok := ctx.Value(ContextKeyImpersonator).(string)
+	return admin, ok
+}
+
+// splitImpersonation rewrites a login name such as "admin:alice" so that
+// authentication handlers see the authenticating user, remembering the user
+// to act as once authenticated.
+func (srv *Server) splitImpersonation(ctx Context) {
+	if srv.ImpersonationCallback == nil || ctx.Value(contextKeyImpersonationTarget) != nil {
+		return
+	}
+	i := strings.Index(ctx.User(), ImpersonationSeparator)
+	if i <= 0 || i == len(ctx.User())-len(ImpersonationSeparator) {
+		return
+	}
+	admin, target := ctx.User()[:i], ctx.User()[i+len(ImpersonationSeparator):]
+	ctx.SetValue(ContextKeyUser, admin)
+	ctx.SetValue(contextKeyImpersonationTarget, target)
+}
+
+// allowImpersonation reports whether the authenticated user of ctx may act
+// as the user requested in the login name, if any.
+func (srv *Server) allowImpersonation(ctx Context) bool {
+	target, ok := ctx.Value(contextKeyImpersonationTarget).(string)
+	if !ok {
+		return true
+	}
+	return srv.ImpersonationCallback(ctx, ctx.User(), target)
+}
+
+// applyImpersonation switches the user of ctx to the impersonated user after
+// authentication succeeded.
+func applyImpersonation(ctx Context) {
+	target, ok := ctx.Value(contextKeyImpersonationTarget).(string)
+	if !ok {
+		return
+	}
+	ctx.SetValue(ContextKeyImpersonator, ctx.User())
+	ctx.SetValue(ContextKeyUser, target)
+}
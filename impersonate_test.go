@@ -0,0 +1,46 @@
+package ssh
+
+import (
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestImpersonation(t *testing.T) {
+	t.Parallel()
+	users := make(chan [2]string, 1)
+	srv := &Server{
+		Handler: func(s Session) {
+			admin, _ := Impersonator(s.Context().(Context))
+			users <- [2]string{s.User(), admin}
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return ctx.User() == "admin" && password == "secret"
+		},
+		ImpersonationCallback: func(ctx Context, admin, target string) bool {
+			return target == "alice"
+		},
+	}
+	session, _, cleanup := newTestSession(t, srv, &gossh.ClientConfig{
+		User: "admin:alice",
+		Auth: []gossh.AuthMethod{gossh.Password("secret")},
+	})
+	defer cleanup()
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-users; got != [2]string{"alice", "admin"} {
+		t.Fatalf("user, impersonator = %#v; want %#v", got, [2]string{"alice", "admin"})
+	}
+
+	l := newLocalListener()
+	go srv.serveOnce(l)
+	_, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "admin:bob",
+		Auth:            []gossh.AuthMethod{gossh.Password("secret")},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("impersonation of bob allowed")
+	}
+}
@@ -0,0 +1,178 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// kexStrictClient is the pseudo key exchange algorithm offered by clients
+// implementing strict key exchange, the countermeasure to the Terrapin
+// attack (CVE-2023-48795). golang.org/x/crypto/ssh servers always offer the
+// server side, so strict key exchange is used iff the client offers it.
+const kexStrictClient = "kex-strict-c-v00@openssh.com"
+
+// maxKexInitLen bounds the bytes buffered while looking for the client's
+// KEXINIT, which is the first packet sent by the client.
+const maxKexInitLen = 64 * 1024
+
+// ErrStrictKexRequired is the error connections are closed with when the
+// server has RequireStrictKex and the client doesn't support strict key
+// exchange.
+var ErrStrictKexRequired = errors.New("ssh: client does not support strict key exchange")
+
+// ErrHASSHDenied is the error connections are closed with when the server's
+// HASSHCallback refuses the client.
+var ErrHASSHDenied = errors.New("ssh: client fingerprint denied")
+
+var contextKeyClientKexInit = &contextKey{"client-kexinit"}
+
+// kexInitMsg is the SSH_MSG_KEXINIT message of RFC 4253, section 7.1.
+type kexInitMsg struct {
+	Cookie                  [16]byte `sshtype:"20"`
+	KexAlgos                []string
+	ServerHostKeyAlgos      []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+	LanguagesClientServer   []string
+	LanguagesServerClient   []string
+	FirstKexFollows         bool
+	Reserved                uint32
+}
+
+// kexSniffer inspects the data read by the handshake, parsing the version
+// line and KEXINIT sent by the client from it. Both are sent in plain text
+// before any key exchange.
+type kexSniffer struct {
+	buf []byte
+	// onKexInit is called with the client's KEXINIT once it was read. If it
+	// returns an error, reads fail with it from then on.
+	onKexInit func(msg *kexInitMsg) error
+	err       error
+}
+
+// observe inspects the result of a read of p, returning the result to pass
+// on to the handshake.
+func (k *kexSniffer) observe(p []byte, n int, err error) (int, error) {
+	if k.err != nil {
+		return 0, k.err
+	}
+	if k.buf == nil || n == 0 {
+		return n, err
+	}
+	k.buf = append(k.buf, p[:n]...)
+	msg, ok := parseClientKexInit(k.buf)
+	if !ok && len(k.buf) < maxKexInitLen {
+		return n, err
+	}
+	k.buf = nil
+	if msg != nil {
+		if k.err = k.onKexInit(msg); k.err != nil {
+			return 0, k.err
+		}
+	}
+	return n, err
+}
+
+// parseClientKexInit parses the KEXINIT following the version line in buf.
+// It reports false if buf doesn't hold all of it yet, and returns a nil
+// message if the data is malformed.
+func parseClientKexInit(buf []byte) (*kexInitMsg, bool) {
+	// Lines before the version line are allowed by RFC 4253, section 4.2.
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return nil, false
+		}
+		line := buf[:i]
+		buf = buf[i+1:]
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+	if len(buf) < 5 {
+		return nil, false
+	}
+	length := binary.BigEndian.Uint32(buf)
+	padding := uint32(buf[4])
+	if length > maxKexInitLen || padding+1 > length {
+		return nil, true
+	}
+	if uint32(len(buf)-4) < length {
+		return nil, false
+	}
+	payload := buf[5 : 4+length-padding]
+	msg := new(kexInitMsg)
+	if err := gossh.Unmarshal(payload, msg); err != nil {
+		return nil, true
+	}
+	return msg, true
+}
+
+// clientKexInit returns the KEXINIT sent by the client of ctx, if it was
+// read already.
+func clientKexInit(ctx Context) (*kexInitMsg, bool) {
+	msg, ok := ctx.Value(contextKeyClientKexInit).(*kexInitMsg)
+	return msg, ok
+}
+
+// StrictKex reports whether the connection of ctx uses strict key exchange,
+// which protects it from the Terrapin attack.
+func StrictKex(ctx Context) bool {
+	msg, ok := clientKexInit(ctx)
+	return ok && contains(msg.KexAlgos, kexStrictClient)
+}
+
+// HASSHAlgorithms returns the algorithms fingerprinted by HASSH for the client
+// of ctx: its key exchange, encryption, MAC and compression algorithms, each
+// comma-separated in the order offered, joined with semicolons.
+func HASSHAlgorithms(ctx Context) (string, bool) {
+	msg, ok := clientKexInit(ctx)
+	if !ok {
+		return "", false
+	}
+	return msg.hasshAlgorithms(), true
+}
+
+// HASSH returns the HASSH fingerprint of the client of ctx, the MD5 of
+// HASSHAlgorithms in hex, which identifies client implementations
+// regardless of their version string.
+func HASSH(ctx Context) (string, bool) {
+	msg, ok := clientKexInit(ctx)
+	if !ok {
+		return "", false
+	}
+	return msg.hassh(), true
+}
+
+func (msg *kexInitMsg) hasshAlgorithms() string {
+	return strings.Join([]string{
+		strings.Join(msg.KexAlgos, ","),
+		strings.Join(msg.CiphersClientServer, ","),
+		strings.Join(msg.MACsClientServer, ","),
+		strings.Join(msg.CompressionClientServer, ","),
+	}, ";")
+}
+
+func (msg *kexInitMsg) hassh() string {
+	sum := md5.Sum([]byte(msg.hasshAlgorithms()))
+	return hex.EncodeToString(sum[:])
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
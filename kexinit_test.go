@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func clientHello(kexAlgos ...string) []byte {
+	payload := gossh.Marshal(&kexInitMsg{
+		KexAlgos:            kexAlgos,
+		ServerHostKeyAlgos:  []string{"ssh-ed25519"},
+		CiphersClientServer: []string{"aes128-ctr"},
+		CiphersServerClient: []string{"aes128-ctr"},
+	})
+	padding := 8 - (len(payload)+5)%8 + 4
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	return append([]byte("SSH-2.0-Test\r\n"), packet...)
+}
+
+func TestParseClientKexInit(t *testing.T) {
+	t.Parallel()
+	hello := clientHello("curve25519-sha256", kexStrictClient)
+	for n := 0; n < len(hello); n++ {
+		if _, ok := parseClientKexInit(hello[:n]); ok {
+			t.Fatalf("parsed KEXINIT from %d of %d bytes", n, len(hello))
+		}
+	}
+	msg, ok := parseClientKexInit(hello)
+	if !ok || msg == nil {
+		t.Fatal("KEXINIT not parsed")
+	}
+	if want := []string{"curve25519-sha256", kexStrictClient}; !reflect.DeepEqual(msg.KexAlgos, want) {
+		t.Fatalf("KexAlgos = %#v; want %#v", msg.KexAlgos, want)
+	}
+}
+
+func TestKexSniffer(t *testing.T) {
+	t.Parallel()
+	hello := clientHello("curve25519-sha256")
+	var got *kexInitMsg
+	k := &kexSniffer{buf: []byte{}, onKexInit: func(msg *kexInitMsg) error {
+		got = msg
+		return ErrStrictKexRequired
+	}}
+	p := make([]byte, len(hello))
+	copy(p, hello)
+	half := len(hello) / 2
+	if n, err := k.observe(p[:half], half, nil); n != half || err != nil {
+		t.Fatalf("observe() = %d, %v; want %d, nil", n, err, half)
+	}
+	if n, err := k.observe(p[half:], len(hello)-half, nil); n != 0 || err != ErrStrictKexRequired {
+		t.Fatalf("observe() = %d, %v; want 0, %v", n, err, ErrStrictKexRequired)
+	}
+	if got == nil || got.KexAlgos[0] != "curve25519-sha256" {
+		t.Fatalf("onKexInit got %#v", got)
+	}
+}
+
+func TestStrictKex(t *testing.T) {
+	t.Parallel()
+	strict := make(chan bool, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			strict <- StrictKex(s.Context().(Context))
+		},
+		RequireStrictKex: true,
+	}, nil)
+	defer cleanup()
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if !<-strict {
+		t.Fatal("StrictKex() = false; want true for golang.org/x/crypto/ssh clients")
+	}
+}
+
+func TestHASSH(t *testing.T) {
+	t.Parallel()
+	msg := &kexInitMsg{
+		KexAlgos:                []string{"curve25519-sha256", "ext-info-c"},
+		ServerHostKeyAlgos:      []string{"ssh-ed25519"},
+		CiphersClientServer:     []string{"aes128-ctr", "aes256-gcm@openssh.com"},
+		CiphersServerClient:     []string{"chacha20-poly1305@openssh.com"},
+		MACsClientServer:        []string{"hmac-sha2-256"},
+		CompressionClientServer: []string{"none", "zlib@openssh.com"},
+	}
+	if got, want := msg.hassh(), "48cededbf2ab7656df38d060cf3325e0"; got != want {
+		t.Fatalf("hassh() = %#v; want %#v", got, want)
+	}
+
+	fingerprints := make(chan string, 1)
+	srv := &Server{
+		Handler: func(s Session) {},
+		HASSHCallback: func(ctx Context, hassh string) bool {
+			fingerprints <- hassh
+			return false
+		},
+	}
+	l := newLocalListener()
+	go srv.serveOnce(l)
+	_, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("connection succeeded; want it closed by HASSHCallback")
+	}
+	if fp := <-fingerprints; len(fp) != 32 {
+		t.Fatalf("HASSHCallback got %#v; want an MD5 in hex", fp)
+	}
+}
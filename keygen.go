@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// KeyType is a type of private key that can be generated.
+type KeyType string
+
+// Key types supported by GenerateKey.
+const (
+	KeyTypeEd25519 KeyType = "ed25519"
+	KeyTypeECDSA   KeyType = "ecdsa"
+	KeyTypeRSA     KeyType = "rsa"
+)
+
+// DefaultRSABits is the size of RSA keys generated when no size is given.
+const DefaultRSABits = 3072
+
+// GenerateKey generates a private key of the given type. For RSA keys, bits
+// is the key size, DefaultRSABits if zero. For ECDSA keys, bits selects the
+// curve among 256, 384 and 521, P-256 if zero. It is ignored for Ed25519.
+func GenerateKey(keyType KeyType, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ssh: unsupported ECDSA key size %d", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeRSA:
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("ssh: RSA key size %d below minimum of 2048", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("ssh: unsupported key type %q", keyType)
+}
+
+// GenerateSigner generates a private key like GenerateKey and returns a
+// Signer for it, such as for use as a host key.
+func GenerateSigner(keyType KeyType, bits int) (Signer, error) {
+	key, err := GenerateKey(keyType, bits)
+	if err != nil {
+		return nil, err
+	}
+	return gossh.NewSignerFromKey(key)
+}
+
+// MarshalPrivateKey encodes key in the OpenSSH private key format, as
+// written by ssh-keygen. If passphrase is not empty, the key is encrypted
+// with it.
+func MarshalPrivateKey(key crypto.PrivateKey, comment string, passphrase []byte) ([]byte, error) {
+	var block *pem.Block
+	var err error
+	if len(passphrase) == 0 {
+		block, err = gossh.MarshalPrivateKey(key, comment)
+	} else {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(key, comment, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// MarshalPKCS8PrivateKey encodes key as an unencrypted PKCS #8 PEM block,
+// for tools that don't read the OpenSSH format.
+func MarshalPKCS8PrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalAuthorizedKey encodes key for use in an authorized_keys or
+// known_hosts file, followed by comment if not empty.
+func MarshalAuthorizedKey(key PublicKey, comment string) []byte {
+	line := gossh.MarshalAuthorizedKey(key)
+	if comment == "" {
+		return line
+	}
+	return append(line[:len(line)-1], []byte(" "+comment+"\n")...)
+}
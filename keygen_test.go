@@ -0,0 +1,66 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKey(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		keyType KeyType
+		bits    int
+		algo    string
+	}{
+		{KeyTypeEd25519, 0, gossh.KeyAlgoED25519},
+		{KeyTypeECDSA, 384, gossh.KeyAlgoECDSA384},
+		{KeyTypeRSA, 2048, gossh.KeyAlgoRSA},
+	} {
+		key, err := GenerateKey(tc.keyType, tc.bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, passphrase := range []string{"", "secret"} {
+			data, err := MarshalPrivateKey(key, "test", []byte(passphrase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var signer Signer
+			if passphrase == "" {
+				signer, err = gossh.ParsePrivateKey(data)
+			} else {
+				signer, err = gossh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tc.keyType, err)
+			}
+			if algo := signer.PublicKey().Type(); algo != tc.algo {
+				t.Fatalf("%s: type = %#v; want %#v", tc.keyType, algo, tc.algo)
+			}
+		}
+	}
+	if _, err := GenerateKey(KeyTypeRSA, 1024); err == nil {
+		t.Fatal("generated 1024 bit RSA key")
+	}
+}
+
+func TestMarshalAuthorizedKey(t *testing.T) {
+	t.Parallel()
+	signer, err := GenerateSigner(KeyTypeEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := MarshalAuthorizedKey(signer.PublicKey(), "host@example")
+	if !bytes.HasSuffix(line, []byte(" host@example\n")) {
+		t.Fatalf("line = %q; want comment suffix", line)
+	}
+	key, comment, _, _, err := gossh.ParseAuthorizedKey(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "host@example" || !KeysEqual(key, signer.PublicKey()) {
+		t.Fatalf("parsed %v, %#v", key, comment)
+	}
+}
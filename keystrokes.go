@@ -0,0 +1,138 @@
+package ssh
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// KeystrokeLogging configures the capture of the input of PTY sessions as
+// AuditKeystrokes audit events, for compliance regimes requiring the
+// commands typed on bastions to be recorded. Keystrokes are batched into an
+// event at each line ending, once 1 KiB is pending and after Interval.
+//
+// Input typed while the terminal doesn't echo it, such as passwords at
+// sudo prompts, is left out unless RecordHidden is set, and only counted in
+// the HiddenBytes of the events. Whether the terminal echoes is read from
+// the PTY of RunWithPty as input arrives, or else taken from the terminal
+// modes of the pty-req, so handlers running programs on terminals of their
+// own should use RunWithPty to get hidden input left out. Input the client
+// typed ahead of a prompt turning off echo is recorded.
+type KeystrokeLogging struct {
+	RecordHidden bool          // record input typed while echo is off too
+	Interval     time.Duration // longest time keystrokes are batched, 1 second if zero
+
+	// Redact are patterns of the recorded input replaced by "[REDACTED]",
+	// such as tokens pasted into commands, matched within each event.
+	Redact []*regexp.Regexp
+}
+
+// keystrokeBatch is the most input batched into an event.
+const keystrokeBatch = 1 << 10
+
+// keystrokeLog batches the input of a session into audit events.
+type keystrokeLog struct {
+	ctx     Context
+	config  *KeystrokeLogging
+	echoing func() bool
+
+	mu      sync.Mutex
+	input   []byte
+	hidden  int
+	timer   *time.Timer
+	stopped bool // the session exited, so later input isn't recorded
+}
+
+// logKeystrokes starts recording the input of the PTY session sess, if
+// keystroke logging is configured.
+func (sess *session) logKeystrokes(srv *Server) {
+	if srv.KeystrokeLogging == nil || sess.pty == nil {
+		return
+	}
+	mode, ok := sess.pty.Modes()[gossh.ECHO]
+	echo := mode != 0 || !ok
+	k := &keystrokeLog{ctx: sess.ctx, config: srv.KeystrokeLogging}
+	k.echoing = func() bool {
+		sess.Lock()
+		ptyEcho := sess.ptyEcho
+		sess.Unlock()
+		if ptyEcho != nil {
+			if on, ok := ptyEcho(); ok {
+				return on
+			}
+		}
+		return echo
+	}
+	sess.Lock()
+	sess.keystrokes = k
+	sess.Unlock()
+}
+
+// record records the input p.
+func (k *keystrokeLog) record(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	hidden := !k.config.RecordHidden && !k.echoing()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stopped {
+		return
+	}
+	if hidden {
+		k.hidden += len(p)
+	} else {
+		k.input = append(k.input, p...)
+	}
+	if bytes.ContainsAny(p, "\r\n") || len(k.input) >= keystrokeBatch {
+		k.flushLocked()
+		return
+	}
+	if k.timer == nil {
+		interval := k.config.Interval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		k.timer = time.AfterFunc(interval, k.flush)
+	}
+}
+
+// flush emits the pending input as an event.
+func (k *keystrokeLog) flush() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.flushLocked()
+}
+
+// stop emits the pending input and stops recording.
+func (k *keystrokeLog) stop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.flushLocked()
+	k.stopped = true
+}
+
+func (k *keystrokeLog) flushLocked() {
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+	if len(k.input) == 0 && k.hidden == 0 {
+		return
+	}
+	input := k.input
+	for _, re := range k.config.Redact {
+		input = re.ReplaceAllLiteral(input, []byte("[REDACTED]"))
+	}
+	audit(k.ctx, AuditEvent{
+		Type:        AuditKeystrokes,
+		Outcome:     AuditSuccess,
+		Pty:         true,
+		Input:       string(input),
+		HiddenBytes: k.hidden,
+	})
+	k.input, k.hidden = nil, 0
+}
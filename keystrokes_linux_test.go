@@ -0,0 +1,71 @@
+package ssh
+
+import (
+	"bufio"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeystrokeLoggingRunWithPty(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("stty"); err != nil {
+		t.Skip("no stty")
+	}
+	if _, err := openPty(Pty{}); err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	var mu sync.Mutex
+	var input string
+	var hidden int
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			RunWithPty(s, exec.Command("/bin/sh", "-c", "stty -echo; echo ready; read x; stty echo; echo done"))
+		},
+		KeystrokeLogging: &KeystrokeLogging{},
+		AuditCallback: func(ctx Context, ev AuditEvent) {
+			if ev.Type == AuditKeystrokes {
+				mu.Lock()
+				input += ev.Input
+				hidden += ev.HiddenBytes
+				mu.Unlock()
+			}
+		},
+	}, nil)
+	defer cleanup()
+	if err := session.RequestPty("xterm", 24, 80, nil); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	// the password is typed once the prompt turned off echo
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "ready") {
+			break
+		}
+	}
+	io.WriteString(stdin, "hunter2\r")
+	io.Copy(io.Discard, r)
+	session.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Contains(input, "hunter2") || hidden != len("hunter2\r") {
+		t.Fatalf("keystrokes = %#v with %d hidden bytes; want none with %d", input, hidden, len("hunter2\r"))
+	}
+}
@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestKeystrokeLogging(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name   string
+		modes  gossh.TerminalModes
+		config KeystrokeLogging
+		input  string
+		hidden int
+	}{
+		{"echo", nil, KeystrokeLogging{}, "ls /\rcat [REDACTED]\r", 0},
+		{"no echo", gossh.TerminalModes{gossh.ECHO: 0}, KeystrokeLogging{}, "", 22},
+		{"record hidden", gossh.TerminalModes{gossh.ECHO: 0}, KeystrokeLogging{RecordHidden: true}, "ls /\rcat [REDACTED]\r", 0},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			var input string
+			var hidden int
+			config := tt.config
+			config.Redact = []*regexp.Regexp{regexp.MustCompile(`token-\w+`)}
+			session, _, cleanup := newTestSession(t, &Server{
+				Handler: func(s Session) {
+					io.ReadAll(s)
+				},
+				KeystrokeLogging: &config,
+				AuditCallback: func(ctx Context, ev AuditEvent) {
+					if ev.Type == AuditKeystrokes {
+						mu.Lock()
+						input += ev.Input
+						hidden += ev.HiddenBytes
+						mu.Unlock()
+					}
+				},
+			}, nil)
+			defer cleanup()
+			if err := session.RequestPty("xterm", 24, 80, tt.modes); err != nil {
+				t.Fatal(err)
+			}
+			stdin, err := session.StdinPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := session.Shell(); err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(stdin, "ls /\r")
+			io.WriteString(stdin, "cat token-s3cr3t\r")
+			stdin.Close()
+			session.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			if input != tt.input || hidden != tt.hidden {
+				t.Fatalf("keystrokes = %#v with %d hidden bytes; want %#v with %d", input, hidden, tt.input, tt.hidden)
+			}
+		})
+	}
+}
+
+func TestKeystrokeLogStop(t *testing.T) {
+	t.Parallel()
+	var events []AuditEvent
+	ctx, cancel := newContext(&Server{AuditCallback: func(ctx Context, ev AuditEvent) {
+		events = append(events, ev)
+	}})
+	defer cancel()
+	k := &keystrokeLog{ctx: ctx, config: &KeystrokeLogging{}, echoing: func() bool { return true }}
+	k.record([]byte("ls"))
+	k.stop()
+	k.record([]byte("late\r"))
+	if len(events) != 1 || events[0].Input != "ls" {
+		t.Fatalf("events = %#v; want one with the input before stop", events)
+	}
+}
@@ -0,0 +1,56 @@
+package ssh
+
+import "fmt"
+
+// PayloadLimits are the maximum sizes, in bytes, accepted for values parsed
+// from session request payloads, and the maximum env requests of a session.
+// A zero field means no limit. Requests exceeding a limit are rejected.
+type PayloadLimits struct {
+	MaxEnvName  int // name of an env request
+	MaxEnvValue int // value of an env request
+	MaxEnvCount int // number of env requests of a session
+	MaxEnvBytes int // names and values of all env requests of a session
+	MaxCommand  int // command of an exec request
+	MaxTerm     int // TERM value of a pty-req request
+}
+
+// DefaultPayloadLimits are the limits used when Server.PayloadLimits is nil.
+var DefaultPayloadLimits = PayloadLimits{
+	MaxEnvName:  256,
+	MaxEnvValue: 32 * 1024,
+	MaxEnvCount: 1024,
+	MaxEnvBytes: 256 * 1024,
+	MaxCommand:  128 * 1024,
+	MaxTerm:     64,
+}
+
+// PayloadLimitError describes a request payload value that exceeded its
+// configured limit. For the env limits of a session, Field is "count" or
+// "total", and Size is the count or total reached with the request.
+type PayloadLimitError struct {
+	Request string // request type, such as "env"
+	Field   string // payload field, such as "value"
+	Size    int
+	Limit   int
+}
+
+func (e *PayloadLimitError) Error() string {
+	if e.Field == "count" {
+		return fmt.Sprintf("ssh: %s request %d exceeds limit of %d requests", e.Request, e.Size, e.Limit)
+	}
+	return fmt.Sprintf("ssh: %s request %s of %d bytes exceeds limit of %d bytes", e.Request, e.Field, e.Size, e.Limit)
+}
+
+func (srv *Server) payloadLimits() PayloadLimits {
+	if srv.PayloadLimits != nil {
+		return *srv.PayloadLimits
+	}
+	return DefaultPayloadLimits
+}
+
+func checkPayloadLimit(request, field string, size, limit int) *PayloadLimitError {
+	if limit > 0 && size > limit {
+		return &PayloadLimitError{request, field, size, limit}
+	}
+	return nil
+}
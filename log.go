@@ -0,0 +1,24 @@
+package ssh
+
+import (
+	"log/slog"
+	"net"
+)
+
+// logf emits a structured event to the Logger of srv, if any, with the
+// details of the connection of ctx known so far.
+func (srv *Server) logf(ctx Context, level slog.Level, msg string, args ...any) {
+	if srv.Logger == nil || !srv.Logger.Enabled(ctx, level) {
+		return
+	}
+	if id, ok := ctx.Value(ContextKeySessionID).(string); ok {
+		args = append(args, "session_id", id)
+	}
+	if user, ok := ctx.Value(ContextKeyUser).(string); ok && user != "" {
+		args = append(args, "user", user)
+	}
+	if addr, ok := ctx.Value(ContextKeyRemoteAddr).(net.Addr); ok {
+		args = append(args, "remote_addr", addr.String())
+	}
+	srv.Logger.Log(ctx, level, msg, args...)
+}
@@ -0,0 +1,82 @@
+package ssh
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordHandler is a slog.Handler keeping the messages and attributes of
+// the records it handles.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []map[string]string
+	closed  chan struct{}
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(ctx context.Context, r slog.Record) error {
+	rec := map[string]string{"msg": r.Message}
+	r.Attrs(func(a slog.Attr) bool {
+		rec[a.Key] = a.Value.String()
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, rec)
+	if r.Message == "connection closed" && h.closed != nil {
+		close(h.closed)
+		h.closed = nil
+	}
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+	closed := make(chan struct{})
+	h := &recordHandler{closed: closed}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return true
+		},
+		Logger: slog.New(h),
+	}, nil)
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection closed event")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	want := []string{"connection accepted", "auth", "connection opened", "channel open", "connection closed"}
+	var got []string
+	for _, rec := range h.records {
+		if len(got) < len(want) && rec["msg"] == want[len(got)] {
+			got = append(got, rec["msg"])
+		}
+		switch rec["msg"] {
+		case "auth":
+			if rec["method"] != "password" || rec["ok"] != "true" || rec["user"] != "testuser" {
+				t.Fatalf("auth event = %v; want successful password auth of testuser", rec)
+			}
+		case "channel open":
+			if rec["type"] != "session" || rec["session_id"] == "" || rec["remote_addr"] == "" {
+				t.Fatalf("channel open event = %v; want session channel with connection details", rec)
+			}
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v; want %v in order", h.records, want)
+	}
+}
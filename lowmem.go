@@ -0,0 +1,96 @@
+package ssh
+
+import "time"
+
+// LowMemoryConnBytes is the memory LowMemory budgets for each connection,
+// rounded up from the measured footprint of an idle connection with a
+// session to leave room for the allocations of handlers. It doesn't cover
+// the channel window a session's input can fill.
+const LowMemoryConnBytes = 128 << 10
+
+// LowMemoryPayloadLimits are the PayloadLimits set by LowMemory.
+var LowMemoryPayloadLimits = PayloadLimits{
+	MaxEnvName:  64,
+	MaxEnvValue: 1024,
+	MaxEnvCount: 16,
+	MaxEnvBytes: 4 * 1024,
+	MaxCommand:  4 * 1024,
+	MaxTerm:     32,
+}
+
+// LowMemory returns a functional option that configures the server for
+// devices with little memory to spare, such as IoT and embedded boards.
+// Connections are capped at limit bytes divided by LowMemoryConnBytes, and
+// at least one, and each may only open one session. Output isn't buffered,
+// payloads are capped by LowMemoryPayloadLimits, and handshakes and idle
+// connections time out early. Port forwarding, agent forwarding and
+// subsystems are disabled, leaving shell and exec sessions. Limits already
+// set lower are kept, including the fields of PayloadLimits.
+//
+// limit budgets idle connections and doesn't bound the memory of the
+// server: each session can hold its 2 MiB channel window of input its
+// handler hasn't read, so the worst case is about limit plus 2.5 MiB per
+// connection.
+//
+// The footprint of a connection is the Go heap and goroutine stacks held by
+// the server for it, as measured by BenchmarkLowMemory on linux/amd64 with
+// Go 1.26:
+//
+//	idle connection with a session        ~60 KiB, of which ~44 KiB are the
+//	                                      stacks of its 7 goroutines
+//	session whose client sends input      up to ~2.5 MiB more, until the
+//	faster than the handler reads it      2 MiB channel window is full
+//
+// LowMemory doesn't shrink the footprint of idle connections, which is
+// spent by golang.org/x/crypto/ssh and the goroutines serving them, but caps
+// how many there are and bounds the memory clients can make a connection
+// hold. Handlers of constrained servers should keep reading the input of
+// their sessions. Setting GOMEMLIMIT or debug.SetMemoryLimit to the memory
+// the server may use makes the garbage collector return memory sooner.
+func LowMemory(limit int) Option {
+	return func(srv *Server) error {
+		maxConns := limit / LowMemoryConnBytes
+		if maxConns < 1 {
+			maxConns = 1
+		}
+		if srv.MaxConns == 0 || srv.MaxConns > maxConns {
+			srv.MaxConns = maxConns
+		}
+		srv.MaxSessions = 1
+		if srv.MaxAuthTries <= 0 || srv.MaxAuthTries > 3 {
+			srv.MaxAuthTries = 3
+		}
+		if srv.HandshakeTimeout == 0 || srv.HandshakeTimeout > 10*time.Second {
+			srv.HandshakeTimeout = 10 * time.Second
+		}
+		if srv.IdleTimeout == 0 || srv.IdleTimeout > 5*time.Minute {
+			srv.IdleTimeout = 5 * time.Minute
+		}
+		limits := LowMemoryPayloadLimits
+		if srv.PayloadLimits != nil {
+			cur := srv.PayloadLimits
+			limits.MaxEnvName = lowerLimit(cur.MaxEnvName, limits.MaxEnvName)
+			limits.MaxEnvValue = lowerLimit(cur.MaxEnvValue, limits.MaxEnvValue)
+			limits.MaxEnvCount = lowerLimit(cur.MaxEnvCount, limits.MaxEnvCount)
+			limits.MaxEnvBytes = lowerLimit(cur.MaxEnvBytes, limits.MaxEnvBytes)
+			limits.MaxCommand = lowerLimit(cur.MaxCommand, limits.MaxCommand)
+			limits.MaxTerm = lowerLimit(cur.MaxTerm, limits.MaxTerm)
+		}
+		srv.PayloadLimits = &limits
+		srv.OutputBuffer = 0
+		srv.PrioritizeStderr = false
+		srv.Scheduler = nil
+		disableForwarding(srv)
+		srv.SubsystemHandlers = nil
+		return nil
+	}
+}
+
+// lowerLimit returns cur if it is a lower limit than max, where zero means
+// no limit.
+func lowerLimit(cur, max int) int {
+	if cur > 0 && cur < max {
+		return cur
+	}
+	return max
+}
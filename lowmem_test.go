@@ -0,0 +1,193 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestLowMemory(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		MaxAuthTries:                2,
+		LocalPortForwardingCallback: func(ctx Context, host string, port uint32) bool { return true },
+		SubsystemHandlers:           map[string]SubsystemHandler{"sftp": func(s Session) {}},
+	}
+	if err := srv.SetOption(LowMemory(4 << 20)); err != nil {
+		t.Fatal(err)
+	}
+	if srv.MaxConns != 32 {
+		t.Fatalf("MaxConns = %#v; want %#v", srv.MaxConns, 32)
+	}
+	if srv.MaxSessions != 1 {
+		t.Fatalf("MaxSessions = %#v; want %#v", srv.MaxSessions, 1)
+	}
+	if srv.MaxAuthTries != 2 {
+		t.Fatalf("MaxAuthTries = %#v; want %#v", srv.MaxAuthTries, 2)
+	}
+	if srv.HandshakeTimeout != 10*time.Second {
+		t.Fatalf("HandshakeTimeout = %v; want %v", srv.HandshakeTimeout, 10*time.Second)
+	}
+	if srv.LocalPortForwardingCallback != nil || srv.SubsystemHandlers != nil {
+		t.Fatal("forwarding and subsystems not disabled")
+	}
+	if *srv.PayloadLimits != LowMemoryPayloadLimits {
+		t.Fatalf("PayloadLimits = %#v; want %#v", *srv.PayloadLimits, LowMemoryPayloadLimits)
+	}
+	if err := srv.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv = &Server{}
+	srv.SetOption(LowMemory(1000))
+	if srv.MaxConns != 1 {
+		t.Fatalf("MaxConns = %#v; want %#v", srv.MaxConns, 1)
+	}
+}
+
+func TestLowMemoryPayloadLimits(t *testing.T) {
+	t.Parallel()
+	srv := &Server{PayloadLimits: &PayloadLimits{MaxEnvCount: 4, MaxCommand: 1 << 20}}
+	if err := srv.SetOption(LowMemory(4 << 20)); err != nil {
+		t.Fatal(err)
+	}
+	want := LowMemoryPayloadLimits
+	want.MaxEnvCount = 4
+	if *srv.PayloadLimits != want {
+		t.Fatalf("PayloadLimits = %#v; want %#v", *srv.PayloadLimits, want)
+	}
+}
+
+// TestLowMemoryClients is the client process started by BenchmarkLowMemory,
+// so the memory of the clients isn't counted as the server's.
+func TestLowMemoryClients(t *testing.T) {
+	addr := os.Getenv("GLIDERLABS_SSH_LOWMEM_ADDR")
+	if addr == "" {
+		t.Skip("helper process")
+	}
+	clients, _ := strconv.Atoi(os.Getenv("GLIDERLABS_SSH_LOWMEM_CLIENTS"))
+	input, _ := strconv.Atoi(os.Getenv("GLIDERLABS_SSH_LOWMEM_INPUT"))
+	for i := 0; i < clients; i++ {
+		client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+			User:            "testuser",
+			Auth:            []gossh.AuthMethod{gossh.Password("testpass")},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		}
+		if input > 0 {
+			// blocks once the window of the channel is full
+			go stdin.Write(make([]byte, input))
+		}
+	}
+	if input > 0 {
+		time.Sleep(time.Second)
+	}
+	fmt.Println("ready")
+	io.Copy(io.Discard, os.Stdin)
+}
+
+// BenchmarkLowMemory reports the footprint of connections of a LowMemory
+// server in B/conn, measured with idle sessions and with sessions whose
+// handler doesn't read their input.
+func BenchmarkLowMemory(b *testing.B) {
+	for _, bb := range []struct {
+		name    string
+		clients int
+		input   int
+	}{
+		{"idle", 200, 0},
+		{"unread input", 20, 4 << 20},
+	} {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.ReportMetric(lowMemoryFootprint(b, bb.clients, bb.input), "B/conn")
+			}
+		})
+	}
+}
+
+// lowMemoryFootprint returns the memory held by a LowMemory server for each
+// of clients connections with a session sent input bytes.
+func lowMemoryFootprint(b *testing.B, clients, input int) float64 {
+	srv := &Server{
+		Handler: func(s Session) {
+			<-s.Context().Done()
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return true
+		},
+	}
+	if err := srv.SetOption(LowMemory(clients * LowMemoryConnBytes)); err != nil {
+		b.Fatal(err)
+	}
+	goroutines := runtime.NumGoroutine()
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer func() {
+		// wait for the connections to go, so they aren't counted as the
+		// baseline of the next measurement
+		srv.Close()
+		for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	before := memInUse()
+	cmd := exec.Command(os.Args[0], "-test.run=TestLowMemoryClients")
+	cmd.Env = append(os.Environ(),
+		"GLIDERLABS_SSH_LOWMEM_ADDR="+l.Addr().String(),
+		"GLIDERLABS_SSH_LOWMEM_CLIENTS="+strconv.Itoa(clients),
+		"GLIDERLABS_SSH_LOWMEM_INPUT="+strconv.Itoa(input),
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); line != "ready\n" {
+		b.Fatalf("client process = %#v, %#v; want ready", line, err)
+	}
+	return float64(memInUse()-before) / float64(clients)
+}
+
+// memInUse returns the heap and stacks in use after a garbage collection.
+func memInUse() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapAlloc + m.StackInuse)
+}
@@ -0,0 +1,114 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// MessageID identifies a message shown to clients.
+type MessageID string
+
+// Messages shown to clients, with their default text. Texts with verbs are
+// formatted with fmt.Sprintf.
+const (
+	// MessageBanner is the banner shown before authentication, none by
+	// default. Providers can see the user it is shown to with ctx.User().
+	MessageBanner MessageID = "banner"
+
+	MessageUnsupportedChannel MessageID = "unsupported-channel" // "unsupported channel type"
+	MessageForwardData        MessageID = "forward-data"        // "error parsing forward data: %v"
+	MessageForwardOrigin      MessageID = "forward-origin"      // "originator address does not match connection"
+	MessageForwardDisabled    MessageID = "forward-disabled"    // "port forwarding is disabled"
+	MessageGitShell           MessageID = "git-shell"           // "fatal: Interactive git shell is not enabled."
+	MessageShuttingDown       MessageID = "shutting-down"       // "server is shutting down"
+	MessageExecOnly           MessageID = "exec-only"           // "This server only runs commands, as in: ssh <host> <command>"
+	MessageFeatureDisabled    MessageID = "feature-disabled"    // "%s is disabled"
+	MessageSessionIdle        MessageID = "session-idle"        // "session idle for %v, closing"
+	MessageCredentialsExpired MessageID = "credentials-expired" // "credentials expired, closing"
+	MessageTooManySessions    MessageID = "too-many-sessions"   // "too many sessions"
+	MessageTunnelExists       MessageID = "tunnel-exists"       // "tunnel already registered"
+	MessageTunnelRejected     MessageID = "tunnel-rejected"     // "tunnel rejected"
+	MessageTunnelPort         MessageID = "tunnel-port"         // "tunnels need a bind port"
+)
+
+var defaultMessages = map[MessageID]string{
+	MessageUnsupportedChannel: "unsupported channel type",
+	MessageForwardData:        "error parsing forward data: %v",
+	MessageForwardOrigin:      "originator address does not match connection",
+	MessageForwardDisabled:    "port forwarding is disabled",
+	MessageGitShell:           "fatal: Interactive git shell is not enabled.",
+	MessageShuttingDown:       "server is shutting down",
+	MessageExecOnly:           "This server only runs commands, as in: ssh <host> <command>",
+	MessageFeatureDisabled:    "%s is disabled",
+	MessageSessionIdle:        "session idle for %v, closing",
+	MessageCredentialsExpired: "credentials expired, closing",
+	MessageTooManySessions:    "too many sessions",
+	MessageTunnelExists:       "tunnel already registered",
+	MessageTunnelRejected:     "tunnel rejected",
+	MessageTunnelPort:         "tunnels need a bind port",
+}
+
+// MessageProvider provides the text of messages shown to clients, so they
+// can be localized or branded. def is the default text of the message, and
+// is returned for messages the provider doesn't change. Texts keep the
+// formatting verbs of the default.
+type MessageProvider interface {
+	Message(ctx Context, id MessageID, def string) string
+}
+
+// MessageFunc is an adapter to use an ordinary function as a
+// MessageProvider.
+type MessageFunc func(ctx Context, id MessageID, def string) string
+
+func (f MessageFunc) Message(ctx Context, id MessageID, def string) string {
+	return f(ctx, id, def)
+}
+
+// MessageMap is a MessageProvider returning the texts it holds, such as the
+// translations of one language, and the defaults of other messages.
+type MessageMap map[MessageID]string
+
+func (m MessageMap) Message(ctx Context, id MessageID, def string) string {
+	if text, ok := m[id]; ok {
+		return text
+	}
+	return def
+}
+
+// message returns the text of the message id for the connection of ctx,
+// formatted with args. ctx may be nil for the default text.
+func message(ctx Context, id MessageID, args ...interface{}) string {
+	text := defaultMessages[id]
+	if ctx != nil {
+		if srv, ok := ctx.Value(ContextKeyServer).(*Server); ok && srv.Messages != nil {
+			text = srv.Messages.Message(ctx, id, text)
+		}
+	}
+	if len(args) > 0 {
+		text = fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// noticeTimeout bounds how long telling clients why their sessions end may
+// take, since writes to clients that stopped reading block.
+const noticeTimeout = time.Second
+
+// writeNotice writes the line msg to each of ws, giving up after
+// noticeTimeout. Writes still blocked fail once the connection is closed.
+func writeNotice(msg string, ws ...io.Writer) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, w := range ws {
+			fmt.Fprintln(w, msg)
+		}
+	}()
+	timer := time.NewTimer(noticeTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
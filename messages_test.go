@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestMessages(t *testing.T) {
+	t.Parallel()
+	if got, want := message(nil, MessageForwardDisabled), "port forwarding is disabled"; got != want {
+		t.Fatalf("message() = %#v; want %#v", got, want)
+	}
+
+	ctx, cancel := newContext(&Server{Messages: MessageMap{
+		MessageForwardData: "données de redirection invalides : %v",
+		MessageBanner:      "Bienvenue",
+	}})
+	defer cancel()
+	for _, tt := range []struct {
+		id   MessageID
+		args []interface{}
+		want string
+	}{
+		{MessageForwardData, []interface{}{errors.New("EOF")}, "données de redirection invalides : EOF"},
+		{MessageBanner, nil, "Bienvenue"},
+		{MessageUnsupportedChannel, nil, "unsupported channel type"},
+	} {
+		if got := message(ctx, tt.id, tt.args...); got != tt.want {
+			t.Fatalf("message(%v) = %#v; want %#v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestBannerHandler(t *testing.T) {
+	t.Parallel()
+	banners := make(chan string, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler:  func(s Session) {},
+		Messages: MessageMap{MessageBanner: "static"},
+		BannerHandler: func(ctx Context) string {
+			host, _, _ := net.SplitHostPort(ctx.RemoteAddr().String())
+			return "Hello " + ctx.User() + " from " + host + "\n"
+		},
+	}, &gossh.ClientConfig{
+		User: "alice",
+		BannerCallback: func(message string) error {
+			banners <- message
+			return nil
+		},
+	})
+	defer cleanup()
+	session.Run("")
+	select {
+	case banner := <-banners:
+		if want := "Hello alice from 127.0.0.1\n"; banner != want {
+			t.Fatalf("banner = %#v; want %#v", banner, want)
+		}
+	default:
+		t.Fatal("no banner")
+	}
+}
+
+func TestForwardMessages(t *testing.T) {
+	t.Parallel()
+	messages := MessageMap{
+		MessageForwardDisabled: "redirection désactivée",
+		MessageTunnelPort:      "port requis",
+	}
+	for _, tt := range []struct {
+		name    string
+		handler RequestHandler
+		allow   bool
+		port    uint32
+		want    string
+	}{
+		{"ForwardedTCPHandler", (&ForwardedTCPHandler{}).HandleSSHRequest, false, 8080, "redirection désactivée"},
+		{"TunnelRegistry disabled", (&TunnelRegistry{}).HandleSSHRequest, false, 8080, "redirection désactivée"},
+		{"TunnelRegistry port", (&TunnelRegistry{}).HandleSSHRequest, true, 0, "port requis"},
+	} {
+		srv := &Server{
+			Handler:         func(s Session) {},
+			Messages:        messages,
+			RequestHandlers: map[string]RequestHandler{"tcpip-forward": tt.handler},
+		}
+		if tt.allow {
+			srv.ReversePortForwardingCallback = func(ctx Context, bindHost string, bindPort uint32) bool {
+				return true
+			}
+		}
+		_, client, cleanup := newTestSession(t, srv, nil)
+		ok, reply, err := client.SendRequest("tcpip-forward", true, gossh.Marshal(&remoteForwardRequest{"127.0.0.1", tt.port}))
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok || string(reply) != tt.want {
+			t.Fatalf("%s: tcpip-forward = %v, %#v; want false, %#v", tt.name, ok, string(reply), tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package ssh
+
+import "time"
+
+// Metrics receives the counters and histograms of a server, to update the
+// metrics of a monitoring system such as Prometheus. Its methods are called
+// while handling connections, concurrently, and must not block. Their
+// labels, such as channel types and auth methods, take few values, so
+// they can be used as metric labels.
+type Metrics interface {
+	// ConnOpened is called as the handshake of a connection starts, and
+	// ConnClosed once the connection ended with its close reason and how
+	// long it was open, so the connections opened but not yet closed are
+	// the active ones.
+	ConnOpened()
+	ConnClosed(reason CloseReason, duration time.Duration)
+
+	// ConnRejected is called for connections refused before the handshake,
+	// with the check refusing them, such as "blocked" or "MaxConns".
+	ConnRejected(reason string)
+
+	// AuthAttempt is called for each auth attempt with its method, such as
+	// "password" or "publickey", and whether it succeeded.
+	AuthAttempt(method string, ok bool)
+
+	// ChannelClosed is called once a session, direct-tcpip or
+	// forwarded-tcpip channel ended, with the bytes read from the client
+	// and written to it.
+	ChannelClosed(chanType string, bytesIn, bytesOut int64)
+
+	// SessionEnded is called once the handler of a shell, exec or
+	// subsystem request returned, with how long it ran.
+	SessionEnded(duration time.Duration)
+}
+
+// nopMetrics is the Metrics of servers without any.
+type nopMetrics struct{}
+
+func (nopMetrics) ConnOpened()                                            {}
+func (nopMetrics) ConnClosed(reason CloseReason, duration time.Duration)  {}
+func (nopMetrics) ConnRejected(reason string)                             {}
+func (nopMetrics) AuthAttempt(method string, ok bool)                     {}
+func (nopMetrics) ChannelClosed(chanType string, bytesIn, bytesOut int64) {}
+func (nopMetrics) SessionEnded(duration time.Duration)                    {}
+
+// metrics returns the Metrics of srv, or one discarding them if it has none.
+func (srv *Server) metrics() Metrics {
+	if srv.Metrics == nil {
+		return nopMetrics{}
+	}
+	return srv.Metrics
+}
@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordMetrics is a Metrics keeping the calls it receives.
+type recordMetrics struct {
+	mu     sync.Mutex
+	calls  []string
+	closed chan struct{}
+}
+
+func (m *recordMetrics) record(format string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *recordMetrics) ConnOpened()                { m.record("ConnOpened") }
+func (m *recordMetrics) ConnRejected(reason string) { m.record("ConnRejected %s", reason) }
+func (m *recordMetrics) AuthAttempt(method string, ok bool) {
+	m.record("AuthAttempt %s %v", method, ok)
+}
+func (m *recordMetrics) ChannelClosed(chanType string, bytesIn, bytesOut int64) {
+	m.record("ChannelClosed %s %d %d", chanType, bytesIn, bytesOut)
+}
+func (m *recordMetrics) SessionEnded(duration time.Duration) { m.record("SessionEnded") }
+
+func (m *recordMetrics) ConnClosed(reason CloseReason, duration time.Duration) {
+	m.record("ConnClosed %v", reason)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed != nil {
+		close(m.closed)
+		m.closed = nil
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	closed := make(chan struct{})
+	m := &recordMetrics{closed: closed}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			s.Write([]byte("hello"))
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return true
+		},
+		Metrics: m,
+	}, nil)
+	if _, err := session.Output(""); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnClosed not called")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	want := []string{
+		"ConnOpened",
+		"AuthAttempt password true",
+		"ChannelClosed session 0 5",
+		"SessionEnded",
+		"ConnClosed client-eof",
+	}
+	if fmt.Sprint(m.calls) != fmt.Sprint(want) {
+		t.Fatalf("calls = %#v; want %#v", m.calls, want)
+	}
+}
@@ -1,7 +1,9 @@
 package ssh
 
 import (
+	"fmt"
 	"io/ioutil"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -57,6 +59,27 @@ func HostKeyPEM(bytes []byte) Option {
 	}
 }
 
+// HostKeyFileWithPassphrase returns a functional option that adds HostSigners
+// to the server from a PEM file at filepath, which may be encrypted with a
+// passphrase supplied by passphrases.
+func HostKeyFileWithPassphrase(filepath string, passphrases PassphraseProvider) Option {
+	return func(srv *Server) error {
+		pemBytes, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			return err
+		}
+
+		signer, err := ParsePrivateKey(filepath, pemBytes, passphrases)
+		if err != nil {
+			return err
+		}
+
+		srv.AddHostKey(signer)
+
+		return nil
+	}
+}
+
 // NoPty returns a functional option that sets PtyCallback to return false,
 // denying PTY requests.
 func NoPty() Option {
@@ -68,6 +91,49 @@ func NoPty() Option {
 	}
 }
 
+// ExecOnlyIdleTimeout is the IdleTimeout set by ExecOnly.
+const ExecOnlyIdleTimeout = 30 * time.Second
+
+// ExecOnly returns a functional option that configures the server as an API
+// running commands with handler, the usual setup of services triggered over
+// SSH. Only exec requests are accepted: shell requests are refused with
+// MessageExecOnly written to stderr, and PTY and subsystem requests are
+// refused too, which clients like OpenSSH report before running the command
+// without a terminal. Port, Unix socket and agent forwarding are disabled and
+// IdleTimeout is lowered to ExecOnlyIdleTimeout.
+func ExecOnly(handler Handler) Option {
+	return func(srv *Server) error {
+		srv.Handler = handler
+		srv.PtyCallback = func(ctx Context, pty Pty) bool {
+			return false
+		}
+		srv.SessionRequestCallback = func(sess Session, requestType string) bool {
+			if requestType == "shell" {
+				ctx, _ := sess.Context().(Context)
+				fmt.Fprintln(sess.Stderr(), message(ctx, MessageExecOnly))
+			}
+			return requestType == "exec"
+		}
+		disableForwarding(srv)
+		if srv.IdleTimeout == 0 || srv.IdleTimeout > ExecOnlyIdleTimeout {
+			srv.IdleTimeout = ExecOnlyIdleTimeout
+		}
+		return nil
+	}
+}
+
+// disableForwarding denies port, Unix socket and agent forwarding on srv.
+func disableForwarding(srv *Server) {
+	srv.AgentForwardingCallback = func(ctx Context) bool {
+		return false
+	}
+	srv.LocalPortForwardingCallback = nil
+	srv.ReversePortForwardingCallback = nil
+	srv.DirectTCPIPCallback = nil
+	srv.LocalUnixForwardingCallback = nil
+	srv.ReverseUnixForwardingCallback = nil
+}
+
 // WrapConn returns a functional option that sets ConnCallback on the server.
 func WrapConn(fn ConnCallback) Option {
 	return func(srv *Server) error {
@@ -1,7 +1,9 @@
 package ssh
 
 import (
+	"io"
 	"net"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -107,3 +109,75 @@ func TestConnWrapping(t *testing.T) {
 		t.Fatal("wrapped conn not written to")
 	}
 }
+
+func TestExecOnly(t *testing.T) {
+	t.Parallel()
+	srv := &Server{}
+	session, client, cleanup := newTestSessionWithOptions(t, srv, nil, ExecOnly(func(s Session) {
+		s.Write([]byte("ran " + s.RawCommand()))
+	}))
+	defer cleanup()
+	if srv.IdleTimeout != ExecOnlyIdleTimeout {
+		t.Fatalf("IdleTimeout = %v; want %v", srv.IdleTimeout, ExecOnlyIdleTimeout)
+	}
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err == nil {
+		t.Fatal("pty-req accepted")
+	}
+	out, err := session.Output("status")
+	if err != nil || string(out) != "ran status" {
+		t.Fatalf("output = %#v, %v; want %#v", string(out), err, "ran status")
+	}
+
+	shell, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shell.Close()
+	stderr, _ := shell.StderrPipe()
+	if err := shell.Shell(); err == nil {
+		t.Fatal("shell accepted")
+	}
+	want := message(nil, MessageExecOnly) + "\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(stderr, buf); err != nil || string(buf) != want {
+		t.Fatalf("stderr = %#v, %v; want %#v", string(buf), err, want)
+	}
+}
+
+func TestPresetsDisableForwarding(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name   string
+		option Option
+	}{
+		{"ExecOnly", ExecOnly(func(s Session) {})},
+		{"GitShellOnly", GitShellOnly(&GitShell{Root: t.TempDir()})},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := &Server{
+				// allowing everything, which the preset overrides
+				AgentForwardingCallback:       func(ctx Context) bool { return true },
+				DirectTCPIPCallback:           func(ctx Context, req DirectTCPIP) bool { return true },
+				LocalUnixForwardingCallback:   func(ctx Context, socketPath string) bool { return true },
+				ReverseUnixForwardingCallback: func(ctx Context, socketPath string) bool { return true },
+			}
+			session, client, cleanup := newTestSessionWithOptions(t, srv, nil, tt.option)
+			defer cleanup()
+			if ok, err := session.SendRequest("auth-agent-req@openssh.com", true, nil); err != nil || ok {
+				t.Fatalf("auth-agent-req = %v, %v; want rejected", ok, err)
+			}
+			if c, err := client.Dial("unix", filepath.Join(t.TempDir(), "sock")); err == nil {
+				c.Close()
+				t.Fatal("direct-streamlocal accepted")
+			}
+			l := newLocalListener()
+			defer l.Close()
+			if c, err := client.Dial("tcp", l.Addr().String()); err == nil {
+				c.Close()
+				t.Fatal("direct-tcpip accepted")
+			}
+		})
+	}
+}
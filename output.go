@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// maxOutputChunk bounds how much of one stream is written to the channel at
+// a time, so the other stream gets a turn.
+const maxOutputChunk = 32 * 1024
+
+const (
+	streamStdout = iota
+	streamStderr
+)
+
+// outputMux buffers the stdout and stderr of a session and writes them to
+// the channel from a single goroutine. Both streams share the flow control
+// window of the channel, so once the client stops reading neither gets
+// through; but a stream whose writes block the handler only fills its own
+// buffer, and writers of the other stream carry on until theirs is full.
+type outputMux struct {
+	ch          gossh.Channel
+	limit       int
+	stderrFirst bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	bufs    [2]bytes.Buffer
+	last    int
+	writing bool
+	err     error
+	closed  bool
+}
+
+func newOutputMux(ch gossh.Channel, limit int, stderrFirst bool) *outputMux {
+	m := &outputMux{ch: ch, limit: limit, stderrFirst: stderrFirst, last: streamStderr}
+	m.cond = sync.NewCond(&m.mu)
+	go m.run()
+	return m
+}
+
+func (m *outputMux) write(stream int, p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		for m.err == nil && !m.closed && m.bufs[stream].Len() >= m.limit {
+			m.cond.Wait()
+		}
+		if m.err != nil {
+			return written, m.err
+		}
+		if m.closed {
+			return written, io.ErrClosedPipe
+		}
+		n := m.limit - m.bufs[stream].Len()
+		if n > len(p) {
+			n = len(p)
+		}
+		m.bufs[stream].Write(p[:n])
+		p = p[n:]
+		written += n
+		m.cond.Broadcast()
+	}
+	return written, nil
+}
+
+// next picks the stream to write next: stderr when it is prioritized,
+// otherwise the streams take turns.
+func (m *outputMux) next() int {
+	switch {
+	case m.bufs[streamStdout].Len() == 0:
+		return streamStderr
+	case m.bufs[streamStderr].Len() == 0:
+		return streamStdout
+	case m.stderrFirst:
+		return streamStderr
+	}
+	return 1 - m.last
+}
+
+func (m *outputMux) run() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		for m.err == nil && !m.closed && m.bufs[streamStdout].Len() == 0 && m.bufs[streamStderr].Len() == 0 {
+			m.cond.Wait()
+		}
+		if m.err != nil || (m.bufs[streamStdout].Len() == 0 && m.bufs[streamStderr].Len() == 0) {
+			return
+		}
+		stream := m.next()
+		m.last = stream
+		chunk := append([]byte(nil), m.bufs[stream].Next(maxOutputChunk)...)
+		m.writing = true
+		m.cond.Broadcast()
+		m.mu.Unlock()
+
+		var err error
+		if stream == streamStderr {
+			_, err = m.ch.Stderr().Write(chunk)
+		} else {
+			_, err = m.ch.Write(chunk)
+		}
+
+		m.mu.Lock()
+		m.writing = false
+		if err != nil {
+			m.err = err
+		}
+		m.cond.Broadcast()
+	}
+}
+
+// flush waits until all buffered output was written to the channel.
+func (m *outputMux) flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.err == nil && (m.writing || m.bufs[streamStdout].Len() > 0 || m.bufs[streamStderr].Len() > 0) {
+		m.cond.Wait()
+	}
+	return m.err
+}
+
+// close flushes buffered output and stops the writer. Further writes fail.
+func (m *outputMux) close() error {
+	err := m.flush()
+	m.mu.Lock()
+	m.closed = true
+	m.cond.Broadcast()
+	m.mu.Unlock()
+	return err
+}
+
+// abort stops the writer, dropping buffered output, for sessions closed
+// without exiting, whose client may no longer read. Writes and flushes in
+// progress fail.
+func (m *outputMux) abort() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = io.ErrClosedPipe
+	}
+	m.closed = true
+	m.bufs[streamStdout].Reset()
+	m.bufs[streamStderr].Reset()
+	m.cond.Broadcast()
+}
+
+// muxStderr is the stderr of a session writing through its outputMux.
+type muxStderr struct {
+	io.Reader
+	m *outputMux
+}
+
+func (s muxStderr) Write(p []byte) (int, error) {
+	return s.m.write(streamStderr, p)
+}
@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// blockingChannel is a gossh.Channel whose stdout writes block until
+// released, recording the order of all writes.
+type blockingChannel struct {
+	gossh.Channel
+	release chan struct{}
+	mu      sync.Mutex
+	writes  []string
+}
+
+func (c *blockingChannel) Write(p []byte) (int, error) {
+	<-c.release
+	return c.record("out:", p)
+}
+
+func (c *blockingChannel) Stderr() io.ReadWriter {
+	return stderrRecorder{c}
+}
+
+func (c *blockingChannel) record(prefix string, p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, prefix+string(p))
+	return len(p), nil
+}
+
+type stderrRecorder struct {
+	c *blockingChannel
+}
+
+func (s stderrRecorder) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (s stderrRecorder) Write(p []byte) (int, error) {
+	return s.c.record("err:", p)
+}
+
+func TestOutputMux(t *testing.T) {
+	t.Parallel()
+	ch := &blockingChannel{release: make(chan struct{})}
+	m := newOutputMux(ch, 4, true)
+
+	// the first stdout chunk is picked up by the writer and blocks, the
+	// rest is buffered
+	if _, err := m.write(streamStdout, []byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.write(streamStdout, []byte("efgh")); err != nil {
+		t.Fatal(err)
+	}
+	// stderr writers are not held up by the blocked stdout
+	done := make(chan struct{})
+	go func() {
+		m.write(streamStderr, []byte("oops"))
+		close(done)
+	}()
+	<-done
+	close(ch.release)
+	if err := m.close(); err != nil {
+		t.Fatal(err)
+	}
+	got := ch.writes
+	want := []string{"out:abcd", "err:oops", "out:efgh"}
+	if len(got) != len(want) {
+		t.Fatalf("writes = %#v; want %#v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("writes = %#v; want %#v", got, want)
+		}
+	}
+	if _, err := m.write(streamStdout, []byte("late")); err == nil {
+		t.Fatal("write after close succeeded")
+	}
+}
+
+func TestOutputMuxAbort(t *testing.T) {
+	t.Parallel()
+	ch := &blockingChannel{release: make(chan struct{})}
+	defer close(ch.release)
+	m := newOutputMux(ch, 4, false)
+	m.write(streamStdout, []byte("abcd"))
+	m.write(streamStdout, []byte("efgh"))
+
+	// the client stopped reading, so flushing waits until aborted
+	flushed := make(chan error)
+	go func() {
+		flushed <- m.flush()
+	}()
+	m.abort()
+	if err := <-flushed; err == nil {
+		t.Fatal("flush of aborted output succeeded")
+	}
+	if _, err := m.write(streamStdout, []byte("late")); err == nil {
+		t.Fatal("write after abort succeeded")
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, "out")
+			io.WriteString(s.Stderr(), "err")
+		},
+		OutputBuffer: 16,
+	}, nil)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out" || stderr.String() != "err" {
+		t.Fatalf("stdout, stderr = %#v, %#v; want %#v, %#v", stdout.String(), stderr.String(), "out", "err")
+	}
+}
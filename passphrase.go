@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// PassphraseProvider supplies the passphrases of encrypted private keys, so
+// they don't need to be stored unencrypted on disk. name identifies the key,
+// such as the path of a host key file. Implementations can read the
+// passphrase from the environment, prompt an operator or fetch it from a
+// secret manager.
+type PassphraseProvider interface {
+	Passphrase(name string) ([]byte, error)
+}
+
+// PassphraseFunc is an adapter to use an ordinary function as a
+// PassphraseProvider.
+type PassphraseFunc func(name string) ([]byte, error)
+
+func (f PassphraseFunc) Passphrase(name string) ([]byte, error) {
+	return f(name)
+}
+
+// EnvPassphrase returns a PassphraseProvider reading the passphrase from the
+// environment variable key.
+func EnvPassphrase(key string) PassphraseProvider {
+	return PassphraseFunc(func(name string) ([]byte, error) {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return nil, fmt.Errorf("ssh: passphrase for %s: %s not set", name, key)
+		}
+		return []byte(value), nil
+	})
+}
+
+// FilePassphrase returns a PassphraseProvider reading the passphrase from the
+// file at path, such as a mounted secret, with trailing newlines removed.
+func FilePassphrase(path string) PassphraseProvider {
+	return PassphraseFunc(func(name string) ([]byte, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	})
+}
+
+// PromptPassphrase returns a PassphraseProvider writing a prompt to w and
+// reading the passphrase as a line from r. Input is echoed if r is a
+// terminal; a PassphraseFunc using golang.org/x/term can read it hidden.
+func PromptPassphrase(r io.Reader, w io.Writer) PassphraseProvider {
+	br := bufio.NewReader(r)
+	return PassphraseFunc(func(name string) ([]byte, error) {
+		fmt.Fprintf(w, "Enter passphrase for %s: ", name)
+		line, err := br.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	})
+}
+
+// ParsePrivateKey parses a PEM encoded private key named name, asking
+// passphrases for its passphrase if it is encrypted.
+func ParsePrivateKey(name string, pemBytes []byte, passphrases PassphraseProvider) (Signer, error) {
+	signer, err := gossh.ParsePrivateKey(pemBytes)
+	var missing *gossh.PassphraseMissingError
+	if !errors.As(err, &missing) || passphrases == nil {
+		return signer, err
+	}
+	passphrase, err := passphrases.Passphrase(name)
+	if err != nil {
+		return nil, err
+	}
+	return gossh.ParsePrivateKeyWithPassphrase(pemBytes, passphrase)
+}
@@ -0,0 +1,44 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostKeyFileWithPassphrase(t *testing.T) {
+	key, err := GenerateKey(KeyTypeEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalPrivateKey(key, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "host_key")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("TEST_HOST_KEY_PASSPHRASE", "hunter2")
+	defer os.Unsetenv("TEST_HOST_KEY_PASSPHRASE")
+	srv := &Server{}
+	if err := srv.SetOption(HostKeyFileWithPassphrase(path, EnvPassphrase("TEST_HOST_KEY_PASSPHRASE"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.HostSigners) != 1 {
+		t.Fatalf("len(HostSigners) = %d; want 1", len(srv.HostSigners))
+	}
+
+	var prompt bytes.Buffer
+	wrong := PromptPassphrase(strings.NewReader("wrong\n"), &prompt)
+	if err := srv.SetOption(HostKeyFileWithPassphrase(path, wrong)); err == nil {
+		t.Fatal("key decrypted with wrong passphrase")
+	}
+	if !strings.Contains(prompt.String(), path) {
+		t.Fatalf("prompt = %#v; want key name", prompt.String())
+	}
+}
@@ -0,0 +1,276 @@
+package ssh
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Standard "permit-*" extensions of OpenSSH certificates, for use with
+// Permissions.Permit and Permissions.SetPermit.
+const (
+	PermitX11Forwarding   = "permit-X11-forwarding"
+	PermitAgentForwarding = "permit-agent-forwarding"
+	PermitPortForwarding  = "permit-port-forwarding"
+	PermitPty             = "permit-pty"
+	PermitUserRC          = "permit-user-rc"
+)
+
+const (
+	optionForceCommand  = "force-command"
+	optionSourceAddress = "source-address"
+
+	// permissionsNamespace namespaces the extensions of this package that
+	// have no OpenSSH equivalent.
+	permissionsNamespace = "gliderlabs.com"
+)
+
+// Extensions of this package, stored as name@permissionsNamespace.
+const (
+	extPrincipals   = "principals"
+	extValidAfter   = "valid-after"
+	extValidBefore  = "valid-before"
+	extPermitOpen   = "permit-open"
+	extPermitListen = "permit-listen"
+)
+
+// ForceCommand returns the command forced by the "force-command" critical
+// option, or "" if none. Sessions run the forced command instead of the
+// shell, exec or subsystem requested, which is passed to them in the
+// SSH_ORIGINAL_COMMAND environment variable, like OpenSSH.
+func (p Permissions) ForceCommand() string {
+	return p.option(optionForceCommand)
+}
+
+// SetForceCommand sets the "force-command" critical option, or removes it
+// if cmd is "".
+func (p *Permissions) SetForceCommand(cmd string) {
+	p.setOption(optionForceCommand, cmd)
+}
+
+// SourceAddress returns the addresses or CIDR ranges of the "source-address"
+// critical option, which are enforced by golang.org/x/crypto/ssh.
+func (p Permissions) SourceAddress() []string {
+	return splitList(p.option(optionSourceAddress))
+}
+
+// SetSourceAddress sets the "source-address" critical option, or removes it
+// if no addresses are given.
+func (p *Permissions) SetSourceAddress(addrs ...string) {
+	p.setOption(optionSourceAddress, strings.Join(addrs, ","))
+}
+
+// Permit reports whether the extension ext, such as PermitPty, is set.
+func (p Permissions) Permit(ext string) bool {
+	if p.Permissions == nil {
+		return false
+	}
+	_, ok := p.Extensions[ext]
+	return ok
+}
+
+// SetPermit sets or removes the extension ext, such as PermitPty.
+func (p *Permissions) SetPermit(ext string, permit bool) {
+	p.setExtension(ext, "", permit)
+}
+
+// Principals returns the principals the user authenticated as, such as
+// those of a certificate.
+func (p Permissions) Principals() []string {
+	return splitList(p.Namespace(permissionsNamespace).Get(extPrincipals))
+}
+
+// SetPrincipals sets the principals the user authenticated as.
+func (p *Permissions) SetPrincipals(principals ...string) {
+	p.Namespace(permissionsNamespace).Set(extPrincipals, strings.Join(principals, ","))
+}
+
+// Validity returns the time range the permissions are valid in. A zero time
+// means no bound.
+func (p Permissions) Validity() (after, before time.Time) {
+	ns := p.Namespace(permissionsNamespace)
+	return parseUnix(ns.Get(extValidAfter)), parseUnix(ns.Get(extValidBefore))
+}
+
+// SetValidity sets the time range the permissions are valid in. A zero time
+// means no bound. Connections are closed once the permissions set during
+// authentication expire, telling their sessions why.
+func (p *Permissions) SetValidity(after, before time.Time) {
+	ns := p.Namespace(permissionsNamespace)
+	ns.Set(extValidAfter, formatUnix(after))
+	ns.Set(extValidBefore, formatUnix(before))
+}
+
+// Valid reports whether t lies in the validity range of the permissions.
+func (p Permissions) Valid(t time.Time) bool {
+	after, before := p.Validity()
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
+
+// PermitOpen returns the "host:port" destinations local port forwarding is
+// restricted to, like the permitopen option of authorized_keys. None means
+// no restriction. The host or port may be "*" to match any. The handlers of
+// this package reject direct-tcpip channels to other destinations.
+func (p Permissions) PermitOpen() []string {
+	return splitList(p.Namespace(permissionsNamespace).Get(extPermitOpen))
+}
+
+// SetPermitOpen restricts local port forwarding to the given "host:port"
+// destinations.
+func (p *Permissions) SetPermitOpen(hostports ...string) {
+	p.Namespace(permissionsNamespace).Set(extPermitOpen, strings.Join(hostports, ","))
+}
+
+// PermitListen returns the "host:port" addresses reverse port forwarding is
+// restricted to, like the permitlisten option of authorized_keys. None means
+// no restriction. The host or port may be "*" to match any. The handlers of
+// this package reject tcpip-forward requests for other addresses.
+func (p Permissions) PermitListen() []string {
+	return splitList(p.Namespace(permissionsNamespace).Get(extPermitListen))
+}
+
+// SetPermitListen restricts reverse port forwarding to the given "host:port"
+// addresses.
+func (p *Permissions) SetPermitListen(hostports ...string) {
+	p.Namespace(permissionsNamespace).Set(extPermitListen, strings.Join(hostports, ","))
+}
+
+// permits reports whether host and port match one of the "host:port"
+// entries of list, or list is empty.
+func permits(list []string, host string, port uint32) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, entry := range list {
+		h, p, err := net.SplitHostPort(entry)
+		if err != nil {
+			continue
+		}
+		if (h == "*" || strings.EqualFold(h, host)) && (p == "*" || p == strconv.FormatUint(uint64(port), 10)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Namespace returns the extensions of p in the namespace ns, a domain name
+// owned by the application such as "example.com", stored as "name@ns" like
+// the vendor extensions of OpenSSH.
+func (p *Permissions) Namespace(ns string) PermissionsNamespace {
+	return PermissionsNamespace{p, ns}
+}
+
+// PermissionsNamespace is a set of extensions of Permissions named "name@ns",
+// which keeps the extensions of different applications apart.
+type PermissionsNamespace struct {
+	perms *Permissions
+	ns    string
+}
+
+// Get returns the value of the extension name, or "" if not set.
+func (n PermissionsNamespace) Get(name string) string {
+	if n.perms.Permissions == nil {
+		return ""
+	}
+	return n.perms.Extensions[name+"@"+n.ns]
+}
+
+// Lookup returns the value of the extension name and whether it is set.
+func (n PermissionsNamespace) Lookup(name string) (string, bool) {
+	if n.perms.Permissions == nil {
+		return "", false
+	}
+	value, ok := n.perms.Extensions[name+"@"+n.ns]
+	return value, ok
+}
+
+// Set sets the extension name to value, or removes it if value is "".
+func (n PermissionsNamespace) Set(name, value string) {
+	n.perms.setExtension(name+"@"+n.ns, value, value != "")
+}
+
+// Delete removes the extension name.
+func (n PermissionsNamespace) Delete(name string) {
+	n.perms.setExtension(name+"@"+n.ns, "", false)
+}
+
+// Names returns the sorted names of the extensions set in the namespace.
+func (n PermissionsNamespace) Names() []string {
+	if n.perms.Permissions == nil {
+		return nil
+	}
+	var names []string
+	suffix := "@" + n.ns
+	for key := range n.perms.Extensions {
+		if strings.HasSuffix(key, suffix) {
+			names = append(names, strings.TrimSuffix(key, suffix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p Permissions) option(name string) string {
+	if p.Permissions == nil {
+		return ""
+	}
+	return p.CriticalOptions[name]
+}
+
+func (p *Permissions) setOption(name, value string) {
+	if value == "" {
+		if p.Permissions != nil {
+			delete(p.CriticalOptions, name)
+		}
+		return
+	}
+	if p.Permissions == nil {
+		p.Permissions = &gossh.Permissions{}
+	}
+	if p.CriticalOptions == nil {
+		p.CriticalOptions = make(map[string]string)
+	}
+	p.CriticalOptions[name] = value
+}
+
+func (p *Permissions) setExtension(name, value string, set bool) {
+	if !set {
+		if p.Permissions != nil {
+			delete(p.Extensions, name)
+		}
+		return
+	}
+	if p.Permissions == nil {
+		p.Permissions = &gossh.Permissions{}
+	}
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]string)
+	}
+	p.Extensions[name] = value
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func parseUnix(s string) time.Time {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func formatUnix(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestPermissions(t *testing.T) {
+	t.Parallel()
+	p := Permissions{&gossh.Permissions{}}
+
+	p.SetForceCommand("/usr/bin/backup")
+	p.SetSourceAddress("10.0.0.0/8", "192.168.1.1")
+	p.SetPermit(PermitPty, true)
+	p.SetPrincipals("alice", "admins")
+	p.SetPermitOpen("db:5432")
+	after := time.Unix(1700000000, 0)
+	p.SetValidity(after, time.Time{})
+	p.Namespace("example.com").Set("role", "operator")
+
+	if got := p.CriticalOptions["force-command"]; got != "/usr/bin/backup" {
+		t.Fatalf("force-command = %#v; want %#v", got, "/usr/bin/backup")
+	}
+	if got := p.CriticalOptions["source-address"]; got != "10.0.0.0/8,192.168.1.1" {
+		t.Fatalf("source-address = %#v; want %#v", got, "10.0.0.0/8,192.168.1.1")
+	}
+	if got := p.Extensions["role@example.com"]; got != "operator" {
+		t.Fatalf("role@example.com = %#v; want %#v", got, "operator")
+	}
+	if !p.Permit(PermitPty) || p.Permit(PermitPortForwarding) {
+		t.Fatalf("Permit() = %v, %v; want true, false", p.Permit(PermitPty), p.Permit(PermitPortForwarding))
+	}
+	if got := p.Principals(); !reflect.DeepEqual(got, []string{"alice", "admins"}) {
+		t.Fatalf("Principals() = %#v; want %#v", got, []string{"alice", "admins"})
+	}
+	if got := p.PermitOpen(); !reflect.DeepEqual(got, []string{"db:5432"}) {
+		t.Fatalf("PermitOpen() = %#v; want %#v", got, []string{"db:5432"})
+	}
+	if got := p.PermitListen(); got != nil {
+		t.Fatalf("PermitListen() = %#v; want nil", got)
+	}
+	if gotAfter, gotBefore := p.Validity(); !gotAfter.Equal(after) || !gotBefore.IsZero() {
+		t.Fatalf("Validity() = %v, %v; want %v, zero", gotAfter, gotBefore, after)
+	}
+	if p.Valid(after.Add(-time.Second)) || !p.Valid(after) {
+		t.Fatal("Valid() ignores valid-after")
+	}
+	if got := p.Namespace("example.com").Names(); !reflect.DeepEqual(got, []string{"role"}) {
+		t.Fatalf("Names() = %#v; want %#v", got, []string{"role"})
+	}
+
+	p.SetForceCommand("")
+	p.SetPermit(PermitPty, false)
+	p.Namespace("example.com").Delete("role")
+	if p.ForceCommand() != "" || p.Permit(PermitPty) || len(p.Namespace("example.com").Names()) != 0 {
+		t.Fatalf("permissions not removed: %#v", p.Permissions)
+	}
+}
+
+func TestPermissionsZero(t *testing.T) {
+	t.Parallel()
+	var p Permissions
+	p.SetPermitOpen("db:5432")
+	p.SetForceCommand("")
+	p.Namespace("example.com").Delete("role")
+	if got := p.PermitOpen(); !reflect.DeepEqual(got, []string{"db:5432"}) {
+		t.Fatalf("PermitOpen() = %#v; want %#v", got, []string{"db:5432"})
+	}
+}
+
+func TestPermits(t *testing.T) {
+	t.Parallel()
+	list := []string{"db:5432", "*:80", "cache:*", "[::1]:22"}
+	for _, tt := range []struct {
+		host string
+		port uint32
+		want bool
+	}{
+		{"db", 5432, true},
+		{"DB", 5432, true},
+		{"db", 5433, false},
+		{"web", 80, true},
+		{"cache", 6379, true},
+		{"::1", 22, true},
+		{"other", 22, false},
+	} {
+		if got := permits(list, tt.host, tt.port); got != tt.want {
+			t.Fatalf("permits(%#v, %d) = %v; want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+	if !permits(nil, "any", 1) {
+		t.Fatal("empty list denies")
+	}
+}
@@ -0,0 +1,215 @@
+package ssh
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW        = kernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = kernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 64 << 10
+	errorPipeConnected        = syscall.Errno(535)
+	errorNoData               = syscall.Errno(232)
+)
+
+// ListenPipe listens on the Windows named pipe name, such as
+// `\\.\pipe\ssh`, for use with Serve. Only local clients can connect, and
+// access is controlled by the default security descriptor of named pipes,
+// which grants full control to the LocalSystem account, administrators and
+// the owner, and read access to everyone else, so other users can't connect.
+// Connections have the pipe name as both their local and remote address, so
+// AbuseReporter, MaxConnsPerSource and other checks of client IPs don't
+// apply to them.
+func ListenPipe(name string) (net.Listener, error) {
+	h, err := createPipe(name, true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(name), Err: err}
+	}
+	return &pipeListener{name: name, h: h}, nil
+}
+
+// ListenAndServePipe listens on the Windows named pipe name, as with
+// ListenPipe, and then calls Serve to handle incoming connections.
+// ListenAndServePipe always returns a non-nil error.
+func (srv *Server) ListenAndServePipe(name string) error {
+	ln, err := ListenPipe(name)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(ln)
+}
+
+// ListenAndServePipe listens on the Windows named pipe name and then calls
+// Serve with handler to handle sessions on incoming connections.
+func ListenAndServePipe(name string, handler Handler, options ...Option) error {
+	srv := &Server{Handler: handler}
+	for _, option := range options {
+		if err := srv.SetOption(option); err != nil {
+			return err
+		}
+	}
+	return srv.ListenAndServePipe(name)
+}
+
+// createPipe creates an instance of the pipe name for overlapped I/O, so
+// connections can be served by the runtime poller and have deadlines.
+func createPipe(name string, first bool) (syscall.Handle, error) {
+	name16, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uint32(pipeAccessDuplex | syscall.FILE_FLAG_OVERLAPPED)
+	if first {
+		// Fail rather than serve as another instance of a pipe created by
+		// someone else.
+		mode |= fileFlagFirstPipeInstance
+	}
+	r, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name16)),
+		uintptr(mode),
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0,
+	)
+	if syscall.Handle(r) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(r), nil
+}
+
+// pipeListener accepts connections on a named pipe. Each connection takes
+// over the pipe instance it connected to, and the next Accept creates a new
+// one.
+type pipeListener struct {
+	name     string
+	acceptMu sync.Mutex // serializes Accept
+
+	mu        sync.Mutex
+	h         syscall.Handle // instance waiting for a client, if valid
+	accepting bool           // whether Accept is waiting on h
+	closed    bool
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.acceptMu.Lock()
+	defer l.acceptMu.Unlock()
+	for {
+		h, err := l.connect()
+		if err == errorNoData {
+			continue
+		}
+		if err != nil {
+			return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.name), Err: err}
+		}
+		return &pipeConn{File: os.NewFile(uintptr(h), l.name), addr: pipeAddr(l.name)}, nil
+	}
+}
+
+// connect waits for a client to connect to the waiting instance and returns
+// it.
+func (l *pipeListener) connect() (syscall.Handle, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return syscall.InvalidHandle, net.ErrClosed
+	}
+	if l.h == syscall.InvalidHandle {
+		h, err := createPipe(l.name, false)
+		if err != nil {
+			l.mu.Unlock()
+			return syscall.InvalidHandle, err
+		}
+		l.h = h
+	}
+	h := l.h
+	event, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if event == 0 {
+		l.mu.Unlock()
+		return syscall.InvalidHandle, err
+	}
+	defer syscall.CloseHandle(syscall.Handle(event))
+	ov := &syscall.Overlapped{HEvent: syscall.Handle(event)}
+	// Close cancels the pending connect, so it is started while holding
+	// the lock.
+	r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+	if r == 0 && err == syscall.ERROR_IO_PENDING {
+		l.accepting = true
+		l.mu.Unlock()
+		var n uint32
+		r, _, err = procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&n)), 1)
+		l.mu.Lock()
+		l.accepting = false
+	}
+	defer l.mu.Unlock()
+	if l.closed {
+		syscall.CloseHandle(h)
+		l.h = syscall.InvalidHandle
+		return syscall.InvalidHandle, net.ErrClosed
+	}
+	if r == 0 && err != errorPipeConnected {
+		if err == errorNoData {
+			// The client went away before it was accepted, and the
+			// instance can't be reused without disconnecting it.
+			syscall.CloseHandle(h)
+			l.h = syscall.InvalidHandle
+		}
+		return syscall.InvalidHandle, err
+	}
+	l.h = syscall.InvalidHandle
+	return h, nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	switch {
+	case l.accepting:
+		// The pending Accept closes the instance once cancelled.
+		return syscall.CancelIoEx(l.h, nil)
+	case l.h != syscall.InvalidHandle:
+		err := syscall.CloseHandle(l.h)
+		l.h = syscall.InvalidHandle
+		return err
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
+
+// pipeAddr is the address of a named pipe, which is its name.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a connection from a client of a named pipe.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func dialPipe(t *testing.T, name string) net.Conn {
+	name16, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := syscall.CreateFile(name16, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pipeConn{File: os.NewFile(uintptr(h), name), addr: pipeAddr(name)}
+}
+
+func TestListenPipe(t *testing.T) {
+	t.Parallel()
+	name := fmt.Sprintf(`\\.\pipe\gliderlabs-ssh-test-%d`, os.Getpid())
+	l, err := ListenPipe(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenPipe(name); err == nil {
+		t.Fatal("second ListenPipe succeeded; want error")
+	}
+	remote := make(chan string, 1)
+	srv := &Server{
+		Handler: func(s Session) {
+			remote <- s.RemoteAddr().String()
+			s.Write([]byte("hello"))
+		},
+		PasswordHandler: func(ctx Context, password string) bool { return password == "testpass" },
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+
+	for i := 0; i < 2; i++ {
+		conn := dialPipe(t, name)
+		c, chans, reqs, err := gossh.NewClientConn(conn, name, &gossh.ClientConfig{
+			User:            "testuser",
+			Auth:            []gossh.AuthMethod{gossh.Password("testpass")},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := gossh.NewClient(c, chans, reqs)
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := session.Output("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != "hello" {
+			t.Fatalf("output = %#v; want %#v", string(out), "hello")
+		}
+		if addr := <-remote; addr != name {
+			t.Fatalf("RemoteAddr = %#v; want %#v", addr, name)
+		}
+		client.Close()
+	}
+
+	srv.Close()
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve = %v; want %v", err, ErrServerClosed)
+	}
+}
@@ -0,0 +1,363 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A connection is handled by a pipeline of stages, each of which can be
+// extended by middleware wrapping the handler of the stage:
+//
+//	transport   the network connection before the SSH handshake, once
+//	            ConnCallback, HTTPHandler and TrustForwardedCallback ran
+//	auth        each authentication attempt during the handshake
+//	connection  the established SSH connection, dispatching its channels
+//	            and global requests
+//	channel     each new channel, dispatched by type to ChannelHandlers
+//	request     each global request, dispatched by type to RequestHandlers
+//
+// Middleware can observe, modify or reject the handling of a stage, and adds
+// behavior to the server without forking this package. The middleware of a
+// stage is applied in order, so the first one runs outermost.
+
+// TransportHandler handles a network connection from the SSH handshake on,
+// and closes it once done.
+type TransportHandler func(ctx Context, srv *Server, conn net.Conn)
+
+// TransportMiddleware wraps the transport stage of the pipeline. To reject a
+// connection it closes conn instead of calling next.
+type TransportMiddleware func(next TransportHandler) TransportHandler
+
+// AuthAttempt checks an authentication attempt with the given method, which
+// is "password", "publickey" or "keyboard-interactive", by calling the
+// corresponding handler of the server.
+type AuthAttempt func(ctx Context, method string) bool
+
+// AuthMiddleware wraps the auth stage of the pipeline. It can reject an
+// attempt by returning false without calling next.
+type AuthMiddleware func(next AuthAttempt) AuthAttempt
+
+// ConnHandler handles an established SSH connection until it is closed. It
+// must service chans and reqs until they are closed.
+type ConnHandler func(ctx Context, srv *Server, conn *gossh.ServerConn, chans <-chan gossh.NewChannel, reqs <-chan *gossh.Request)
+
+// ConnMiddleware wraps the connection stage of the pipeline. To reject a
+// connection it closes conn instead of calling next.
+type ConnMiddleware func(next ConnHandler) ConnHandler
+
+// ChannelMiddleware wraps the channel stage of the pipeline, and so every
+// channel handler. It can reject a channel by calling newChan.Reject instead
+// of next.
+type ChannelMiddleware func(next ChannelHandler) ChannelHandler
+
+// RequestMiddleware wraps the request stage of the pipeline, and so every
+// global request handler. It can reject a request by returning false without
+// calling next.
+type RequestMiddleware func(next RequestHandler) RequestHandler
+
+// acceptTransport runs the built-in steps of the transport stage. It reports
+// whether the connection should be handled as SSH, in which case it returns
+// the connection to use.
+func (srv *Server) acceptTransport(ctx Context, newConn net.Conn) (net.Conn, bool) {
+	if srv.ConnCallback != nil {
+		cbConn := srv.ConnCallback(ctx, newConn)
+		if cbConn == nil {
+			srv.logf(ctx, slog.LevelInfo, "connection rejected", "addr", newConn.RemoteAddr().String(), "reason", "ConnCallback")
+			srv.metrics().ConnRejected("ConnCallback")
+			newConn.Close()
+			return nil, false
+		}
+		newConn = cbConn
+	}
+	if srv.HTTPHandler != nil {
+		conn, isSSH, err := sniffConn(newConn)
+		if err != nil {
+			newConn.Close()
+			return nil, false
+		}
+		if !isSSH {
+			srv.serveHTTP(conn)
+			return nil, false
+		}
+		newConn = conn
+	}
+	if srv.HostSignersCallback != nil {
+		var version string
+		newConn, version = readClientVersion(newConn)
+		ctx.SetValue(ContextKeyClientVersion, version)
+	}
+	srv.applyConnAddrs(ctx, newConn)
+	// count the connection first, so clients held up by Blocked can't
+	// pile up past the connection limits
+	if limit := srv.acquireConn(ctx); limit != "" {
+		srv.logf(ctx, slog.LevelInfo, "connection rejected", "reason", limit)
+		srv.metrics().ConnRejected(limit)
+		newConn.Close()
+		return nil, false
+	}
+	if srv.AbuseReporter != nil && srv.AbuseReporter.Blocked(ctx.RemoteAddr()) {
+		srv.logf(ctx, slog.LevelInfo, "connection rejected", "reason", "blocked")
+		srv.metrics().ConnRejected("blocked")
+		newConn.Close()
+		return nil, false
+	}
+	return newConn, true
+}
+
+// ErrHandshakeTimeout is the error of connections closed for exceeding the
+// server's HandshakeTimeout.
+var ErrHandshakeTimeout = errors.New("ssh: handshake timeout")
+
+// handshake performs the SSH handshake, including the auth stage, and passes
+// the established connection to the connection stage.
+func (srv *Server) handshake(ctx Context, newConn net.Conn, cancel context.CancelFunc) {
+	conn := &serverConn{
+		Conn:          newConn,
+		idleTimeout:   srv.IdleTimeout,
+		closeCanceler: cancel,
+	}
+	if srv.MaxTimeout > 0 {
+		conn.maxDeadline = time.Now().Add(srv.MaxTimeout)
+	}
+	conn.kex = &kexSniffer{
+		buf: make([]byte, 0, 1024),
+		onKexInit: func(msg *kexInitMsg) error {
+			ctx.SetValue(contextKeyClientKexInit, msg)
+			if srv.RequireStrictKex && !contains(msg.KexAlgos, kexStrictClient) {
+				conn.setCloseReason(CloseReasonPolicy, ErrStrictKexRequired)
+				return ErrStrictKexRequired
+			}
+			if srv.HASSHCallback != nil && !srv.HASSHCallback(ctx, msg.hassh()) {
+				conn.setCloseReason(CloseReasonPolicy, ErrHASSHDenied)
+				return ErrHASSHDenied
+			}
+			return nil
+		},
+	}
+	defer conn.Close()
+	if !srv.trackConn(conn, true) {
+		return
+	}
+	defer srv.trackConn(conn, false)
+	conn.opened = time.Now()
+	srv.metrics().ConnOpened()
+	ctx.SetValue(contextKeyServerConn, conn)
+	srv.traceConn(ctx, conn)
+	var timer *time.Timer
+	if srv.HandshakeTimeout > 0 {
+		timer = time.AfterFunc(srv.HandshakeTimeout, func() { conn.Close() })
+	}
+	sshConn, chans, reqs, err := gossh.NewServerConn(conn, srv.config(ctx))
+	if err != nil {
+		srv.reportAuthFailure(ctx)
+	}
+	if timer != nil && !timer.Stop() {
+		// the timer fired and closed the connection, possibly just as the
+		// handshake completed
+		if err == nil {
+			sshConn.Close()
+		}
+		err = ErrHandshakeTimeout
+	}
+	if err != nil {
+		srv.logf(ctx, slog.LevelInfo, "handshake failed", "error", err.Error())
+		if srv.ConnectionFailedCallback != nil {
+			srv.ConnectionFailedCallback(ctx, err)
+		}
+		srv.connClosed(ctx, conn, err)
+		return
+	}
+	defer srv.untrackChannels(ctx)
+
+	ctx.SetValue(ContextKeyConn, sshConn)
+	applyConnMetadata(ctx, sshConn)
+	applyImpersonation(ctx)
+	conn.span.SetAttribute("ssh.user", ctx.User())
+	conn.span.SetAttribute("ssh.session_id", ctx.SessionID())
+	conn.span.SetAttribute("ssh.client_version", ctx.ClientVersion())
+	srv.watchExpiry(ctx, conn)
+	defer conn.stopExpiry()
+	open := AuditEvent{Type: AuditConnOpen, Outcome: AuditSuccess, StrictKex: StrictKex(ctx)}
+	if params, ok := ConnCrypto(ctx); ok {
+		open.KeyExchange, open.Cipher = params.KeyExchange, params.ClientToServer.Cipher
+	}
+	open.HASSH, _ = HASSH(ctx)
+	audit(ctx, open)
+	srv.logf(ctx, slog.LevelInfo, "connection opened", "client_version", string(sshConn.ClientVersion()), "kex", open.KeyExchange, "cipher", open.Cipher)
+
+	handler := ConnHandler(func(ctx Context, srv *Server, sshConn *gossh.ServerConn, chans <-chan gossh.NewChannel, reqs <-chan *gossh.Request) {
+		srv.serveConn(ctx, sshConn, chans, reqs)
+	})
+	for i := len(srv.ConnMiddleware) - 1; i >= 0; i-- {
+		handler = srv.ConnMiddleware[i](handler)
+	}
+	handler(ctx, srv, sshConn, chans, reqs)
+	srv.connClosed(ctx, conn, sshConn.Wait())
+	// Closing the connection cancels ctx, which tells handlers to return.
+	conn.Close()
+	conn.handlers.Wait()
+}
+
+// serveConn dispatches the channels and global requests of a connection,
+// returning once chans is closed.
+func (srv *Server) serveConn(ctx Context, sshConn *gossh.ServerConn, chans <-chan gossh.NewChannel, reqs <-chan *gossh.Request) {
+	spawn(ctx, func() { srv.handleRequests(ctx, reqs) })
+	conn, _ := ctx.Value(contextKeyServerConn).(*serverConn)
+	for ch := range chans {
+		srv.logf(ctx, slog.LevelDebug, "channel open", "type", ch.ChannelType())
+		if srv.isDraining() {
+			ch.Reject(gossh.ResourceShortage, message(ctx, MessageShuttingDown))
+			continue
+		}
+		handler := srv.ChannelHandlers[ch.ChannelType()]
+		if handler == nil {
+			handler = srv.ChannelHandlers["default"]
+		}
+		if handler == nil {
+			srv.logf(ctx, slog.LevelDebug, "channel rejected", "type", ch.ChannelType(), "reason", "unsupported")
+			ch.Reject(gossh.UnknownChannelType, message(ctx, MessageUnsupportedChannel))
+			continue
+		}
+		for i := len(srv.ChannelMiddleware) - 1; i >= 0; i-- {
+			handler = srv.ChannelMiddleware[i](handler)
+		}
+		ch := ch
+		session := ch.ChannelType() == "session" && srv.MaxSessions > 0 && conn != nil
+		if session && atomic.AddInt32(&conn.sessions, 1) > int32(srv.MaxSessions) {
+			atomic.AddInt32(&conn.sessions, -1)
+			srv.logf(ctx, slog.LevelDebug, "channel rejected", "type", ch.ChannelType(), "reason", "MaxSessions")
+			ch.Reject(gossh.ResourceShortage, message(ctx, MessageTooManySessions))
+			continue
+		}
+		if conn != nil {
+			atomic.AddInt32(&conn.opening, 1)
+		}
+		spawn(ctx, func() {
+			if conn != nil {
+				defer atomic.AddInt32(&conn.opening, -1)
+			}
+			if session {
+				defer atomic.AddInt32(&conn.sessions, -1)
+			}
+			handler(srv, sshConn, ch, ctx)
+		})
+	}
+}
+
+func (srv *Server) handleRequests(ctx Context, in <-chan *gossh.Request) {
+	limiter := newRequestLimiter(srv.GlobalRequestLimits)
+	for req := range in {
+		if !limiter.allow(req.Type, time.Now()) {
+			srv.logf(ctx, slog.LevelInfo, "global request rate exceeded", "type", req.Type)
+			req.Reply(false, nil)
+			if conn, ok := ctx.Value(contextKeyServerConn).(*serverConn); ok {
+				conn.setCloseReason(CloseReasonPolicy, ErrRequestRateExceeded)
+				conn.Close()
+			}
+			continue
+		}
+		handler := srv.RequestHandlers[req.Type]
+		if handler == nil {
+			handler = srv.RequestHandlers["default"]
+		}
+		if handler == nil {
+			srv.logf(ctx, slog.LevelDebug, "global request", "type", req.Type, "ok", false)
+			req.Reply(false, nil)
+			continue
+		}
+		for i := len(srv.RequestMiddleware) - 1; i >= 0; i-- {
+			handler = srv.RequestMiddleware[i](handler)
+		}
+		req := req
+		ret, payload := replyWithin(srv.RequestTimeout, req.WantReply, func() (bool, []byte) {
+			return handler(ctx, srv, req)
+		}, func(ok bool, payload []byte) {
+			if ok {
+				srv.cancelRequest(ctx, req, payload)
+			}
+		})
+		srv.logf(ctx, slog.LevelDebug, "global request", "type", req.Type, "ok", ret)
+		req.Reply(ret, payload)
+	}
+}
+
+// cancelRequest undoes a forward request whose handler succeeded after the
+// request was answered with a failure, so the listener it bound doesn't
+// outlive the forward the client was told failed.
+func (srv *Server) cancelRequest(ctx Context, req *gossh.Request, payload []byte) {
+	var cancel *gossh.Request
+	switch req.Type {
+	case "tcpip-forward":
+		var reqPayload remoteForwardRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			return
+		}
+		port := reqPayload.BindPort
+		if port == 0 {
+			var success remoteForwardSuccess
+			if err := gossh.Unmarshal(payload, &success); err != nil {
+				return
+			}
+			port = success.BindPort
+		}
+		cancel = &gossh.Request{
+			Type:    "cancel-tcpip-forward",
+			Payload: gossh.Marshal(&remoteForwardCancelRequest{reqPayload.BindAddr, port}),
+		}
+	case "streamlocal-forward@openssh.com":
+		cancel = &gossh.Request{Type: "cancel-streamlocal-forward@openssh.com", Payload: req.Payload}
+	default:
+		return
+	}
+	handler := srv.RequestHandlers[cancel.Type]
+	if handler == nil {
+		handler = srv.RequestHandlers["default"]
+	}
+	if handler == nil {
+		return
+	}
+	ok, _ := handler(ctx, srv, cancel)
+	srv.logf(ctx, slog.LevelInfo, "late request canceled", "type", req.Type, "ok", ok)
+}
+
+// authAttempt returns check wrapped in the auth middleware of the server.
+func (srv *Server) authAttempt(method string, check func(ctx Context) bool) func(ctx Context) bool {
+	attempt := AuthAttempt(func(ctx Context, method string) bool {
+		if srv.UserExistsCallback != nil && !srv.UserExistsCallback(ctx) {
+			// go through the motions, so the rejection takes as long
+			if srv.DecoyUser != "" {
+				ctx = decoyContext{ctx, srv.DecoyUser}
+			}
+			check(ctx)
+			return false
+		}
+		return check(ctx)
+	})
+	for i := len(srv.AuthMiddleware) - 1; i >= 0; i-- {
+		attempt = srv.AuthMiddleware[i](attempt)
+	}
+	return func(ctx Context) bool {
+		ok := attempt(ctx, method)
+		if !ok || method != "publickey" {
+			// accepted keys are reported once the client signed with them
+			srv.reportAuth(ctx, method, ok)
+		}
+		if !ok {
+			ctx.SetValue(contextKeyAuthFailure, method)
+		}
+		return ok
+	}
+}
+
+// reportAuth records the outcome of an authentication attempt with method.
+func (srv *Server) reportAuth(ctx Context, method string, ok bool) {
+	audit(ctx, AuditEvent{Type: AuditAuth, Outcome: auditOutcome(ok), Method: method})
+	srv.logf(ctx, slog.LevelInfo, "auth", "method", method, "ok", ok)
+	srv.metrics().AuthAttempt(method, ok)
+}
@@ -0,0 +1,224 @@
+package ssh
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+	var calls []string
+	trace := func(name string) AuthMiddleware {
+		return func(next AuthAttempt) AuthAttempt {
+			return func(ctx Context, method string) bool {
+				calls = append(calls, name+" "+method)
+				return next(ctx, method)
+			}
+		}
+	}
+	srv := &Server{AuthMiddleware: []AuthMiddleware{trace("outer"), trace("inner")}}
+	ctx, cancel := newContext(srv)
+	defer cancel()
+	ok := srv.authAttempt("password", func(ctx Context) bool {
+		calls = append(calls, "handler")
+		return true
+	})(ctx)
+	want := []string{"outer password", "inner password", "handler"}
+	if !ok || !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %#v, ok = %v; want %#v, true", calls, ok, want)
+	}
+}
+
+func TestChannelMiddleware(t *testing.T) {
+	t.Parallel()
+	reject := func(next ChannelHandler) ChannelHandler {
+		return func(srv *Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx Context) {
+			if ctx.User() == "blocked" {
+				newChan.Reject(gossh.Prohibited, "blocked")
+				return
+			}
+			next(srv, conn, newChan, ctx)
+		}
+	}
+	l := newLocalListener()
+	srv := &Server{
+		Handler:           func(s Session) {},
+		ChannelMiddleware: []ChannelMiddleware{reject},
+	}
+	go srv.serveOnce(l)
+	config := &gossh.ClientConfig{
+		User:            "blocked",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	}
+	client, err := gossh.Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.NewSession(); err == nil {
+		t.Fatal("session opened despite middleware rejecting it")
+	}
+}
+
+// callTrace records the calls made through the stages of a pipeline.
+type callTrace struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (c *callTrace) add(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+func (c *callTrace) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func TestTransportMiddleware(t *testing.T) {
+	t.Parallel()
+	for _, reject := range []bool{false, true} {
+		var trace callTrace
+		middleware := func(name string) TransportMiddleware {
+			return func(next TransportHandler) TransportHandler {
+				return func(ctx Context, srv *Server, conn net.Conn) {
+					trace.add(name)
+					if reject && name == "inner" {
+						conn.Close()
+						return
+					}
+					next(ctx, srv, conn)
+				}
+			}
+		}
+		l := newLocalListener()
+		srv := &Server{
+			Handler:             func(s Session) {},
+			TransportMiddleware: []TransportMiddleware{middleware("outer"), middleware("inner")},
+		}
+		done := make(chan struct{})
+		go func() {
+			srv.serveOnce(l)
+			close(done)
+		}()
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "user",
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		<-done
+		if reject != (err != nil) {
+			t.Fatalf("Dial with reject = %v: %v", reject, err)
+		}
+		if want := []string{"outer", "inner"}; !reflect.DeepEqual(trace.get(), want) {
+			t.Fatalf("calls = %#v; want %#v", trace.get(), want)
+		}
+	}
+}
+
+func TestConnMiddleware(t *testing.T) {
+	t.Parallel()
+	for _, user := range []string{"allowed", "blocked"} {
+		var trace callTrace
+		middleware := func(name string) ConnMiddleware {
+			return func(next ConnHandler) ConnHandler {
+				return func(ctx Context, srv *Server, conn *gossh.ServerConn, chans <-chan gossh.NewChannel, reqs <-chan *gossh.Request) {
+					trace.add(name + " " + ctx.User())
+					if name == "inner" && ctx.User() == "blocked" {
+						conn.Close()
+						return
+					}
+					next(ctx, srv, conn, chans, reqs)
+				}
+			}
+		}
+		l := newLocalListener()
+		srv := &Server{
+			Handler:        func(s Session) {},
+			ConnMiddleware: []ConnMiddleware{middleware("outer"), middleware("inner")},
+		}
+		go srv.serveOnce(l)
+		client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            user,
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := client.NewSession()
+		if err == nil {
+			session.Close()
+		}
+		client.Close()
+		if blocked := user == "blocked"; blocked != (err != nil) {
+			t.Fatalf("NewSession as %s: %v", user, err)
+		}
+		if want := []string{"outer " + user, "inner " + user}; !reflect.DeepEqual(trace.get(), want) {
+			t.Fatalf("calls = %#v; want %#v", trace.get(), want)
+		}
+	}
+}
+
+func TestRequestMiddleware(t *testing.T) {
+	t.Parallel()
+	var trace callTrace
+	middleware := func(name string) RequestMiddleware {
+		return func(next RequestHandler) RequestHandler {
+			return func(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
+				trace.add(name + " " + string(req.Payload))
+				if name == "inner" && string(req.Payload) == "blocked" {
+					return false, nil
+				}
+				return next(ctx, srv, req)
+			}
+		}
+	}
+	l := newLocalListener()
+	srv := &Server{
+		Handler: func(s Session) {},
+		RequestHandlers: map[string]RequestHandler{
+			"test": func(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
+				trace.add("handler")
+				return true, nil
+			},
+		},
+		RequestMiddleware: []RequestMiddleware{middleware("outer"), middleware("inner")},
+	}
+	go srv.serveOnce(l)
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "user",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, tt := range []struct {
+		payload string
+		ok      bool
+		calls   []string
+	}{
+		{"allowed", true, []string{"outer allowed", "inner allowed", "handler"}},
+		{"blocked", false, []string{"outer blocked", "inner blocked"}},
+	} {
+		trace.mu.Lock()
+		trace.calls = nil
+		trace.mu.Unlock()
+		ok, _, err := client.SendRequest("test", true, []byte(tt.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok || !reflect.DeepEqual(trace.get(), tt.calls) {
+			t.Fatalf("SendRequest(%q) = %v with calls %#v; want %v with %#v", tt.payload, ok, trace.get(), tt.ok, tt.calls)
+		}
+	}
+}
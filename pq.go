@@ -0,0 +1,66 @@
+package ssh
+
+import gossh "golang.org/x/crypto/ssh"
+
+// PostQuantumKexAlgorithms are the hybrid post-quantum key exchanges, in
+// order of preference. Only those implemented by golang.org/x/crypto/ssh are
+// offered.
+var PostQuantumKexAlgorithms = []string{
+	"mlkem768x25519-sha256",
+	"sntrup761x25519-sha512",
+	"sntrup761x25519-sha512@openssh.com",
+}
+
+// PostQuantumPolicy controls the use of post-quantum key exchanges.
+type PostQuantumPolicy int
+
+const (
+	// PostQuantumDefault leaves the key exchanges as configured, or the
+	// defaults of golang.org/x/crypto/ssh.
+	PostQuantumDefault PostQuantumPolicy = iota
+	// PostQuantumPrefer moves the post-quantum key exchanges before the
+	// others, so they are used with every client supporting them.
+	PostQuantumPrefer
+	// PostQuantumRequire only offers post-quantum key exchanges, refusing
+	// clients without support for them.
+	PostQuantumRequire
+)
+
+// isPostQuantumKex reports whether algo is a post-quantum key exchange.
+func isPostQuantumKex(algo string) bool {
+	return contains(PostQuantumKexAlgorithms, algo)
+}
+
+// PostQuantum reports whether the key exchange of p is post-quantum.
+func (p CryptoParams) PostQuantum() bool {
+	return isPostQuantumKex(p.KeyExchange)
+}
+
+// applyPostQuantumPolicy reorders or filters the key exchanges of config
+// according to policy.
+func applyPostQuantumPolicy(config *gossh.ServerConfig, policy PostQuantumPolicy) {
+	if policy == PostQuantumDefault {
+		return
+	}
+	kexs := config.KeyExchanges
+	if len(kexs) == 0 {
+		kexs = gossh.SupportedAlgorithms().KeyExchanges
+	}
+	supported := gossh.SupportedAlgorithms().KeyExchanges
+	var pq, classic []string
+	for _, algo := range PostQuantumKexAlgorithms {
+		if contains(supported, algo) {
+			pq = append(pq, algo)
+		}
+	}
+	for _, algo := range kexs {
+		if !isPostQuantumKex(algo) {
+			classic = append(classic, algo)
+		}
+	}
+	if policy == PostQuantumRequire {
+		config.KeyExchanges = pq
+		return
+	}
+	config.KeyExchanges = append(pq, classic...)
+}
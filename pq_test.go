@@ -0,0 +1,60 @@
+package ssh
+
+import (
+	"reflect"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestApplyPostQuantumPolicy(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		policy PostQuantumPolicy
+		kexs   []string
+		want   []string
+	}{
+		{PostQuantumDefault, []string{"curve25519-sha256"}, []string{"curve25519-sha256"}},
+		{PostQuantumPrefer, []string{"curve25519-sha256", "mlkem768x25519-sha256"}, []string{"mlkem768x25519-sha256", "curve25519-sha256"}},
+		{PostQuantumRequire, []string{"curve25519-sha256"}, []string{"mlkem768x25519-sha256"}},
+	} {
+		config := &gossh.ServerConfig{}
+		config.KeyExchanges = tt.kexs
+		applyPostQuantumPolicy(config, tt.policy)
+		if !reflect.DeepEqual(config.KeyExchanges, tt.want) {
+			t.Fatalf("policy %d: KeyExchanges = %#v; want %#v", tt.policy, config.KeyExchanges, tt.want)
+		}
+	}
+}
+
+func TestPostQuantumRequire(t *testing.T) {
+	t.Parallel()
+	params := make(chan CryptoParams, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			p, _ := ConnCrypto(s.Context().(Context))
+			params <- p
+		},
+		PostQuantumKex: PostQuantumRequire,
+	}, nil)
+	defer cleanup()
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-params; !p.PostQuantum() {
+		t.Fatalf("KeyExchange = %#v; want a post-quantum one", p.KeyExchange)
+	}
+
+	l := newLocalListener()
+	srv := &Server{Handler: func(s Session) {}, PostQuantumKex: PostQuantumRequire}
+	go srv.serveOnce(l)
+	config := &gossh.ClientConfig{
+		Config:          gossh.Config{KeyExchanges: []string{"curve25519-sha256"}},
+		User:            "testuser",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	}
+	if client, err := gossh.Dial("tcp", l.Addr().String(), config); err == nil {
+		client.Close()
+		t.Fatal("classic key exchange accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// errPtyUnsupported is returned by openPty on platforms without PTY support.
+var errPtyUnsupported = errors.New("ssh: pty allocation is not supported on this platform")
+
+// ptyFile is a PTY allocated for a session.
+type ptyFile struct {
+	master *os.File // read and written by the server
+	tty    *os.File // terminal of the command
+}
+
+// name returns the path of the terminal device, or "" for a nil PTY.
+func (t *ptyFile) name() string {
+	if t == nil {
+		return ""
+	}
+	return t.tty.Name()
+}
+
+// RunWithPty runs cmd attached to the session and ends the session with its
+// outcome, like ShellHandler. If the client requested a PTY, the command runs
+// on a terminal allocated with the requested size and terminal modes, as the
+// leader of a new session controlled by it, and window changes are applied to
+// the terminal. Output is copied until every process using the terminal has
+// closed it. Otherwise, or where PTYs are not supported, its standard streams
+// are connected to the session. PTYs are supported on Linux, macOS, FreeBSD,
+// OpenBSD and illumos.
+//
+// RunWithPty reads the window changes of Session.Pty, which must not be
+// consumed by the handler. The standard streams and the controlling terminal
+// options of cmd.SysProcAttr are set by RunWithPty.
+func RunWithPty(s Session, cmd *exec.Cmd) {
+	pty, winCh, isPty := s.Pty()
+	if !isPty {
+		runCommand(s, cmd)
+		return
+	}
+	t, err := openPty(pty)
+	if errors.Is(err, errPtyUnsupported) {
+		runCommand(s, cmd)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(s.Stderr(), err)
+		s.Exit(1)
+		return
+	}
+	runPty(s, cmd, t, winCh)
+}
+
+// runPty runs cmd on the terminal t and ends the session with its outcome.
+func runPty(s Session, cmd *exec.Cmd, t *ptyFile, winCh <-chan Window) {
+	defer t.master.Close()
+	if sess, ok := s.(*session); ok {
+		sess.Lock()
+		sess.ptyEcho = t.echoing
+		sess.Unlock()
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = t.tty, t.tty, t.tty
+	err := t.start(cmd)
+	t.tty.Close()
+	if err != nil {
+		fmt.Fprintln(s.Stderr(), err)
+		s.Exit(127)
+		return
+	}
+	go func() {
+		for win := range winCh {
+			// the kernel signals the foreground process group
+			t.resize(win)
+		}
+	}()
+	go io.Copy(t.master, s)
+	output := make(chan struct{})
+	go func() {
+		// ends with an error once no process has the terminal open
+		io.Copy(s, t.master)
+		close(output)
+	}()
+	err = cmd.Wait()
+	<-output
+	if err != nil && cmd.ProcessState == nil {
+		s.Exit(1)
+		return
+	}
+	ExitProcess(s, cmd.ProcessState)
+}
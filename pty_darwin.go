@@ -0,0 +1,55 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type tcflag = uint64
+
+const (
+	sysIoctl        = syscall.SYS_IOCTL
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+var (
+	ttyOSIflags = map[uint8]tcflag{gossh.IUTF8: syscall.IUTF8}
+	ttyOSLflags map[uint8]tcflag
+	ttyOSOflags map[uint8]tcflag
+)
+
+// openPtyPair opens /dev/ptmx and the terminal it allocated, doing the work
+// of grantpt, unlockpt and ptsname.
+func openPtyPair() (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var name [128]byte
+	err = ioctl(master, syscall.TIOCPTYGRANT, nil)
+	if err == nil {
+		err = ioctl(master, syscall.TIOCPTYUNLK, nil)
+	}
+	if err == nil {
+		err = ioctl(master, syscall.TIOCPTYGNAME, unsafe.Pointer(&name))
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	n := bytes.IndexByte(name[:], 0)
+	if n < 0 {
+		n = len(name)
+	}
+	tty, err = os.OpenFile(string(name[:n]), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type tcflag = uint32
+
+const (
+	sysIoctl        = syscall.SYS_IOCTL
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+var ttyOSIflags, ttyOSLflags, ttyOSOflags map[uint8]tcflag
+
+// openPtyPair allocates a PTY with posix_openpt, for which grantpt and
+// unlockpt have nothing to do, and opens its terminal.
+func openPtyPair() (master, tty *os.File, err error) {
+	fd, _, errno := syscall.Syscall(syscall.SYS_POSIX_OPENPT, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0, 0)
+	if errno != 0 {
+		return nil, nil, errno
+	}
+	// os.NewFile only uses the poller for non-blocking descriptors, which
+	// lets Close end pending reads.
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		syscall.Close(int(fd))
+		return nil, nil, err
+	}
+	master = os.NewFile(fd, "/dev/ptmx")
+	var n int32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	tty, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
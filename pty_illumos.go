@@ -0,0 +1,71 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type tcflag = uint32
+
+// The ioctls of illumos, which the syscall package lacks. System calls go
+// through syscall(2) of libc.
+const (
+	sysIoctl        = 54     // SYS_ioctl
+	ioctlGetTermios = 0x540d // TCGETS
+	ioctlSetTermios = 0x540e // TCSETS
+
+	ioctlUnlockPt = 0x5002 // UNLKPT
+	ioctlPush     = 0x5302 // I_PUSH
+	ioctlFind     = 0x530b // I_FIND
+)
+
+var ttyOSIflags, ttyOSLflags, ttyOSOflags map[uint8]tcflag
+
+// openPtyPair opens /dev/ptmx and the terminal it allocated, doing the work
+// of unlockpt and ptsname, and pushes the STREAMS modules making the
+// terminal a tty unless the system already pushed them.
+func openPtyPair() (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fail := func(err error) (*os.File, *os.File, error) {
+		master.Close()
+		if tty != nil {
+			tty.Close()
+		}
+		return nil, nil, err
+	}
+	if err := ioctl(master, ioctlUnlockPt, nil); err != nil {
+		return fail(err)
+	}
+	fi, err := master.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fail(syscall.EINVAL)
+	}
+	minor := uint64(st.Rdev) & 0xffffffff
+	tty, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", minor), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return fail(err)
+	}
+	for _, module := range []string{"ptem", "ldterm", "ttcompat"} {
+		name, err := syscall.BytePtrFromString(module)
+		if err != nil {
+			return fail(err)
+		}
+		found, err := ioctlValue(tty, ioctlFind, uintptr(unsafe.Pointer(name)))
+		if err == nil && found == 0 {
+			err = ioctl(tty, ioctlPush, unsafe.Pointer(name))
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	return master, tty, nil
+}
@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type tcflag = uint32
+
+const (
+	sysIoctl        = syscall.SYS_IOCTL
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
+
+var (
+	ttyOSIflags = map[uint8]tcflag{gossh.IUCLC: syscall.IUCLC, gossh.IUTF8: syscall.IUTF8}
+	ttyOSLflags = map[uint8]tcflag{gossh.XCASE: syscall.XCASE}
+	ttyOSOflags = map[uint8]tcflag{gossh.OLCUC: syscall.OLCUC}
+)
+
+// openPtyPair opens /dev/ptmx and the terminal it allocated.
+func openPtyPair() (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n, unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	tty, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type tcflag = uint32
+
+const (
+	sysIoctl        = syscall.SYS_IOCTL
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+
+	ioctlPtmGet = 0x40287401 // PTMGET, _IOR('t', 1, struct ptmget)
+)
+
+var ttyOSIflags, ttyOSLflags, ttyOSOflags map[uint8]tcflag
+
+// ptmget is the result of PTMGET: the descriptors and names of the master
+// and terminal of a new PTY.
+type ptmget struct {
+	cfd, sfd int32
+	cn, sn   [16]byte
+}
+
+// openPtyPair allocates a PTY with the PTMGET ioctl of /dev/ptm, like
+// openpty(3).
+func openPtyPair() (master, tty *os.File, err error) {
+	ptm, err := os.OpenFile("/dev/ptm", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ptm.Close()
+	var pt ptmget
+	if err := ioctl(ptm, ioctlPtmGet, unsafe.Pointer(&pt)); err != nil {
+		return nil, nil, err
+	}
+	syscall.CloseOnExec(int(pt.cfd))
+	syscall.CloseOnExec(int(pt.sfd))
+	// os.NewFile only uses the poller for non-blocking descriptors, which
+	// lets Close end pending reads.
+	if err := syscall.SetNonblock(int(pt.cfd), true); err != nil {
+		syscall.Close(int(pt.cfd))
+		syscall.Close(int(pt.sfd))
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(pt.cfd), cString(pt.cn[:])), os.NewFile(uintptr(pt.sfd), cString(pt.sn[:])), nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
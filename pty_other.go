@@ -0,0 +1,22 @@
+//go:build !darwin && !freebsd && !illumos && !linux && !openbsd
+// +build !darwin,!freebsd,!illumos,!linux,!openbsd
+
+package ssh
+
+import "os/exec"
+
+func openPty(p Pty) (*ptyFile, error) {
+	return nil, errPtyUnsupported
+}
+
+func (t *ptyFile) start(cmd *exec.Cmd) error {
+	return errPtyUnsupported
+}
+
+func (t *ptyFile) resize(win Window) error {
+	return errPtyUnsupported
+}
+
+func (t *ptyFile) echoing() (on, ok bool) {
+	return false, false
+}
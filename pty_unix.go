@@ -0,0 +1,176 @@
+//go:build darwin || freebsd || illumos || linux || openbsd
+// +build darwin freebsd illumos linux openbsd
+
+package ssh
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// SetWindowSize sets the window size of the terminal tty, which is usually
+// the master side of a PTY started for the session.
+func SetWindowSize(tty *os.File, win Window) error {
+	ws := struct{ rows, cols, x, y uint16 }{uint16(win.Height), uint16(win.Width), 0, 0}
+	return ioctl(tty, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// ForwardWindowChanges applies every window size received on winCh, such as
+// the channel returned by Session.Pty, to tty. It blocks until winCh is
+// closed. A change of size makes the kernel deliver SIGWINCH to the
+// foreground process group of the terminal, so full-screen programs redraw.
+func ForwardWindowChanges(winCh <-chan Window, tty *os.File) {
+	for win := range winCh {
+		SetWindowSize(tty, win)
+	}
+}
+
+// ioctl runs an ioctl on f. Unlike f.Fd, it leaves f in non-blocking mode,
+// so pending reads end when f is closed.
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, err := ioctlValue(f, req, uintptr(arg))
+	return err
+}
+
+// ioctlValue runs an ioctl on f like ioctl, returning its result.
+func ioctlValue(f *os.File, req, arg uintptr) (uintptr, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var r uintptr
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		r, _, errno = syscall.Syscall(sysIoctl, fd, req, arg)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// openPty allocates a PTY with the window size and terminal modes of p.
+func openPty(p Pty) (*ptyFile, error) {
+	master, tty, err := openPtyPair()
+	if err != nil {
+		return nil, err
+	}
+	err = SetWindowSize(master, p.Window)
+	if err == nil {
+		err = setTerminalModes(tty, p.Modes())
+	}
+	if err != nil {
+		master.Close()
+		tty.Close()
+		return nil, err
+	}
+	return &ptyFile{master: master, tty: tty}, nil
+}
+
+func (t *ptyFile) start(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // stdin of the child
+	return cmd.Start()
+}
+
+func (t *ptyFile) resize(win Window) error {
+	return SetWindowSize(t.master, win)
+}
+
+// Terminal mode flags by opcode, shared by the supported platforms, which
+// add their own in ttyOSIflags, ttyOSLflags and ttyOSOflags. Modes without
+// an equivalent, such as VDSUSP or VSTATUS, and the baud rates are ignored.
+var (
+	ttyChars = map[uint8]int{
+		gossh.VINTR: syscall.VINTR, gossh.VQUIT: syscall.VQUIT, gossh.VERASE: syscall.VERASE,
+		gossh.VKILL: syscall.VKILL, gossh.VEOF: syscall.VEOF, gossh.VEOL: syscall.VEOL,
+		gossh.VEOL2: syscall.VEOL2, gossh.VSTART: syscall.VSTART, gossh.VSTOP: syscall.VSTOP,
+		gossh.VSUSP: syscall.VSUSP, gossh.VREPRINT: syscall.VREPRINT, gossh.VWERASE: syscall.VWERASE,
+		gossh.VLNEXT: syscall.VLNEXT, gossh.VDISCARD: syscall.VDISCARD,
+	}
+	ttyIflags = map[uint8]tcflag{
+		gossh.IGNPAR: syscall.IGNPAR, gossh.PARMRK: syscall.PARMRK, gossh.INPCK: syscall.INPCK,
+		gossh.ISTRIP: syscall.ISTRIP, gossh.INLCR: syscall.INLCR, gossh.IGNCR: syscall.IGNCR,
+		gossh.ICRNL: syscall.ICRNL, gossh.IXON: syscall.IXON, gossh.IXANY: syscall.IXANY,
+		gossh.IXOFF: syscall.IXOFF, gossh.IMAXBEL: syscall.IMAXBEL,
+	}
+	ttyLflags = map[uint8]tcflag{
+		gossh.ISIG: syscall.ISIG, gossh.ICANON: syscall.ICANON, gossh.ECHO: syscall.ECHO,
+		gossh.ECHOE: syscall.ECHOE, gossh.ECHOK: syscall.ECHOK, gossh.ECHONL: syscall.ECHONL,
+		gossh.NOFLSH: syscall.NOFLSH, gossh.TOSTOP: syscall.TOSTOP, gossh.IEXTEN: syscall.IEXTEN,
+		gossh.ECHOCTL: syscall.ECHOCTL, gossh.ECHOKE: syscall.ECHOKE, gossh.PENDIN: syscall.PENDIN,
+	}
+	ttyOflags = map[uint8]tcflag{
+		gossh.OPOST: syscall.OPOST, gossh.ONLCR: syscall.ONLCR, gossh.OCRNL: syscall.OCRNL,
+		gossh.ONOCR: syscall.ONOCR, gossh.ONLRET: syscall.ONLRET,
+	}
+	ttyCflags = map[uint8]tcflag{
+		gossh.PARENB: syscall.PARENB, gossh.PARODD: syscall.PARODD,
+	}
+)
+
+// ttyFlag returns the flag of op in the shared or platform map.
+func ttyFlag(shared, os map[uint8]tcflag, op uint8) (tcflag, bool) {
+	if bit, ok := shared[op]; ok {
+		return bit, true
+	}
+	bit, ok := os[op]
+	return bit, ok
+}
+
+// echoing reports whether the terminal echoes input, and whether that is
+// known.
+func (t *ptyFile) echoing() (on, ok bool) {
+	var tio syscall.Termios
+	if err := ioctl(t.master, ioctlGetTermios, unsafe.Pointer(&tio)); err != nil {
+		return false, false
+	}
+	return tio.Lflag&syscall.ECHO != 0, true
+}
+
+// setTerminalModes applies the terminal modes of a pty-req to tty.
+func setTerminalModes(tty *os.File, modes gossh.TerminalModes) error {
+	if len(modes) == 0 {
+		return nil
+	}
+	var tio syscall.Termios
+	if err := ioctl(tty, ioctlGetTermios, unsafe.Pointer(&tio)); err != nil {
+		return err
+	}
+	setFlag := func(flags *tcflag, bit tcflag, on bool) {
+		if on {
+			*flags |= bit
+		} else {
+			*flags &^= bit
+		}
+	}
+	for op, value := range modes {
+		if i, ok := ttyChars[op]; ok {
+			tio.Cc[i] = uint8(value)
+		} else if bit, ok := ttyFlag(ttyIflags, ttyOSIflags, op); ok {
+			setFlag(&tio.Iflag, bit, value != 0)
+		} else if bit, ok := ttyFlag(ttyLflags, ttyOSLflags, op); ok {
+			setFlag(&tio.Lflag, bit, value != 0)
+		} else if bit, ok := ttyFlag(ttyOflags, ttyOSOflags, op); ok {
+			setFlag(&tio.Oflag, bit, value != 0)
+		} else if bit, ok := ttyCflags[op]; ok {
+			setFlag(&tio.Cflag, bit, value != 0)
+		} else if op == gossh.CS7 && value != 0 {
+			tio.Cflag = tio.Cflag&^syscall.CSIZE | syscall.CS7
+		} else if op == gossh.CS8 && value != 0 {
+			tio.Cflag = tio.Cflag&^syscall.CSIZE | syscall.CS8
+		}
+	}
+	return ioctl(tty, ioctlSetTermios, unsafe.Pointer(&tio))
+}
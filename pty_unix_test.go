@@ -0,0 +1,118 @@
+//go:build darwin || freebsd || illumos || linux || openbsd
+// +build darwin freebsd illumos linux openbsd
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestRunWithPty(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("stty"); err != nil {
+		t.Skip("no stty")
+	}
+	if _, err := openPty(Pty{}); err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			RunWithPty(s, exec.Command("/bin/sh", "-c", "stty size; stty -a; exit 3"))
+		},
+	}, nil)
+	defer cleanup()
+	if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{gossh.ECHO: 0, gossh.ICANON: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	var exitErr *gossh.ExitError
+	if err := session.Run(""); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("Run = %v; want exit status 3", err)
+	}
+	out := stdout.String()
+	if !strings.HasPrefix(out, "40 80\r\n") {
+		t.Fatalf("stty size = %#v; want %#v", out, "40 80\r\n")
+	}
+	if !strings.Contains(out, " -echo ") || !strings.Contains(out, " icanon ") {
+		t.Fatalf("stty -a = %#v; want -echo and icanon", out)
+	}
+}
+
+func TestShellHandlerTTY(t *testing.T) {
+	t.Parallel()
+	if _, err := openPty(Pty{}); err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	shell := &ShellHandler{Default: "/bin/echo $tty"}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: shell.HandleSession,
+	}, nil)
+	defer cleanup()
+	if err := session.RequestPty("xterm", 24, 80, nil); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if out := stdout.String(); !strings.HasPrefix(out, "/dev/pts/") {
+		t.Fatalf("stdout = %#v; want a /dev/pts device", out)
+	}
+}
+
+func TestForwardWindowChanges(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("stty"); err != nil {
+		t.Skip("no stty")
+	}
+	p, err := openPty(Pty{Window: Window{Width: 80, Height: 24}})
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer p.master.Close()
+	cmd := exec.Command("/bin/sh", "-c", `stty -echo; n=0; trap 'n=$((n+1))' WINCH; echo ready; read x; echo "winch $n"; stty size`)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = p.tty, p.tty, p.tty
+	if err := p.start(cmd); err != nil {
+		t.Fatal(err)
+	}
+	p.tty.Close()
+	defer cmd.Wait()
+	out := bufio.NewReader(p.master)
+	if line, err := out.ReadString('\n'); err != nil || line != "ready\r\n" {
+		t.Fatalf("ReadString = %#v, %v; want %#v", line, err, "ready\r\n")
+	}
+
+	winCh := make(chan Window, 1)
+	winCh <- Window{Width: 100, Height: 30}
+	close(winCh)
+	ForwardWindowChanges(winCh, p.master)
+	// give the shell time to take the signal before it reads its input
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(p.master, "\n")
+	var lines []string
+	for len(lines) < 2 {
+		line, err := out.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString = %v after %#v", err, lines)
+		}
+		lines = append(lines, line)
+	}
+	want := []string{"winch 1\r\n", "30 100\r\n"}
+	if strings.Join(lines, "") != strings.Join(want, "") {
+		t.Fatalf("output = %#v; want %#v", lines, want)
+	}
+}
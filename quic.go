@@ -0,0 +1,156 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// quicStreamTimeout is how long a new QUIC connection may take to open the
+// stream carrying SSH.
+const quicStreamTimeout = 10 * time.Second
+
+// QUICConn is a multiplexed transport connection such as a QUIC connection.
+// Implementations such as quic-go need a small adapter returning their
+// stream type as an io.ReadWriteCloser. Streams implementing SetDeadline,
+// SetReadDeadline and SetWriteDeadline get the server's timeouts applied.
+//
+// QUIC support is experimental, and the interface may change.
+type QUICConn interface {
+	AcceptStream(ctx context.Context) (io.ReadWriteCloser, error)
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// QUICListener accepts multiplexed transport connections.
+//
+// QUIC support is experimental, and the interface may change.
+type QUICListener interface {
+	Accept(ctx context.Context) (QUICConn, error)
+	Addr() net.Addr
+	Close() error
+}
+
+// NewQUICListener returns a net.Listener running SSH over QUIC, so it can be
+// passed to Serve. The first stream opened by the client on each connection
+// carries the SSH protocol, benefitting from QUIC's loss recovery and
+// connection migration on lossy and mobile networks; closing it closes the
+// connection.
+func NewQUICListener(l QUICListener) net.Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	ql := &quicListener{
+		l:      l,
+		ctx:    ctx,
+		cancel: cancel,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	go ql.acceptLoop()
+	return ql
+}
+
+type quicListener struct {
+	l      QUICListener
+	ctx    context.Context
+	cancel context.CancelFunc
+	conns  chan net.Conn
+	done   chan struct{}
+	err    error
+	once   sync.Once
+}
+
+func (ql *quicListener) acceptLoop() {
+	defer close(ql.done)
+	for {
+		conn, err := ql.l.Accept(ql.ctx)
+		if err != nil {
+			ql.err = err
+			return
+		}
+		go ql.acceptStream(conn)
+	}
+}
+
+func (ql *quicListener) acceptStream(conn QUICConn) {
+	ctx, cancel := context.WithTimeout(ql.ctx, quicStreamTimeout)
+	defer cancel()
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case ql.conns <- &quicStreamConn{stream, conn}:
+	case <-ql.ctx.Done():
+		conn.Close()
+	}
+}
+
+func (ql *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ql.conns:
+		return conn, nil
+	case <-ql.done:
+		if ql.ctx.Err() != nil {
+			return nil, errors.New("ssh: QUIC listener closed")
+		}
+		return nil, ql.err
+	}
+}
+
+func (ql *quicListener) Close() error {
+	var err error
+	ql.once.Do(func() {
+		ql.cancel()
+		err = ql.l.Close()
+	})
+	return err
+}
+
+func (ql *quicListener) Addr() net.Addr {
+	return ql.l.Addr()
+}
+
+// quicStreamConn is the stream carrying SSH on a QUIC connection.
+type quicStreamConn struct {
+	io.ReadWriteCloser
+	conn QUICConn
+}
+
+func (c *quicStreamConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+var errNoDeadlines = errors.New("ssh: QUIC stream does not support deadlines")
+
+func (c *quicStreamConn) SetDeadline(t time.Time) error {
+	if s, ok := c.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return s.SetDeadline(t)
+	}
+	return errNoDeadlines
+}
+
+func (c *quicStreamConn) SetReadDeadline(t time.Time) error {
+	if s, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return s.SetReadDeadline(t)
+	}
+	return errNoDeadlines
+}
+
+func (c *quicStreamConn) SetWriteDeadline(t time.Time) error {
+	if s, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return s.SetWriteDeadline(t)
+	}
+	return errNoDeadlines
+}
@@ -0,0 +1,73 @@
+package ssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+// pipeQUIC is a QUICListener whose connections carry a single net.Pipe
+// stream.
+type pipeQUIC struct {
+	conns chan QUICConn
+}
+
+func (p *pipeQUIC) Accept(ctx context.Context) (QUICConn, error) {
+	select {
+	case c := <-p.conns:
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *pipeQUIC) Addr() net.Addr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443} }
+func (p *pipeQUIC) Close() error   { return nil }
+
+type pipeQUICConn struct {
+	stream net.Conn
+	closed chan struct{}
+}
+
+func (c *pipeQUICConn) AcceptStream(ctx context.Context) (io.ReadWriteCloser, error) {
+	return c.stream, nil
+}
+
+func (c *pipeQUICConn) LocalAddr() net.Addr  { return c.stream.LocalAddr() }
+func (c *pipeQUICConn) RemoteAddr() net.Addr { return c.stream.RemoteAddr() }
+
+func (c *pipeQUICConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestQUICListener(t *testing.T) {
+	t.Parallel()
+	transport := &pipeQUIC{conns: make(chan QUICConn, 1)}
+	l := NewQUICListener(transport)
+	defer l.Close()
+
+	server, client := net.Pipe()
+	qc := &pipeQUICConn{stream: server, closed: make(chan struct{})}
+	transport.conns <- qc
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Write([]byte("SSH-2.0-test\r\n"))
+	buf := make([]byte, 14)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "SSH-2.0-test\r\n" {
+		t.Fatalf("read %#v; want %#v", string(buf), "SSH-2.0-test\r\n")
+	}
+	conn.Close()
+	<-qc.closed
+
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("Accept succeeded after Close")
+	}
+}
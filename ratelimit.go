@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRequestRateExceeded is the error connections are closed with when they
+// exceed the server's GlobalRequestLimits.
+var ErrRequestRateExceeded = errors.New("ssh: global request rate exceeded")
+
+// RateLimit is a token bucket: Burst requests are allowed at once, and the
+// bucket refills at Rate requests per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// tokenBucket tracks the requests of one RateLimit.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take reports whether a request is allowed at now, using up a token.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+		if b.tokens > float64(limit.Burst) {
+			b.tokens = float64(limit.Burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// requestLimiter applies GlobalRequestLimits to the requests of a connection.
+// It is only used by the goroutine serving them.
+type requestLimiter struct {
+	limits  map[string]RateLimit
+	buckets map[string]*tokenBucket
+}
+
+func newRequestLimiter(limits map[string]RateLimit) *requestLimiter {
+	return &requestLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow reports whether a request of type reqType is allowed at now.
+func (l *requestLimiter) allow(reqType string, now time.Time) bool {
+	limit, ok := l.limits[reqType]
+	if !ok {
+		if limit, ok = l.limits[""]; !ok {
+			return true
+		}
+		reqType = ""
+	}
+	b := l.buckets[reqType]
+	if b == nil {
+		b = new(tokenBucket)
+		l.buckets[reqType] = b
+	}
+	return b.take(limit, now)
+}
@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+	limit := RateLimit{Rate: 2, Burst: 3}
+	var b tokenBucket
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !b.take(limit, now) {
+			t.Fatalf("request %d of burst denied", i)
+		}
+	}
+	if b.take(limit, now) {
+		t.Fatal("request beyond burst allowed")
+	}
+	if !b.take(limit, now.Add(500*time.Millisecond)) {
+		t.Fatal("request denied after refill")
+	}
+	if b.take(limit, now.Add(500*time.Millisecond)) {
+		t.Fatal("second request allowed after refilling one token")
+	}
+}
+
+func TestGlobalRequestLimits(t *testing.T) {
+	t.Parallel()
+	reasons := make(chan error, 1)
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		GlobalRequestLimits: map[string]RateLimit{
+			"": {Rate: 0.1, Burst: 2},
+		},
+		ConnCloseCallback: func(ctx Context, reason CloseReason, err error) {
+			reasons <- err
+		},
+	}, nil)
+	defer cleanup()
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	client.SendRequest("keepalive@openssh.com", true, nil)
+	if err := client.Wait(); err == nil {
+		t.Fatal("connection ended cleanly; want it closed")
+	}
+	if err := <-reasons; err != ErrRequestRateExceeded {
+		t.Fatalf("close err = %v; want %v", err, ErrRequestRateExceeded)
+	}
+}
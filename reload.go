@@ -0,0 +1,81 @@
+package ssh
+
+// Reload replaces the configuration of a running server. update is called
+// with a copy of the current configuration, and if it returns no error the
+// copy becomes the configuration of new connections, as a whole. Existing
+// connections keep the configuration they were accepted with, which
+// handlers can read from ContextKeyServer.
+//
+// The fields of a server must not be modified directly once it serves
+// connections; Reload makes such changes race-free instead. The copy has
+// its own HostSigners, ChannelHandlers, RequestHandlers and
+// SubsystemHandlers, but values referenced by other fields are shared with
+// the previous configuration.
+//
+//	srv.Reload(func(cfg *Server) error {
+//		cfg.IdleTimeout = time.Minute
+//		return cfg.SetOption(HostKeyFile("/etc/ssh/ssh_host_ed25519_key"))
+//	})
+func (srv *Server) Reload(update func(cfg *Server) error) error {
+	srv.init()
+	// update runs without holding srv.mu, so it can call the methods of
+	// the server
+	srv.reloadMu.Lock()
+	defer srv.reloadMu.Unlock()
+	cfg := *srv.current()
+	cfg.HostSigners = append([]Signer(nil), cfg.HostSigners...)
+	cfg.ChannelHandlers = copyChannelHandlers(cfg.ChannelHandlers)
+	cfg.RequestHandlers = copyRequestHandlers(cfg.RequestHandlers)
+	if cfg.SubsystemHandlers != nil {
+		handlers := make(map[string]SubsystemHandler, len(cfg.SubsystemHandlers))
+		for k, v := range cfg.SubsystemHandlers {
+			handlers[k] = v
+		}
+		cfg.SubsystemHandlers = handlers
+	}
+	if err := update(&cfg); err != nil {
+		return err
+	}
+	if cfg.Handler == nil {
+		cfg.Handler = DefaultHandler
+	}
+	if err := cfg.ensureHostSigner(); err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	srv.snapshot.Store(&cfg)
+	srv.mu.Unlock()
+	return nil
+}
+
+// current returns the configuration of new connections.
+func (srv *Server) current() *Server {
+	if cfg, ok := srv.snapshot.Load().(*Server); ok {
+		return cfg
+	}
+	return srv
+}
+
+// copyChannelHandlers copies handlers, or DefaultChannelHandlers if nil.
+func copyChannelHandlers(handlers map[string]ChannelHandler) map[string]ChannelHandler {
+	if handlers == nil {
+		handlers = DefaultChannelHandlers
+	}
+	m := make(map[string]ChannelHandler, len(handlers))
+	for k, v := range handlers {
+		m[k] = v
+	}
+	return m
+}
+
+// copyRequestHandlers copies handlers, or DefaultRequestHandlers if nil.
+func copyRequestHandlers(handlers map[string]RequestHandler) map[string]RequestHandler {
+	if handlers == nil {
+		handlers = DefaultRequestHandlers
+	}
+	m := make(map[string]RequestHandler, len(handlers))
+	for k, v := range handlers {
+		m[k] = v
+	}
+	return m
+}
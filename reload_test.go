@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	l := newLocalListener()
+	srv := &Server{Handler: func(s Session) {
+		io.WriteString(s, "old")
+	}}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	output := func(client *gossh.Client) string {
+		sess, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sess.Close()
+		out, err := sess.Output("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	_, before, cleanup := newClientSession(t, l.Addr().String(), nil)
+	defer cleanup()
+	err := srv.Reload(func(cfg *Server) error {
+		cfg.Handler = func(s Session) {
+			io.WriteString(s, "new")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := output(before); got != "old" {
+		t.Fatalf("existing connection output = %#v; want %#v", got, "old")
+	}
+	_, after, cleanup := newClientSession(t, l.Addr().String(), nil)
+	defer cleanup()
+	if got := output(after); got != "new" {
+		t.Fatalf("new connection output = %#v; want %#v", got, "new")
+	}
+}
+
+func TestReloadCallingServer(t *testing.T) {
+	t.Parallel()
+	srv := &Server{}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Reload(func(cfg *Server) error {
+			// the server can be inspected while reloading
+			srv.FeatureEnabled(FeatureSFTP)
+			srv.ChannelStats()
+			cfg.IdleTimeout = time.Minute
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload deadlocked")
+	}
+	if srv.current().IdleTimeout != time.Minute {
+		t.Fatalf("IdleTimeout = %v; want %v", srv.current().IdleTimeout, time.Minute)
+	}
+}
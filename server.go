@@ -2,10 +2,14 @@ package ssh
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gossh "golang.org/x/crypto/ssh"
@@ -27,42 +31,299 @@ var DefaultChannelHandlers = map[string]ChannelHandler{
 
 // Server defines parameters for running an SSH server. The zero value for
 // Server is a valid configuration. When both PasswordHandler and
-// PublicKeyHandler are nil, no client authentication is performed.
+// PublicKeyHandler are nil, no client authentication is performed. Use Reload
+// to change the configuration of a running server.
 type Server struct {
-	Addr        string   // TCP address to listen on, ":22" if empty
-	Handler     Handler  // handler to invoke, ssh.DefaultHandler if nil
-	HostSigners []Signer // private keys for the host key, must have at least one
-	Version     string   // server version to be sent before the initial handshake
+	Addr        string      // TCP address to listen on, ":22" if empty
+	Handler     Handler     // handler to invoke, ssh.DefaultHandler if nil
+	HostSigners []Signer    // private keys for the host key, must have at least one
+	Version     string      // server version to be sent before the initial handshake
+	TLSConfig   *tls.Config // optional TLS config, used by ServeTLS and ListenAndServeTLS
+
+	// HTTPHandler, if set, serves HTTP requests received on the same port
+	// as SSH, such as health checks or metrics. Connections are told apart
+	// by the first bytes sent by the client, which delays the server's
+	// version banner until the client sent its own, or for up to 2 seconds
+	// for clients waiting for the server to speak first.
+	HTTPHandler http.Handler
+
+	// Messages, if set, provides the text of messages shown to clients,
+	// such as the banner and the reasons channels are rejected.
+	Messages MessageProvider
+
+	// BannerHandler, if set, chooses the banner of each client, which can
+	// vary by source address, user or time. It overrides the MessageBanner
+	// of Messages, but not a BannerCallback set by ServerConfigCallback.
+	BannerHandler BannerHandler
 
 	KeyboardInteractiveHandler    KeyboardInteractiveHandler    // keyboard-interactive authentication handler
 	PasswordHandler               PasswordHandler               // password authentication handler
 	PublicKeyHandler              PublicKeyHandler              // public key authentication handler
 	PtyCallback                   PtyCallback                   // callback for allowing PTY sessions, allows all if nil
-	ConnCallback                  ConnCallback                  // optional callback for wrapping net.Conn before handling
+	PtyRepeat                     PtyRepeatPolicy               // policy for repeated PTY requests of a session, rejected if zero
+	AgentForwardingCallback       AgentForwardingCallback       // callback for allowing agent forwarding requests, allows all if nil
+	EnvRequestCallback            EnvRequestCallback            // callback for allowing env requests accepted by AcceptEnv, allows all if nil
+	ConnCallback                  ConnCallback                  // optional callback for wrapping net.Conn before handling, or rejecting it with nil
 	LocalPortForwardingCallback   LocalPortForwardingCallback   // callback for allowing local port forwarding, denies all if nil
 	ReversePortForwardingCallback ReversePortForwardingCallback // callback for allowing reverse port forwarding, denies all if nil
+	DirectTCPIPCallback           DirectTCPIPCallback           // callback for allowing port forwarding with its originator, overrides LocalPortForwardingCallback
 	ServerConfigCallback          ServerConfigCallback          // callback for configuring detailed SSH options
 	SessionRequestCallback        SessionRequestCallback        // callback for allowing or denying SSH sessions
+	SessionStartCallback          SessionStartCallback          // optional callback run before the Handler, can reject the session
+	SessionEndCallback            SessionEndCallback            // optional callback run after the Handler
+	SessionTransformCallback      SessionTransformCallback      // optional callback transforming the I/O of sessions
+	ConnCloseCallback             ConnCloseCallback             // optional callback run when a connection ends
+	ConnectionFailedCallback      ConnectionFailedCallback      // optional callback run when the handshake of a connection fails
+	TrustForwardedCallback        TrustForwardedCallback        // callback for trusting addresses claimed by proxies, trusts none if nil
+	ImpersonationCallback         ImpersonationCallback         // callback for allowing users to act as other users, denies all if nil
+	HostSignersCallback           HostSignersCallback           // callback for choosing the host keys offered to a client, HostSigners if nil
+
+	// ForwardAddressPolicy restricts the addresses local forwarding
+	// connects to once destinations are resolved. If nil, loopback,
+	// link-local and cloud metadata addresses are denied, see AddressPolicy.
+	ForwardAddressPolicy *AddressPolicy
+
+	// ReversePortForwardingListenerCallback creates the listener for accepted
+	// reverse port forwarding requests. If nil, net.Listen is used.
+	ReversePortForwardingListenerCallback ReversePortForwardingListenerCallback
+
+	// ReversePortForwardingBindCallback, if set, chooses the address listened
+	// on for requests allowed by ReversePortForwardingCallback.
+	// ReversePortForwardingBoundCallback is called with the address bound,
+	// and ReversePortForwardingCancelCallback when the client cancels a
+	// forwarding with cancel-tcpip-forward.
+	ReversePortForwardingBindCallback   ReversePortForwardingBindCallback
+	ReversePortForwardingBoundCallback  ReversePortForwardingEventCallback
+	ReversePortForwardingCancelCallback ReversePortForwardingEventCallback
+
+	// AcceptEnv lists the environment variables accepted from clients, as
+	// patterns of path.Match such as "LC_*", like the AcceptEnv option of
+	// OpenSSH. Other env requests fail and aren't in Session.Environ. All
+	// are accepted if nil.
+	AcceptEnv []string
+
+	// LocalUnixForwardingCallback and ReverseUnixForwardingCallback allow
+	// forwarding Unix sockets with DirectStreamLocalHandler and
+	// ForwardedStreamLocalHandler. Both deny all if nil.
+	LocalUnixForwardingCallback   LocalUnixForwardingCallback
+	ReverseUnixForwardingCallback ReverseUnixForwardingCallback
+
+	// PostQuantumKex prefers or requires post-quantum key exchanges, over
+	// the KeyExchanges configured by ServerConfigCallback if any. ConnCrypto
+	// reports whether a connection uses one.
+	PostQuantumKex PostQuantumPolicy
+
+	// RequireStrictKex refuses clients that don't support strict key
+	// exchange, the countermeasure to the Terrapin attack (CVE-2023-48795).
+	// Use StrictKex to report on clients instead.
+	RequireStrictKex bool
+
+	// HASSHCallback is called with the HASSH fingerprint of each client,
+	// which is also available from HASSH, to log or block known tools.
+	HASSHCallback HASSHCallback
+
+	// GlobalRequestLimits rate-limit the global requests of each
+	// connection, such as tcpip-forward and keepalive@openssh.com, by
+	// request type. The limit under "" applies to the other types together.
+	// Connections exceeding a limit are closed. None if nil.
+	GlobalRequestLimits map[string]RateLimit
+
+	// AuditCallback, if set, receives an AuditEvent for each
+	// security-relevant action, such as auth attempts, sessions and
+	// forwards. The audit package encodes them as JSON Lines or CEF.
+	AuditCallback AuditCallback
+
+	// AbuseReporter, if set, is told of connections failing auth and closed
+	// by policy, and decides which clients are refused.
+	AbuseReporter AbuseReporter
+
+	// Logger, if set, receives structured events as connections are
+	// accepted, rejected, authenticated and closed at level info, and as
+	// channels are opened and global requests handled at level debug.
+	// Events carry the session ID, user and remote address once known.
+	Logger *slog.Logger
+
+	// Metrics, if set, receives the counters and histograms of the server,
+	// such as active connections, auth attempts by method and bytes by
+	// channel type, to export them to Prometheus and the like.
+	Metrics Metrics
+
+	// TracerProvider, if set, traces connections, their channels and the
+	// requests of their sessions as spans, such as for OpenTelemetry.
+	TracerProvider TracerProvider
+
+	// MaxConns, if positive, caps the connections open at once, refusing
+	// extra connections before the key exchange.
+	MaxConns int
+
+	// MaxConnsPerSource, if positive, caps the connections open at once
+	// from a source, refusing extra connections before the key exchange to
+	// blunt connection floods. Sources are client IPs, grouped into
+	// networks by SourceBlockIPv4 and SourceBlockIPv6 like the
+	// PerSourceNetBlockSize option of sshd.
+	MaxConnsPerSource int
+	SourceBlockIPv4   int // prefix length of IPv4 sources, 32 if zero
+	SourceBlockIPv6   int // prefix length of IPv6 sources, 128 if zero
+
+	// VerifyForwardOrigin rejects port forwarding whose originator address,
+	// as claimed by the client, isn't the address of the client. Only enable
+	// it for clients known to send their own address, since clients like
+	// OpenSSH send the address connecting to the forwarded port, usually
+	// 127.0.0.1.
+	VerifyForwardOrigin bool
+
+	// PayloadLimits bounds the size of values parsed from session request
+	// payloads. If nil, DefaultPayloadLimits is used.
+	PayloadLimits        *PayloadLimits
+	PayloadLimitCallback PayloadLimitCallback // optional callback for requests rejected by PayloadLimits
+
+	// OutputBuffer, if positive, buffers up to that many bytes of both the
+	// stdout and the stderr of sessions, written to the client by a single
+	// goroutine. Handlers writing to one stream then only block once its
+	// buffer is full, instead of whenever the other stream is busy. Both
+	// streams still share the channel's flow control window. Buffered
+	// output is delivered before the exit status, and dropped when the
+	// session is closed without exiting, such as once idle.
+	OutputBuffer     int
+	PrioritizeStderr bool // write buffered stderr before stdout, requires OutputBuffer
+
+	// Scheduler, if set, shares the outbound bandwidth of the server among
+	// users, so bulk transfers don't starve interactive sessions.
+	Scheduler *FairScheduler
+
+	// EchoLatencyCallback, if set, is called with the time between a
+	// keystroke read from a PTY session and the next output written to it,
+	// which approximates the latency perceived by the user, for one in
+	// EchoLatencySample keystrokes. ChannelStats reports the samples too.
+	EchoLatencyCallback EchoLatencyCallback
+	EchoLatencySample   int // keystrokes per echo latency sample, 10 if zero
+
+	// KeystrokeLogging, if set, records the input of PTY sessions as
+	// AuditKeystrokes events for the AuditCallback.
+	KeystrokeLogging *KeystrokeLogging
+
+	// SessionLinger, if positive, is how long a session waits after the
+	// exit of its handler for the client to close it, so the client reads
+	// all output before the channel is torn down. The session sends EOF and
+	// its exit status first. Clients like OpenSSH close right away, while
+	// golang.org/x/crypto/ssh clients wait for the server, so sessions of
+	// those take the full period to end.
+	SessionLinger time.Duration
 
 	IdleTimeout time.Duration // connection timeout when no activity, none if empty
 	MaxTimeout  time.Duration // absolute connection timeout, none if empty
 
+	// HandshakeTimeout bounds the SSH handshake, from the version exchange
+	// through the key exchange to authentication, like the LoginGraceTime
+	// option of sshd. Connections not authenticated by then are closed with
+	// the reason CloseReasonHandshakeTimeout. None if empty.
+	HandshakeTimeout time.Duration
+
+	// SessionIdleTimeout, if positive, closes session channels without
+	// input or output for that long, after telling the client why on
+	// stderr, while the connection and its other channels stay open.
+	SessionIdleTimeout time.Duration
+
+	// RequestTimeout bounds the time global and session requests wanting a
+	// reply wait for handlers and callbacks, such as RequestHandlers and
+	// PtyCallback, after which they are answered with a failure. None if
+	// empty.
+	RequestTimeout time.Duration
+
+	// AuthTimeout bounds each call of an auth handler. The context of the
+	// handler is done once it elapses, and password and public key attempts
+	// whose handler hasn't returned by then are rejected without waiting
+	// for it. None if empty.
+	AuthTimeout time.Duration
+
+	// MaxAuthTries is the number of failed auth attempts after which a
+	// client is disconnected, like the option of sshd, with the reason
+	// CloseReasonAuthFailures. 6 if zero, unlimited if negative. It
+	// overrides the MaxAuthTries of ServerConfigCallback unless zero.
+	MaxAuthTries int
+
+	// MaxSessions, if positive, caps the session channels open at once on a
+	// connection, like the option of sshd. Extra session channels are
+	// rejected with RESOURCE_SHORTAGE.
+	MaxSessions int
+
+	// AuthPolicy, if set, requires clients to complete one of its sequences
+	// of auth methods, such as a public key followed by a password, instead
+	// of any single method accepted by its handler.
+	AuthPolicy AuthPolicy
+
+	// UserExistsCallback, if set, hides which users exist from clients
+	// timing auth attempts. Attempts for users it reports as unknown are
+	// still evaluated in full by the auth handlers, as DecoyUser if set, and
+	// then rejected, so they cost the same work as failed attempts by known
+	// users.
+	UserExistsCallback UserExistsCallback
+	DecoyUser          string
+
+	// Middleware extending the stages of the connection pipeline, applied
+	// in order so the first one runs outermost. See TransportHandler,
+	// AuthAttempt, ConnHandler, ChannelHandler and RequestHandler.
+	TransportMiddleware []TransportMiddleware
+	AuthMiddleware      []AuthMiddleware
+	ConnMiddleware      []ConnMiddleware
+	ChannelMiddleware   []ChannelMiddleware
+	RequestMiddleware   []RequestMiddleware
+
 	// ChannelHandlers allow overriding the built-in session handlers or provide
 	// extensions to the protocol, such as tcpip forwarding. By default only the
 	// "session" handler is enabled.
 	ChannelHandlers map[string]ChannelHandler
 
+	// SubsystemHandlers handle the sessions requesting a subsystem, by its
+	// name, such as "sftp". The handler registered as "default" handles
+	// subsystems without a handler of their own. Subsystems without handler
+	// are rejected.
+	SubsystemHandlers map[string]SubsystemHandler
+
 	// RequestHandlers allow overriding the server-level request handlers or
 	// provide extensions to the protocol, such as tcpip forwarding. By default
 	// no handlers are enabled.
 	RequestHandlers map[string]RequestHandler
 
+	// serverState is shared by the server and its configuration snapshots.
+	*serverState
+}
+
+// serverState is the runtime state of a Server, as opposed to its
+// configuration.
+type serverState struct {
 	listenerWg sync.WaitGroup
 	mu         sync.Mutex
 	listeners  map[net.Listener]struct{}
-	conns      map[*gossh.ServerConn]struct{}
+	conns      map[*serverConn]struct{}
+	channels   map[*statsChannel]struct{}
+	sources    map[string]int // connections by source, for MaxConnsPerSource
+	open       int            // connections counted against MaxConns
 	connWg     sync.WaitGroup
 	doneChan   chan struct{}
+	closing    bool // Close was called
+	draining   bool // Shutdown was called
+	disabled   map[Feature]bool
+
+	httpListener *connListener
+	httpServer   *http.Server
+
+	// snapshot holds the *Server configuration new connections are handled
+	// with, once Reload was called.
+	snapshot atomic.Value
+	reloadMu sync.Mutex // serializes Reload
+}
+
+// serverStateMu guards the lazy allocation of serverState, so the zero
+// Server stays valid.
+var serverStateMu sync.Mutex
+
+func (srv *Server) init() {
+	serverStateMu.Lock()
+	if srv.serverState == nil {
+		srv.serverState = &serverState{}
+	}
+	serverStateMu.Unlock()
 }
 
 func (srv *Server) ensureHostSigner() error {
@@ -76,7 +337,16 @@ func (srv *Server) ensureHostSigner() error {
 	return nil
 }
 
+func (srv *Server) ensureDefaults() error {
+	srv.ensureHandlers()
+	if srv.Handler == nil {
+		srv.Handler = DefaultHandler
+	}
+	return srv.ensureHostSigner()
+}
+
 func (srv *Server) ensureHandlers() {
+	srv.init()
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	if srv.RequestHandlers == nil {
@@ -100,7 +370,8 @@ func (srv *Server) config(ctx Context) *gossh.ServerConfig {
 	} else {
 		config = srv.ServerConfigCallback(ctx)
 	}
-	for _, signer := range srv.HostSigners {
+	applyPostQuantumPolicy(config, srv.PostQuantumKex)
+	for _, signer := range srv.hostSigners(ctx) {
 		config.AddHostKey(signer)
 	}
 	if srv.PasswordHandler == nil && srv.PublicKeyHandler == nil {
@@ -109,34 +380,79 @@ func (srv *Server) config(ctx Context) *gossh.ServerConfig {
 	if srv.Version != "" {
 		config.ServerVersion = "SSH-2.0-" + srv.Version
 	}
+	if srv.MaxAuthTries != 0 {
+		config.MaxAuthTries = srv.MaxAuthTries
+	}
+	if srv.BannerHandler != nil && config.BannerCallback == nil {
+		config.BannerCallback = func(conn gossh.ConnMetadata) string {
+			applyConnMetadata(ctx, conn)
+			return srv.BannerHandler(ctx)
+		}
+	}
+	if srv.Messages != nil && config.BannerCallback == nil {
+		config.BannerCallback = func(conn gossh.ConnMetadata) string {
+			applyConnMetadata(ctx, conn)
+			return message(ctx, MessageBanner)
+		}
+	}
 	if srv.PasswordHandler != nil {
 		config.PasswordCallback = func(conn gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
 			applyConnMetadata(ctx, conn)
-			if ok := srv.PasswordHandler(ctx, string(password)); !ok {
+			srv.splitImpersonation(ctx)
+			ok := srv.authenticate(ctx, true, srv.authAttempt("password", func(ctx Context) bool {
+				return srv.PasswordHandler(ctx, string(password))
+			}))
+			if !ok || !srv.allowImpersonation(ctx) {
 				return ctx.Permissions().Permissions, fmt.Errorf("permission denied")
 			}
 			return ctx.Permissions().Permissions, nil
 		}
 	}
 	if srv.PublicKeyHandler != nil {
+		// what the handler deferred for the accepted keys, by user and key,
+		// until the client signs with one of them
+		verified := map[string][]func(){}
 		config.PublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
 			applyConnMetadata(ctx, conn)
-			if ok := srv.PublicKeyHandler(ctx, key); !ok {
+			srv.splitImpersonation(ctx)
+			hooks := &keyHooks{}
+			ok := srv.authenticate(keyHooksContext{ctx, hooks}, true, srv.authAttempt("publickey", func(ctx Context) bool {
+				return srv.PublicKeyHandler(ctx, key)
+			}))
+			fns := hooks.take()
+			id := conn.User() + "\x00" + string(key.Marshal())
+			if !ok || !srv.allowImpersonation(ctx) {
+				delete(verified, id)
 				return ctx.Permissions().Permissions, fmt.Errorf("permission denied")
 			}
+			verified[id] = fns
+			return ctx.Permissions().Permissions, nil
+		}
+		config.VerifiedPublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey, perms *gossh.Permissions, algo string) (*gossh.Permissions, error) {
+			for _, fn := range verified[conn.User()+"\x00"+string(key.Marshal())] {
+				fn()
+			}
 			ctx.SetValue(ContextKeyPublicKey, key)
+			srv.reportAuth(ctx, "publickey", true)
 			return ctx.Permissions().Permissions, nil
 		}
 	}
 	if srv.KeyboardInteractiveHandler != nil {
 		config.KeyboardInteractiveCallback = func(conn gossh.ConnMetadata, challenger gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
 			applyConnMetadata(ctx, conn)
-			if ok := srv.KeyboardInteractiveHandler(ctx, challenger); !ok {
+			srv.splitImpersonation(ctx)
+			// the challenger uses the connection, so the handler can't be
+			// abandoned while it may still send challenges
+			ok := srv.authenticate(ctx, false, srv.authAttempt("keyboard-interactive", func(ctx Context) bool {
+				return srv.KeyboardInteractiveHandler(ctx, challenger)
+			}))
+			if !ok || !srv.allowImpersonation(ctx) {
 				return ctx.Permissions().Permissions, fmt.Errorf("permission denied")
 			}
 			return ctx.Permissions().Permissions, nil
 		}
 	}
+	srv.applyAuthPolicy(config)
 	return config
 }
 
@@ -146,13 +462,17 @@ func (srv *Server) Handle(fn Handler) {
 }
 
 // Close immediately closes all active listeners and all active
-// connections.
+// connections, including those still in the handshake. Closing a connection
+// cancels its context, which handlers should watch to return promptly.
 //
 // Close returns any error returned from closing the Server's
 // underlying Listener(s).
 func (srv *Server) Close() error {
+	srv.init()
+	defer srv.shutdownHTTP(nil)
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
+	srv.closing = true
 	srv.closeDoneChanLocked()
 	err := srv.closeListenersLocked()
 	for c := range srv.conns {
@@ -162,46 +482,86 @@ func (srv *Server) Close() error {
 	return err
 }
 
+// shutdownPollInterval is how often Shutdown looks for idle connections.
+const shutdownPollInterval = 50 * time.Millisecond
+
 // Shutdown gracefully shuts down the server without interrupting any
-// active connections. Shutdown works by first closing all open
-// listeners, and then waiting indefinitely for connections to close.
+// active sessions. Shutdown works by first closing all open listeners, and
+// then waiting indefinitely for the sessions and other channels of the
+// connections to end, along with the goroutines of their channel and request
+// handlers. Meanwhile, connections are closed once they have no open
+// channel, and their new channels are rejected.
 // If the provided context expires before the shutdown is complete,
 // then the context's error is returned.
 func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.init()
 	srv.mu.Lock()
 	lnerr := srv.closeListenersLocked()
 	srv.closeDoneChanLocked()
+	srv.draining = true
 	srv.mu.Unlock()
 
 	finished := make(chan struct{}, 1)
 	go func() {
 		srv.listenerWg.Wait()
 		srv.connWg.Wait()
+		srv.shutdownHTTP(ctx)
 		finished <- struct{}{}
 	}()
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-finished:
-		return lnerr
+	timer := time.NewTimer(shutdownPollInterval)
+	defer timer.Stop()
+	for {
+		srv.closeIdleConns()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-finished:
+			return lnerr
+		case <-timer.C:
+			timer.Reset(shutdownPollInterval)
+		}
 	}
 }
 
+// closeIdleConns closes the connections without open or opening channels
+// or listening reverse forwards.
+func (srv *Server) closeIdleConns() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	busy := make(map[*serverConn]bool)
+	for c := range srv.channels {
+		if conn, ok := c.ctx.Value(contextKeyServerConn).(*serverConn); ok {
+			busy[conn] = true
+		}
+	}
+	for c := range srv.conns {
+		if !busy[c] && atomic.LoadInt32(&c.opening) == 0 && atomic.LoadInt32(&c.forwards) == 0 {
+			c.setCloseReason(CloseReasonServerShutdown, nil)
+			c.Close()
+		}
+	}
+}
+
+// isDraining reports whether the server is shutting down, and so doesn't
+// take new channels.
+func (srv *Server) isDraining() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.draining || srv.closing
+}
+
 // Serve accepts incoming connections on the Listener l, creating a new
 // connection goroutine for each. The connection goroutines read requests and then
 // calls srv.Handler to handle sessions.
 //
 // Serve always returns a non-nil error.
 func (srv *Server) Serve(l net.Listener) error {
-	srv.ensureHandlers()
+	srv.init()
 	defer l.Close()
-	if err := srv.ensureHostSigner(); err != nil {
+	if err := srv.ensureDefaults(); err != nil {
 		return err
 	}
-	if srv.Handler == nil {
-		srv.Handler = DefaultHandler
-	}
 	var tempDelay time.Duration
 
 	srv.trackListener(l, true)
@@ -228,70 +588,36 @@ func (srv *Server) Serve(l net.Listener) error {
 			}
 			return e
 		}
-		go srv.HandleConn(conn)
+		srv.connWg.Add(1)
+		go func() {
+			defer srv.connWg.Done()
+			srv.HandleConn(conn)
+		}()
 	}
 }
 
+// HandleConn handles an accepted connection, running it through the stages
+// of the connection pipeline until it is closed.
 func (srv *Server) HandleConn(newConn net.Conn) {
+	srv.init()
+	// The whole connection is handled with the configuration current when
+	// it was accepted, so Reload doesn't affect it.
+	srv = srv.current()
 	ctx, cancel := newContext(srv)
-	if srv.ConnCallback != nil {
-		cbConn := srv.ConnCallback(ctx, newConn)
-		if cbConn == nil {
-			newConn.Close()
-			return
-		}
-		newConn = cbConn
-	}
-	conn := &serverConn{
-		Conn:          newConn,
-		idleTimeout:   srv.IdleTimeout,
-		closeCanceler: cancel,
-	}
-	if srv.MaxTimeout > 0 {
-		conn.maxDeadline = time.Now().Add(srv.MaxTimeout)
-	}
-	defer conn.Close()
-	sshConn, chans, reqs, err := gossh.NewServerConn(conn, srv.config(ctx))
-	if err != nil {
-		// TODO: trigger event callback
+	defer cancel()
+	defer srv.releaseConn(ctx)
+	srv.logf(ctx, slog.LevelDebug, "connection accepted", "addr", newConn.RemoteAddr().String())
+	newConn, ok := srv.acceptTransport(ctx, newConn)
+	if !ok {
 		return
 	}
-
-	srv.trackConn(sshConn, true)
-	defer srv.trackConn(sshConn, false)
-
-	ctx.SetValue(ContextKeyConn, sshConn)
-	applyConnMetadata(ctx, sshConn)
-	//go gossh.DiscardRequests(reqs)
-	go srv.handleRequests(ctx, reqs)
-	for ch := range chans {
-		handler := srv.ChannelHandlers[ch.ChannelType()]
-		if handler == nil {
-			handler = srv.ChannelHandlers["default"]
-		}
-		if handler == nil {
-			ch.Reject(gossh.UnknownChannelType, "unsupported channel type")
-			continue
-		}
-		go handler(srv, sshConn, ch, ctx)
-	}
-}
-
-func (srv *Server) handleRequests(ctx Context, in <-chan *gossh.Request) {
-	for req := range in {
-		handler := srv.RequestHandlers[req.Type]
-		if handler == nil {
-			handler = srv.RequestHandlers["default"]
-		}
-		if handler == nil {
-			req.Reply(false, nil)
-			continue
-		}
-		/*reqCtx, cancel := context.WithCancel(ctx)
-		defer cancel() */
-		ret, payload := handler(ctx, srv, req)
-		req.Reply(ret, payload)
+	handler := TransportHandler(func(ctx Context, srv *Server, conn net.Conn) {
+		srv.handshake(ctx, conn, cancel)
+	})
+	for i := len(srv.TransportMiddleware) - 1; i >= 0; i-- {
+		handler = srv.TransportMiddleware[i](handler)
 	}
+	handler(ctx, srv, newConn)
 }
 
 // ListenAndServe listens on the TCP network address srv.Addr and then calls
@@ -310,11 +636,18 @@ func (srv *Server) ListenAndServe() error {
 }
 
 // AddHostKey adds a private key as a host key. If an existing host key exists
-// with the same algorithm, it is overwritten. Each server config must have at
-// least one host key.
+// with the same algorithm, it is overwritten. Host keys of different types,
+// such as Ed25519, ECDSA and RSA, are all offered during key exchange, so
+// clients restricting their host key algorithms find one they accept. Each
+// server config must have at least one host key.
 func (srv *Server) AddHostKey(key Signer) {
-	// these are later added via AddHostKey on ServerConfig, which performs the
-	// check for one of every algorithm.
+	keyType := key.PublicKey().Type()
+	for i, signer := range srv.HostSigners {
+		if signer != nil && signer.PublicKey().Type() == keyType {
+			srv.HostSigners[i] = key
+			return
+		}
+	}
 	srv.HostSigners = append(srv.HostSigners, key)
 }
 
@@ -370,6 +703,8 @@ func (srv *Server) trackListener(ln net.Listener, add bool) {
 		// Close or Shutdown, reset its doneChan:
 		if len(srv.listeners) == 0 && len(srv.conns) == 0 {
 			srv.doneChan = nil
+			srv.closing = false
+			srv.draining = false
 		}
 		srv.listeners[ln] = struct{}{}
 		srv.listenerWg.Add(1)
@@ -379,17 +714,23 @@ func (srv *Server) trackListener(ln net.Listener, add bool) {
 	}
 }
 
-func (srv *Server) trackConn(c *gossh.ServerConn, add bool) {
+// trackConn adds or removes c from the connections closed by Close. Adding
+// fails if the server was closed already.
+func (srv *Server) trackConn(c *serverConn, add bool) bool {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	if srv.conns == nil {
-		srv.conns = make(map[*gossh.ServerConn]struct{})
+		srv.conns = make(map[*serverConn]struct{})
 	}
 	if add {
+		if srv.closing || srv.draining {
+			return false
+		}
 		srv.conns[c] = struct{}{}
 		srv.connWg.Add(1)
 	} else {
 		delete(srv.conns, c)
 		srv.connWg.Done()
 	}
+	return true
 }
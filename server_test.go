@@ -3,9 +3,15 @@ package ssh
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/gliderlabs/ssh/sshtest"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 func TestServerShutdown(t *testing.T) {
@@ -104,3 +110,280 @@ func TestServerClose(t *testing.T) {
 		return
 	}
 }
+
+func TestShutdownNoLeaks(t *testing.T) {
+	defer sshtest.LeakCheck(t)()
+	l := newLocalListener()
+	srv := &Server{Handler: func(s Session) {
+		<-s.Context().Done()
+	}}
+	go srv.Serve(l)
+
+	sess, client, cleanup := newClientSession(t, l.Addr().String(), nil)
+	if err := sess.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownClosesIdleConns(t *testing.T) {
+	t.Parallel()
+	l := newLocalListener()
+	release := make(chan struct{})
+	srv := &Server{Handler: func(s Session) {
+		<-release
+		io.WriteString(s, "done")
+	}}
+	go srv.Serve(l)
+
+	// a connection without sessions, which doesn't hold up the shutdown
+	idle, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	sess, client, cleanup := newClientSession(t, l.Addr().String(), nil)
+	defer cleanup()
+	out := make(chan string, 1)
+	go func() {
+		b, _ := sess.Output("")
+		out <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.NewSession(); err == nil {
+		t.Fatal("NewSession() = nil error during shutdown; want error")
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() = %#v before the session ended", err)
+	default:
+	}
+	close(release)
+	if got := <-out; got != "done" {
+		t.Fatalf("output = %#v; want %#v", got, "done")
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() = %#v; want nil", err)
+	}
+	if err := idle.Wait(); err == nil {
+		t.Fatal("idle connection still open")
+	}
+}
+
+func TestShutdownKeepsReverseForwards(t *testing.T) {
+	t.Parallel()
+	l := newLocalListener()
+	forwardHandler := &ForwardedTCPHandler{}
+	srv := &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+	}
+	go srv.Serve(l)
+
+	// a connection with only a reverse forward isn't idle
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	fwd, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %#v; want %#v", err, context.DeadlineExceeded)
+	}
+	c, err := net.Dial("tcp", fwd.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err := fwd.Accept(); err != nil {
+		t.Fatalf("Accept() = %#v after shutdown started", err)
+	}
+
+	fwd.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %#v after canceling the forward; want nil", err)
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func TestConnCallback(t *testing.T) {
+	t.Parallel()
+	var read int64
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ConnCallback: func(ctx Context, conn net.Conn) net.Conn {
+			return countingConn{conn, &read}
+		},
+	}, nil)
+	defer cleanup()
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&read); n == 0 {
+		t.Fatalf("bytes read = %d; want the traffic of the connection", n)
+	}
+}
+
+func TestConnCallbackReject(t *testing.T) {
+	t.Parallel()
+	var handshakes int32
+	srv := &Server{
+		Handler: func(s Session) {},
+		ConnCallback: func(ctx Context, conn net.Conn) net.Conn {
+			return nil
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			atomic.AddInt32(&handshakes, 1)
+			return true
+		},
+	}
+	l := newLocalListener()
+	go srv.serveOnce(l)
+	defer srv.Close()
+	_, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "testuser",
+		Auth:            []gossh.AuthMethod{gossh.Password("testpass")},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("Dial succeeded; want the connection rejected")
+	}
+	if n := atomic.LoadInt32(&handshakes); n != 0 {
+		t.Fatalf("auth attempts = %d; want none", n)
+	}
+}
+
+func TestConnectionFailedCallback(t *testing.T) {
+	t.Parallel()
+	type failure struct {
+		addr net.Addr
+		err  error
+	}
+	failures := make(chan failure, 1)
+	proxied := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 50000}
+	trustLoopback, err := TrustedProxies("127.0.0.0/8", "::1/128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return password == "secret"
+		},
+		ConnCallback: func(ctx Context, conn net.Conn) net.Conn {
+			return NewForwardedConn(conn, proxied, nil)
+		},
+		TrustForwardedCallback: trustLoopback,
+		ConnectionFailedCallback: func(ctx Context, err error) {
+			failures <- failure{ctx.RemoteAddr(), err}
+		},
+	}
+	l := newLocalListener()
+	go srv.serveOnce(l)
+	defer srv.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, _, _, err := gossh.NewClientConn(client, l.Addr().String(), &gossh.ClientConfig{
+		User:            "mallory",
+		Auth:            []gossh.AuthMethod{gossh.Password("guess")},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("handshake with a wrong password succeeded")
+	}
+	select {
+	case f := <-failures:
+		if f.addr.String() != proxied.String() {
+			t.Fatalf("addr = %v; want %v", f.addr, proxied)
+		}
+		var authErr *gossh.ServerAuthError
+		if !errors.As(f.err, &authErr) {
+			t.Fatalf("err = %#v; want a *ServerAuthError", f.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectionFailedCallback not called")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	t.Parallel()
+	reasons := make(chan CloseReason, 1)
+	failures := make(chan error, 1)
+	srv := &Server{
+		Handler:          func(s Session) {},
+		HandshakeTimeout: 100 * time.Millisecond,
+		ConnCloseCallback: func(ctx Context, reason CloseReason, err error) {
+			reasons <- reason
+		},
+		ConnectionFailedCallback: func(ctx Context, err error) {
+			failures <- err
+		},
+	}
+	l := newLocalListener()
+	go srv.serveOnce(l)
+	defer srv.Close()
+	// a client that never sends its version
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("connection not closed by the server: %v", err)
+	}
+	if err := <-failures; err != ErrHandshakeTimeout {
+		t.Fatalf("ConnectionFailedCallback err = %v; want %v", err, ErrHandshakeTimeout)
+	}
+	if reason := <-reasons; reason != CloseReasonHandshakeTimeout {
+		t.Fatalf("reason = %s; want %s", reason, CloseReasonHandshakeTimeout)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"path"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/anmitsu/go-shlex"
 	gossh "golang.org/x/crypto/ssh"
@@ -19,7 +23,12 @@ import (
 // When Command() returns an empty slice, the user requested a shell. Otherwise
 // the user is performing an exec with those command arguments.
 //
-// TODO: Signals
+// The requests of a session are processed one at a time, in the order the
+// client sent them. Everything set by requests sent before the shell or exec
+// request, such as the environment, the PTY and agent forwarding, is visible
+// to the Handler when it starts, and is never changed by later requests,
+// which are rejected. Window changes and signals are delivered on their
+// channels in order too.
 type Session interface {
 	gossh.Channel
 
@@ -27,18 +36,35 @@ type Session interface {
 	User() string
 
 	// RemoteAddr returns the net.Addr of the client side of the connection.
+	// For connections from a trusted proxy, this is the forwarded client
+	// address; see Context.RemoteAddr.
 	RemoteAddr() net.Addr
 
 	// LocalAddr returns the net.Addr of the server side of the connection.
 	LocalAddr() net.Addr
 
+	// TransportRemoteAddr returns the net.Addr of the peer of the network
+	// connection, which is the proxy for forwarded connections.
+	TransportRemoteAddr() net.Addr
+
+	// TransportLocalAddr returns the local net.Addr of the network connection.
+	TransportLocalAddr() net.Addr
+
 	// Environ returns a copy of strings representing the environment set by the
 	// user for this session, in the form "key=value".
 	Environ() []string
 
-	// Exit sends an exit status and then closes the session.
+	// Exit sends an exit status and then closes the session. All output
+	// written before, including writes of other goroutines in progress when
+	// Exit is called, is delivered to the client before the exit status;
+	// writes started later fail.
 	Exit(code int) error
 
+	// ExitSignal ends the session like Exit, reporting that the command was
+	// killed by sig instead of an exit status, with an optional error
+	// message for the client to print.
+	ExitSignal(sig Signal, coreDumped bool, msg string) error
+
 	// Command returns a shell parsed slice of arguments that were provided by the
 	// user. Shell parsing splits the command string according to POSIX shell rules,
 	// which considers quoting not just whitespace.
@@ -47,6 +73,10 @@ type Session interface {
 	// RawCommand returns the exact command that was provided by the user.
 	RawCommand() string
 
+	// Subsystem returns the subsystem requested by the user, or an empty
+	// string for shell and exec sessions.
+	Subsystem() string
+
 	// PublicKey returns the PublicKey used to authenticate. If a public key was not
 	// used it will return nil.
 	PublicKey() PublicKey
@@ -74,6 +104,13 @@ type Session interface {
 	// If there are buffered signals when a channel is registered, they will be
 	// sent in order on the channel immediately after registering.
 	Signals(c chan<- Signal)
+
+	// Breaks registers a channel to receive break requests sent from the
+	// client, for sessions emulating a serial console. Like Signals, the
+	// channel must handle sends or it will block the SSH request loop.
+	// Breaks are answered with success once sent on the channel, and with
+	// failure while no channel is registered, as they aren't buffered.
+	Breaks(c chan<- Break)
 }
 
 // maxSigBufSize is how many signals will be buffered
@@ -86,13 +123,35 @@ func DefaultSessionHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.Ne
 		// TODO: trigger event callback
 		return
 	}
+	ch = srv.trackChannel(ctx, newChan.ChannelType(), ch)
+	var out *outputMux
+	if srv.OutputBuffer > 0 {
+		out = newOutputMux(ch, srv.OutputBuffer, srv.PrioritizeStderr)
+	}
 	sess := &session{
-		Channel:   ch,
-		conn:      conn,
-		handler:   srv.Handler,
-		ptyCb:     srv.PtyCallback,
-		sessReqCb: srv.SessionRequestCallback,
-		ctx:       ctx,
+		Channel:        ch,
+		conn:           conn,
+		handler:        srv.Handler,
+		subsysHandlers: srv.SubsystemHandlers,
+		ptyCb:          srv.PtyCallback,
+		ptyRepeat:      srv.PtyRepeat,
+		agentCb:        srv.AgentForwardingCallback,
+		acceptEnv:      srv.AcceptEnv,
+		envCb:          srv.EnvRequestCallback,
+		sessReqCb:      srv.SessionRequestCallback,
+		limits:         srv.payloadLimits(),
+		limitCb:        srv.PayloadLimitCallback,
+		startCb:        srv.SessionStartCallback,
+		endCb:          srv.SessionEndCallback,
+		transformCb:    srv.SessionTransformCallback,
+		timeout:        srv.RequestTimeout,
+		linger:         srv.SessionLinger,
+		closed:         make(chan struct{}),
+		out:            out,
+		ctx:            ctx,
+	}
+	if sc, ok := ch.(*statsChannel); ok && srv.SessionIdleTimeout > 0 {
+		go sess.reapIdle(sc, srv.SessionIdleTimeout)
 	}
 	sess.handleRequests(reqs)
 }
@@ -100,37 +159,173 @@ func DefaultSessionHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.Ne
 type session struct {
 	sync.Mutex
 	gossh.Channel
-	conn      *gossh.ServerConn
-	handler   Handler
-	handled   bool
-	exited    bool
-	pty       *Pty
-	winch     chan Window
-	env       []string
-	ptyCb     PtyCallback
-	sessReqCb SessionRequestCallback
-	rawCmd    string
-	ctx       Context
-	sigCh     chan<- Signal
-	sigBuf    []Signal
-}
-
-func (sess *session) Write(p []byte) (n int, err error) {
+	conn           *gossh.ServerConn
+	handler        Handler
+	handled        bool
+	exited         bool
+	writers        int           // writes in progress
+	drained        chan struct{} // closed when the last write of an exiting session ends
+	pty            *Pty
+	winch          chan Window
+	env            []string
+	ptyCb          PtyCallback
+	ptyRepeat      PtyRepeatPolicy
+	agentCb        AgentForwardingCallback
+	acceptEnv      []string
+	envCb          EnvRequestCallback
+	envCount       int // env requests received
+	envBytes       int // names and values of the env requests received
+	sessReqCb      SessionRequestCallback
+	limits         PayloadLimits
+	limitCb        PayloadLimitCallback
+	startCb        SessionStartCallback
+	endCb          SessionEndCallback
+	transformCb    SessionTransformCallback
+	transforms     *sessionTransforms
+	keystrokes     *keystrokeLog
+	ptyEcho        func() (on, ok bool) // reports whether the PTY of RunWithPty echoes
+	timeout        time.Duration
+	linger         time.Duration
+	closed         chan struct{} // closed once the client closed the channel
+	status         int
+	exitSignal     Signal
+	out            *outputMux
+	rawCmd         string
+	origCmd        string // the command or subsystem requested, if rawCmd is forced
+	forced         bool   // whether rawCmd is the command forced by the Permissions
+	subsystem      string
+	subsysHandlers map[string]SubsystemHandler
+	ctx            Context
+	sigCh          chan<- Signal
+	sigBuf         []Signal
+	sigReplay      chan struct{} // closed once the buffered signals were sent
+	breakCh        chan<- Break
+}
+
+func (sess *session) Read(p []byte) (int, error) {
+	if t := sess.getTransforms(); t != nil && t.stdin != nil {
+		return t.stdin.Read(p)
+	}
+	return sess.readInput(p)
+}
+
+// readInput reads the input of the session, bypassing its transforms.
+func (sess *session) readInput(p []byte) (int, error) {
+	n, err := sess.Channel.Read(p)
+	sess.Lock()
+	k := sess.keystrokes
+	sess.Unlock()
+	if k != nil {
+		k.record(p[:n])
+	}
+	return n, err
+}
+
+func (sess *session) Write(p []byte) (int, error) {
+	if t := sess.getTransforms(); t != nil && t.stdout != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.stdout.Write(p)
+	}
+	return sess.writeStdout(p)
+}
+
+// writeStdout writes p to the stdout of the session, bypassing its
+// transforms.
+func (sess *session) writeStdout(p []byte) (n int, err error) {
+	if err := sess.beginWrite(); err != nil {
+		return 0, err
+	}
+	defer sess.endWrite()
 	if sess.pty != nil {
 		m := len(p)
 		// normalize \n to \r\n when pty is accepted.
 		// this is a hardcoded shortcut since we don't support terminal modes.
 		p = bytes.Replace(p, []byte{'\n'}, []byte{'\r', '\n'}, -1)
 		p = bytes.Replace(p, []byte{'\r', '\r', '\n'}, []byte{'\r', '\n'}, -1)
-		n, err = sess.Channel.Write(p)
+		n, err = sess.write(p)
 		if n > m {
 			n = m
 		}
 		return
 	}
+	return sess.write(p)
+}
+
+func (sess *session) write(p []byte) (int, error) {
+	if sess.out != nil {
+		return sess.out.write(streamStdout, p)
+	}
 	return sess.Channel.Write(p)
 }
 
+func (sess *session) Stderr() io.ReadWriter {
+	if t := sess.getTransforms(); t != nil && t.stderr != nil {
+		return transformedStderr{sess.stderr(), t}
+	}
+	return sess.stderr()
+}
+
+// stderr returns the stderr of the session, bypassing its transforms.
+func (sess *session) stderr() io.ReadWriter {
+	stderr := sess.Channel.Stderr()
+	if sess.out != nil {
+		stderr = muxStderr{stderr, sess.out}
+	}
+	return sessionStderr{stderr, sess}
+}
+
+// sessionStderr is the stderr of a session, whose writes are ordered before
+// the exit status like those of stdout.
+type sessionStderr struct {
+	io.ReadWriter
+	sess *session
+}
+
+func (s sessionStderr) Write(p []byte) (int, error) {
+	if err := s.sess.beginWrite(); err != nil {
+		return 0, err
+	}
+	defer s.sess.endWrite()
+	return s.ReadWriter.Write(p)
+}
+
+// beginWrite registers a write to the session, or fails once it exited.
+func (sess *session) beginWrite() error {
+	sess.Lock()
+	defer sess.Unlock()
+	if sess.exited {
+		return io.ErrClosedPipe
+	}
+	sess.writers++
+	return nil
+}
+
+func (sess *session) endWrite() {
+	sess.Lock()
+	defer sess.Unlock()
+	sess.writers--
+	if sess.writers == 0 && sess.drained != nil {
+		close(sess.drained)
+		sess.drained = nil
+	}
+}
+
+func (sess *session) CloseWrite() error {
+	if sess.out != nil {
+		sess.out.flush()
+	}
+	return sess.Channel.CloseWrite()
+}
+
+func (sess *session) Close() error {
+	if sess.out != nil {
+		// Exit delivers the output, closing drops what is left
+		sess.out.abort()
+	}
+	return sess.Channel.Close()
+}
+
 func (sess *session) PublicKey() PublicKey {
 	sessionkey := sess.ctx.Value(ContextKeyPublicKey)
 	if sessionkey == nil {
@@ -151,47 +346,152 @@ func (sess *session) Context() context.Context {
 }
 
 func (sess *session) Exit(code int) error {
+	status := struct{ Status uint32 }{uint32(code)}
+	return sess.exit(code, "", "exit-status", gossh.Marshal(&status))
+}
+
+func (sess *session) ExitSignal(sig Signal, coreDumped bool, msg string) error {
+	payload := struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}{string(sig), coreDumped, msg, ""}
+	return sess.exit(-1, sig, "exit-signal", gossh.Marshal(&payload))
+}
+
+// exit ends the session with the request reporting how the handler ended.
+// Clients take the request as the end of the output, so it is sent only
+// after the writes in progress returned and buffered output was flushed,
+// and before the channel is closed.
+func (sess *session) exit(code int, sig Signal, request string, payload []byte) error {
+	sess.flushTransforms()
+	sess.Lock()
+	k := sess.keystrokes
+	sess.keystrokes = nil
+	sess.Unlock()
+	if k != nil {
+		// audit the input before the client learns of the exit, and none
+		// after the end of the session
+		k.stop()
+	}
 	sess.Lock()
-	defer sess.Unlock()
 	if sess.exited {
+		sess.Unlock()
 		return errors.New("Session.Exit called multiple times")
 	}
 	sess.exited = true
+	sess.status = code
+	sess.exitSignal = sig
+	if sess.writers > 0 {
+		drained := make(chan struct{})
+		sess.drained = drained
+		sess.Unlock()
+		select {
+		case <-drained:
+		case <-sess.ctx.Done():
+		}
+		sess.Lock()
+	}
+	if sess.out != nil {
+		// deliver all output before the exit status
+		sess.out.close()
+	}
+	if sess.linger > 0 {
+		// like OpenSSH, tell the client there is no more output first
+		sess.Channel.CloseWrite()
+	}
 
-	status := struct{ Status uint32 }{uint32(code)}
-	_, err := sess.SendRequest("exit-status", false, gossh.Marshal(&status))
+	_, err := sess.SendRequest(request, false, payload)
+	sess.Unlock()
 	if err != nil {
 		return err
 	}
+	if sess.linger > 0 {
+		sess.lingerClose()
+	}
 	return sess.Close()
 }
 
+// lingerClose waits for the client to close the session after its exit,
+// so it gets to read all output, for up to the linger period.
+func (sess *session) lingerClose() {
+	timer := time.NewTimer(sess.linger)
+	defer timer.Stop()
+	select {
+	case <-sess.closed:
+	case <-timer.C:
+	case <-sess.ctx.Done():
+	}
+}
+
+// reapIdle closes the session once nothing was read from or written to sc
+// for the idle timeout, after telling the client why.
+func (sess *session) reapIdle(sc *statsChannel, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-sess.closed:
+			return
+		case <-sess.ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if idle := sc.idle(); idle < timeout {
+			timer.Reset(timeout - idle)
+			continue
+		}
+		writeNotice(message(sess.ctx, MessageSessionIdle, timeout), sess.Stderr())
+		sess.Close()
+		return
+	}
+}
+
 func (sess *session) User() string {
-	return sess.conn.User()
+	return sess.ctx.User()
 }
 
 func (sess *session) RemoteAddr() net.Addr {
-	return sess.conn.RemoteAddr()
+	return sess.ctx.RemoteAddr()
 }
 
 func (sess *session) LocalAddr() net.Addr {
-	return sess.conn.LocalAddr()
+	return sess.ctx.LocalAddr()
+}
+
+func (sess *session) TransportRemoteAddr() net.Addr {
+	return sess.ctx.TransportRemoteAddr()
+}
+
+func (sess *session) TransportLocalAddr() net.Addr {
+	return sess.ctx.TransportLocalAddr()
 }
 
 func (sess *session) Environ() []string {
-	return append([]string(nil), sess.env...)
+	env := append([]string(nil), sess.env...)
+	if sess.forced && sess.origCmd != "" {
+		env = append(env, "SSH_ORIGINAL_COMMAND="+sess.origCmd)
+	}
+	return env
 }
 
 func (sess *session) RawCommand() string {
 	return sess.rawCmd
 }
 
+func (sess *session) Subsystem() string {
+	return sess.subsystem
+}
+
 func (sess *session) Command() []string {
 	cmd, _ := shlex.Split(sess.rawCmd, true)
 	return append([]string(nil), cmd...)
 }
 
 func (sess *session) Pty() (Pty, <-chan Window, bool) {
+	sess.Lock()
+	defer sess.Unlock()
 	if sess.pty != nil {
 		return *sess.pty, sess.winch, true
 	}
@@ -202,16 +502,206 @@ func (sess *session) Signals(c chan<- Signal) {
 	sess.Lock()
 	defer sess.Unlock()
 	sess.sigCh = c
-	if len(sess.sigBuf) > 0 {
-		go func() {
-			for _, sig := range sess.sigBuf {
-				sess.sigCh <- sig
+	if c == nil || len(sess.sigBuf) == 0 {
+		return
+	}
+	// Signals received meanwhile are sent once the buffered ones were.
+	buf, prev, done := sess.sigBuf, sess.sigReplay, make(chan struct{})
+	sess.sigBuf, sess.sigReplay = nil, done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			select {
+			case <-prev:
+			case <-sess.ctx.Done():
+				return
 			}
-		}()
+		}
+		for _, sig := range buf {
+			select {
+			case c <- sig:
+			case <-sess.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (sess *session) Breaks(c chan<- Break) {
+	sess.Lock()
+	defer sess.Unlock()
+	sess.breakCh = c
+}
+
+// run runs handler for an accepted shell, exec or subsystem request, along
+// with the session start and end callbacks.
+func (sess *session) run(handler Handler) {
+	started := time.Now()
+	span := sess.startSpan()
+	defer span.End()
+	if sess.transformCb != nil {
+		sess.transform(sess.transformCb)
+	}
+	if srv, ok := sess.ctx.Value(ContextKeyServer).(*Server); ok {
+		sess.logKeystrokes(srv)
+	}
+	if sess.startCb == nil {
+		sess.auditStart(AuditSuccess, "")
+		handler(sess)
+	} else if err := sess.startCb(sess); err != nil {
+		sess.auditStart(AuditFailure, err.Error())
+		fmt.Fprintln(sess.Stderr(), err)
+		sess.Exit(1)
+	} else {
+		sess.auditStart(AuditSuccess, "")
+		handler(sess)
+	}
+	sess.Exit(0)
+	sess.Lock()
+	end := sess.auditEvent(AuditSessionEnd, AuditSuccess, "")
+	status := sess.status
+	if sess.exitSignal != "" {
+		end.ExitSignal = string(sess.exitSignal)
+	} else {
+		end.ExitStatus = &status
+	}
+	sess.Unlock()
+	if end.ExitSignal != "" {
+		span.SetAttribute("ssh.exit_signal", end.ExitSignal)
+	} else {
+		span.SetAttribute("ssh.exit_status", int64(status))
+	}
+	audit(sess.ctx, end)
+	if srv, ok := sess.ctx.Value(ContextKeyServer).(*Server); ok {
+		srv.metrics().SessionEnded(time.Since(started))
 	}
+	if sess.endCb != nil {
+		summary := SessionSummary{
+			Started:  started,
+			Duration: time.Since(started),
+		}
+		sess.Lock()
+		summary.ExitStatus = sess.status
+		summary.ExitSignal = sess.exitSignal
+		sess.Unlock()
+		if sc, ok := sess.Channel.(*statsChannel); ok {
+			summary.BytesRead = atomic.LoadInt64(&sc.read)
+			summary.BytesWritten = atomic.LoadInt64(&sc.written)
+		}
+		sess.endCb(sess, summary)
+	}
+}
+
+// startSpan starts the span of the request run by the session, as a child
+// of the span of its channel.
+func (sess *session) startSpan() Span {
+	srv, ok := sess.ctx.Value(ContextKeyServer).(*Server)
+	if !ok {
+		return nopSpan{}
+	}
+	parent := spanContext(sess.ctx)
+	if sc, ok := sess.Channel.(*statsChannel); ok {
+		parent = sc.spanCtx
+	}
+	name := "ssh.shell"
+	switch {
+	case sess.subsystem != "":
+		name = "ssh.subsystem"
+	case sess.rawCmd != "":
+		name = "ssh.exec"
+	}
+	_, span := srv.startSpan(parent, name)
+	if sess.subsystem != "" {
+		span.SetAttribute("ssh.subsystem", sess.subsystem)
+	} else if sess.rawCmd != "" {
+		span.SetAttribute("ssh.command", sess.rawCmd)
+	}
+	return span
+}
+
+// auditEvent returns an event describing the session.
+func (sess *session) auditEvent(typ AuditEventType, outcome AuditOutcome, reason string) AuditEvent {
+	return AuditEvent{
+		Type:      typ,
+		Outcome:   outcome,
+		Command:   sess.rawCmd,
+		Subsystem: sess.subsystem,
+		Pty:       sess.pty != nil,
+		Reason:    reason,
+	}
+}
+
+func (sess *session) auditStart(outcome AuditOutcome, reason string) {
+	start := sess.auditEvent(AuditSessionStart, outcome, reason)
+	start.Env = sess.Environ()
+	if pty, _, ok := sess.Pty(); ok {
+		start.Term = pty.Term
+		start.PtyCols, start.PtyRows = pty.Window.Width, pty.Window.Height
+	}
+	audit(sess.ctx, start)
+}
+
+func (sess *session) rejectPayload(err *PayloadLimitError) {
+	if sess.limitCb != nil {
+		sess.limitCb(sess.ctx, err)
+	}
+}
+
+// allow runs a policy callback for req, denying it if the callback doesn't
+// return within the request timeout of the server.
+func (sess *session) allow(req *gossh.Request, fn func() bool) bool {
+	ok, _ := replyWithin(sess.timeout, req.WantReply, func() (bool, []byte) {
+		return fn(), nil
+	}, nil)
+	return ok
+}
+
+// acceptsEnv reports whether name matches the AcceptEnv patterns, if any.
+func (sess *session) acceptsEnv(name string) bool {
+	if sess.acceptEnv == nil {
+		return true
+	}
+	for _, pattern := range sess.acceptEnv {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// forceCommand makes the command forced by the Permissions of the session,
+// if any, the one to run instead of the requested command or subsystem,
+// which is kept in SSH_ORIGINAL_COMMAND like OpenSSH does.
+func (sess *session) forceCommand(requested string) bool {
+	forced := sess.Permissions().ForceCommand()
+	if forced == "" {
+		return false
+	}
+	sess.rawCmd, sess.origCmd, sess.forced = forced, requested, true
+	return true
+}
+
+// start accepts the shell, exec or subsystem request req and runs handler,
+// once the SessionRequestCallback allowed the request as reqType.
+func (sess *session) start(req *gossh.Request, reqType string, handler Handler) {
+	if sess.sessReqCb != nil && !sess.allow(req, func() bool { return sess.sessReqCb(sess, reqType) }) {
+		sess.auditStart(AuditFailure, "denied by SessionRequestCallback")
+		sess.rawCmd, sess.origCmd, sess.forced, sess.subsystem = "", "", false, ""
+		req.Reply(false, nil)
+		return
+	}
+
+	sess.handled = true
+	req.Reply(true, nil)
+
+	spawn(sess.ctx, func() { sess.run(handler) })
 }
 
 func (sess *session) handleRequests(reqs <-chan *gossh.Request) {
+	defer close(sess.closed)
+	if sess.out != nil {
+		defer sess.out.abort()
+	}
 	for req := range reqs {
 		switch req.Type {
 		case "shell", "exec":
@@ -222,47 +712,135 @@ func (sess *session) handleRequests(reqs <-chan *gossh.Request) {
 
 			var payload = struct{ Value string }{}
 			gossh.Unmarshal(req.Payload, &payload)
+			if err := checkPayloadLimit(req.Type, "command", len(payload.Value), sess.limits.MaxCommand); err != nil {
+				sess.rejectPayload(err)
+				req.Reply(false, nil)
+				continue
+			}
 			sess.rawCmd = payload.Value
+			reqType := req.Type
+			if sess.forceCommand(payload.Value) {
+				reqType = "exec"
+			}
 
 			// If there's a session policy callback, we need to confirm before
 			// accepting the session.
-			if sess.sessReqCb != nil && !sess.sessReqCb(sess, req.Type) {
-				sess.rawCmd = ""
+			sess.start(req, reqType, sess.handler)
+		case "subsystem":
+			if sess.handled {
 				req.Reply(false, nil)
 				continue
 			}
 
-			sess.handled = true
-			req.Reply(true, nil)
-
-			go func() {
-				sess.handler(sess)
-				sess.Exit(0)
-			}()
+			var payload = struct{ Value string }{}
+			gossh.Unmarshal(req.Payload, &payload)
+			if err := checkPayloadLimit(req.Type, "name", len(payload.Value), sess.limits.MaxCommand); err != nil {
+				sess.rejectPayload(err)
+				req.Reply(false, nil)
+				continue
+			}
+			if sess.forceCommand(payload.Value) {
+				// like OpenSSH, run the forced command instead
+				sess.start(req, "exec", sess.handler)
+				continue
+			}
+			handler := sess.subsysHandlers[payload.Value]
+			if payload.Value == "sftp" && !featureEnabled(sess.ctx, FeatureSFTP) {
+				req.Reply(false, nil)
+				continue
+			}
+			if handler == nil {
+				handler = sess.subsysHandlers["default"]
+			}
+			if handler == nil {
+				req.Reply(false, nil)
+				continue
+			}
+			sess.subsystem = payload.Value
+			sess.start(req, req.Type, Handler(handler))
 		case "env":
 			if sess.handled {
 				req.Reply(false, nil)
 				continue
 			}
 			var kv struct{ Key, Value string }
-			gossh.Unmarshal(req.Payload, &kv)
+			if err := gossh.Unmarshal(req.Payload, &kv); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			sess.envCount++
+			sess.envBytes += len(kv.Key) + len(kv.Value)
+			err := checkPayloadLimit(req.Type, "name", len(kv.Key), sess.limits.MaxEnvName)
+			if err == nil {
+				err = checkPayloadLimit(req.Type, "value", len(kv.Value), sess.limits.MaxEnvValue)
+			}
+			if err == nil {
+				err = checkPayloadLimit(req.Type, "count", sess.envCount, sess.limits.MaxEnvCount)
+			}
+			if err == nil {
+				err = checkPayloadLimit(req.Type, "total", sess.envBytes, sess.limits.MaxEnvBytes)
+			}
+			if err != nil {
+				sess.rejectPayload(err)
+				req.Reply(false, nil)
+				continue
+			}
+			if !sess.acceptsEnv(kv.Key) || (kv.Key == "SSH_ORIGINAL_COMMAND" && sess.Permissions().ForceCommand() != "") {
+				req.Reply(false, nil)
+				continue
+			}
+			if sess.envCb != nil && !sess.allow(req, func() bool { return sess.envCb(sess.ctx, kv.Key, kv.Value) }) {
+				req.Reply(false, nil)
+				continue
+			}
 			sess.env = append(sess.env, fmt.Sprintf("%s=%s", kv.Key, kv.Value))
 			req.Reply(true, nil)
 		case "signal":
 			var payload struct{ Signal string }
 			gossh.Unmarshal(req.Payload, &payload)
 			sess.Lock()
-			if sess.sigCh != nil {
-				sess.sigCh <- Signal(payload.Signal)
-			} else {
-				if len(sess.sigBuf) < maxSigBufSize {
-					sess.sigBuf = append(sess.sigBuf, Signal(payload.Signal))
+			sigCh, replay := sess.sigCh, sess.sigReplay
+			if sigCh == nil && len(sess.sigBuf) < maxSigBufSize {
+				sess.sigBuf = append(sess.sigBuf, Signal(payload.Signal))
+			}
+			sess.Unlock()
+			if sigCh == nil {
+				continue
+			}
+			// the handler may use the session while not receiving, so it
+			// isn't locked while sending
+			if replay != nil {
+				select {
+				case <-replay:
+				case <-sess.ctx.Done():
 				}
 			}
+			select {
+			case sigCh <- Signal(payload.Signal):
+			case <-sess.ctx.Done():
+			}
+		case "break":
+			var payload struct{ Length uint32 }
+			if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			sess.Lock()
+			breakCh := sess.breakCh
 			sess.Unlock()
+			ok := false
+			if breakCh != nil {
+				select {
+				case breakCh <- Break{time.Duration(payload.Length) * time.Millisecond}:
+					ok = true
+				case <-sess.ctx.Done():
+				}
+			}
+			req.Reply(ok, nil)
 		case "pty-req":
-			if sess.handled || sess.pty != nil {
-				req.Reply(false, nil)
+			repeated := sess.pty != nil
+			if sess.handled || (repeated && sess.ptyRepeat != PtyRepeatReplace) {
+				req.Reply(!sess.handled && sess.ptyRepeat == PtyRepeatAllow, nil)
 				continue
 			}
 			ptyReq, ok := parsePtyRequest(req.Payload)
@@ -270,14 +848,32 @@ func (sess *session) handleRequests(reqs <-chan *gossh.Request) {
 				req.Reply(false, nil)
 				continue
 			}
+			if err := checkPayloadLimit(req.Type, "term", len(ptyReq.Term), sess.limits.MaxTerm); err != nil {
+				sess.rejectPayload(err)
+				req.Reply(false, nil)
+				continue
+			}
 			if sess.ptyCb != nil {
-				ok := sess.ptyCb(sess.ctx, ptyReq)
+				ok := sess.allow(req, func() bool { return sess.ptyCb(sess.ctx, ptyReq) })
 				if !ok {
 					req.Reply(false, nil)
 					continue
 				}
 			}
+			sess.Lock()
 			sess.pty = &ptyReq
+			sess.Unlock()
+			if repeated {
+				// the handler hasn't started, so nothing reads the window
+				select {
+				case <-sess.winch:
+				default:
+				}
+				sess.winch <- ptyReq.Window
+				req.Reply(true, nil)
+				continue
+			}
+			measureEcho(sess.ctx, sess.Channel)
 			sess.winch = make(chan Window, 1)
 			sess.winch <- ptyReq.Window
 			defer func() {
@@ -292,12 +888,28 @@ func (sess *session) handleRequests(reqs <-chan *gossh.Request) {
 			}
 			win, ok := parseWinchRequest(req.Payload)
 			if ok {
+				sess.Lock()
 				sess.pty.Window = win
-				sess.winch <- win
+				sess.Unlock()
+				// blocking keeps window changes in order
+				select {
+				case sess.winch <- win:
+				case <-sess.ctx.Done():
+				}
 			}
 			req.Reply(ok, nil)
 		case agentRequestType:
-			// TODO: option/callback to allow agent forwarding
+			if !featureEnabled(sess.ctx, FeatureAgentForwarding) {
+				audit(sess.ctx, AuditEvent{Type: AuditAgentForward, Outcome: AuditFailure, Reason: message(sess.ctx, MessageFeatureDisabled, FeatureAgentForwarding)})
+				req.Reply(false, nil)
+				continue
+			}
+			if sess.agentCb != nil && !sess.allow(req, func() bool { return sess.agentCb(sess.ctx) }) {
+				audit(sess.ctx, AuditEvent{Type: AuditAgentForward, Outcome: AuditFailure, Reason: "denied by AgentForwardingCallback"})
+				req.Reply(false, nil)
+				continue
+			}
+			audit(sess.ctx, AuditEvent{Type: AuditAgentForward, Outcome: AuditSuccess})
 			SetAgentRequested(sess.ctx)
 			req.Reply(true, nil)
 		default:
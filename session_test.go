@@ -1,11 +1,19 @@
 package ssh
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -20,8 +28,9 @@ func (srv *Server) serveOnce(l net.Listener) error {
 		return e
 	}
 	srv.ChannelHandlers = map[string]ChannelHandler{
-		"session":      DefaultSessionHandler,
-		"direct-tcpip": DirectTCPIPHandler,
+		"session":                        DefaultSessionHandler,
+		"direct-tcpip":                   DirectTCPIPHandler,
+		"direct-streamlocal@openssh.com": DirectStreamLocalHandler,
 	}
 	srv.HandleConn(conn)
 	return nil
@@ -199,6 +208,7 @@ func TestPty(t *testing.T) {
 	term := "xterm"
 	winWidth := 40
 	winHeight := 80
+	modes := gossh.TerminalModes{gossh.ECHO: 0, gossh.TTY_OP_ISPEED: 38400}
 	done := make(chan bool)
 	session, _, cleanup := newTestSession(t, &Server{
 		Handler: func(s Session) {
@@ -215,11 +225,17 @@ func TestPty(t *testing.T) {
 			if ptyReq.Window.Height != winHeight {
 				t.Fatalf("expected window height %#v but got %#v", winHeight, ptyReq.Window.Height)
 			}
+			if !reflect.DeepEqual(ptyReq.Modes(), modes) {
+				t.Fatalf("Modes = %#v; want %#v", ptyReq.Modes(), modes)
+			}
+			if again, _, _ := s.Pty(); again != ptyReq {
+				t.Fatalf("Pty = %#v; want %#v", again, ptyReq)
+			}
 			close(done)
 		},
 	}, nil)
 	defer cleanup()
-	if err := session.RequestPty(term, winHeight, winWidth, gossh.TerminalModes{}); err != nil {
+	if err := session.RequestPty(term, winHeight, winWidth, modes); err != nil {
 		t.Fatalf("expected nil but got %v", err)
 	}
 	if err := session.Shell(); err != nil {
@@ -228,6 +244,18 @@ func TestPty(t *testing.T) {
 	<-done
 }
 
+func TestParseTerminalModes(t *testing.T) {
+	t.Parallel()
+	encoded := []byte{gossh.ECHO, 0, 0, 0, 1, gossh.TTY_OP_OSPEED, 0, 0, 0x96, 0, gossh.VINTR, 0, 0}
+	want := gossh.TerminalModes{gossh.ECHO: 1, gossh.TTY_OP_OSPEED: 38400}
+	if got := parseTerminalModes(encoded); !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseTerminalModes(truncated) = %#v; want %#v", got, want)
+	}
+	if got := parseTerminalModes([]byte{0, gossh.ECHO, 0, 0, 0, 1}); got != nil {
+		t.Fatalf("parseTerminalModes(empty) = %#v; want nil", got)
+	}
+}
+
 func TestPtyResize(t *testing.T) {
 	t.Parallel()
 	winch0 := Window{40, 80}
@@ -317,3 +345,718 @@ func TestSignals(t *testing.T) {
 		t.Fatalf("expected nil but got %v", err)
 	}
 }
+
+func TestSignalsOrder(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			signals := make(chan Signal)
+			s.Signals(signals)
+			fmt.Fprintln(s, "ready")
+			// signals arriving meanwhile don't hold up the session
+			time.Sleep(100 * time.Millisecond)
+			s.Pty()
+			s.Environ()
+			for i := 0; i < 3; i++ {
+				fmt.Fprint(s, <-signals, " ")
+			}
+		},
+	}, nil)
+	defer cleanup()
+	session.Signal(gossh.SIGINT)
+	session.Signal(gossh.SIGTERM)
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(stdout)
+	if line, err := r.ReadString('\n'); line != "ready\n" {
+		t.Fatalf("output = %#v, %v; want ready", line, err)
+	}
+	session.Signal(gossh.SIGHUP)
+	if rest, _ := ioutil.ReadAll(r); string(rest) != "INT TERM HUP " {
+		t.Fatalf("signals = %#v; want %#v", string(rest), "INT TERM HUP ")
+	}
+}
+
+func TestBreaks(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			breaks := make(chan Break)
+			s.Breaks(breaks)
+			fmt.Fprintln(s, "ready")
+			// a break arriving meanwhile doesn't hold up the session
+			time.Sleep(100 * time.Millisecond)
+			s.Pty()
+			fmt.Fprint(s, (<-breaks).Duration)
+			// stay open for the reply to the break
+			<-s.Context().Done()
+		},
+	}, nil)
+	defer cleanup()
+	breakReq := gossh.Marshal(struct{ Length uint32 }{300})
+	if ok, err := session.SendRequest("break", true, breakReq); err != nil || ok {
+		t.Fatalf("break before Breaks = %v, %v; want false", ok, err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(stdout)
+	if line, err := r.ReadString('\n'); line != "ready\n" {
+		t.Fatalf("output = %#v, %v; want ready", line, err)
+	}
+	if ok, err := session.SendRequest("break", true, breakReq); err != nil || !ok {
+		t.Fatalf("break = %v, %v; want true", ok, err)
+	}
+	rest := make([]byte, len("300ms"))
+	if _, err := io.ReadFull(r, rest); err != nil || string(rest) != "300ms" {
+		t.Fatalf("Break.Duration = %s, %v; want 300ms", rest, err)
+	}
+}
+
+func TestPayloadLimits(t *testing.T) {
+	t.Parallel()
+	limitErrs := make(chan *PayloadLimitError, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			if len(s.Environ()) != 0 {
+				t.Fatalf("expected oversized env to be rejected, got %#v", s.Environ())
+			}
+		},
+		PayloadLimits: &PayloadLimits{MaxEnvValue: 4},
+		PayloadLimitCallback: func(ctx Context, err *PayloadLimitError) {
+			limitErrs <- err
+		},
+	}, nil)
+	defer cleanup()
+	if err := session.Setenv("FOO", "too long"); err == nil {
+		t.Fatal("expected env request to be rejected")
+	}
+	err := <-limitErrs
+	if err.Request != "env" || err.Field != "value" || err.Size != 8 || err.Limit != 4 {
+		t.Fatalf("unexpected limit error %#v", err)
+	}
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvLimits(t *testing.T) {
+	t.Parallel()
+	limitErrs := make(chan *PayloadLimitError, 2)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			fmt.Fprint(s, strings.Join(s.Environ(), " "))
+		},
+		PayloadLimits: &PayloadLimits{MaxEnvCount: 2, MaxEnvBytes: 8},
+		PayloadLimitCallback: func(ctx Context, err *PayloadLimitError) {
+			limitErrs <- err
+		},
+	}, nil)
+	defer cleanup()
+	for _, env := range []struct {
+		name, value string
+		ok          bool
+	}{
+		{"A", "1", true},
+		{"B", "longer", false}, // 9 bytes in total
+		{"C", "3", false},      // third request, rejected ones count too
+	} {
+		if err := session.Setenv(env.name, env.value); (err == nil) != env.ok {
+			t.Fatalf("Setenv(%q) = %v; want ok %v", env.name, err, env.ok)
+		}
+	}
+	if err := <-limitErrs; err.Field != "total" || err.Size != 9 {
+		t.Fatalf("limit error = %#v; want total of 9 bytes", err)
+	}
+	if err := <-limitErrs; err.Field != "count" || err.Size != 3 {
+		t.Fatalf("limit error = %#v; want count of 3", err)
+	}
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "A=1" {
+		t.Fatalf("Environ() = %#v; want %#v", string(out), "A=1")
+	}
+}
+
+func TestSessionHooks(t *testing.T) {
+	t.Parallel()
+	summaries := make(chan SessionSummary, 1)
+	srv := &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, "hello")
+			s.Exit(3)
+		},
+		SessionStartCallback: func(s Session) error {
+			if s.RawCommand() == "forbidden" {
+				return errors.New("not allowed")
+			}
+			return nil
+		},
+		SessionEndCallback: func(s Session, summary SessionSummary) {
+			summaries <- summary
+		},
+	}
+
+	session, _, cleanup := newTestSession(t, srv, nil)
+	defer cleanup()
+	err := session.Run("ok")
+	if e, ok := err.(*gossh.ExitError); !ok || e.ExitStatus() != 3 {
+		t.Fatalf("Run err = %v; want exit status 3", err)
+	}
+	summary := <-summaries
+	if summary.ExitStatus != 3 || summary.BytesWritten != 5 {
+		t.Fatalf("summary = %+v; want exit status 3 after 5 bytes", summary)
+	}
+
+	session, _, cleanup = newTestSession(t, srv, nil)
+	defer cleanup()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	err = session.Run("forbidden")
+	if e, ok := err.(*gossh.ExitError); !ok || e.ExitStatus() != 1 {
+		t.Fatalf("Run err = %v; want exit status 1", err)
+	}
+	if stderr.String() != "not allowed\n" {
+		t.Fatalf("stderr = %#v; want %#v", stderr.String(), "not allowed\n")
+	}
+	if summary := <-summaries; summary.ExitStatus != 1 {
+		t.Fatalf("ExitStatus = %d; want 1", summary.ExitStatus)
+	}
+}
+
+func TestRequestOrder(t *testing.T) {
+	t.Parallel()
+	var want []string
+	for i := 0; i < 50; i++ {
+		want = append(want, fmt.Sprintf("VAR%d=%d", i, i))
+	}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			if _, _, isPty := s.Pty(); !isPty {
+				t.Errorf("pty not set before exec")
+			}
+			fmt.Fprint(s, strings.Join(s.Environ(), "\n"))
+		},
+	}, nil)
+	defer cleanup()
+	for i := range want {
+		if err := session.Setenv(fmt.Sprintf("VAR%d", i), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.Replace(string(out), "\r\n", "\n", -1), "\n"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Environ() = %#v; want %#v", got, want)
+	}
+}
+
+func TestAcceptEnv(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			fmt.Fprint(s, strings.Join(s.Environ(), " "))
+		},
+		AcceptEnv: []string{"LANG", "LC_*"},
+		EnvRequestCallback: func(ctx Context, name, value string) bool {
+			return value != "evil"
+		},
+	}, nil)
+	defer cleanup()
+	for _, env := range []struct {
+		name, value string
+		ok          bool
+	}{
+		{"LANG", "C", true},
+		{"LC_ALL", "C", true},
+		{"LC_TIME", "evil", false},
+		{"LD_PRELOAD", "/tmp/x.so", false},
+	} {
+		if err := session.Setenv(env.name, env.value); (err == nil) != env.ok {
+			t.Fatalf("Setenv(%q, %q) = %v; want ok %v", env.name, env.value, err, env.ok)
+		}
+	}
+	out, err := session.Output("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "LANG=C LC_ALL=C"; string(out) != want {
+		t.Fatalf("Environ() = %#v; want %#v", string(out), want)
+	}
+}
+
+func TestSubsystemHandlers(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		Handler: func(s Session) {
+			t.Errorf("Handler called for subsystem %#v", s.Subsystem())
+		},
+		SubsystemHandlers: map[string]SubsystemHandler{
+			"echo": func(s Session) {
+				io.Copy(s, s)
+			},
+		},
+	}
+	session, client, cleanup := newTestSession(t, srv, nil)
+	defer cleanup()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestSubsystem("echo"); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdin, "hello")
+	stdin.Close()
+	out, err := ioutil.ReadAll(stdout)
+	if err != nil || string(out) != "hello" {
+		t.Fatalf("stdout = %#v, %#v; want %#v, nil", string(out), err, "hello")
+	}
+
+	unknown, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unknown.Close()
+	if err := unknown.RequestSubsystem("sftp"); err == nil {
+		t.Fatal("RequestSubsystem(\"sftp\") = nil; want error")
+	}
+}
+
+func TestSessionLinger(t *testing.T) {
+	t.Parallel()
+	session, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, "bye")
+		},
+		SessionLinger: 5 * time.Second,
+	}, nil)
+	defer cleanup()
+	session.Close()
+
+	ch, reqs, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := ch.SendRequest("exec", true, gossh.Marshal(struct{ Command string }{""})); !ok || err != nil {
+		t.Fatalf("exec = %#v, %#v; want true, nil", ok, err)
+	}
+	out, err := ioutil.ReadAll(ch)
+	if err != nil || string(out) != "bye" {
+		t.Fatalf("output = %#v, %#v; want %#v, nil", string(out), err, "bye")
+	}
+	req := <-reqs
+	if req == nil || req.Type != "exit-status" {
+		t.Fatalf("request = %#v; want exit-status", req)
+	}
+	select {
+	case req, ok := <-reqs:
+		t.Fatalf("request = %#v, %#v before the client closed", req, ok)
+	case <-time.After(100 * time.Millisecond):
+	}
+	start := time.Now()
+	ch.Close()
+	for range reqs {
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("close took %v; want the session to end with the client closing", d)
+	}
+}
+
+func TestSessionLingerElapses(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, "bye")
+			s.Exit(3)
+		},
+		SessionLinger: 100 * time.Millisecond,
+	}, nil)
+	defer cleanup()
+	start := time.Now()
+	out, err := session.Output("")
+	if e, ok := err.(*gossh.ExitError); !ok || e.ExitStatus() != 3 || string(out) != "bye" {
+		t.Fatalf("Output() = %#v, %#v; want %#v, exit status 3", string(out), err, "bye")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("session ended after %v; want at least the linger period", d)
+	}
+}
+
+// exitChannel is a blockingChannel also recording the requests it sends and
+// when it is closed.
+type exitChannel struct {
+	*blockingChannel
+}
+
+func (c exitChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	c.record("req:", []byte(name))
+	return true, nil
+}
+
+func (c exitChannel) CloseWrite() error {
+	c.record("eof", nil)
+	return nil
+}
+
+func (c exitChannel) Close() error {
+	c.record("close", nil)
+	return nil
+}
+
+func TestExitOrdering(t *testing.T) {
+	t.Parallel()
+	ch := exitChannel{&blockingChannel{release: make(chan struct{})}}
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+	sess := &session{Channel: ch, ctx: ctx, closed: make(chan struct{})}
+
+	// a write of another goroutine is in progress when the handler exits
+	go sess.Write([]byte("output"))
+	for {
+		sess.Lock()
+		writers := sess.writers
+		sess.Unlock()
+		if writers == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- sess.Exit(3) }()
+	for {
+		sess.Lock()
+		done := sess.exited
+		sess.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := sess.Stderr().Write([]byte("late")); err != io.ErrClosedPipe {
+		t.Fatalf("write after Exit err = %#v; want %#v", err, io.ErrClosedPipe)
+	}
+	close(ch.release)
+	if err := <-exited; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"out:output", "req:exit-status", "close"}
+	if !reflect.DeepEqual(ch.writes, want) {
+		t.Fatalf("channel events = %#v; want %#v", ch.writes, want)
+	}
+}
+
+func TestExitOrderingOpenSSH(t *testing.T) {
+	t.Parallel()
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		t.Skip("no OpenSSH client")
+	}
+	stdout := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	stderr := bytes.Repeat([]byte("e"), 100*1024)
+	l := newLocalListener()
+	srv := &Server{
+		Handler: func(s Session) {
+			// output still buffered when the handler exits
+			s.Write(stdout)
+			s.Stderr().Write(stderr)
+			s.Exit(7)
+		},
+		OutputBuffer: 256 * 1024,
+	}
+	go srv.serveOnce(l)
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	cmd := exec.Command(sshPath, "-F", "/dev/null", "-p", port,
+		"-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null",
+		"-o", "LogLevel=ERROR", "testuser@"+host, "run")
+	var gotStdout, gotStderr bytes.Buffer
+	cmd.Stdout = &gotStdout
+	cmd.Stderr = &gotStderr
+	err = cmd.Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 7 {
+		t.Fatalf("ssh = %v, stderr %q; want exit status 7", err, gotStderr.Bytes())
+	}
+	if !bytes.Equal(gotStdout.Bytes(), stdout) {
+		t.Fatalf("stdout has %d bytes; want %d", gotStdout.Len(), len(stdout))
+	}
+	if !bytes.Equal(gotStderr.Bytes(), stderr) {
+		t.Fatalf("stderr has %d bytes; want %d", gotStderr.Len(), len(stderr))
+	}
+}
+
+// libsshExec runs a command with the Python bindings of libssh
+// (ansible-pylibssh), copying its output and exit status.
+const libsshExec = `
+import sys
+from pylibsshext.session import Session
+
+host, port, command = sys.argv[1], int(sys.argv[2]), sys.argv[3]
+session = Session()
+session.connect(host=host, port=port, user="testuser", password="secret",
+                host_key_checking=False, look_for_keys=False, timeout=10)
+channel = session.new_channel()
+result = channel.exec_command(command)
+sys.stdout.buffer.write(result.stdout)
+sys.stderr.buffer.write(result.stderr)
+channel.close()
+session.close()
+sys.exit(result.returncode)
+`
+
+func TestExitOrderingLibssh(t *testing.T) {
+	t.Parallel()
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("no python3")
+	}
+	if err := exec.Command(python, "-c", "import pylibsshext.session").Run(); err != nil {
+		t.Skip("no libssh client (ansible-pylibssh)")
+	}
+	stdout := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	stderr := bytes.Repeat([]byte("e"), 100*1024)
+	l := newLocalListener()
+	srv := &Server{
+		Handler: func(s Session) {
+			// output still buffered when the handler exits
+			s.Write(stdout)
+			s.Stderr().Write(stderr)
+			s.Exit(7)
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			return password == "secret"
+		},
+		OutputBuffer: 256 * 1024,
+	}
+	go srv.serveOnce(l)
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	cmd := exec.Command(python, "-c", libsshExec, host, port, "run")
+	var gotStdout, gotStderr bytes.Buffer
+	cmd.Stdout = &gotStdout
+	cmd.Stderr = &gotStderr
+	err = cmd.Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 7 {
+		t.Fatalf("libssh = %v, stderr %q; want exit status 7", err, gotStderr.Bytes())
+	}
+	if !bytes.Equal(gotStdout.Bytes(), stdout) {
+		t.Fatalf("stdout has %d bytes; want %d", gotStdout.Len(), len(stdout))
+	}
+	if !bytes.Equal(gotStderr.Bytes(), stderr) {
+		t.Fatalf("stderr has %d bytes; want %d", gotStderr.Len(), len(stderr))
+	}
+}
+
+func TestPtyRepeat(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		policy PtyRepeatPolicy
+		ok     bool
+		want   string
+	}{
+		{PtyRepeatReject, false, "xterm 80x24"},
+		{PtyRepeatReplace, true, "vt100 40x20"},
+		{PtyRepeatAllow, true, "xterm 80x24"},
+	} {
+		session, _, cleanup := newTestSession(t, &Server{
+			Handler: func(s Session) {
+				pty, winch, _ := s.Pty()
+				win := <-winch
+				fmt.Fprintf(s, "%s %dx%d", pty.Term, win.Width, win.Height)
+			},
+			PtyRepeat: tt.policy,
+		}, nil)
+		if err := session.RequestPty("xterm", 24, 80, nil); err != nil {
+			t.Fatal(err)
+		}
+		err := session.RequestPty("vt100", 20, 40, nil)
+		if (err == nil) != tt.ok {
+			t.Fatalf("policy %d: repeated pty-req err = %v; want ok %v", tt.policy, err, tt.ok)
+		}
+		out, err := session.Output("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.want {
+			t.Fatalf("policy %d: pty = %#v; want %#v", tt.policy, string(out), tt.want)
+		}
+		cleanup()
+	}
+}
+
+func TestPtyCallback(t *testing.T) {
+	t.Parallel()
+	srv := &Server{
+		Handler: func(s Session) {
+			_, _, isPty := s.Pty()
+			fmt.Fprintf(s, "%s pty=%v", s.RawCommand(), isPty)
+		},
+		PtyCallback: func(ctx Context, pty Pty) bool {
+			return ctx.User() != "sftponly"
+		},
+	}
+	l := newLocalListener()
+	go srv.Serve(l)
+	defer srv.Close()
+	for _, tt := range []struct {
+		user string
+		pty  bool
+	}{
+		{"testuser", true},
+		{"sftponly", false},
+	} {
+		session, _, cleanup := newClientSession(t, l.Addr().String(), &gossh.ClientConfig{User: tt.user})
+		err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{})
+		if (err == nil) != tt.pty {
+			t.Fatalf("%s: RequestPty err = %v; want allowed %v", tt.user, err, tt.pty)
+		}
+		out, err := session.Output("ls")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("ls pty=%v", tt.pty); string(out) != want {
+			t.Fatalf("%s: output = %#v; want %#v", tt.user, string(out), want)
+		}
+		cleanup()
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	t.Parallel()
+	session, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			for i := 0; i < 4; i++ {
+				time.Sleep(50 * time.Millisecond)
+				io.WriteString(s, "tick\n")
+			}
+			io.Copy(ioutil.Discard, s)
+		},
+		SessionIdleTimeout: 150 * time.Millisecond,
+	}, nil)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	stdin, _ := io.Pipe() // open but silent
+	session.Stdin = stdin
+	if err := session.Run(""); err == nil {
+		t.Fatal("Run succeeded; want a session closed without exit status")
+	}
+	if got := strings.Count(stdout.String(), "tick"); got != 4 {
+		t.Fatalf("ticks = %d; want 4 before the session went idle", got)
+	}
+	if want := "session idle for 150ms, closing\n"; stderr.String() != want {
+		t.Fatalf("stderr = %#v; want %#v", stderr.String(), want)
+	}
+	if _, err := client.NewSession(); err != nil {
+		t.Fatalf("NewSession after idle session = %v; want the connection open", err)
+	}
+}
+
+func TestSessionIdleTimeoutStalledClient(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			defer close(done)
+			// fill the window of the channel
+			buf := make([]byte, 32<<10)
+			for {
+				if _, err := s.Write(buf); err != nil {
+					return
+				}
+			}
+		},
+		SessionIdleTimeout: 150 * time.Millisecond,
+	}, nil)
+	defer cleanup()
+	if _, err := session.StdoutPipe(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session of a client not reading outlived the idle timeout")
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	t.Parallel()
+	session, client, cleanup := newTestSession(t, &Server{
+		Handler:     func(s Session) {},
+		MaxSessions: 1,
+	}, nil)
+	defer cleanup()
+	_, err := client.NewSession()
+	var openErr *gossh.OpenChannelError
+	if !errors.As(err, &openErr) || openErr.Reason != gossh.ResourceShortage {
+		t.Fatalf("NewSession() err = %#v; want RESOURCE_SHORTAGE", err)
+	}
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	// the slot is freed once the server is done with the first session
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		second, err := client.NewSession()
+		if err == nil {
+			second.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("NewSession() after the first session ended: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForceCommand(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, s.RawCommand()+" "+strings.Join(s.Environ(), " "))
+		},
+		SubsystemHandlers: map[string]SubsystemHandler{
+			"sftp": func(s Session) {
+				io.WriteString(s, "subsystem")
+			},
+		},
+		PasswordHandler: func(ctx Context, password string) bool {
+			ctx.Permissions().SetForceCommand("uptime")
+			return true
+		},
+	}, nil)
+	defer cleanup()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(stdout)
+	if want := "uptime SSH_ORIGINAL_COMMAND=sftp"; err != nil || string(out) != want {
+		t.Fatalf("stdout = %#v, %#v; want %#v, nil", string(out), err, want)
+	}
+}
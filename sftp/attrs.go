@@ -0,0 +1,154 @@
+package sftp
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// Flags of the ATTRS structure.
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+// File type bits of the permissions attribute, as in stat(2).
+const (
+	modeFIFO    = 0010000
+	modeCharDev = 0020000
+	modeDir     = 0040000
+	modeDevice  = 0060000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+	modeSocket  = 0140000
+	modeSetuid  = 0004000
+	modeSetgid  = 0002000
+	modeSticky  = 0001000
+)
+
+// attrs are the file attributes sent and received by the protocol.
+type attrs struct {
+	flags       uint32
+	size        uint64
+	uid, gid    uint32
+	permissions uint32
+	atime       uint32
+	mtime       uint32
+}
+
+// fileAttrs returns the attributes of fi.
+func fileAttrs(fi fs.FileInfo) attrs {
+	mtime := uint32(fi.ModTime().Unix())
+	return attrs{
+		flags:       attrSize | attrPermissions | attrACModTime,
+		size:        uint64(fi.Size()),
+		permissions: fromFileMode(fi.Mode()),
+		atime:       mtime,
+		mtime:       mtime,
+	}
+}
+
+func (a attrs) encode(e *encoder) {
+	e.uint32(a.flags)
+	if a.flags&attrSize != 0 {
+		e.uint64(a.size)
+	}
+	if a.flags&attrUIDGID != 0 {
+		e.uint32(a.uid)
+		e.uint32(a.gid)
+	}
+	if a.flags&attrPermissions != 0 {
+		e.uint32(a.permissions)
+	}
+	if a.flags&attrACModTime != 0 {
+		e.uint32(a.atime)
+		e.uint32(a.mtime)
+	}
+}
+
+func decodeAttrs(d *decoder) attrs {
+	var a attrs
+	a.flags = d.uint32()
+	if a.flags&attrSize != 0 {
+		a.size = d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		a.uid = d.uint32()
+		a.gid = d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.permissions = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		a.atime = d.uint32()
+		a.mtime = d.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
+
+// fromFileMode converts mode to the permissions attribute.
+func fromFileMode(mode fs.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= modeDir
+	case mode&fs.ModeSymlink != 0:
+		m |= modeSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		m |= modeFIFO
+	case mode&fs.ModeSocket != 0:
+		m |= modeSocket
+	case mode&fs.ModeCharDevice != 0:
+		m |= modeCharDev
+	case mode&fs.ModeDevice != 0:
+		m |= modeDevice
+	default:
+		m |= modeRegular
+	}
+	if mode&fs.ModeSetuid != 0 {
+		m |= modeSetuid
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= modeSetgid
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= modeSticky
+	}
+	return m
+}
+
+// toFileMode converts the permissions attribute to the permission bits of a
+// fs.FileMode.
+func toFileMode(permissions uint32) fs.FileMode {
+	mode := fs.FileMode(permissions & 0777)
+	if permissions&modeSetuid != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if permissions&modeSetgid != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if permissions&modeSticky != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// longName formats fi like ls -l, as clients show it for directory
+// listings.
+func longName(fi fs.FileInfo, now time.Time) string {
+	mtime := fi.ModTime()
+	date := mtime.Format("Jan _2 15:04")
+	if mtime.Before(now.AddDate(0, -6, 0)) || mtime.After(now.Add(time.Hour)) {
+		date = mtime.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s    1 0        0        %8d %s %s", fi.Mode(), fi.Size(), date, fi.Name())
+}
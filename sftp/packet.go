@@ -0,0 +1,174 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types of SFTP version 3, draft-ietf-secsh-filexfer-02.
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Status codes of SSH_FXP_STATUS.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Flags of SSH_FXP_OPEN.
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// errShortPacket is returned when decoding a packet missing fields.
+var errShortPacket = errors.New("sftp: short packet")
+
+// readPacket reads the next packet from r, which must not be longer than
+// max bytes.
+func readPacket(r io.Reader, max int) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 || n > uint32(max) {
+		return nil, fmt.Errorf("sftp: packet of %d bytes exceeds limit of %d bytes", n, max)
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// decoder reads the fields of a packet, remembering the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *decoder) byte() byte {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if p := d.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if p := d.next(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil || uint32(len(d.b)) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// encoder builds a packet, leaving room for its length.
+type encoder struct {
+	b []byte
+}
+
+func newPacket(typ byte, id uint32) *encoder {
+	e := &encoder{b: make([]byte, 4, 64)}
+	e.byte(typ)
+	e.uint32(id)
+	return e
+}
+
+func (e *encoder) byte(v byte) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.b = binary.BigEndian.AppendUint32(e.b, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.b = binary.BigEndian.AppendUint64(e.b, v)
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint32(uint32(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.uint32(uint32(len(v)))
+	e.b = append(e.b, v...)
+}
+
+// packet returns the encoded packet, prefixed with its length.
+func (e *encoder) packet() []byte {
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+	return e.b
+}
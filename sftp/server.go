@@ -0,0 +1,595 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh/vfs"
+)
+
+// Limits of the server, as reported by the limits@openssh.com extension.
+const (
+	maxPacket = 256 * 1024
+	maxData   = maxPacket - 1024 // largest read or write
+	maxDirRun = 128              // directory entries per SSH_FXP_NAME
+)
+
+// extensions are the protocol extensions announced by the server.
+var extensions = [][2]string{
+	{"posix-rename@openssh.com", "1"},
+	{"statvfs@openssh.com", "2"},
+	{"fstatvfs@openssh.com", "2"},
+	{"fsync@openssh.com", "1"},
+	{"limits@openssh.com", "1"},
+	{"check-file-name", "1"},
+	{"check-file-handle", "1"},
+}
+
+// errBadHandle is returned for requests on handles that aren't open.
+var errBadHandle = errors.New("sftp: invalid handle")
+
+// handle is a file or directory opened by the client.
+type handle struct {
+	name    string
+	file    vfs.File      // nil for directories
+	append  bool          // writes ignore their offset
+	entries []fs.DirEntry // directory entries not read yet
+	write   bool          // opened for writing
+}
+
+// server serves the requests of one client.
+type server struct {
+	rw      io.ReadWriter
+	fsys    vfs.FS
+	handles map[string]*handle
+	next    uint64
+}
+
+// Serve runs the SFTP protocol, version 3, over rw with the files of fsys
+// until rw returns io.EOF, which it reports as a nil error. Paths of the
+// client are resolved inside fsys, with "/" as its root and the working
+// directory. Files still open when the client goes away are aborted if they
+// implement vfs.Aborter, so vfs.Atomic discards interrupted uploads.
+func Serve(rw io.ReadWriter, fsys vfs.FS) error {
+	s := &server{rw: rw, fsys: fsys, handles: make(map[string]*handle)}
+	defer s.closeAll()
+	for {
+		p, err := readPacket(rw, maxPacket)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(p); err != nil {
+			return err
+		}
+	}
+}
+
+// closeAll closes the handles left open by the client.
+func (s *server) closeAll() {
+	for id, h := range s.handles {
+		if a, ok := h.file.(vfs.Aborter); ok && h.write {
+			a.Abort()
+		} else if h.file != nil {
+			h.file.Close()
+		}
+		delete(s.handles, id)
+	}
+}
+
+func (s *server) send(e *encoder) error {
+	_, err := s.rw.Write(e.packet())
+	return err
+}
+
+func (s *server) sendStatus(id uint32, err error) error {
+	code, msg := status(err)
+	e := newPacket(fxpStatus, id)
+	e.uint32(code)
+	e.string(msg)
+	e.string("en")
+	return s.send(e)
+}
+
+// status returns the status code and message reporting err.
+func status(err error) (uint32, string) {
+	switch {
+	case err == nil:
+		return fxOK, "OK"
+	case err == io.EOF:
+		return fxEOF, "EOF"
+	case err == errShortPacket:
+		return fxBadMessage, err.Error()
+	case errors.Is(err, fs.ErrNotExist):
+		return fxNoSuchFile, err.Error()
+	case errors.Is(err, fs.ErrPermission):
+		return fxPermissionDenied, err.Error()
+	case errors.Is(err, vfs.ErrUnsupported), errors.Is(err, vfs.ErrUnknownAlgorithm):
+		return fxOpUnsupported, err.Error()
+	}
+	return fxFailure, err.Error()
+}
+
+// resolve returns the name in the filesystem of the client path p.
+func resolve(p string) string {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (s *server) handle(p []byte) error {
+	d := &decoder{b: p}
+	typ := d.byte()
+	if typ == fxpInit {
+		e := &encoder{b: make([]byte, 4, 256)}
+		e.byte(fxpVersion)
+		e.uint32(3)
+		for _, ext := range extensions {
+			e.string(ext[0])
+			e.string(ext[1])
+		}
+		return s.send(e)
+	}
+	id := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	var err error
+	switch typ {
+	case fxpOpen:
+		err = s.open(id, d)
+	case fxpClose:
+		err = s.close(id, d)
+	case fxpRead:
+		err = s.read(id, d)
+	case fxpWrite:
+		err = s.write(id, d)
+	case fxpLstat, fxpStat:
+		name := resolve(d.string())
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		fi, err := s.fsys.Stat(name)
+		return s.sendAttrs(id, fi, err)
+	case fxpFstat:
+		h, err := s.lookup(d.string(), d)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		if h.file == nil {
+			fi, err := s.fsys.Stat(h.name)
+			return s.sendAttrs(id, fi, err)
+		}
+		fi, err := h.file.Stat()
+		return s.sendAttrs(id, fi, err)
+	case fxpSetstat:
+		name := resolve(d.string())
+		a := decodeAttrs(d)
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		return s.sendStatus(id, s.setstat(name, nil, a))
+	case fxpFsetstat:
+		h, err := s.lookup(d.string(), d)
+		a := decodeAttrs(d)
+		if err == nil {
+			err = d.err
+		}
+		if err == nil {
+			err = s.setstat(h.name, h.file, a)
+		}
+		return s.sendStatus(id, err)
+	case fxpOpendir:
+		err = s.opendir(id, d)
+	case fxpReaddir:
+		err = s.readdir(id, d)
+	case fxpRemove:
+		name := resolve(d.string())
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		fi, err := s.fsys.Stat(name)
+		if err == nil && fi.IsDir() {
+			err = &fs.PathError{Op: "remove", Path: name, Err: errors.New("is a directory")}
+		}
+		if err == nil {
+			err = s.fsys.Remove(name)
+		}
+		return s.sendStatus(id, err)
+	case fxpMkdir:
+		name := resolve(d.string())
+		a := decodeAttrs(d)
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		perm := fs.FileMode(0755)
+		if a.flags&attrPermissions != 0 {
+			perm = toFileMode(a.permissions)
+		}
+		return s.sendStatus(id, s.fsys.Mkdir(name, perm))
+	case fxpRmdir:
+		name := resolve(d.string())
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		fi, err := s.fsys.Stat(name)
+		if err == nil && !fi.IsDir() {
+			err = &fs.PathError{Op: "rmdir", Path: name, Err: errors.New("not a directory")}
+		}
+		if err == nil {
+			err = s.fsys.Remove(name)
+		}
+		return s.sendStatus(id, err)
+	case fxpRealpath:
+		name := resolve(d.string())
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		e := newPacket(fxpName, id)
+		e.uint32(1)
+		e.string(path.Join("/", name))
+		e.string(path.Join("/", name))
+		attrs{}.encode(e)
+		return s.send(e)
+	case fxpRename:
+		oldname, newname := resolve(d.string()), resolve(d.string())
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		// version 3 renames don't replace existing files
+		if _, err := s.fsys.Stat(newname); err == nil {
+			return s.sendStatus(id, &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist})
+		}
+		return s.sendStatus(id, s.fsys.Rename(oldname, newname))
+	case fxpExtended:
+		err = s.extended(id, d)
+	default:
+		// readlink, symlink and unknown requests
+		return s.sendStatus(id, vfs.ErrUnsupported)
+	}
+	return err
+}
+
+// lookup returns the open handle named by h.
+func (s *server) lookup(h string, d *decoder) (*handle, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if hd, ok := s.handles[h]; ok {
+		return hd, nil
+	}
+	return nil, errBadHandle
+}
+
+func (s *server) newHandle(id uint32, h *handle) error {
+	s.next++
+	key := strconv.FormatUint(s.next, 10)
+	s.handles[key] = h
+	e := newPacket(fxpHandle, id)
+	e.string(key)
+	return s.send(e)
+}
+
+func (s *server) sendAttrs(id uint32, fi fs.FileInfo, err error) error {
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	e := newPacket(fxpAttrs, id)
+	fileAttrs(fi).encode(e)
+	return s.send(e)
+}
+
+func (s *server) open(id uint32, d *decoder) error {
+	name := resolve(d.string())
+	pflags := d.uint32()
+	a := decodeAttrs(d)
+	if d.err != nil {
+		return s.sendStatus(id, d.err)
+	}
+	var flag int
+	switch {
+	case pflags&fxfRead != 0 && pflags&fxfWrite != 0:
+		flag = os.O_RDWR
+	case pflags&fxfWrite != 0:
+		flag = os.O_WRONLY
+	default:
+		flag = os.O_RDONLY
+	}
+	if pflags&fxfAppend != 0 {
+		flag |= os.O_APPEND
+	}
+	if pflags&fxfCreat != 0 {
+		flag |= os.O_CREATE
+	}
+	if pflags&fxfTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	if pflags&fxfExcl != 0 {
+		flag |= os.O_EXCL
+	}
+	perm := fs.FileMode(0644)
+	if a.flags&attrPermissions != 0 {
+		perm = toFileMode(a.permissions)
+	}
+	f, err := s.fsys.OpenFile(name, flag, perm)
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	return s.newHandle(id, &handle{
+		name:   name,
+		file:   f,
+		append: pflags&fxfAppend != 0,
+		write:  pflags&fxfWrite != 0,
+	})
+}
+
+func (s *server) close(id uint32, d *decoder) error {
+	key := d.string()
+	h, err := s.lookup(key, d)
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	delete(s.handles, key)
+	if h.file != nil {
+		err = h.file.Close()
+	}
+	return s.sendStatus(id, err)
+}
+
+func (s *server) read(id uint32, d *decoder) error {
+	h, err := s.lookup(d.string(), d)
+	off := int64(d.uint64())
+	length := d.uint32()
+	if err == nil {
+		err = d.err
+	}
+	if err == nil && h.file == nil {
+		err = errBadHandle
+	}
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	if length > maxData {
+		length = maxData
+	}
+	buf := make([]byte, length)
+	n, err := h.file.ReadAt(buf, off)
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return s.sendStatus(id, err)
+	}
+	e := newPacket(fxpData, id)
+	e.bytes(buf[:n])
+	return s.send(e)
+}
+
+func (s *server) write(id uint32, d *decoder) error {
+	h, err := s.lookup(d.string(), d)
+	off := int64(d.uint64())
+	data := d.bytes()
+	if err == nil {
+		err = d.err
+	}
+	if err == nil && h.file == nil {
+		err = errBadHandle
+	}
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	if h.append {
+		_, err = h.file.Write(data)
+	} else {
+		_, err = h.file.WriteAt(data, off)
+	}
+	return s.sendStatus(id, err)
+}
+
+// setstat applies a to the named file, or to f if it is open. Only the size
+// can be changed, for files implementing Truncate like *os.File.
+func (s *server) setstat(name string, f vfs.File, a attrs) error {
+	if a.flags&(attrUIDGID|attrPermissions|attrACModTime) != 0 {
+		return &fs.PathError{Op: "setstat", Path: name, Err: vfs.ErrUnsupported}
+	}
+	if a.flags&attrSize == 0 {
+		return nil
+	}
+	if f == nil {
+		var err error
+		if f, err = s.fsys.OpenFile(name, os.O_WRONLY, 0); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	t, ok := f.(interface{ Truncate(size int64) error })
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: vfs.ErrUnsupported}
+	}
+	if err := t.Truncate(int64(a.size)); err != nil {
+		return &fs.PathError{Op: "truncate", Path: name, Err: errors.Unwrap(err)}
+	}
+	return nil
+}
+
+func (s *server) opendir(id uint32, d *decoder) error {
+	name := resolve(d.string())
+	if d.err != nil {
+		return s.sendStatus(id, d.err)
+	}
+	entries, err := s.fsys.ReadDir(name)
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	return s.newHandle(id, &handle{name: name, entries: entries})
+}
+
+func (s *server) readdir(id uint32, d *decoder) error {
+	h, err := s.lookup(d.string(), d)
+	if err == nil && h.file != nil {
+		err = errBadHandle
+	}
+	if err != nil {
+		return s.sendStatus(id, err)
+	}
+	if len(h.entries) == 0 {
+		return s.sendStatus(id, io.EOF)
+	}
+	n := len(h.entries)
+	if n > maxDirRun {
+		n = maxDirRun
+	}
+	now := time.Now()
+	var infos []fs.FileInfo
+	for _, entry := range h.entries[:n] {
+		// entries removed since the directory was opened are skipped
+		if fi, err := entry.Info(); err == nil {
+			infos = append(infos, fi)
+		}
+	}
+	h.entries = h.entries[n:]
+	e := newPacket(fxpName, id)
+	e.uint32(uint32(len(infos)))
+	for _, fi := range infos {
+		e.string(fi.Name())
+		e.string(longName(fi, now))
+		fileAttrs(fi).encode(e)
+	}
+	return s.send(e)
+}
+
+func (s *server) extended(id uint32, d *decoder) error {
+	ext := d.string()
+	if d.err != nil {
+		return s.sendStatus(id, d.err)
+	}
+	switch ext {
+	case "posix-rename@openssh.com":
+		oldname, newname := resolve(d.string()), resolve(d.string())
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		return s.sendStatus(id, s.fsys.Rename(oldname, newname))
+	case "statvfs@openssh.com", "fstatvfs@openssh.com":
+		var name string
+		if ext == "statvfs@openssh.com" {
+			name = resolve(d.string())
+		} else {
+			h, err := s.lookup(d.string(), d)
+			if err != nil {
+				return s.sendStatus(id, err)
+			}
+			name = h.name
+		}
+		if d.err != nil {
+			return s.sendStatus(id, d.err)
+		}
+		st, err := vfs.StatFS(s.fsys, name)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		e := newPacket(fxpExtendedReply, id)
+		for _, v := range []uint64{st.BlockSize, st.FragmentSize, st.Blocks, st.BlocksFree, st.BlocksAvail,
+			st.Files, st.FilesFree, st.FilesAvail, st.FSID, st.Flags, st.MaxNameLen} {
+			e.uint64(v)
+		}
+		return s.send(e)
+	case "fsync@openssh.com":
+		h, err := s.lookup(d.string(), d)
+		if err == nil && h.file == nil {
+			err = errBadHandle
+		}
+		if err == nil {
+			err = vfs.Sync(h.file)
+		}
+		return s.sendStatus(id, err)
+	case "limits@openssh.com":
+		e := newPacket(fxpExtendedReply, id)
+		e.uint64(maxPacket)
+		e.uint64(maxData)
+		e.uint64(maxData)
+		e.uint64(0) // no limit on open handles
+		return s.send(e)
+	case "check-file-name", "check-file-handle":
+		return s.checkFile(id, ext, d)
+	}
+	return s.sendStatus(id, vfs.ErrUnsupported)
+}
+
+// checkFile replies to the check-file extension with the checksums of the
+// blocks of a file, computed by vfs.Hash.
+func (s *server) checkFile(id uint32, ext string, d *decoder) error {
+	var name string
+	if ext == "check-file-name" {
+		name = resolve(d.string())
+	} else {
+		h, err := s.lookup(d.string(), d)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		name = h.name
+	}
+	algorithms := strings.Split(d.string(), ",")
+	off := int64(d.uint64())
+	length := int64(d.uint64())
+	blockSize := int64(d.uint32())
+	if d.err != nil {
+		return s.sendStatus(id, d.err)
+	}
+	algorithm := ""
+	for _, a := range algorithms {
+		for _, supported := range vfs.HashAlgorithms() {
+			if a == supported && algorithm == "" {
+				algorithm = a
+			}
+		}
+	}
+	if algorithm == "" {
+		return s.sendStatus(id, &fs.PathError{Op: "hash", Path: name, Err: vfs.ErrUnknownAlgorithm})
+	}
+	if length == 0 {
+		fi, err := s.fsys.Stat(name)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		length = fi.Size() - off
+	}
+	if length < 0 {
+		return s.sendStatus(id, &fs.PathError{Op: "hash", Path: name, Err: fs.ErrInvalid})
+	}
+	if blockSize == 0 || blockSize > length {
+		blockSize = length
+	}
+	if blockSize > 0 && length/blockSize > maxData/64 {
+		return s.sendStatus(id, fmt.Errorf("sftp: block size %d too small", blockSize))
+	}
+	e := newPacket(fxpExtendedReply, id)
+	e.string("check-file")
+	e.string(algorithm)
+	for pos := off; pos < off+length || length == 0; pos += blockSize {
+		n := blockSize
+		if pos+n > off+length {
+			n = off + length - pos
+		}
+		sum, err := vfs.Hash(s.fsys, name, algorithm, pos, n)
+		if err != nil {
+			return s.sendStatus(id, err)
+		}
+		e.b = append(e.b, sum...)
+		if length == 0 {
+			break
+		}
+	}
+	return s.send(e)
+}
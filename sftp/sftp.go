@@ -0,0 +1,40 @@
+// Package sftp implements the SFTP subsystem, version 3 as implemented by
+// OpenSSH, serving the files of a vfs.FS, so servers can offer file transfer
+// without depending on an SFTP library. Register it as a subsystem handler:
+//
+//	srv.SubsystemHandlers = map[string]ssh.SubsystemHandler{
+//		"sftp": sftp.Handler(func(s ssh.Session) (vfs.FS, error) {
+//			return vfs.DirFS(filepath.Join("/srv/sftp", s.User())), nil
+//		}),
+//	}
+//
+// Read-only trees such as an embed.FS are served with vfs.FromFS. Requests
+// are processed in order. Symbolic links, ownership, permission and time
+// changes are not supported; SETSTAT can only truncate files whose backend
+// provides Truncate, like vfs.DirFS.
+package sftp
+
+import (
+	"fmt"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/gliderlabs/ssh/vfs"
+)
+
+// Handler returns a SubsystemHandler serving each session the filesystem
+// returned by fsys. If fsys returns an error, it is written to the stderr of
+// the session, which exits with status 1.
+func Handler(fsys func(s ssh.Session) (vfs.FS, error)) ssh.SubsystemHandler {
+	return func(s ssh.Session) {
+		root, err := fsys(s)
+		if err == nil {
+			err = Serve(s, root)
+		}
+		if err != nil {
+			fmt.Fprintln(s.Stderr(), err)
+			s.Exit(1)
+			return
+		}
+		s.Exit(0)
+	}
+}
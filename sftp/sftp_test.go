@@ -0,0 +1,312 @@
+package sftp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/gliderlabs/ssh/vfs"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testClient speaks the protocol to a server, one request at a time.
+type testClient struct {
+	t  *testing.T
+	rw io.ReadWriter
+	id uint32
+}
+
+func newTestClient(t *testing.T, fsys vfs.FS) *testClient {
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- Serve(server, fsys) }()
+	t.Cleanup(func() {
+		client.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve = %v; want nil", err)
+		}
+	})
+	c := &testClient{t: t, rw: client}
+	e := &encoder{b: make([]byte, 4)}
+	e.byte(fxpInit)
+	e.uint32(3)
+	if typ, d := c.roundTrip(e); typ != fxpVersion || d.uint32() != 3 {
+		t.Fatalf("init reply = %d; want version 3", typ)
+	}
+	return c
+}
+
+// request builds a request with the next id.
+func (c *testClient) request(typ byte) *encoder {
+	c.id++
+	return newPacket(typ, c.id)
+}
+
+func (c *testClient) roundTrip(e *encoder) (byte, *decoder) {
+	c.t.Helper()
+	if _, err := c.rw.Write(e.packet()); err != nil {
+		c.t.Fatal(err)
+	}
+	p, err := readPacket(c.rw, maxPacket)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	d := &decoder{b: p}
+	typ := d.byte()
+	if typ != fxpVersion {
+		if id := d.uint32(); id != c.id {
+			c.t.Fatalf("reply id = %d; want %d", id, c.id)
+		}
+	}
+	return typ, d
+}
+
+// status sends e and returns the status code of the reply.
+func (c *testClient) status(e *encoder) uint32 {
+	c.t.Helper()
+	typ, d := c.roundTrip(e)
+	if typ != fxpStatus {
+		c.t.Fatalf("reply type = %d; want status", typ)
+	}
+	return d.uint32()
+}
+
+func (c *testClient) open(name string, pflags uint32) string {
+	c.t.Helper()
+	e := c.request(fxpOpen)
+	e.string(name)
+	e.uint32(pflags)
+	attrs{}.encode(e)
+	typ, d := c.roundTrip(e)
+	if typ != fxpHandle {
+		c.t.Fatalf("open %s: reply type = %d, status %d; want handle", name, typ, d.uint32())
+	}
+	return d.string()
+}
+
+func (c *testClient) read(handle string, off uint64) (string, uint32) {
+	c.t.Helper()
+	e := c.request(fxpRead)
+	e.string(handle)
+	e.uint64(off)
+	e.uint32(1024)
+	typ, d := c.roundTrip(e)
+	if typ == fxpStatus {
+		return "", d.uint32()
+	}
+	return d.string(), fxOK
+}
+
+func (c *testClient) close(handle string) {
+	c.t.Helper()
+	e := c.request(fxpClose)
+	e.string(handle)
+	if code := c.status(e); code != fxOK {
+		c.t.Fatalf("close status = %d; want %d", code, fxOK)
+	}
+}
+
+func (c *testClient) names(typ byte, name string) []string {
+	c.t.Helper()
+	e := c.request(typ)
+	e.string(name)
+	rtyp, d := c.roundTrip(e)
+	if rtyp != fxpName {
+		c.t.Fatalf("reply type = %d; want name", rtyp)
+	}
+	var names []string
+	for n := d.uint32(); n > 0; n-- {
+		names = append(names, d.string())
+		d.string()
+		decodeAttrs(d)
+	}
+	return names
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	c := newTestClient(t, vfs.DirFS(dir))
+
+	h := c.open("/../up.txt", fxfWrite|fxfCreat|fxfTrunc)
+	e := c.request(fxpWrite)
+	e.string(h)
+	e.uint64(0)
+	e.string("hello world")
+	if code := c.status(e); code != fxOK {
+		t.Fatalf("write status = %d; want %d", code, fxOK)
+	}
+	c.close(h)
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "up.txt")); string(data) != "hello world" {
+		t.Fatalf("uploaded = %#v; want %#v", string(data), "hello world")
+	}
+
+	h = c.open("up.txt", fxfRead)
+	if data, code := c.read(h, 6); data != "world" {
+		t.Fatalf("read = %#v, status %d; want %#v", data, code, "world")
+	}
+	if _, code := c.read(h, 11); code != fxEOF {
+		t.Fatalf("read status = %d at end; want %d", code, fxEOF)
+	}
+	c.close(h)
+
+	e = c.request(fxpMkdir)
+	e.string("sub")
+	attrs{}.encode(e)
+	if code := c.status(e); code != fxOK {
+		t.Fatalf("mkdir status = %d; want %d", code, fxOK)
+	}
+	e = c.request(fxpRename)
+	e.string("up.txt")
+	e.string("sub/moved.txt")
+	if code := c.status(e); code != fxOK {
+		t.Fatalf("rename status = %d; want %d", code, fxOK)
+	}
+
+	if names := c.names(fxpRealpath, "sub/../sub/."); len(names) != 1 || names[0] != "/sub" {
+		t.Fatalf("realpath = %#v; want %#v", names, []string{"/sub"})
+	}
+	e = c.request(fxpOpendir)
+	e.string("/sub")
+	typ, d := c.roundTrip(e)
+	if typ != fxpHandle {
+		t.Fatalf("opendir reply type = %d; want handle", typ)
+	}
+	h = d.string()
+	if names := c.names(fxpReaddir, h); len(names) != 1 || names[0] != "moved.txt" {
+		t.Fatalf("readdir = %#v; want %#v", names, []string{"moved.txt"})
+	}
+	e = c.request(fxpReaddir)
+	e.string(h)
+	if code := c.status(e); code != fxEOF {
+		t.Fatalf("readdir status = %d at end; want %d", code, fxEOF)
+	}
+	c.close(h)
+
+	e = c.request(fxpRmdir)
+	e.string("sub")
+	if code := c.status(e); code != fxFailure {
+		t.Fatalf("rmdir status = %d for non-empty directory; want %d", code, fxFailure)
+	}
+	e = c.request(fxpRemove)
+	e.string("sub/moved.txt")
+	if code := c.status(e); code != fxOK {
+		t.Fatalf("remove status = %d; want %d", code, fxOK)
+	}
+	e = c.request(fxpStat)
+	e.string("sub/moved.txt")
+	if code := c.status(e); code != fxNoSuchFile {
+		t.Fatalf("stat status = %d after remove; want %d", code, fxNoSuchFile)
+	}
+}
+
+func TestServeReadOnly(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t, vfs.FromFS(fstest.MapFS{
+		"docs/readme.txt": {Data: []byte("read me")},
+	}))
+
+	h := c.open("/docs/readme.txt", fxfRead)
+	if data, code := c.read(h, 0); data != "read me" {
+		t.Fatalf("read = %#v, status %d; want %#v", data, code, "read me")
+	}
+	c.close(h)
+
+	e := c.request(fxpOpen)
+	e.string("/docs/readme.txt")
+	e.uint32(fxfWrite | fxfTrunc)
+	attrs{}.encode(e)
+	if code := c.status(e); code != fxPermissionDenied {
+		t.Fatalf("open for writing status = %d; want %d", code, fxPermissionDenied)
+	}
+	e = c.request(fxpSymlink)
+	e.string("link")
+	e.string("docs")
+	if code := c.status(e); code != fxOpUnsupported {
+		t.Fatalf("symlink status = %d; want %d", code, fxOpUnsupported)
+	}
+	e = c.request(fxpRead)
+	e.string("bogus")
+	e.uint64(0)
+	e.uint32(10)
+	if code := c.status(e); code != fxFailure {
+		t.Fatalf("read of unknown handle status = %d; want %d", code, fxFailure)
+	}
+}
+
+func TestServeAbortsUploads(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- Serve(server, vfs.Atomic(vfs.DirFS(dir), nil)) }()
+	c := &testClient{t: t, rw: client}
+	h := c.open("partial.txt", fxfWrite|fxfCreat)
+	e := c.request(fxpWrite)
+	e.string(h)
+	e.uint64(0)
+	e.string("half an upl")
+	c.status(e)
+	client.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve = %v; want nil", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("files left after disconnect: %v", entries)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644)
+	srv := &ssh.Server{SubsystemHandlers: map[string]ssh.SubsystemHandler{
+		"sftp": Handler(func(s ssh.Session) (vfs.FS, error) {
+			return vfs.DirFS(dir), nil
+		}),
+	}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "testuser",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, rw: struct {
+		io.Reader
+		io.Writer
+	}{stdout, stdin}}
+	e := &encoder{b: make([]byte, 4)}
+	e.byte(fxpInit)
+	e.uint32(3)
+	if typ, _ := c.roundTrip(e); typ != fxpVersion {
+		t.Fatalf("init reply type = %d; want version", typ)
+	}
+	h := c.open("hello.txt", fxfRead)
+	if data, code := c.read(h, 0); data != "hello" {
+		t.Fatalf("read = %#v, status %d; want %#v", data, code, "hello")
+	}
+	c.close(h)
+}
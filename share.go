@@ -0,0 +1,375 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// DefaultScrollback is the amount of session output, in bytes, replayed to
+// observers attaching to a shared session when SessionHub.Scrollback is zero.
+const DefaultScrollback = 64 * 1024
+
+// observerQueueSize is how many output chunks are queued for an observer
+// before it is considered too slow and detached.
+const observerQueueSize = 256
+
+var (
+	// ErrSessionNotFound is returned by SessionHub.Attach when no shared session
+	// exists with the requested ID.
+	ErrSessionNotFound = errors.New("ssh: shared session not found")
+
+	// ErrAttachDenied is returned by SessionHub.Attach when the AttachCallback
+	// denies the observer.
+	ErrAttachDenied = errors.New("ssh: attach denied")
+)
+
+// AttachCallback is a hook for allowing an observer to attach to a shared
+// session.
+type AttachCallback func(ctx Context, target *SharedSession) bool
+
+// InputAuditCallback is a hook for recording input sent to a shared session.
+// The context is that of the user who typed the input.
+type InputAuditCallback func(ctx Context, target *SharedSession, input []byte)
+
+// SessionHub tracks shared sessions so that authorized observers, such as an
+// admin API or another SSH session, can attach to them. Observers receive a
+// bounded scrollback of previous output followed by live output, and may also
+// send input to the session if allowed by InputCallback.
+type SessionHub struct {
+	AttachCallback     AttachCallback     // callback for allowing observers to attach, denies all if nil
+	InputCallback      AttachCallback     // callback for allowing attached observers to send input, denies all if nil
+	InputAuditCallback InputAuditCallback // optional callback for recording who typed what
+	Scrollback         int                // bytes of output replayed to new observers, DefaultScrollback if zero
+
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[string]*SharedSession
+}
+
+// Share registers sess with the hub and returns a SharedSession that must be
+// used in its place for output to be visible to observers. The session stays
+// shared until Exit or Close is called on the SharedSession or the connection
+// closes.
+func (h *SessionHub) Share(sess Session) *SharedSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*SharedSession)
+	}
+	h.nextID++
+	shared := &SharedSession{
+		Session:   sess,
+		id:        strconv.FormatUint(h.nextID, 10),
+		hub:       h,
+		observers: make(map[*observer]struct{}),
+		done:      make(chan struct{}),
+	}
+	h.sessions[shared.id] = shared
+	spawn(sess.Context(), func() {
+		select {
+		case <-sess.Context().Done():
+			shared.unshare()
+		case <-shared.done:
+		}
+	})
+	return shared
+}
+
+// Lookup returns the shared session with the given ID.
+func (h *SessionHub) Lookup(id string) (*SharedSession, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[id]
+	return s, ok
+}
+
+// Sessions returns all currently shared sessions.
+func (h *SessionHub) Sessions() []*SharedSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions := make([]*SharedSession, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Attach attaches the observer session to the shared session with the given
+// ID if allowed by AttachCallback. It blocks, copying the target's output to
+// the observer, until either session ends. If InputCallback allows it, input
+// from the observer is sent to the target as well, until Attach returns; a
+// read from the observer in progress by then ends the copy, and its input
+// is dropped.
+func (h *SessionHub) Attach(observer Session, id string) error {
+	target, ok := h.Lookup(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	ctx, _ := observer.Context().(Context)
+	if h.AttachCallback == nil || ctx == nil || !h.AttachCallback(ctx, target) {
+		return ErrAttachDenied
+	}
+	detach := target.Observe(observer)
+	defer detach()
+	if h.InputCallback != nil && h.InputCallback(ctx, target) {
+		stop := make(chan struct{})
+		defer close(stop)
+		in := target.InputWriter(ctx)
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := observer.Read(buf)
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if n > 0 {
+					if _, err := in.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	select {
+	case <-target.Done():
+	case <-observer.Context().Done():
+	}
+	return nil
+}
+
+func (h *SessionHub) scrollback() int {
+	if h.Scrollback > 0 {
+		return h.Scrollback
+	}
+	return DefaultScrollback
+}
+
+func (h *SessionHub) remove(s *SharedSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, s.id)
+}
+
+// SharedSession is a Session registered with a SessionHub. Output written to
+// it or its Stderr is recorded in a scrollback buffer and copied to attached
+// observers. Reading from it returns the owner's input merged with input sent
+// by collaborators through InputWriter, which is audited as
+// AuditSharedInput.
+type SharedSession struct {
+	Session
+
+	id  string
+	hub *SessionHub
+
+	inputOnce sync.Once
+	inputMu   sync.Mutex
+	inputR    *io.PipeReader
+	inputW    *io.PipeWriter
+
+	mu         sync.Mutex
+	scrollback []byte
+	observers  map[*observer]struct{}
+	done       chan struct{}
+	closed     bool
+}
+
+// ID returns the identifier observers use to attach to the session.
+func (s *SharedSession) ID() string {
+	return s.id
+}
+
+// Done returns a channel that is closed when the session stops being shared.
+func (s *SharedSession) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *SharedSession) Write(p []byte) (n int, err error) {
+	n, err = s.Session.Write(p)
+	if n > 0 {
+		s.record(p[:n])
+	}
+	return
+}
+
+// Stderr returns the stderr of the session, whose output is recorded like
+// that written to the session.
+func (s *SharedSession) Stderr() io.ReadWriter {
+	return sharedStderr{s.Session.Stderr(), s}
+}
+
+type sharedStderr struct {
+	io.ReadWriter
+	s *SharedSession
+}
+
+func (e sharedStderr) Write(p []byte) (n int, err error) {
+	n, err = e.ReadWriter.Write(p)
+	if n > 0 {
+		e.s.record(p[:n])
+	}
+	return
+}
+
+func (s *SharedSession) Read(p []byte) (int, error) {
+	s.inputOnce.Do(s.startInput)
+	return s.inputR.Read(p)
+}
+
+// InputWriter returns a writer that sends input to the session on behalf of
+// the user of ctx. Callers are responsible for authorizing the user.
+func (s *SharedSession) InputWriter(ctx Context) io.Writer {
+	return &sharedInput{s, ctx}
+}
+
+func (s *SharedSession) startInput() {
+	s.inputR, s.inputW = io.Pipe()
+	ctx, _ := s.Session.Context().(Context)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := s.Session.Read(buf)
+			if n > 0 {
+				if _, werr := s.input(ctx, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				s.inputW.CloseWithError(err)
+				return
+			}
+		}
+	}()
+}
+
+func (s *SharedSession) input(ctx Context, p []byte) (int, error) {
+	s.inputOnce.Do(s.startInput)
+	select {
+	case <-s.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	s.inputMu.Lock()
+	defer s.inputMu.Unlock()
+	if s.hub.InputAuditCallback != nil {
+		s.hub.InputAuditCallback(ctx, s, append([]byte(nil), p...))
+	}
+	return s.inputW.Write(p)
+}
+
+func (s *SharedSession) Exit(code int) error {
+	s.unshare()
+	return s.Session.Exit(code)
+}
+
+func (s *SharedSession) Close() error {
+	s.unshare()
+	return s.Session.Close()
+}
+
+// Observe writes the scrollback to w followed by all further output of the
+// session, until the returned detach function is called or the session stops
+// being shared. Writes to w happen on a separate goroutine; an observer that
+// falls too far behind is detached.
+func (s *SharedSession) Observe(w io.Writer) (detach func()) {
+	o := &observer{
+		w:     w,
+		queue: make(chan []byte, observerQueueSize),
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return func() {}
+	}
+	if len(s.scrollback) > 0 {
+		o.queue <- append([]byte(nil), s.scrollback...)
+	}
+	s.observers[o] = struct{}{}
+	s.mu.Unlock()
+	go o.run()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.detachLocked(o)
+	}
+}
+
+func (s *SharedSession) record(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.scrollback = append(s.scrollback, p...)
+	if max := s.hub.scrollback(); len(s.scrollback) > max {
+		s.scrollback = append([]byte(nil), s.scrollback[len(s.scrollback)-max:]...)
+	}
+	for o := range s.observers {
+		select {
+		case o.queue <- append([]byte(nil), p...):
+		default:
+			s.detachLocked(o)
+		}
+	}
+}
+
+func (s *SharedSession) detachLocked(o *observer) {
+	if _, ok := s.observers[o]; ok {
+		delete(s.observers, o)
+		close(o.queue)
+	}
+}
+
+func (s *SharedSession) unshare() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for o := range s.observers {
+		s.detachLocked(o)
+	}
+	close(s.done)
+	s.mu.Unlock()
+	s.hub.remove(s)
+	// fail input blocked on a session no longer reading it
+	s.inputOnce.Do(func() { s.inputR, s.inputW = io.Pipe() })
+	s.inputW.CloseWithError(io.ErrClosedPipe)
+}
+
+type sharedInput struct {
+	s   *SharedSession
+	ctx Context
+}
+
+func (in *sharedInput) Write(p []byte) (int, error) {
+	n, err := in.s.input(in.ctx, p)
+	if n > 0 && in.ctx != nil {
+		owner, _ := in.s.Session.Context().Value(ContextKeySessionID).(string)
+		audit(in.ctx, AuditEvent{Type: AuditSharedInput, Outcome: AuditSuccess, Input: string(p[:n]), SharedSessionID: owner})
+	}
+	return n, err
+}
+
+type observer struct {
+	w     io.Writer
+	queue chan []byte
+}
+
+func (o *observer) run() {
+	for p := range o.queue {
+		if _, err := o.w.Write(p); err != nil {
+			break
+		}
+	}
+	// drain so senders never block on a failed observer
+	for range o.queue {
+	}
+}
@@ -0,0 +1,229 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type bufferSession struct {
+	Session
+	stdin io.Reader
+	mu    sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (s *bufferSession) Read(p []byte) (int, error) {
+	return s.stdin.Read(p)
+}
+
+func (s *bufferSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+// Stderr shares the output buffer of the session.
+func (s *bufferSession) Stderr() io.ReadWriter {
+	return s
+}
+
+func (s *bufferSession) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func (s *bufferSession) Context() context.Context {
+	return context.Background()
+}
+
+func TestSharedSessionObserve(t *testing.T) {
+	t.Parallel()
+	hub := &SessionHub{Scrollback: 5}
+	owner := &bufferSession{}
+	shared := hub.Share(owner)
+	if _, ok := hub.Lookup(shared.ID()); !ok {
+		t.Fatal("shared session not registered")
+	}
+	shared.Write([]byte("hello world"))
+
+	observer := &bufferSession{}
+	detach := shared.Observe(observer)
+	defer detach()
+	shared.Write([]byte("!"))
+
+	want := "world!"
+	deadline := time.Now().Add(time.Second)
+	for observer.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("observer output = %#v; want %#v", observer.String(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := owner.String(); got != "hello world!" {
+		t.Fatalf("owner output = %#v; want %#v", got, "hello world!")
+	}
+}
+
+func TestSharedSessionInput(t *testing.T) {
+	t.Parallel()
+	var typed []string
+	hub := &SessionHub{
+		InputAuditCallback: func(ctx Context, target *SharedSession, input []byte) {
+			typed = append(typed, string(input))
+		},
+	}
+	stdin, ownerInput := io.Pipe()
+	shared := hub.Share(&bufferSession{stdin: stdin})
+
+	collaborator, _ := newContext(nil)
+	go ownerInput.Write([]byte("ls\n"))
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(shared, buf); err != nil || string(buf) != "ls\n" {
+		t.Fatalf("owner input = %#v, %v; want %#v", string(buf), err, "ls\n")
+	}
+	go shared.InputWriter(collaborator).Write([]byte("pwd\n"))
+	buf = make([]byte, 4)
+	if _, err := io.ReadFull(shared, buf); err != nil || string(buf) != "pwd\n" {
+		t.Fatalf("collaborator input = %#v, %v; want %#v", string(buf), err, "pwd\n")
+	}
+	ownerInput.Close()
+	if _, err := shared.Read(buf); err != io.EOF {
+		t.Fatalf("err = %#v; want EOF", err)
+	}
+	if len(typed) != 2 {
+		t.Fatalf("audited input = %#v; want 2 records", typed)
+	}
+}
+
+// contextSession is a bufferSession with a Context, so it can attach.
+type contextSession struct {
+	*bufferSession
+	ctx Context
+}
+
+func (s contextSession) Context() context.Context {
+	return s.ctx
+}
+
+func TestSessionHubAttachInput(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var typed []string
+	allow := func(ctx Context, target *SharedSession) bool { return true }
+	hub := &SessionHub{
+		AttachCallback: allow,
+		InputCallback:  allow,
+		InputAuditCallback: func(ctx Context, target *SharedSession, input []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			typed = append(typed, string(input))
+		},
+	}
+	ownerInput, _ := io.Pipe()
+	shared := hub.Share(&bufferSession{stdin: ownerInput})
+	go io.Copy(io.Discard, shared)
+	ctx, cancel := newContext(nil)
+	defer cancel()
+	stdin, typing := io.Pipe()
+	observer := contextSession{&bufferSession{stdin: stdin}, ctx}
+	attached := make(chan error, 1)
+	go func() {
+		attached <- hub.Attach(observer, shared.ID())
+	}()
+	audited := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), typed...)
+	}
+
+	io.WriteString(typing, "ls\n")
+	for deadline := time.Now().Add(5 * time.Second); len(audited()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	shared.unshare()
+	if err := <-attached; err != nil {
+		t.Fatal(err)
+	}
+	// the read in progress when detaching ends the copy, and nothing is
+	// read from the observer afterwards
+	io.WriteString(typing, "exit\n")
+	wrote := make(chan struct{})
+	go func() {
+		io.WriteString(typing, "more\n")
+		close(wrote)
+	}()
+	select {
+	case <-wrote:
+		t.Fatal("input still read from the observer after detaching")
+	case <-time.After(50 * time.Millisecond):
+	}
+	typing.Close()
+	if got := audited(); len(got) != 1 || got[0] != "ls\n" {
+		t.Fatalf("audited input = %#v; want only the input before detaching", got)
+	}
+}
+
+func TestSharedSessionStderr(t *testing.T) {
+	t.Parallel()
+	hub := &SessionHub{}
+	owner := &bufferSession{}
+	shared := hub.Share(owner)
+	observer := &bufferSession{}
+	detach := shared.Observe(observer)
+	defer detach()
+	shared.Write([]byte("$ "))
+	shared.Stderr().Write([]byte("not found\n"))
+
+	want := "$ not found\n"
+	deadline := time.Now().Add(time.Second)
+	for observer.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("observer output = %#v; want %#v", observer.String(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := owner.String(); got != want {
+		t.Fatalf("owner output = %#v; want %#v", got, want)
+	}
+}
+
+func TestSharedSessionInputAudit(t *testing.T) {
+	t.Parallel()
+	var events []AuditEvent
+	hub := &SessionHub{}
+	stdin, _ := io.Pipe()
+	ownerCtx, cancelOwner := newContext(nil)
+	defer cancelOwner()
+	ownerCtx.SetValue(ContextKeySessionID, "owner-session")
+	shared := hub.Share(contextSession{&bufferSession{stdin: stdin}, ownerCtx})
+	collaborator, cancel := newContext(&Server{AuditCallback: func(ctx Context, ev AuditEvent) {
+		events = append(events, ev)
+	}})
+	defer cancel()
+	collaborator.SetValue(ContextKeyUser, "alice")
+
+	read := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4)
+		io.ReadFull(shared, buf)
+		read <- string(buf)
+	}()
+	if _, err := shared.InputWriter(collaborator).Write([]byte("pwd\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-read; got != "pwd\n" {
+		t.Fatalf("input = %#v; want %#v", got, "pwd\n")
+	}
+	if len(events) != 1 {
+		t.Fatalf("events = %#v; want one", events)
+	}
+	ev := events[0]
+	if ev.Type != AuditSharedInput || ev.User != "alice" || ev.Input != "pwd\n" || ev.SharedSessionID != "owner-session" {
+		t.Fatalf("event = %#v; want alice typing %#v into owner-session", ev, "pwd\n")
+	}
+}
@@ -0,0 +1,168 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/anmitsu/go-shlex"
+)
+
+// DefaultShell is the command template used by ShellHandler when no template
+// is configured for a user.
+const DefaultShell = "/bin/sh"
+
+// ShellHandler is a Handler that runs a shell for each session, so simple
+// shell servers don't need a custom Handler. It can be enabled by setting the
+// server's Handler to HandleSession.
+//
+// Command templates are split using POSIX shell rules, then the variables
+// $user, $home and $tty (also as ${user} etc) are expanded in each
+// argument. $tty expands to the terminal device when one is allocated for
+// the session and is empty otherwise. Sessions requesting a PTY are run on
+// one, as by RunWithPty. When the client requests a shell the user's
+// template is run as is; exec requests are run by the template with "-c"
+// and the command appended.
+//
+// Commands get the environment the client set, as filtered by the
+// server's AcceptEnv, while PATH, USER, LOGNAME, SHELL and HOME are always
+// set by the handler.
+type ShellHandler struct {
+	// Users maps user names to the command template run for them.
+	Users map[string]string
+
+	// Default is the template for users not in Users. If empty, DefaultShell
+	// is used.
+	Default string
+
+	// HomeDir returns the home directory of a user. If nil, the home directory
+	// of the system account with the same name is used, if any.
+	HomeDir func(user string) string
+}
+
+func (h *ShellHandler) HandleSession(s Session) {
+	var t *ptyFile
+	pty, winCh, isPty := s.Pty()
+	if isPty {
+		var err error
+		if t, err = openPty(pty); err != nil && !errors.Is(err, errPtyUnsupported) {
+			fmt.Fprintln(s.Stderr(), err)
+			s.Exit(1)
+			return
+		}
+	}
+	cmd, err := h.command(s, t.name())
+	if err != nil {
+		if t != nil {
+			t.master.Close()
+			t.tty.Close()
+		}
+		fmt.Fprintln(s.Stderr(), err)
+		s.Exit(1)
+		return
+	}
+	if t != nil {
+		runPty(s, cmd, t, winCh)
+		return
+	}
+	runCommand(s, cmd)
+}
+
+// Command returns the command that HandleSession runs for the session. $tty
+// expands to "", as no terminal is allocated.
+func (h *ShellHandler) Command(s Session) (*exec.Cmd, error) {
+	return h.command(s, "")
+}
+
+func (h *ShellHandler) command(s Session, tty string) (*exec.Cmd, error) {
+	tmpl, ok := h.Users[s.User()]
+	if !ok {
+		tmpl = h.Default
+	}
+	if tmpl == "" {
+		tmpl = DefaultShell
+	}
+	home := h.homeDir(s.User())
+	vars := map[string]string{
+		"user": s.User(),
+		"home": home,
+		"tty":  tty,
+	}
+	// split before expanding so values can't inject extra arguments
+	args, err := shlex.Split(tmpl, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range args {
+		args[i] = os.Expand(args[i], func(name string) string {
+			return vars[name]
+		})
+	}
+	if len(args) == 0 {
+		return nil, errors.New("ssh: empty shell command")
+	}
+	if s.RawCommand() != "" {
+		args = append(args, "-c", s.RawCommand())
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	// the variables set last win, so the client can't override the
+	// server's
+	cmd.Env = s.Environ()
+	if pty, _, isPty := s.Pty(); isPty {
+		cmd.Env = append(cmd.Env, "TERM="+pty.Term)
+	}
+	cmd.Env = append(cmd.Env,
+		"PATH="+os.Getenv("PATH"),
+		"USER="+s.User(),
+		"LOGNAME="+s.User(),
+		"SHELL="+args[0],
+	)
+	if home != "" {
+		cmd.Env = append(cmd.Env, "HOME="+home)
+		if fi, err := os.Stat(home); err == nil && fi.IsDir() {
+			cmd.Dir = home
+		}
+	}
+	return cmd, nil
+}
+
+func (h *ShellHandler) homeDir(name string) string {
+	if h.HomeDir != nil {
+		return h.HomeDir(name)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return ""
+	}
+	return u.HomeDir
+}
+
+// runCommand runs cmd with its standard streams connected to the session and
+// ends the session with its outcome.
+func runCommand(s Session, cmd *exec.Cmd) {
+	cmd.Stdout = s
+	cmd.Stderr = s.Stderr()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		fmt.Fprintln(s.Stderr(), err)
+		s.Exit(1)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(s.Stderr(), err)
+		s.Exit(127)
+		return
+	}
+	go func() {
+		io.Copy(stdin, s)
+		stdin.Close()
+	}()
+	if err := cmd.Wait(); err != nil && cmd.ProcessState == nil {
+		s.Exit(1)
+		return
+	}
+	ExitProcess(s, cmd.ProcessState)
+}
@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestShellHandler(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("/bin/echo"); err != nil {
+		t.Skip("no /bin/echo")
+	}
+	shell := &ShellHandler{
+		Users: map[string]string{"testuser": "/bin/echo $user ${home}"},
+		HomeDir: func(user string) string {
+			return "/nonexistent/" + user
+		},
+	}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: shell.HandleSession,
+	}, nil)
+	defer cleanup()
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := "testuser /nonexistent/testuser\n"
+	if stdout.String() != want {
+		t.Fatalf("stdout = %#v; want %#v", stdout.String(), want)
+	}
+}
+
+func TestShellHandlerExec(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath(DefaultShell); err != nil {
+		t.Skip("no " + DefaultShell)
+	}
+	shell := &ShellHandler{}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: shell.HandleSession,
+	}, nil)
+	defer cleanup()
+	err := session.Run("exit 3")
+	e, ok := err.(*gossh.ExitError)
+	if !ok {
+		t.Fatalf("expected ExitError but got %T", err)
+	}
+	if e.ExitStatus() != 3 {
+		t.Fatalf("exit-status = %#v; want %#v", e.ExitStatus(), 3)
+	}
+}
+
+func TestShellHandlerEnv(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath(DefaultShell); err != nil {
+		t.Skip("no " + DefaultShell)
+	}
+	shell := &ShellHandler{
+		HomeDir: func(user string) string {
+			return "/nonexistent/" + user
+		},
+	}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: shell.HandleSession,
+	}, nil)
+	defer cleanup()
+	// the server's variables win over the client's
+	if err := session.Setenv("HOME", "/tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Setenv("LANG", "C"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output(`echo "$HOME $LANG"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/nonexistent/testuser C\n"; string(out) != want {
+		t.Fatalf("output = %#v; want %#v", string(out), want)
+	}
+}
@@ -0,0 +1,27 @@
+package ssh
+
+import "os"
+
+// OSSignal returns the os.Signal corresponding to s on the current platform.
+// It returns false if the platform has no equivalent signal.
+func (s Signal) OSSignal() (os.Signal, bool) {
+	return osSignal(s)
+}
+
+// SignalFromOS returns the Signal corresponding to an os.Signal, such as the
+// signal that terminated a child process. It returns false if the signal has
+// no SSH name.
+func SignalFromOS(sig os.Signal) (Signal, bool) {
+	return fromOSSignal(sig)
+}
+
+// ExitProcess ends the session s with the outcome of a process run for it,
+// such as the ProcessState of an exec.Cmd: with exit-signal if it was killed
+// by a signal with an SSH name, and with its exit status otherwise.
+func ExitProcess(s Session, state *os.ProcessState) error {
+	osSig, coreDumped, status := processExit(state)
+	if sig, ok := fromOSSignal(osSig); ok {
+		return s.ExitSignal(sig, coreDumped, "")
+	}
+	return s.Exit(status)
+}
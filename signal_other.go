@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !illumos && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package ssh
+
+import "os"
+
+func osSignal(s Signal) (os.Signal, bool) {
+	return nil, false
+}
+
+func fromOSSignal(sig os.Signal) (Signal, bool) {
+	return "", false
+}
+
+func processExit(state *os.ProcessState) (sig os.Signal, coreDumped bool, status int) {
+	return nil, false, state.ExitCode()
+}
@@ -0,0 +1,36 @@
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris || windows
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris windows
+
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+// Syscall returns the platform signal number corresponding to s.
+func (s Signal) Syscall() (syscall.Signal, bool) {
+	sig, ok := syscallSignals[s]
+	return sig, ok
+}
+
+func osSignal(s Signal) (os.Signal, bool) {
+	sig, ok := syscallSignals[s]
+	if !ok {
+		return nil, false
+	}
+	return sig, true
+}
+
+func fromOSSignal(sig os.Signal) (Signal, bool) {
+	num, ok := sig.(syscall.Signal)
+	if !ok {
+		return "", false
+	}
+	for s, n := range syscallSignals {
+		if n == num {
+			return s, true
+		}
+	}
+	return "", false
+}
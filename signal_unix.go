@@ -0,0 +1,35 @@
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+var syscallSignals = map[Signal]syscall.Signal{
+	SIGABRT: syscall.SIGABRT,
+	SIGALRM: syscall.SIGALRM,
+	SIGFPE:  syscall.SIGFPE,
+	SIGHUP:  syscall.SIGHUP,
+	SIGILL:  syscall.SIGILL,
+	SIGINT:  syscall.SIGINT,
+	SIGKILL: syscall.SIGKILL,
+	SIGPIPE: syscall.SIGPIPE,
+	SIGQUIT: syscall.SIGQUIT,
+	SIGSEGV: syscall.SIGSEGV,
+	SIGTERM: syscall.SIGTERM,
+	SIGUSR1: syscall.SIGUSR1,
+	SIGUSR2: syscall.SIGUSR2,
+}
+
+// processExit returns the signal that killed a process, if any, and its exit
+// status as reported by shells, 128 plus the signal number when killed.
+func processExit(state *os.ProcessState) (sig os.Signal, coreDumped bool, status int) {
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return nil, false, state.ExitCode()
+	}
+	return ws.Signal(), ws.CoreDump(), 128 + int(ws.Signal())
+}
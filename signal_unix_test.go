@@ -0,0 +1,54 @@
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package ssh
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSignalConversion(t *testing.T) {
+	t.Parallel()
+	sig, ok := SIGTERM.Syscall()
+	if !ok || sig != syscall.SIGTERM {
+		t.Fatalf("SIGTERM.Syscall() = %v, %v; want %v, true", sig, ok, syscall.SIGTERM)
+	}
+	s, ok := SignalFromOS(syscall.SIGUSR1)
+	if !ok || s != SIGUSR1 {
+		t.Fatalf("SignalFromOS(SIGUSR1) = %v, %v; want %v, true", s, ok, SIGUSR1)
+	}
+	if _, ok := SignalFromOS(syscall.SIGCHLD); ok {
+		t.Fatal("expected SIGCHLD to have no SSH name")
+	}
+}
+
+func TestExitSignal(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath(DefaultShell); err != nil {
+		t.Skip("no " + DefaultShell)
+	}
+	summaries := make(chan SessionSummary, 1)
+	shell := &ShellHandler{}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: shell.HandleSession,
+		SessionEndCallback: func(s Session, summary SessionSummary) {
+			summaries <- summary
+		},
+	}, nil)
+	defer cleanup()
+	err := session.Run("kill -TERM $$")
+	e, ok := err.(*gossh.ExitError)
+	if !ok {
+		t.Fatalf("expected ExitError but got %T: %v", err, err)
+	}
+	if e.Signal() != "TERM" {
+		t.Fatalf("exit-signal = %#v; want %#v", e.Signal(), "TERM")
+	}
+	if summary := <-summaries; summary.ExitSignal != SIGTERM || summary.ExitStatus != -1 {
+		t.Fatalf("summary exit = %d, %#v; want -1, %#v", summary.ExitStatus, summary.ExitSignal, SIGTERM)
+	}
+}
@@ -0,0 +1,26 @@
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows has no SIGUSR1 and SIGUSR2.
+var syscallSignals = map[Signal]syscall.Signal{
+	SIGABRT: syscall.SIGABRT,
+	SIGALRM: syscall.SIGALRM,
+	SIGFPE:  syscall.SIGFPE,
+	SIGHUP:  syscall.SIGHUP,
+	SIGILL:  syscall.SIGILL,
+	SIGINT:  syscall.SIGINT,
+	SIGKILL: syscall.SIGKILL,
+	SIGPIPE: syscall.SIGPIPE,
+	SIGQUIT: syscall.SIGQUIT,
+	SIGSEGV: syscall.SIGSEGV,
+	SIGTERM: syscall.SIGTERM,
+}
+
+// Processes on Windows don't die from signals.
+func processExit(state *os.ProcessState) (sig os.Signal, coreDumped bool, status int) {
+	return nil, false, state.ExitCode()
+}
@@ -3,6 +3,7 @@ package ssh
 import (
 	"crypto/subtle"
 	"net"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -35,7 +36,13 @@ type Option func(*Server) error
 // Handler is a callback for handling established SSH sessions.
 type Handler func(Session)
 
+// SubsystemHandler is a callback for handling the sessions requesting a
+// subsystem, such as sftp.
+type SubsystemHandler func(Session)
+
 // PublicKeyHandler is a callback for performing public key authentication.
+// Like other auth handlers, it should return once ctx is done, which happens
+// when the connection is closed or Server.AuthTimeout elapses.
 type PublicKeyHandler func(ctx Context, key PublicKey) bool
 
 // PasswordHandler is a callback for performing password authentication.
@@ -44,23 +51,152 @@ type PasswordHandler func(ctx Context, password string) bool
 // KeyboardInteractiveHandler is a callback for performing keyboard-interactive authentication.
 type KeyboardInteractiveHandler func(ctx Context, challenger gossh.KeyboardInteractiveChallenge) bool
 
-// PtyCallback is a hook for allowing PTY sessions.
+// BannerHandler is a callback returning the banner shown to a client before
+// authentication, or "" for none. The user, client version and addresses
+// are set in ctx.
+type BannerHandler func(ctx Context) string
+
+// PtyCallback is a hook for allowing PTY sessions. It is consulted on each
+// pty-req, so terminals can be denied to some users, such as SFTP-only
+// accounts, whose sessions can still exec commands or start subsystems.
 type PtyCallback func(ctx Context, pty Pty) bool
 
 // SessionRequestCallback is a callback for allowing or denying SSH sessions.
 type SessionRequestCallback func(sess Session, requestType string) bool
 
+// EnvRequestCallback is a hook for allowing the environment variable name
+// sent by the client, given its value.
+type EnvRequestCallback func(ctx Context, name, value string) bool
+
+// UserExistsCallback is a hook for telling whether the user of ctx exists.
+type UserExistsCallback func(ctx Context) bool
+
+// AuditCallback is a hook for recording audit events. It is called while
+// handling the action, so it should queue slow writes.
+type AuditCallback func(ctx Context, ev AuditEvent)
+
+// AgentForwardingCallback is a hook for allowing the client to forward its
+// agent to the session of ctx.
+type AgentForwardingCallback func(ctx Context) bool
+
+// HASSHCallback is a hook for inspecting the HASSH fingerprint of a client
+// as soon as its key exchange starts, returning false to close the
+// connection before authentication.
+type HASSHCallback func(ctx Context, hassh string) bool
+
+// SessionStartCallback is a hook run before the Handler of a shell or exec
+// session starts. Returning a non-nil error rejects the session: the error
+// is written to the session's stderr and it exits with status 1.
+type SessionStartCallback func(sess Session) error
+
+// SessionEndCallback is a hook run after the Handler of a session returned
+// and the exit status was sent, for accounting and cleanup.
+type SessionEndCallback func(sess Session, summary SessionSummary)
+
+// SessionTransformCallback is a hook run before the Handler of a session,
+// returning the transforms applied to its input and output.
+type SessionTransformCallback func(sess Session) SessionTransforms
+
+// SessionSummary describes a finished session.
+type SessionSummary struct {
+	ExitStatus   int    // -1 if the session ended with ExitSignal
+	ExitSignal   Signal // signal passed to ExitSignal, if any
+	Started      time.Time
+	Duration     time.Duration
+	BytesRead    int64 // data received from the client
+	BytesWritten int64 // data sent to the client, including stderr
+}
+
+// PayloadLimitCallback is a hook for observing session requests rejected
+// because a payload value exceeded the server's PayloadLimits. It can end
+// the connection of clients sending pathological values with CloseConn.
+type PayloadLimitCallback func(ctx Context, err *PayloadLimitError)
+
 // ConnCallback is a hook for new connections before handling.
 // It allows wrapping for timeouts and limiting by returning
-// the net.Conn that will be used as the underlying connection.
+// the net.Conn that will be used as the underlying connection,
+// for example to count bytes, unwrap TLS or throttle the client.
+// It runs before the SSH handshake, and returning nil rejects the
+// connection, which is closed without a word to the client.
 type ConnCallback func(ctx Context, conn net.Conn) net.Conn
 
+// HostSignersCallback is a hook for choosing the host keys offered to a
+// client before the handshake, for example based on ctx.ClientVersion() to
+// work around clients known to mishandle some key types. Among the offered
+// keys, the client's preferred algorithm is used, so offering both Ed25519
+// and RSA keys lets legacy clients connect while modern clients get
+// Ed25519. Returning no signers offers the server's HostSigners.
+//
+// Setting the callback makes the server read the client's version before
+// sending its own. Clients waiting for the server's version first, as RFC
+// 4253 allows, are held for up to 2 seconds until the server gives up and
+// calls the callback with an empty ctx.ClientVersion().
+type HostSignersCallback func(ctx Context) []Signer
+
+// ConnCloseCallback is a hook run when a connection ends, with the reason
+// and the underlying error, if any.
+type ConnCloseCallback func(ctx Context, reason CloseReason, err error)
+
+// ConnectionFailedCallback is a hook for reporting connections whose SSH
+// handshake failed, for example because of a protocol error, a failed key
+// exchange or the client running out of auth attempts, with the error of the
+// handshake. ctx.RemoteAddr() is the address of the client, as forwarded by a
+// trusted proxy, and ctx.TransportRemoteAddr() the address of the peer.
+type ConnectionFailedCallback func(ctx Context, err error)
+
+// TrustForwardedCallback is a hook for deciding whether to trust the client
+// addresses claimed by the proxy at proxyAddr for a ForwardedConn.
+type TrustForwardedCallback func(ctx Context, proxyAddr net.Addr) bool
+
+// ImpersonationCallback is a hook for allowing the authenticated user admin
+// to open sessions as the user target, by logging in as "admin:target".
+type ImpersonationCallback func(ctx Context, admin, target string) bool
+
 // LocalPortForwardingCallback is a hook for allowing port forwarding
 type LocalPortForwardingCallback func(ctx Context, destinationHost string, destinationPort uint32) bool
 
+// DirectTCPIPCallback is a hook for allowing port forwarding, given the
+// originator claimed by the client along with the destination.
+type DirectTCPIPCallback func(ctx Context, req DirectTCPIP) bool
+
 // ReversePortForwardingCallback is a hook for allowing reverse port forwarding
 type ReversePortForwardingCallback func(ctx Context, bindHost string, bindPort uint32) bool
 
+// LocalUnixForwardingCallback is a hook for allowing the forwarding of
+// connections to the Unix socket at socketPath.
+type LocalUnixForwardingCallback func(ctx Context, socketPath string) bool
+
+// ReverseUnixForwardingCallback is a hook for allowing listening on the Unix
+// socket at socketPath for the client.
+type ReverseUnixForwardingCallback func(ctx Context, socketPath string) bool
+
+// ReversePortForwardingListenerCallback is a hook for creating the listener
+// used to honor an accepted reverse port forwarding request. It allows binding
+// in a different network namespace, on a specific interface or through any
+// other custom net.Listener implementation.
+type ReversePortForwardingListenerCallback func(ctx Context, network, addr string) (net.Listener, error)
+
+// ReversePortForwardingBindCallback is a hook for choosing the address
+// listened on for an allowed reverse port forwarding request. It returns the
+// host and port to bind, which may differ from those requested, with port 0
+// allocating a free port, or false to deny the request.
+type ReversePortForwardingBindCallback func(ctx Context, bindHost string, bindPort uint32) (host string, port uint32, ok bool)
+
+// ReverseForward is a reverse port forwarding of a connection.
+type ReverseForward struct {
+	BindHost string   // host requested by the client
+	BindPort uint32   // port requested by the client, or the allocated port if it requested 0
+	Addr     net.Addr // address listened on
+}
+
+// ReversePortForwardingEventCallback is a hook for learning about reverse
+// port forwardings, once they are listening or when the client cancels them.
+type ReversePortForwardingEventCallback func(ctx Context, fwd ReverseForward)
+
+// EchoLatencyCallback is a hook for reporting the echo latency samples of PTY
+// sessions.
+type EchoLatencyCallback func(ctx Context, latency time.Duration)
+
 // ServerConfigCallback is a hook for creating custom default server configs
 type ServerConfigCallback func(ctx Context) *gossh.ServerConfig
 
@@ -70,13 +206,44 @@ type Window struct {
 	Height int
 }
 
+// Break represents a break request (RFC 4335), which asks a serial console
+// session to send a BREAK on its line.
+type Break struct {
+	Duration time.Duration // requested length, usually 500ms or less
+}
+
 // Pty represents a PTY request and configuration.
 type Pty struct {
 	Term   string
 	Window Window
-	// HELP WANTED: terminal modes!
+
+	// modes are the encoded terminal modes, kept as a string so Pty stays
+	// comparable.
+	modes string
+}
+
+// Modes returns the terminal modes sent by the client, by opcode such as
+// gossh.ECHO, gossh.VINTR or gossh.TTY_OP_ISPEED for the baud rate. It is
+// nil if the client sent none. Each call returns a new map.
+func (p Pty) Modes() gossh.TerminalModes {
+	return parseTerminalModes([]byte(p.modes))
 }
 
+// PtyRepeatPolicy controls how a session answers a pty-req after it already
+// accepted one. Requests made once the handler started are always rejected.
+type PtyRepeatPolicy int
+
+const (
+	// PtyRepeatReject rejects repeated requests, keeping the first PTY.
+	PtyRepeatReject PtyRepeatPolicy = iota
+	// PtyRepeatReplace replaces the terminal and window of the PTY with
+	// those of the new request, which is subject to PtyCallback again.
+	PtyRepeatReplace
+	// PtyRepeatAllow accepts repeated requests without changing the PTY,
+	// for clients failing the session when their request is rejected.
+	PtyRepeatAllow
+)
+
 // Serve accepts incoming SSH connections on the listener l, creating a new
 // connection goroutine for each. The connection goroutines read requests and
 // then calls handler to handle sessions. Handler is typically nil, in which
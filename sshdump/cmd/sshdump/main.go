@@ -0,0 +1,38 @@
+// Command sshdump prints the SSH messages recorded by sshdump.Recorder.
+//
+// Usage:
+//
+//	sshdump [file ...]
+//
+// With no files, it reads standard input.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gliderlabs/ssh/sshdump"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		if err := sshdump.Decode(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "sshdump:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	for _, name := range os.Args[1:] {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sshdump:", err)
+			os.Exit(1)
+		}
+		err = sshdump.Decode(f, os.Stdout)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sshdump: %s: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sshdump
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Decode reads the messages recorded in r and writes them to w in a human
+// readable form, one per line, decoding the payloads of the well-known
+// request and channel types.
+func Decode(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, Format(&m)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Format returns m as a line of text.
+func Format(m *Message) string {
+	fields := []string{m.Time.Format("15:04:05.000000"), fmt.Sprintf("%-3s", m.Dir), m.Type}
+	if m.Channel != 0 {
+		fields = append(fields, "#"+strconv.Itoa(m.Channel))
+	}
+	if m.Name != "" {
+		fields = append(fields, strconv.Quote(m.Name))
+	}
+	if m.WantReply {
+		fields = append(fields, "want-reply")
+	}
+	if m.OK != nil {
+		fields = append(fields, "ok="+strconv.FormatBool(*m.OK))
+	}
+	switch {
+	case m.Type == "channel-data" || m.Type == "channel-extended-data":
+		fields = append(fields, fmt.Sprintf("len=%d", m.Length))
+		if m.Payload != nil {
+			fields = append(fields, strconv.Quote(string(m.Payload)))
+		}
+	case len(m.Payload) > 0:
+		fields = append(fields, decodePayload(m))
+	}
+	if m.Redacted {
+		fields = append(fields, "(redacted)")
+	}
+	return strings.Join(fields, " ")
+}
+
+// decodePayload decodes the payload of a request or channel open.
+func decodePayload(m *Message) string {
+	var v interface{}
+	switch m.Name {
+	case "pty-req":
+		v = &struct {
+			Term                      string
+			Columns, Rows             uint32
+			WidthPixels, HeightPixels uint32
+			Modes                     string
+		}{}
+	case "env":
+		v = &struct{ Name, Value string }{}
+	case "exec", "subsystem":
+		v = &struct{ Command string }{}
+	case "window-change":
+		v = &struct{ Columns, Rows, WidthPixels, HeightPixels uint32 }{}
+	case "signal":
+		v = &struct{ Signal string }{}
+	case "break":
+		v = &struct{ Length uint32 }{}
+	case "exit-status":
+		v = &struct{ Status uint32 }{}
+	case "exit-signal":
+		v = &struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{}
+	case "tcpip-forward", "cancel-tcpip-forward":
+		v = &struct {
+			BindAddr string
+			BindPort uint32
+		}{}
+	case "direct-tcpip", "forwarded-tcpip":
+		v = &struct {
+			DestAddr   string
+			DestPort   uint32
+			OriginAddr string
+			OriginPort uint32
+		}{}
+	default:
+		return fmt.Sprintf("payload=%x", m.Payload)
+	}
+	if err := gossh.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Sprintf("payload=%x (%v)", m.Payload, err)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%+v", v), "&")
+}
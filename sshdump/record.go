@@ -0,0 +1,308 @@
+// Package sshdump records the SSH messages exchanged by the handlers of a
+// server to files, for offline analysis of protocol-level bugs with Decode or
+// the sshdump command.
+//
+// Messages are recorded after decryption and before the application sees
+// them, at the level of the connection protocol of RFC 4254: global
+// requests, channel opens, channel requests, data and closes. The transport
+// and authentication layers are handled by golang.org/x/crypto/ssh and
+// aren't recorded, so passwords and keys never reach the files. Finished
+// recordings can be handed to a search index with Recorder.Finalize.
+package sshdump
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Message is a recorded SSH message, written to files as a line of JSON.
+type Message struct {
+	Time time.Time `json:"time"`
+	// Dir is "in" for messages from the client, "out" for messages to it.
+	Dir string `json:"dir"`
+	// Type is one of global-request, channel-open, channel-open-confirm,
+	// channel-open-failure, channel-request, channel-data,
+	// channel-extended-data, channel-eof and channel-close.
+	Type string `json:"type"`
+	// Channel numbers the channels of a connection from 1 in the order they
+	// were opened. It is 0 for global requests.
+	Channel int `json:"channel,omitempty"`
+	// Name is the request or channel type, or the message of open failures.
+	Name      string `json:"name,omitempty"`
+	WantReply bool   `json:"want_reply,omitempty"`
+	// OK is the reply to requests and channel opens, when known.
+	OK      *bool  `json:"ok,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	// Length is the size of data, which is only recorded in Payload if the
+	// Recorder has IncludeData.
+	Length int `json:"length,omitempty"`
+	// Redacted is set if secrets were removed from Payload.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// secretEnv matches the names of environment variables whose values are
+// redacted.
+var secretEnv = regexp.MustCompile(`(?i)pass|secret|token|key|auth|cred`)
+
+// Recorder records the messages of each connection of a server to a file in
+// Dir named after the session ID.
+type Recorder struct {
+	Dir string
+
+	// IncludeData records the contents of channel data rather than just its
+	// size. Data may contain secrets, such as passwords typed into a
+	// terminal, so it is left out by default.
+	IncludeData bool
+
+	// Redact, if set, can remove further secrets from messages before they
+	// are written.
+	Redact func(m *Message)
+
+	// Finalize, if set, is called with each recording once its connection
+	// ended and its file is complete, so deployments can index command
+	// history and transcripts for search. It runs on the goroutine of the
+	// connection, so slow indexing should be queued.
+	Finalize func(rec *Recording)
+}
+
+var contextKeyDump = &struct{ name string }{"sshdump"}
+
+// Install adds the middleware recording messages to srv. It must be called
+// before the server serves connections, or in Server.Reload.
+func (r *Recorder) Install(srv *ssh.Server) {
+	srv.ConnMiddleware = append(srv.ConnMiddleware, r.conn)
+	srv.ChannelMiddleware = append(srv.ChannelMiddleware, r.channel)
+	srv.RequestMiddleware = append(srv.RequestMiddleware, r.request)
+}
+
+func (r *Recorder) conn(next ssh.ConnHandler) ssh.ConnHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, conn *gossh.ServerConn, chans <-chan gossh.NewChannel, reqs <-chan *gossh.Request) {
+		f, err := os.OpenFile(filepath.Join(r.Dir, ctx.SessionID()+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			next(ctx, srv, conn, chans, reqs)
+			return
+		}
+		d := &connDump{r: r, f: f, enc: json.NewEncoder(f), rec: &Recording{
+			Path:       f.Name(),
+			SessionID:  ctx.SessionID(),
+			User:       ctx.User(),
+			RemoteAddr: ctx.RemoteAddr().String(),
+			Start:      time.Now(),
+		}}
+		ctx.SetValue(contextKeyDump, d)
+		defer d.close()
+		next(ctx, srv, conn, chans, reqs)
+	}
+}
+
+func (r *Recorder) channel(next ssh.ChannelHandler) ssh.ChannelHandler {
+	return func(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+		if d, ok := ctx.Value(contextKeyDump).(*connDump); ok {
+			newChan = d.newChannel(newChan)
+		}
+		next(srv, conn, newChan, ctx)
+	}
+}
+
+func (r *Recorder) request(next ssh.RequestHandler) ssh.RequestHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		ok, payload := next(ctx, srv, req)
+		if d, found := ctx.Value(contextKeyDump).(*connDump); found {
+			d.write(&Message{
+				Dir:       "in",
+				Type:      "global-request",
+				Name:      req.Type,
+				WantReply: req.WantReply,
+				OK:        &ok,
+				Payload:   req.Payload,
+			})
+		}
+		return ok, payload
+	}
+}
+
+// connDump is the file recording the messages of a connection.
+type connDump struct {
+	r *Recorder
+
+	mu       sync.Mutex
+	f        *os.File
+	enc      *json.Encoder
+	channels int
+	rec      *Recording
+}
+
+func (d *connDump) write(m *Message) {
+	m.Time = time.Now()
+	if m.Type == "channel-request" && m.Name == "env" {
+		redactEnv(m)
+	}
+	if d.r.Redact != nil {
+		d.r.Redact(m)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return
+	}
+	d.enc.Encode(m)
+	d.rec.Messages++
+	d.rec.DataBytes += int64(m.Length)
+	if m.Type == "channel-request" && m.Dir == "in" {
+		d.rec.addCommand(m)
+	}
+}
+
+// close closes the file and passes the recording to Finalize.
+func (d *connDump) close() {
+	d.mu.Lock()
+	d.f.Close()
+	d.f = nil
+	d.rec.End = time.Now()
+	d.mu.Unlock()
+	if d.r.Finalize != nil {
+		d.r.Finalize(d.rec)
+	}
+}
+
+func redactEnv(m *Message) {
+	var env struct{ Name, Value string }
+	if gossh.Unmarshal(m.Payload, &env) != nil || !secretEnv.MatchString(env.Name) {
+		return
+	}
+	env.Value = "[redacted]"
+	m.Payload = gossh.Marshal(&env)
+	m.Redacted = true
+}
+
+func (d *connDump) data(dir, typ string, channel int, p []byte) {
+	m := &Message{Dir: dir, Type: typ, Channel: channel, Length: len(p)}
+	if d.r.IncludeData {
+		m.Payload = append([]byte(nil), p...)
+	}
+	d.write(m)
+}
+
+func (d *connDump) newChannel(newChan gossh.NewChannel) gossh.NewChannel {
+	d.mu.Lock()
+	d.channels++
+	id := d.channels
+	d.mu.Unlock()
+	d.write(&Message{
+		Dir:     "in",
+		Type:    "channel-open",
+		Channel: id,
+		Name:    newChan.ChannelType(),
+		Payload: newChan.ExtraData(),
+	})
+	return &newChannel{newChan, d, id}
+}
+
+type newChannel struct {
+	gossh.NewChannel
+	d  *connDump
+	id int
+}
+
+func (c *newChannel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	ch, in, err := c.NewChannel.Accept()
+	if err != nil {
+		return ch, in, err
+	}
+	c.d.write(&Message{Dir: "out", Type: "channel-open-confirm", Channel: c.id})
+	out := make(chan *gossh.Request)
+	go func() {
+		defer close(out)
+		for req := range in {
+			c.d.write(&Message{
+				Dir:       "in",
+				Type:      "channel-request",
+				Channel:   c.id,
+				Name:      req.Type,
+				WantReply: req.WantReply,
+				Payload:   req.Payload,
+			})
+			out <- req
+		}
+	}()
+	return &channel{ch, c.d, c.id}, out, nil
+}
+
+func (c *newChannel) Reject(reason gossh.RejectionReason, message string) error {
+	c.d.write(&Message{Dir: "out", Type: "channel-open-failure", Channel: c.id, Name: message})
+	return c.NewChannel.Reject(reason, message)
+}
+
+type channel struct {
+	gossh.Channel
+	d  *connDump
+	id int
+}
+
+func (c *channel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		c.d.data("in", "channel-data", c.id, p[:n])
+	}
+	if err == io.EOF {
+		c.d.write(&Message{Dir: "in", Type: "channel-eof", Channel: c.id})
+	}
+	return n, err
+}
+
+func (c *channel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if n > 0 {
+		c.d.data("out", "channel-data", c.id, p[:n])
+	}
+	return n, err
+}
+
+func (c *channel) CloseWrite() error {
+	c.d.write(&Message{Dir: "out", Type: "channel-eof", Channel: c.id})
+	return c.Channel.CloseWrite()
+}
+
+func (c *channel) Close() error {
+	c.d.write(&Message{Dir: "out", Type: "channel-close", Channel: c.id})
+	return c.Channel.Close()
+}
+
+func (c *channel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	ok, err := c.Channel.SendRequest(name, wantReply, payload)
+	c.d.write(&Message{
+		Dir:       "out",
+		Type:      "channel-request",
+		Channel:   c.id,
+		Name:      name,
+		WantReply: wantReply,
+		OK:        &ok,
+		Payload:   payload,
+	})
+	return ok, err
+}
+
+func (c *channel) Stderr() io.ReadWriter {
+	return &stderr{c.Channel.Stderr(), c}
+}
+
+type stderr struct {
+	io.ReadWriter
+	c *channel
+}
+
+func (s *stderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	if n > 0 {
+		s.c.d.data("out", "channel-extended-data", s.c.id, p[:n])
+	}
+	return n, err
+}
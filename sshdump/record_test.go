@@ -0,0 +1,123 @@
+package sshdump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	srv := &ssh.Server{Handler: func(s ssh.Session) {
+		s.Write([]byte("hello"))
+	}}
+	(&Recorder{Dir: dir}).Install(srv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "testuser",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Setenv("API_TOKEN", "hunter2")
+	session.Setenv("LANG", "C")
+	if out, err := session.Output("whoami"); err != nil || string(out) != "hello" {
+		t.Fatalf("Output() = %#v, %v; want %#v, nil", string(out), err, "hello")
+	}
+	client.Close()
+	srv.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("files = %#v; want one", files)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Decode(bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	dump := out.String()
+	for _, want := range []string{
+		`in  channel-open #1 "session"`,
+		`channel-request #1 "env" want-reply {Name:LANG Value:C}`,
+		`{Name:API_TOKEN Value:[redacted]} (redacted)`,
+		`channel-request #1 "exec" want-reply {Command:whoami}`,
+		`out channel-data #1 len=5`,
+		`out channel-request #1 "exit-status" ok=false {Status:0}`,
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump lacks %#v:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(string(data), "hunter2") || strings.Contains(dump, "hello") {
+		t.Errorf("dump leaks secrets or data:\n%s", dump)
+	}
+}
+
+func TestRecorderFinalize(t *testing.T) {
+	recs := make(chan *Recording, 1)
+	srv := &ssh.Server{Handler: func(s ssh.Session) {
+		s.Write([]byte("hello "))
+		s.Stderr().Write([]byte("world"))
+	}}
+	(&Recorder{Dir: t.TempDir(), IncludeData: true, Finalize: func(rec *Recording) {
+		recs <- rec
+	}}).Install(srv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		User:            "testuser",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.CombinedOutput("uptime"); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	rec := <-recs
+	if rec.User != "testuser" || rec.SessionID == "" || rec.End.Before(rec.Start) || rec.DataBytes != 11 {
+		t.Fatalf("recording = %#v; want metadata of the connection", rec)
+	}
+	if len(rec.Commands) != 1 || rec.Commands[0].Type != "exec" || rec.Commands[0].Command != "uptime" || rec.Commands[0].Channel != 1 {
+		t.Fatalf("Commands = %#v; want exec of uptime on channel 1", rec.Commands)
+	}
+	transcript, err := rec.Transcript(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(transcript) != "hello world" {
+		t.Fatalf("Transcript(1) = %#v; want %#v", string(transcript), "hello world")
+	}
+}
@@ -0,0 +1,75 @@
+package sshdump
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Recording describes the finished recording of a connection, as passed to
+// Recorder.Finalize.
+type Recording struct {
+	Path       string // file of the messages
+	SessionID  string
+	User       string
+	RemoteAddr string
+	Start, End time.Time
+
+	// Commands are the shell, exec and subsystem requests of the sessions
+	// of the connection, in the order received.
+	Commands []Command
+
+	Messages  int   // number of messages recorded
+	DataBytes int64 // size of the channel data in both directions
+}
+
+// Command is a shell, exec or subsystem request of a recorded session.
+type Command struct {
+	Time    time.Time
+	Channel int
+	Type    string // "shell", "exec" or "subsystem"
+	Command string // the command or subsystem name, empty for shells
+}
+
+func (rec *Recording) addCommand(m *Message) {
+	c := Command{Time: m.Time, Channel: m.Channel, Type: m.Name}
+	switch m.Name {
+	case "shell":
+	case "exec", "subsystem":
+		var req struct{ Value string }
+		if gossh.Unmarshal(m.Payload, &req) != nil {
+			return
+		}
+		c.Command = req.Value
+	default:
+		return
+	}
+	rec.Commands = append(rec.Commands, c)
+}
+
+// Transcript returns the output sent on a channel of the recording, stdout
+// and stderr interleaved as written. Data is only recorded by Recorders with
+// IncludeData, so the transcript is empty otherwise.
+func (rec *Recording) Transcript(channel int) ([]byte, error) {
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, err
+		}
+		if m.Channel == channel && m.Dir == "out" && (m.Type == "channel-data" || m.Type == "channel-extended-data") {
+			out = append(out, m.Payload...)
+		}
+	}
+	return out, scanner.Err()
+}
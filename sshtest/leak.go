@@ -0,0 +1,87 @@
+// Package sshtest provides utilities for testing SSH servers and handlers.
+package sshtest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LeakTimeout is how long LeakCheck waits for goroutines to exit.
+var LeakTimeout = 5 * time.Second
+
+// LeakCheck records the running goroutines and returns a function that fails
+// t if goroutines started since are still running, once they were given
+// LeakTimeout to exit. It is meant to be deferred at the start of a test,
+// after the server under test is shut down by an earlier deferred call:
+//
+//	defer sshtest.LeakCheck(t)()
+//	srv := &ssh.Server{Handler: handler}
+//	defer srv.Shutdown(context.Background())
+//
+// Tests using LeakCheck shouldn't run in parallel with others.
+func LeakCheck(t testing.TB) func() {
+	before := make(map[string]bool)
+	for _, g := range goroutines() {
+		before[goroutineID(g)] = true
+	}
+	return func() {
+		t.Helper()
+		var leaked []string
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			leaked = leaked[:0]
+			for _, g := range goroutines() {
+				if !before[goroutineID(g)] && !ignored(g) {
+					leaked = append(leaked, g)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, g := range leaked {
+			t.Errorf("leaked goroutine: %s", g)
+		}
+	}
+}
+
+// goroutines returns the stack traces of all goroutines but the calling one.
+func goroutines() []string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := strings.Split(string(bytes.TrimSpace(buf)), "\n\n")
+	return stacks[1:]
+}
+
+// goroutineID returns the ID from the "goroutine N [state]:" header of g.
+func goroutineID(g string) string {
+	header := strings.SplitN(g, "\n", 2)[0]
+	if i := strings.Index(header, " ["); i >= 0 {
+		header = header[:i]
+	}
+	return header
+}
+
+// ignored reports whether g belongs to the testing package or the runtime
+// rather than to the code under test, judging by the functions on its stack.
+func ignored(g string) bool {
+	for _, line := range strings.Split(g, "\n")[1:] {
+		for _, prefix := range []string{"testing.", "os/signal.", "runtime."} {
+			if strings.HasPrefix(line, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package sshtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestLeakCheck(t *testing.T) {
+	defer func(timeout time.Duration) { LeakTimeout = timeout }(LeakTimeout)
+	LeakTimeout = 100 * time.Millisecond
+
+	r := &recorder{TB: t}
+	release := make(chan struct{})
+	check := LeakCheck(r)
+	go func() { <-release }()
+	check()
+	if len(r.errors) != 1 {
+		t.Fatalf("errors = %#v; want one leaked goroutine", r.errors)
+	}
+
+	r.errors = nil
+	check = LeakCheck(r)
+	go func() { time.Sleep(20 * time.Millisecond) }()
+	close(release)
+	check()
+	if len(r.errors) != 0 {
+		t.Fatalf("errors = %#v; want none", r.errors)
+	}
+}
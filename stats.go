@@ -0,0 +1,225 @@
+package ssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// stallThreshold is how long a write has to block before it is counted as a
+// stall.
+const stallThreshold = 10 * time.Millisecond
+
+// contextKeyServerConn is an internal context key for the wrapped net.Conn
+// of the connection.
+var contextKeyServerConn = &contextKey{"server-conn"}
+
+// ChannelStats is a snapshot of the flow control statistics of an open
+// channel, as returned by Server.ChannelStats.
+//
+// crypto/ssh does not expose the state of the peer's flow control window, so
+// window exhaustion is measured indirectly: writes block once the window is
+// used up. A channel with a high StallTime over a connection with a low
+// TransportStallTime is waiting on the client to adjust its window, while a
+// high TransportStallTime means the network itself is the bottleneck.
+type ChannelStats struct {
+	Type       string
+	User       string
+	RemoteAddr net.Addr
+	Opened     time.Time
+
+	BytesRead    int64
+	BytesWritten int64
+
+	// Stalls is the number of writes that blocked for longer than 10ms and
+	// StallTime the total time spent in those writes.
+	Stalls    int64
+	StallTime time.Duration
+
+	// TransportStalls and TransportStallTime are the same figures for writes
+	// to the underlying network connection, shared by all of its channels.
+	TransportStalls    int64
+	TransportStallTime time.Duration
+
+	// EchoSamples is the number of keystrokes of a PTY session whose echo
+	// latency was sampled, and EchoLatency their total latency.
+	EchoSamples int64
+	EchoLatency time.Duration
+}
+
+// flowStats holds stall counters updated atomically.
+type flowStats struct {
+	stalls    int64
+	stallTime int64
+}
+
+func (s *flowStats) track(start time.Time) {
+	if d := time.Since(start); d > stallThreshold {
+		atomic.AddInt64(&s.stalls, 1)
+		atomic.AddInt64(&s.stallTime, int64(d))
+	}
+}
+
+// statsChannel is a gossh.Channel tracked by the server for ChannelStats.
+type statsChannel struct {
+	gossh.Channel
+	flowStats
+	read    int64
+	written int64
+	active  int64 // time of the last read or write, in Unix nanoseconds
+	done    int32 // set once the channel is reported to the server's Metrics
+	echo    echoMeter
+
+	chanType string
+	ctx      Context
+	opened   time.Time
+	srv      *Server
+	spanCtx  context.Context
+	span     Span
+}
+
+// trackChannel wraps ch so its statistics are reported by ChannelStats until
+// it is closed, and its writes are scheduled by the Scheduler of the server.
+func (srv *Server) trackChannel(ctx Context, chanType string, ch gossh.Channel) gossh.Channel {
+	sc := &statsChannel{
+		Channel:  srv.Scheduler.channel(ctx, ch),
+		chanType: chanType,
+		ctx:      ctx,
+		opened:   time.Now(),
+		srv:      srv,
+	}
+	sc.active = sc.opened.UnixNano()
+	sc.spanCtx, sc.span = srv.startSpan(spanContext(ctx), "ssh.channel")
+	sc.span.SetAttribute("ssh.channel_type", chanType)
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.channels == nil {
+		srv.channels = make(map[*statsChannel]struct{})
+	}
+	srv.channels[sc] = struct{}{}
+	return sc
+}
+
+func (c *statsChannel) Read(p []byte) (n int, err error) {
+	n, err = c.Channel.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	if n > 0 {
+		c.touch()
+	}
+	c.echo.read(n)
+	return
+}
+
+func (c *statsChannel) Write(p []byte) (n int, err error) {
+	defer c.track(time.Now())
+	n, err = c.Channel.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	c.touch()
+	c.echo.write()
+	return
+}
+
+func (c *statsChannel) touch() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+}
+
+// idle returns the time since the last read or write.
+func (c *statsChannel) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.active)))
+}
+
+func (c *statsChannel) Stderr() io.ReadWriter {
+	return &statsStderr{c.Channel.Stderr(), c}
+}
+
+func (c *statsChannel) Close() error {
+	c.srv.mu.Lock()
+	delete(c.srv.channels, c)
+	c.srv.mu.Unlock()
+	c.finish()
+	return c.Channel.Close()
+}
+
+// finish reports the channel to the server's Metrics and ends its span,
+// once.
+func (c *statsChannel) finish() {
+	if atomic.CompareAndSwapInt32(&c.done, 0, 1) {
+		read, written := atomic.LoadInt64(&c.read), atomic.LoadInt64(&c.written)
+		c.srv.metrics().ChannelClosed(c.chanType, read, written)
+		c.span.SetAttribute("ssh.bytes_in", read)
+		c.span.SetAttribute("ssh.bytes_out", written)
+		c.span.End()
+	}
+}
+
+func (c *statsChannel) stats() ChannelStats {
+	s := ChannelStats{
+		Type:         c.chanType,
+		Opened:       c.opened,
+		BytesRead:    atomic.LoadInt64(&c.read),
+		BytesWritten: atomic.LoadInt64(&c.written),
+		Stalls:       atomic.LoadInt64(&c.stalls),
+		StallTime:    time.Duration(atomic.LoadInt64(&c.stallTime)),
+	}
+	s.EchoSamples, s.EchoLatency = c.echo.stats()
+	if user, ok := c.ctx.Value(ContextKeyUser).(string); ok {
+		s.User = user
+	}
+	if addr, ok := c.ctx.Value(ContextKeyRemoteAddr).(net.Addr); ok {
+		s.RemoteAddr = addr
+	}
+	if conn, ok := c.ctx.Value(contextKeyServerConn).(*serverConn); ok {
+		s.TransportStalls = atomic.LoadInt64(&conn.stalls)
+		s.TransportStallTime = time.Duration(atomic.LoadInt64(&conn.stallTime))
+	}
+	return s
+}
+
+type statsStderr struct {
+	io.ReadWriter
+	c *statsChannel
+}
+
+func (s *statsStderr) Write(p []byte) (n int, err error) {
+	defer s.c.track(time.Now())
+	n, err = s.ReadWriter.Write(p)
+	atomic.AddInt64(&s.c.written, int64(n))
+	s.c.touch()
+	s.c.echo.write()
+	return
+}
+
+// untrackChannels stops reporting the channels of the connection of ctx once
+// it is closed.
+func (srv *Server) untrackChannels(ctx Context) {
+	var closed []*statsChannel
+	srv.mu.Lock()
+	for c := range srv.channels {
+		if c.ctx == ctx {
+			delete(srv.channels, c)
+			closed = append(closed, c)
+		}
+	}
+	srv.mu.Unlock()
+	for _, c := range closed {
+		c.finish()
+	}
+}
+
+// ChannelStats returns flow control statistics for all open session,
+// direct-tcpip and forwarded-tcpip channels.
+func (srv *Server) ChannelStats() []ChannelStats {
+	srv.init()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	stats := make([]ChannelStats, 0, len(srv.channels))
+	for c := range srv.channels {
+		stats = append(stats, c.stats())
+	}
+	return stats
+}
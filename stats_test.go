@@ -0,0 +1,32 @@
+package ssh
+
+import (
+	"testing"
+)
+
+func TestChannelStats(t *testing.T) {
+	t.Parallel()
+	testBytes := []byte("Hello world\n")
+	stats := make(chan []ChannelStats, 1)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			s.Write(testBytes)
+			srv := s.Context().Value(ContextKeyServer).(*Server)
+			stats <- srv.ChannelStats()
+		},
+	}, nil)
+	defer cleanup()
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	got := <-stats
+	if len(got) != 1 {
+		t.Fatalf("len(ChannelStats()) = %d; want 1", len(got))
+	}
+	if got[0].Type != "session" {
+		t.Fatalf("Type = %#v; want %#v", got[0].Type, "session")
+	}
+	if got[0].BytesWritten != int64(len(testBytes)) {
+		t.Fatalf("BytesWritten = %d; want %d", got[0].BytesWritten, len(testBytes))
+	}
+}
@@ -0,0 +1,204 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	forwardedStreamLocalChannelType = "forwarded-streamlocal@openssh.com"
+)
+
+// direct-streamlocal@openssh.com data struct as specified in OpenSSH's
+// PROTOCOL, Section 2.4
+type localStreamForwardChannelData struct {
+	SocketPath string
+
+	Reserved0 string
+	Reserved1 uint32
+}
+
+type remoteStreamForwardRequest struct {
+	SocketPath string
+}
+
+type remoteStreamForwardChannelData struct {
+	SocketPath string
+	Reserved   string
+}
+
+// DirectStreamLocalHandler can be enabled by adding it to the server's
+// ChannelHandlers under direct-streamlocal@openssh.com, forwarding the
+// connections of ssh -L to Unix sockets of the server, as allowed by
+// LocalUnixForwardingCallback.
+func DirectStreamLocalHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx Context) {
+	d := localStreamForwardChannelData{}
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+		newChan.Reject(gossh.ConnectionFailed, message(ctx, MessageForwardData, err))
+		return
+	}
+
+	reject := func(reason gossh.RejectionReason, msg string) {
+		audit(ctx, AuditEvent{Type: AuditLocalForward, Outcome: AuditFailure, Destination: d.SocketPath, Reason: msg})
+		newChan.Reject(reason, msg)
+	}
+	if !srv.FeatureEnabled(FeatureLocalForwarding) {
+		reject(gossh.Prohibited, message(ctx, MessageFeatureDisabled, FeatureLocalForwarding))
+		return
+	}
+	if srv.LocalUnixForwardingCallback == nil || !srv.LocalUnixForwardingCallback(ctx, d.SocketPath) {
+		reject(gossh.Prohibited, message(ctx, MessageForwardDisabled))
+		return
+	}
+
+	var dialer net.Dialer
+	dconn, err := dialer.DialContext(ctx, "unix", d.SocketPath)
+	if err != nil {
+		reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	audit(ctx, AuditEvent{Type: AuditLocalForward, Outcome: AuditSuccess, Destination: d.SocketPath})
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		dconn.Close()
+		return
+	}
+	ch = srv.trackChannel(ctx, newChan.ChannelType(), ch)
+	go gossh.DiscardRequests(reqs)
+
+	spawn(ctx, func() {
+		defer ch.Close()
+		defer dconn.Close()
+		io.Copy(ch, dconn)
+	})
+	spawn(ctx, func() {
+		defer ch.Close()
+		defer dconn.Close()
+		io.Copy(dconn, ch)
+	})
+}
+
+// ForwardedStreamLocalHandler can be enabled by creating a
+// ForwardedStreamLocalHandler and adding the HandleSSHRequest callback to the
+// server's RequestHandlers under streamlocal-forward@openssh.com and
+// cancel-streamlocal-forward@openssh.com, listening on the Unix sockets of
+// ssh -R as allowed by ReverseUnixForwardingCallback. Listeners are created
+// with ReversePortForwardingListenerCallback, if set, on the "unix" network.
+type ForwardedStreamLocalHandler struct {
+	forwards map[forwardKey]net.Listener
+	sync.Mutex
+}
+
+func (h *ForwardedStreamLocalHandler) HandleSSHRequest(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
+	h.Lock()
+	if h.forwards == nil {
+		h.forwards = make(map[forwardKey]net.Listener)
+	}
+	h.Unlock()
+	conn := ctx.Value(ContextKeyConn).(*gossh.ServerConn)
+	switch req.Type {
+	case "streamlocal-forward@openssh.com":
+		var reqPayload remoteStreamForwardRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			return false, []byte{}
+		}
+		deny := func(reason string) (bool, []byte) {
+			audit(ctx, AuditEvent{Type: AuditReverseForward, Outcome: AuditFailure, Destination: reqPayload.SocketPath, Reason: reason})
+			return false, []byte(reason)
+		}
+		if !srv.FeatureEnabled(FeatureReverseForwarding) {
+			return deny(message(ctx, MessageFeatureDisabled, FeatureReverseForwarding))
+		}
+		if srv.ReverseUnixForwardingCallback == nil || !srv.ReverseUnixForwardingCallback(ctx, reqPayload.SocketPath) {
+			return deny(message(ctx, MessageForwardDisabled))
+		}
+		key := forwardKey{conn, reqPayload.SocketPath}
+		h.Lock()
+		_, dup := h.forwards[key]
+		h.Unlock()
+		if dup {
+			return false, []byte{}
+		}
+		listen := net.Listen
+		if srv.ReversePortForwardingListenerCallback != nil {
+			listen = func(network, addr string) (net.Listener, error) {
+				return srv.ReversePortForwardingListenerCallback(ctx, network, addr)
+			}
+		}
+		ln, err := listen("unix", reqPayload.SocketPath)
+		if err != nil {
+			return false, []byte{}
+		}
+		h.Lock()
+		if _, ok := h.forwards[key]; ok {
+			h.Unlock()
+			ln.Close()
+			return false, []byte{}
+		}
+		h.forwards[key] = ln
+		h.Unlock()
+		release := trackForward(ctx)
+		audit(ctx, AuditEvent{Type: AuditReverseForward, Outcome: AuditSuccess, Destination: reqPayload.SocketPath})
+		spawn(ctx, func() {
+			<-ctx.Done()
+			ln.Close()
+		})
+		spawn(ctx, func() {
+			payload := gossh.Marshal(&remoteStreamForwardChannelData{SocketPath: reqPayload.SocketPath})
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					break
+				}
+				spawn(ctx, func() {
+					ch, reqs, err := conn.OpenChannel(forwardedStreamLocalChannelType, payload)
+					if err != nil {
+						c.Close()
+						return
+					}
+					ch = srv.trackChannel(ctx, forwardedStreamLocalChannelType, ch)
+					go gossh.DiscardRequests(reqs)
+					spawn(ctx, func() {
+						defer ch.Close()
+						defer c.Close()
+						io.Copy(ch, c)
+					})
+					spawn(ctx, func() {
+						defer ch.Close()
+						defer c.Close()
+						io.Copy(c, ch)
+					})
+				})
+			}
+			h.Lock()
+			if h.forwards[key] == ln {
+				delete(h.forwards, key)
+			}
+			h.Unlock()
+			release()
+		})
+		return true, nil
+
+	case "cancel-streamlocal-forward@openssh.com":
+		var reqPayload remoteStreamForwardRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			return false, []byte{}
+		}
+		key := forwardKey{conn, reqPayload.SocketPath}
+		h.Lock()
+		ln, ok := h.forwards[key]
+		delete(h.forwards, key)
+		h.Unlock()
+		if !ok {
+			return false, nil
+		}
+		ln.Close()
+		return true, nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalUnixForwarding(t *testing.T) {
+	t.Parallel()
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(sampleServerResponse)
+		conn.Close()
+	}()
+
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		LocalUnixForwardingCallback: func(ctx Context, socketPath string) bool {
+			return socketPath == sock
+		},
+	}, nil)
+	defer cleanup()
+
+	if _, err := client.Dial("unix", sock+".other"); err == nil || !strings.Contains(err.Error(), "port forwarding is disabled") {
+		t.Fatalf("Dial err = %v; want port forwarding is disabled", err)
+	}
+	conn, err := client.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, sampleServerResponse) {
+		t.Fatalf("result = %#v; want %#v", result, sampleServerResponse)
+	}
+}
+
+func TestReverseUnixForwarding(t *testing.T) {
+	t.Parallel()
+	sock := filepath.Join(t.TempDir(), "remote.sock")
+	forwardHandler := &ForwardedStreamLocalHandler{}
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		RequestHandlers: map[string]RequestHandler{
+			"streamlocal-forward@openssh.com":        forwardHandler.HandleSSHRequest,
+			"cancel-streamlocal-forward@openssh.com": forwardHandler.HandleSSHRequest,
+		},
+		ReverseUnixForwardingCallback: func(ctx Context, socketPath string) bool {
+			return socketPath == sock
+		},
+	}, nil)
+	defer cleanup()
+
+	if _, err := client.ListenUnix(sock + ".other"); err == nil {
+		t.Fatal("ListenUnix succeeded for a denied path")
+	}
+	ln, err := client.ListenUnix(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(sampleServerResponse)
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, sampleServerResponse) {
+		t.Fatalf("result = %#v; want %#v", result, sampleServerResponse)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListenUnix(sock); err != nil {
+		t.Fatalf("ListenUnix after cancel err = %v; want nil", err)
+	}
+}
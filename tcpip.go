@@ -1,8 +1,9 @@
 package ssh
 
 import (
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"strconv"
 	"sync"
@@ -23,46 +24,117 @@ type localForwardChannelData struct {
 	OriginPort uint32
 }
 
+// DirectTCPIP describes a direct-tcpip channel request, which opens a local
+// port forwarding connection. The originator is claimed by the client and
+// usually is the address of the program connecting to the forwarded port on
+// the client side, not the address of the client itself.
+type DirectTCPIP struct {
+	DestAddr string
+	DestPort uint32
+
+	OriginAddr string
+	OriginPort uint32
+}
+
+// Origin returns the originator as a net.Addr, or nil if the client didn't
+// send an IP address.
+func (d DirectTCPIP) Origin() net.Addr {
+	ip := net.ParseIP(d.OriginAddr)
+	if ip == nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: int(d.OriginPort)}
+}
+
+// originMatches reports whether the originator of d is the host of the peer
+// of ctx.
+func (d DirectTCPIP) originMatches(ctx Context) bool {
+	origin, ok := d.Origin().(*net.TCPAddr)
+	if !ok || ctx.RemoteAddr() == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(ctx.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	return origin.IP.Equal(net.ParseIP(host))
+}
+
 // DirectTCPIPHandler can be enabled by adding it to the server's
 // ChannelHandlers under direct-tcpip.
 func DirectTCPIPHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx Context) {
 	d := localForwardChannelData{}
 	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
-		newChan.Reject(gossh.ConnectionFailed, "error parsing forward data: "+err.Error())
+		newChan.Reject(gossh.ConnectionFailed, message(ctx, MessageForwardData, err))
 		return
 	}
 
-	if srv.LocalPortForwardingCallback == nil || !srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort) {
-		newChan.Reject(gossh.Prohibited, "port forwarding is disabled")
+	dest := net.JoinHostPort(d.DestAddr, strconv.FormatInt(int64(d.DestPort), 10))
+	reject := func(reason gossh.RejectionReason, msg string) {
+		audit(ctx, AuditEvent{
+			Type:        AuditLocalForward,
+			Outcome:     AuditFailure,
+			Destination: dest,
+			Origin:      net.JoinHostPort(d.OriginAddr, strconv.Itoa(int(d.OriginPort))),
+			Reason:      msg,
+		})
+		newChan.Reject(reason, msg)
+	}
+	if !srv.FeatureEnabled(FeatureLocalForwarding) {
+		reject(gossh.Prohibited, message(ctx, MessageFeatureDisabled, FeatureLocalForwarding))
+		return
+	}
+	req := DirectTCPIP(d)
+	if srv.VerifyForwardOrigin && !req.originMatches(ctx) {
+		reject(gossh.Prohibited, message(ctx, MessageForwardOrigin))
+		return
+	}
+	var allowed bool
+	switch {
+	case srv.DirectTCPIPCallback != nil:
+		allowed = srv.DirectTCPIPCallback(ctx, req)
+	case srv.LocalPortForwardingCallback != nil:
+		allowed = srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort)
+	}
+	if !allowed || !permits(ctx.Permissions().PermitOpen(), d.DestAddr, d.DestPort) {
+		reject(gossh.Prohibited, message(ctx, MessageForwardDisabled))
 		return
 	}
 
-	dest := net.JoinHostPort(d.DestAddr, strconv.FormatInt(int64(d.DestPort), 10))
-
-	var dialer net.Dialer
-	dconn, err := dialer.DialContext(ctx, "tcp", dest)
+	dconn, err := srv.forwardDialer().DialContext(ctx, "tcp", dest)
+	if errors.Is(err, ErrAddressDenied) {
+		reject(gossh.Prohibited, err.Error())
+		return
+	}
 	if err != nil {
-		newChan.Reject(gossh.ConnectionFailed, err.Error())
+		reject(gossh.ConnectionFailed, err.Error())
 		return
 	}
+	audit(ctx, AuditEvent{
+		Type:        AuditLocalForward,
+		Outcome:     AuditSuccess,
+		Destination: dest,
+		Origin:      net.JoinHostPort(d.OriginAddr, strconv.Itoa(int(d.OriginPort))),
+	})
 
 	ch, reqs, err := newChan.Accept()
 	if err != nil {
 		dconn.Close()
 		return
 	}
+	ch = srv.trackChannel(ctx, newChan.ChannelType(), ch)
 	go gossh.DiscardRequests(reqs)
 
-	go func() {
+	spawn(ctx, func() {
 		defer ch.Close()
 		defer dconn.Close()
 		io.Copy(ch, dconn)
-	}()
-	go func() {
+	})
+	spawn(ctx, func() {
 		defer ch.Close()
 		defer dconn.Close()
 		io.Copy(dconn, ch)
-	}()
+	})
 }
 
 type remoteForwardRequest struct {
@@ -90,14 +162,27 @@ type remoteForwardChannelData struct {
 // adding the HandleSSHRequest callback to the server's RequestHandlers under
 // tcpip-forward and cancel-tcpip-forward.
 type ForwardedTCPHandler struct {
-	forwards map[string]net.Listener
+	forwards map[forwardKey]*reverseForward
 	sync.Mutex
 }
 
+// forwardKey identifies a forward by its connection and the address the
+// client knows it by.
+type forwardKey struct {
+	conn *gossh.ServerConn
+	addr string
+}
+
+// reverseForward is a listening forward.
+type reverseForward struct {
+	net.Listener
+	fwd ReverseForward
+}
+
 func (h *ForwardedTCPHandler) HandleSSHRequest(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
 	h.Lock()
 	if h.forwards == nil {
-		h.forwards = make(map[string]net.Listener)
+		h.forwards = make(map[forwardKey]*reverseForward)
 	}
 	h.Unlock()
 	conn := ctx.Value(ContextKeyConn).(*gossh.ServerConn)
@@ -108,30 +193,67 @@ func (h *ForwardedTCPHandler) HandleSSHRequest(ctx Context, srv *Server, req *go
 			// TODO: log parse failure
 			return false, []byte{}
 		}
+		requested := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
+		deny := func(reason string) (bool, []byte) {
+			audit(ctx, AuditEvent{Type: AuditReverseForward, Outcome: AuditFailure, Destination: requested, Reason: reason})
+			return false, []byte(reason)
+		}
+		if !srv.FeatureEnabled(FeatureReverseForwarding) {
+			return deny(message(ctx, MessageFeatureDisabled, FeatureReverseForwarding))
+		}
 		if srv.ReversePortForwardingCallback == nil || !srv.ReversePortForwardingCallback(ctx, reqPayload.BindAddr, reqPayload.BindPort) {
-			return false, []byte("port forwarding is disabled")
+			return deny(message(ctx, MessageForwardDisabled))
+		}
+		if !permits(ctx.Permissions().PermitListen(), reqPayload.BindAddr, reqPayload.BindPort) {
+			return deny(message(ctx, MessageForwardDisabled))
 		}
-		addr := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
-		ln, err := net.Listen("tcp", addr)
+		bindHost, bindPort := reqPayload.BindAddr, reqPayload.BindPort
+		if srv.ReversePortForwardingBindCallback != nil {
+			var ok bool
+			bindHost, bindPort, ok = srv.ReversePortForwardingBindCallback(ctx, bindHost, bindPort)
+			if !ok {
+				return deny(message(ctx, MessageForwardDisabled))
+			}
+		}
+		listen := net.Listen
+		if srv.ReversePortForwardingListenerCallback != nil {
+			listen = func(network, addr string) (net.Listener, error) {
+				return srv.ReversePortForwardingListenerCallback(ctx, network, addr)
+			}
+		}
+		ln, err := listen("tcp", net.JoinHostPort(bindHost, strconv.Itoa(int(bindPort))))
 		if err != nil {
 			// TODO: log listen failure
 			return false, []byte{}
 		}
-		_, destPortStr, _ := net.SplitHostPort(ln.Addr().String())
-		destPort, _ := strconv.Atoi(destPortStr)
+		// the client refers to the forward by the port it requested, unless
+		// it asked for one to be allocated
+		destPort := reqPayload.BindPort
+		if destPort == 0 {
+			_, portStr, _ := net.SplitHostPort(ln.Addr().String())
+			port, _ := strconv.Atoi(portStr)
+			destPort = uint32(port)
+		}
+		key := forwardKey{conn, net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(destPort)))}
+		fwd := ReverseForward{BindHost: reqPayload.BindAddr, BindPort: destPort, Addr: ln.Addr()}
 		h.Lock()
-		h.forwards[addr] = ln
+		if _, ok := h.forwards[key]; ok {
+			h.Unlock()
+			ln.Close()
+			return false, []byte{}
+		}
+		h.forwards[key] = &reverseForward{ln, fwd}
 		h.Unlock()
-		go func() {
+		release := trackForward(ctx)
+		audit(ctx, AuditEvent{Type: AuditReverseForward, Outcome: AuditSuccess, Destination: key.addr})
+		if srv.ReversePortForwardingBoundCallback != nil {
+			srv.ReversePortForwardingBoundCallback(ctx, fwd)
+		}
+		spawn(ctx, func() {
 			<-ctx.Done()
-			h.Lock()
-			ln, ok := h.forwards[addr]
-			h.Unlock()
-			if ok {
-				ln.Close()
-			}
-		}()
-		go func() {
+			ln.Close()
+		})
+		spawn(ctx, func() {
 			for {
 				c, err := ln.Accept()
 				if err != nil {
@@ -142,36 +264,39 @@ func (h *ForwardedTCPHandler) HandleSSHRequest(ctx Context, srv *Server, req *go
 				originPort, _ := strconv.Atoi(orignPortStr)
 				payload := gossh.Marshal(&remoteForwardChannelData{
 					DestAddr:   reqPayload.BindAddr,
-					DestPort:   uint32(destPort),
+					DestPort:   destPort,
 					OriginAddr: originAddr,
 					OriginPort: uint32(originPort),
 				})
-				go func() {
+				spawn(ctx, func() {
 					ch, reqs, err := conn.OpenChannel(forwardedTCPChannelType, payload)
 					if err != nil {
-						// TODO: log failure to open channel
-						log.Println(err)
+						srv.logf(ctx, slog.LevelWarn, "forwarded channel open failed", "error", err.Error())
 						c.Close()
 						return
 					}
+					ch = srv.trackChannel(ctx, forwardedTCPChannelType, ch)
 					go gossh.DiscardRequests(reqs)
-					go func() {
+					spawn(ctx, func() {
 						defer ch.Close()
 						defer c.Close()
 						io.Copy(ch, c)
-					}()
-					go func() {
+					})
+					spawn(ctx, func() {
 						defer ch.Close()
 						defer c.Close()
 						io.Copy(c, ch)
-					}()
-				}()
+					})
+				})
 			}
 			h.Lock()
-			delete(h.forwards, addr)
+			if rf := h.forwards[key]; rf != nil && rf.Listener == ln {
+				delete(h.forwards, key)
+			}
 			h.Unlock()
-		}()
-		return true, gossh.Marshal(&remoteForwardSuccess{uint32(destPort)})
+			release()
+		})
+		return true, gossh.Marshal(&remoteForwardSuccess{destPort})
 
 	case "cancel-tcpip-forward":
 		var reqPayload remoteForwardCancelRequest
@@ -179,12 +304,17 @@ func (h *ForwardedTCPHandler) HandleSSHRequest(ctx Context, srv *Server, req *go
 			// TODO: log parse failure
 			return false, []byte{}
 		}
-		addr := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
+		key := forwardKey{conn, net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))}
 		h.Lock()
-		ln, ok := h.forwards[addr]
+		rf, ok := h.forwards[key]
+		delete(h.forwards, key)
 		h.Unlock()
-		if ok {
-			ln.Close()
+		if !ok {
+			return false, nil
+		}
+		rf.Close()
+		if srv.ReversePortForwardingCancelCallback != nil {
+			srv.ReversePortForwardingCancelCallback(ctx, rf.fwd)
 		}
 		return true, nil
 	default:
@@ -2,11 +2,13 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"net"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -40,6 +42,7 @@ func newTestSessionWithForwarding(t *testing.T, forwardingEnabled bool) (net.Lis
 			}
 			return forwardingEnabled
 		},
+		ForwardAddressPolicy: &AddressPolicy{AllowLoopback: true},
 	}, nil)
 
 	return l, client, func() {
@@ -81,3 +84,278 @@ func TestLocalPortForwardingRespectsCallback(t *testing.T) {
 		t.Fatalf("Expected permission error but got %#v", err)
 	}
 }
+
+func TestReversePortForwardingListenerCallback(t *testing.T) {
+	t.Parallel()
+
+	forwardHandler := &ForwardedTCPHandler{}
+	listened := make(chan string, 1)
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		ReversePortForwardingListenerCallback: func(ctx Context, network, addr string) (net.Listener, error) {
+			listened <- addr
+			return net.Listen(network, addr)
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+	}, nil)
+	defer cleanup()
+
+	l, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if addr := <-listened; addr != "127.0.0.1:0" {
+		t.Fatalf("listener callback addr = %#v; want %#v", addr, "127.0.0.1:0")
+	}
+}
+
+// holdListener is a listener whose Accept, once the listener is closed,
+// doesn't return until hold is closed.
+type holdListener struct {
+	net.Listener
+	hold chan struct{}
+}
+
+func (l holdListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.hold
+	}
+	return c, err
+}
+
+func TestReversePortForwardingShutdown(t *testing.T) {
+	t.Parallel()
+	forwardHandler := &ForwardedTCPHandler{}
+	hold := make(chan struct{})
+	l := newLocalListener()
+	srv := &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		ReversePortForwardingListenerCallback: func(ctx Context, network, addr string) (net.Listener, error) {
+			ln, err := net.Listen(network, addr)
+			return holdListener{ln, hold}, err
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+	}
+	go srv.Serve(l)
+	client, err := gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a forwarded connection is open when the client goes away
+	c, err := net.Dial("tcp", fwd.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := fwd.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	// the accept loop of the forward is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %#v with the forward running; want %#v", err, context.DeadlineExceeded)
+	}
+	close(hold)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %#v once the forward ended; want nil", err)
+	}
+}
+
+func TestDirectTCPIPOrigin(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := newContext(&Server{})
+	defer cancel()
+	ctx.SetValue(ContextKeyRemoteAddr, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 50000})
+
+	for _, tt := range []struct {
+		origin string
+		want   bool
+	}{
+		{"192.0.2.1", true},
+		{"127.0.0.1", false},
+		{"localhost", false},
+		{"", false},
+	} {
+		d := DirectTCPIP{DestAddr: "db", DestPort: 5432, OriginAddr: tt.origin, OriginPort: 4242}
+		if got := d.originMatches(ctx); got != tt.want {
+			t.Fatalf("originMatches(%#v) = %v; want %v", tt.origin, got, tt.want)
+		}
+	}
+	want := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4242}
+	if got := (DirectTCPIP{OriginAddr: "192.0.2.1", OriginPort: 4242}).Origin(); got.String() != want.String() {
+		t.Fatalf("Origin() = %v; want %v", got, want)
+	}
+}
+
+func TestReversePortForwardingBind(t *testing.T) {
+	t.Parallel()
+
+	forwardHandler := &ForwardedTCPHandler{}
+	bound := make(chan ReverseForward, 2)
+	canceled := make(chan ReverseForward, 2)
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		// privileged ports are moved to an allocated one
+		ReversePortForwardingBindCallback: func(ctx Context, bindHost string, bindPort uint32) (string, uint32, bool) {
+			if bindPort < 1024 {
+				bindPort = 0
+			}
+			return bindHost, bindPort, true
+		},
+		ReversePortForwardingBoundCallback: func(ctx Context, fwd ReverseForward) {
+			bound <- fwd
+		},
+		ReversePortForwardingCancelCallback: func(ctx Context, fwd ReverseForward) {
+			canceled <- fwd
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+	}, nil)
+	defer cleanup()
+
+	for _, requested := range []string{"127.0.0.1:0", "127.0.0.1:22"} {
+		l, err := client.Listen("tcp", requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fwd := <-bound
+		if requested == "127.0.0.1:0" && l.Addr().String() != fwd.Addr.String() {
+			t.Fatalf("allocated port = %s; want %s", l.Addr(), fwd.Addr)
+		}
+		if requested == "127.0.0.1:22" && fwd.BindPort != 22 {
+			t.Fatalf("BindPort = %d; want 22", fwd.BindPort)
+		}
+		go func() {
+			conn, err := net.Dial("tcp", fwd.Addr.String())
+			if err == nil {
+				conn.Write(sampleServerResponse)
+				conn.Close()
+			}
+		}()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, _ := ioutil.ReadAll(conn)
+		if !bytes.Equal(result, sampleServerResponse) {
+			t.Fatalf("result = %#v; want %#v", result, sampleServerResponse)
+		}
+		l.Close()
+		if c := <-canceled; c.BindPort != fwd.BindPort || c.Addr.String() != fwd.Addr.String() {
+			t.Fatalf("canceled %#v; want %#v", c, fwd)
+		}
+	}
+}
+
+func TestReversePortForwardingLate(t *testing.T) {
+	t.Parallel()
+
+	forwardHandler := &ForwardedTCPHandler{}
+	canceled := make(chan ReverseForward, 1)
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		ReversePortForwardingListenerCallback: func(ctx Context, network, addr string) (net.Listener, error) {
+			time.Sleep(200 * time.Millisecond)
+			return net.Listen(network, addr)
+		},
+		ReversePortForwardingCancelCallback: func(ctx Context, fwd ReverseForward) {
+			canceled <- fwd
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+		RequestTimeout: 20 * time.Millisecond,
+	}, nil)
+	defer cleanup()
+
+	if _, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Fatal("late forward succeeded")
+	}
+	select {
+	case fwd := <-canceled:
+		if _, err := net.Dial("tcp", fwd.Addr.String()); err == nil {
+			t.Fatalf("late forward %v still listening", fwd.Addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("late forward not canceled")
+	}
+}
+
+func TestPermitOpenListen(t *testing.T) {
+	t.Parallel()
+
+	l := sampleSocketServer()
+	defer l.Close()
+	forwardHandler := &ForwardedTCPHandler{}
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		PasswordHandler: func(ctx Context, password string) bool {
+			ctx.Permissions().SetPermitOpen(l.Addr().String())
+			ctx.Permissions().SetPermitListen("localhost:*")
+			return true
+		},
+		LocalPortForwardingCallback: func(ctx Context, destinationHost string, destinationPort uint32) bool {
+			return true
+		},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        forwardHandler.HandleSSHRequest,
+			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+		},
+		ForwardAddressPolicy: &AddressPolicy{AllowLoopback: true},
+	}, nil)
+	defer cleanup()
+
+	conn, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() to a permitted destination = %#v", err)
+	}
+	conn.Close()
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Fatal("Dial() to a destination not permitted succeeded")
+	}
+	fwd, err := client.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Listen() on a permitted address = %#v", err)
+	}
+	fwd.Close()
+	if _, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Fatal("Listen() on an address not permitted succeeded")
+	}
+}
@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ALPNProtocol is the application protocol negotiated for SSH over TLS.
+const ALPNProtocol = "ssh"
+
+// ServeTLS accepts incoming connections on the Listener l, terminates TLS
+// and then serves SSH over it like Serve. This allows running the server on
+// port 443 behind SNI routers and through middleboxes only passing TLS.
+//
+// Files containing a certificate and matching private key for the server
+// must be provided if neither the Server's TLSConfig.Certificates nor
+// TLSConfig.GetCertificate are populated. ALPNProtocol is added to the
+// NextProtos of the config, so clients offering ALPN must offer "ssh".
+//
+// ServeTLS always returns a non-nil error.
+func (srv *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	var config *tls.Config
+	if srv.TLSConfig == nil {
+		config = &tls.Config{}
+	} else {
+		config = srv.TLSConfig.Clone()
+	}
+	if !hasALPN(config.NextProtos, ALPNProtocol) {
+		config.NextProtos = append(config.NextProtos, ALPNProtocol)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return srv.Serve(tls.NewListener(l, config))
+}
+
+// ListenAndServeTLS listens on the TCP network address srv.Addr and then
+// calls ServeTLS. If srv.Addr is blank, ":443" is used.
+func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":443"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.ServeTLS(ln, certFile, keyFile)
+}
+
+// ListenAndServeTLS listens on the TCP network address addr and then calls
+// ServeTLS with handler to handle sessions on incoming connections.
+// Functional options can be provided to configure the server.
+func ListenAndServeTLS(addr, certFile, keyFile string, handler Handler, options ...Option) error {
+	srv := &Server{Addr: addr, Handler: handler}
+	for _, option := range options {
+		if err := srv.SetOption(option); err != nil {
+			return err
+		}
+	}
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+// TLSConnectionState returns the state of the TLS layer of a connection
+// accepted by ServeTLS, such as the server name requested through SNI.
+func TLSConnectionState(ctx Context) (tls.ConnectionState, bool) {
+	conn, ok := ctx.Value(contextKeyServerConn).(*serverConn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	tc, ok := conn.Conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tc.ConnectionState(), true
+}
+
+func hasALPN(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ssh.example.com"},
+		DNSNames:     []string{"ssh.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServeTLS(t *testing.T) {
+	t.Parallel()
+	serverNames := make(chan string, 1)
+	srv := &Server{
+		Handler: func(s Session) {
+			state, _ := TLSConnectionState(s.Context().(Context))
+			serverNames <- state.ServerName
+		},
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}},
+	}
+	l := newLocalListener()
+	go srv.ServeTLS(l, "", "")
+	defer srv.Close()
+
+	tlsConn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		ServerName:         "ssh.example.com",
+		NextProtos:         []string{ALPNProtocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != ALPNProtocol {
+		t.Fatalf("NegotiatedProtocol = %#v; want %#v", proto, ALPNProtocol)
+	}
+	c, chans, reqs, err := gossh.NewClientConn(tlsConn, l.Addr().String(), &gossh.ClientConfig{
+		User:            "testuser",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := gossh.NewClient(c, chans, reqs)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if name := <-serverNames; name != "ssh.example.com" {
+		t.Fatalf("ServerName = %#v; want %#v", name, "ssh.example.com")
+	}
+}
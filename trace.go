@@ -0,0 +1,70 @@
+package ssh
+
+import (
+	"context"
+	"net"
+)
+
+// TracerProvider starts the spans traced by a server, which are:
+//
+//	ssh.conn       a connection, from the start of its handshake until it
+//	               ended, with ssh.remote_addr, ssh.user, ssh.session_id,
+//	               ssh.client_version and ssh.close_reason
+//	ssh.channel    a session, direct-tcpip or forwarded-tcpip channel, as a
+//	               child of its connection, with ssh.channel_type,
+//	               ssh.bytes_in and ssh.bytes_out
+//	ssh.exec       a shell, exec or subsystem request of a session channel,
+//	ssh.shell      as a child of the channel, with ssh.command or
+//	ssh.subsystem  ssh.subsystem and ssh.exit_status or ssh.exit_signal
+//
+// It has the shape of the TracerProvider of OpenTelemetry, without the
+// dependency, so adapting one takes a few lines, as in _examples/ssh-otel.
+type TracerProvider interface {
+	// StartSpan starts a span named name as a child of the span in ctx, if
+	// any, and returns it with a context carrying it.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a TracerProvider.
+type Span interface {
+	// SetAttribute sets an attribute of the span, whose value is a
+	// string, an int64 or a bool.
+	SetAttribute(key string, value any)
+	// RecordError records err as the error the span ended with.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// nopSpan is the Span of servers without a TracerProvider.
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value any) {}
+func (nopSpan) RecordError(err error)              {}
+func (nopSpan) End()                               {}
+
+// startSpan starts a span of the TracerProvider of srv, if any, as a child
+// of the span in parent.
+func (srv *Server) startSpan(parent context.Context, name string) (context.Context, Span) {
+	if srv.TracerProvider == nil {
+		return parent, nopSpan{}
+	}
+	return srv.TracerProvider.StartSpan(parent, name)
+}
+
+// traceConn starts the span of the connection conn of ctx.
+func (srv *Server) traceConn(ctx Context, conn *serverConn) {
+	conn.spanCtx, conn.span = srv.startSpan(ctx, "ssh.conn")
+	if addr, ok := ctx.Value(ContextKeyRemoteAddr).(net.Addr); ok {
+		conn.span.SetAttribute("ssh.remote_addr", addr.String())
+	}
+}
+
+// spanContext returns the context carrying the span of the connection of
+// ctx, or ctx if it has none.
+func spanContext(ctx Context) context.Context {
+	if conn, ok := ctx.Value(contextKeyServerConn).(*serverConn); ok && conn.spanCtx != nil {
+		return conn.spanCtx
+	}
+	return ctx
+}
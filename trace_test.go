@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanKey struct{}
+
+// recordTracer is a TracerProvider keeping the spans it starts.
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []*recordSpan
+	ended chan *recordSpan
+}
+
+type recordSpan struct {
+	name   string
+	parent *recordSpan
+	attrs  map[string]any
+	tracer *recordTracer
+}
+
+func (t *recordTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordSpan)
+	span := &recordSpan{name: name, parent: parent, attrs: map[string]any{}, tracer: t}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordSpan) SetAttribute(key string, value any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *recordSpan) RecordError(err error) { s.SetAttribute("error", err.Error()) }
+func (s *recordSpan) End()                  { s.tracer.ended <- s }
+
+func TestTracerProvider(t *testing.T) {
+	t.Parallel()
+	tracer := &recordTracer{ended: make(chan *recordSpan, 10)}
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			s.Exit(3)
+		},
+		TracerProvider: tracer,
+	}, nil)
+	session.Run("true")
+	cleanup()
+
+	ended := map[string]*recordSpan{}
+	for len(ended) < 3 {
+		select {
+		case span := <-tracer.ended:
+			ended[span.name] = span
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ended spans = %v; want ssh.conn, ssh.channel and ssh.exec", ended)
+		}
+	}
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	conn, channel, exec := ended["ssh.conn"], ended["ssh.channel"], ended["ssh.exec"]
+	if conn == nil || channel == nil || exec == nil {
+		t.Fatalf("ended spans = %v; want ssh.conn, ssh.channel and ssh.exec", ended)
+	}
+	if channel.parent != conn || exec.parent != channel {
+		t.Fatal("spans not nested as connection, channel and exec")
+	}
+	if conn.attrs["ssh.user"] != "testuser" || conn.attrs["ssh.remote_addr"] == nil || conn.attrs["ssh.close_reason"] != "client-eof" {
+		t.Fatalf("ssh.conn attributes = %#v", conn.attrs)
+	}
+	if channel.attrs["ssh.channel_type"] != "session" {
+		t.Fatalf("ssh.channel attributes = %#v", channel.attrs)
+	}
+	if exec.attrs["ssh.command"] != "true" || exec.attrs["ssh.exit_status"] != int64(3) {
+		t.Fatalf("ssh.exec attributes = %#v", exec.attrs)
+	}
+}
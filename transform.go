@@ -0,0 +1,234 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// SessionTransforms wrap the streams of a session between its channel and
+// the Handler, such as to normalize newlines, convert charsets or redact
+// secrets from the output. A nil function leaves its stream as it is. The
+// transformers of golang.org/x/text/transform fit as they are:
+//
+//	Stdout: func(w io.Writer) io.WriteCloser {
+//		return transform.NewWriter(w, charmap.ISO8859_1.NewEncoder())
+//	}
+type SessionTransforms struct {
+	// Stdin wraps the input of the session, as read by the Handler.
+	Stdin func(r io.Reader) io.Reader
+
+	// Stdout and Stderr wrap the output of the session. The writers may
+	// buffer output, which is flushed by closing them once the session
+	// exits, before its exit status is sent. Writes to them are
+	// serialized.
+	Stdout func(w io.Writer) io.WriteCloser
+	Stderr func(w io.Writer) io.WriteCloser
+}
+
+// sessionTransforms are the wrapped streams of a session.
+type sessionTransforms struct {
+	stdin  io.Reader
+	mu     sync.Mutex // serializes writes to stdout and stderr
+	stdout io.WriteCloser
+	stderr io.WriteCloser
+	once   sync.Once
+}
+
+// writerFunc is an io.Writer without a Close method, so transformers
+// closing the writer they wrap can't close the channel.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// transform installs the transforms returned by cb on the session.
+func (sess *session) transform(cb SessionTransformCallback) {
+	transforms := cb(sess)
+	t := &sessionTransforms{}
+	if transforms.Stdin != nil {
+		t.stdin = transforms.Stdin(readerFunc(sess.readInput))
+	}
+	if transforms.Stdout != nil {
+		t.stdout = transforms.Stdout(writerFunc(sess.writeStdout))
+	}
+	if transforms.Stderr != nil {
+		t.stderr = transforms.Stderr(writerFunc(sess.stderr().Write))
+	}
+	sess.Lock()
+	sess.transforms = t
+	sess.Unlock()
+}
+
+func (sess *session) getTransforms() *sessionTransforms {
+	sess.Lock()
+	defer sess.Unlock()
+	return sess.transforms
+}
+
+// flushTransforms closes the output transforms of the session, once.
+func (sess *session) flushTransforms() {
+	t := sess.getTransforms()
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.stdout != nil {
+			t.stdout.Close()
+		}
+		if t.stderr != nil {
+			t.stderr.Close()
+		}
+	})
+}
+
+// transformedStderr is the stderr of a session with a Stderr transform.
+type transformedStderr struct {
+	io.ReadWriter
+	t *sessionTransforms
+}
+
+func (s transformedStderr) Write(p []byte) (int, error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	return s.t.stderr.Write(p)
+}
+
+// NormalizeNewlines is a Stdin transform translating the CRLF and CR line
+// endings sent by some clients and terminals to LF, for handlers reading
+// lines.
+func NormalizeNewlines(r io.Reader) io.Reader {
+	return &newlineReader{r: r}
+}
+
+type newlineReader struct {
+	r  io.Reader
+	cr bool // whether the last byte read was a CR
+}
+
+func (nr *newlineReader) Read(p []byte) (int, error) {
+	for {
+		n, err := nr.r.Read(p)
+		out := p[:0]
+		for _, b := range p[:n] {
+			switch {
+			case b == '\r':
+				out = append(out, '\n')
+				nr.cr = true
+			case b == '\n' && nr.cr:
+				nr.cr = false
+			default:
+				out = append(out, b)
+				nr.cr = false
+			}
+		}
+		if len(out) > 0 || err != nil || n == 0 {
+			return len(out), err
+		}
+	}
+}
+
+// redactMaxLine is the longest line RedactOutput buffers; longer lines are
+// redacted in pieces of that length.
+const redactMaxLine = 64 << 10
+
+// redactFlushDelay is how long RedactOutput holds an incomplete line, such
+// as a prompt, waiting for the rest of it.
+const redactFlushDelay = 50 * time.Millisecond
+
+// RedactOutput returns a Stdout or Stderr transform replacing the matches
+// of patterns with replacement, such as to keep tokens and keys printed by
+// commands out of session recordings and terminals. Output is redacted a
+// line at a time, so patterns can't match across lines. An incomplete line
+// is written once no output followed it for 50ms, so prompts show up, and
+// patterns can't match across writes that far apart either.
+func RedactOutput(replacement string, patterns ...*regexp.Regexp) func(w io.Writer) io.WriteCloser {
+	return func(w io.Writer) io.WriteCloser {
+		return &redactWriter{w: w, replacement: []byte(replacement), patterns: patterns}
+	}
+}
+
+type redactWriter struct {
+	w           io.Writer
+	replacement []byte
+	patterns    []*regexp.Regexp
+
+	mu     sync.Mutex
+	line   []byte      // incomplete last line
+	timer  *time.Timer // flushes line
+	closed bool
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.line = append(rw.line, p...)
+	end := bytes.LastIndexByte(rw.line, '\n') + 1
+	if end == 0 && len(rw.line) >= redactMaxLine {
+		end = redactMaxLine
+	}
+	if end > 0 {
+		if err := rw.emit(rw.line[:end]); err != nil {
+			return 0, err
+		}
+		rw.line = append(rw.line[:0], rw.line[end:]...)
+	}
+	if len(rw.line) > 0 && !rw.closed {
+		if rw.timer == nil {
+			rw.timer = time.AfterFunc(redactFlushDelay, rw.flush)
+		} else {
+			rw.timer.Reset(redactFlushDelay)
+		}
+	}
+	return len(p), nil
+}
+
+// flush writes the redacted incomplete last line.
+func (rw *redactWriter) flush() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed || len(rw.line) == 0 {
+		return
+	}
+	rw.emit(rw.line)
+	rw.line = rw.line[:0]
+}
+
+func (rw *redactWriter) emit(text []byte) error {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(text, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		for _, re := range rw.patterns {
+			line = re.ReplaceAllLiteral(line, rw.replacement)
+		}
+		out.Write(line)
+	}
+	_, err := rw.w.Write(out.Bytes())
+	return err
+}
+
+// Close writes the redacted incomplete last line, without closing the
+// underlying writer.
+func (rw *redactWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.closed = true
+	if rw.timer != nil {
+		rw.timer.Stop()
+	}
+	if len(rw.line) == 0 {
+		return nil
+	}
+	err := rw.emit(rw.line)
+	rw.line = nil
+	return err
+}
@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSessionTransforms(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			input, _ := io.ReadAll(s)
+			s.Write(input)
+			io.WriteString(s, "|token secret-abc123 ")
+			io.WriteString(s, "and secret-")
+			io.WriteString(s, "def")
+			io.WriteString(s.Stderr(), "secret-xyz")
+		},
+		SessionTransformCallback: func(sess Session) SessionTransforms {
+			redact := RedactOutput("***", regexp.MustCompile(`secret-\w+`))
+			return SessionTransforms{Stdin: NormalizeNewlines, Stdout: redact, Stderr: redact}
+		},
+	}, nil)
+	defer cleanup()
+	session.Stdin = strings.NewReader("a\r\nb\rc")
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	if err := session.Run(""); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb\nc|token *** and ***"; stdout.String() != want {
+		t.Fatalf("stdout = %#v; want %#v", stdout.String(), want)
+	}
+	if want := "***"; stderr.String() != want {
+		t.Fatalf("stderr = %#v; want %#v", stderr.String(), want)
+	}
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	t.Parallel()
+	got, err := io.ReadAll(NormalizeNewlines(iotest.OneByteReader(strings.NewReader("a\r\n\r\nb\r\rc\n"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\n\nb\n\nc\n"; string(got) != want {
+		t.Fatalf("NormalizeNewlines = %#v; want %#v", string(got), want)
+	}
+}
+
+func TestRedactOutputPrompt(t *testing.T) {
+	t.Parallel()
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			io.WriteString(s, "secret-abc\nPassword: ")
+			io.ReadAll(s)
+		},
+		SessionTransformCallback: func(sess Session) SessionTransforms {
+			return SessionTransforms{Stdout: RedactOutput("***", regexp.MustCompile(`secret-\w+`))}
+		},
+	}, nil)
+	defer cleanup()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	// the prompt is written while the session waits for input
+	want := "***\nPassword: "
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(stdout, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("stdout = %#v; want %#v", string(got), want)
+	}
+	stdin.Close()
+}
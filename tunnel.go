@@ -0,0 +1,332 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ErrTunnelNotFound is returned by TunnelRegistry.Dial when no tunnel is
+// registered under the requested name.
+var ErrTunnelNotFound = errors.New("ssh: tunnel not found")
+
+// TunnelNameCallback is a hook for mapping a requested reverse forward to the
+// logical name it is registered under in a TunnelRegistry.
+type TunnelNameCallback func(ctx Context, bindHost string, bindPort uint32) string
+
+// TunnelAddrCallback is a hook for assigning the public address a tunnel is
+// reachable at. Returning false rejects the tunnel.
+type TunnelAddrCallback func(t *Tunnel) (host string, port uint32, ok bool)
+
+// TunnelReleaseCallback is a hook for reclaiming the public address assigned
+// to a tunnel once it is no longer used.
+type TunnelReleaseCallback func(t *Tunnel)
+
+const tunnelAddrRequestType = "tunnel-addr@gliderlabs.com"
+
+// tunnelAddrRequest is the payload of the global request sent to the client to
+// announce the public address assigned to one of its tunnels.
+type tunnelAddrRequest struct {
+	Name       string
+	BindAddr   string
+	BindPort   uint32
+	PublicHost string
+	PublicPort uint32
+}
+
+// Tunnel is a reverse forward registered by a connected client. Connections
+// dialed through it are delivered to the client as forwarded-tcpip channels.
+type Tunnel struct {
+	Name     string
+	BindHost string
+	BindPort uint32
+
+	// PublicHost and PublicPort are the public address assigned by the
+	// registry's PublicAddrCallback, if any.
+	PublicHost string
+	PublicPort uint32
+
+	ctx      Context
+	conn     *gossh.ServerConn
+	release  func()
+	assigned bool // PublicAddrCallback assigned the public address
+}
+
+// Context returns the context of the connection that requested the tunnel.
+func (t *Tunnel) Context() Context {
+	return t.ctx
+}
+
+// PublicAddr returns the assigned public address in host:port form, or an
+// empty string if none was assigned.
+func (t *Tunnel) PublicAddr() string {
+	if t.PublicHost == "" {
+		return ""
+	}
+	return net.JoinHostPort(t.PublicHost, strconv.FormatInt(int64(t.PublicPort), 10))
+}
+
+// Dial opens a forwarded-tcpip channel to the client through the tunnel. The
+// origin address and port are reported to the client as the source of the
+// connection.
+func (t *Tunnel) Dial(originAddr string, originPort uint32) (net.Conn, error) {
+	payload := gossh.Marshal(&remoteForwardChannelData{
+		DestAddr:   t.BindHost,
+		DestPort:   t.BindPort,
+		OriginAddr: originAddr,
+		OriginPort: originPort,
+	})
+	ch, reqs, err := t.conn.OpenChannel(forwardedTCPChannelType, payload)
+	if err != nil {
+		return nil, err
+	}
+	go gossh.DiscardRequests(reqs)
+	return &channelConn{
+		Channel: ch,
+		laddr:   newTunnelAddr(t.BindHost, t.BindPort),
+		raddr:   newTunnelAddr(originAddr, originPort),
+	}, nil
+}
+
+// TunnelRegistry can be used instead of ForwardedTCPHandler by servers acting
+// as relays. Rather than listening on the requested bind address, it registers
+// each tcpip-forward request under a logical name so server code can dial
+// through the client's tunnel. Requests for port 0 are rejected, as there is
+// no listener to allocate a port from. It can be enabled by adding the
+// HandleSSHRequest callback to the server's RequestHandlers under
+// tcpip-forward and cancel-tcpip-forward.
+type TunnelRegistry struct {
+	// NameCallback returns the name a tunnel is registered under. If nil, the
+	// requested bind address in host:port form is used.
+	NameCallback TunnelNameCallback
+
+	// PublicAddrCallback assigns the public address of each tunnel. The
+	// address is announced to the client with a tunnel-addr@gliderlabs.com
+	// global request and is available on the Tunnel, so session handlers can
+	// also report it, e.g. on stderr. It isn't called for names already
+	// registered. If nil, no address is assigned.
+	PublicAddrCallback TunnelAddrCallback
+
+	// ReleaseAddrCallback is called with each tunnel PublicAddrCallback
+	// assigned an address to, once the tunnel is canceled, its connection is
+	// closed, or it lost its name to a tunnel registered meanwhile, so the
+	// address can be reused.
+	ReleaseAddrCallback TunnelReleaseCallback
+
+	mu      sync.Mutex
+	tunnels map[string]*Tunnel
+}
+
+// Lookup returns the tunnel registered under name.
+func (r *TunnelRegistry) Lookup(name string) (*Tunnel, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tunnels[name]
+	return t, ok
+}
+
+// Tunnels returns all currently registered tunnels.
+func (r *TunnelRegistry) Tunnels() []*Tunnel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tunnels := make([]*Tunnel, 0, len(r.tunnels))
+	for _, t := range r.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	return tunnels
+}
+
+// TunnelsFor returns the tunnels registered by the connection of ctx.
+func (r *TunnelRegistry) TunnelsFor(ctx Context) []*Tunnel {
+	conn, _ := ctx.Value(ContextKeyConn).(*gossh.ServerConn)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tunnels []*Tunnel
+	for _, t := range r.tunnels {
+		if t.conn == conn {
+			tunnels = append(tunnels, t)
+		}
+	}
+	return tunnels
+}
+
+// Dial opens a connection through the tunnel registered under name.
+func (r *TunnelRegistry) Dial(name, originAddr string, originPort uint32) (net.Conn, error) {
+	t, ok := r.Lookup(name)
+	if !ok {
+		return nil, ErrTunnelNotFound
+	}
+	return t.Dial(originAddr, originPort)
+}
+
+func (r *TunnelRegistry) HandleSSHRequest(ctx Context, srv *Server, req *gossh.Request) (bool, []byte) {
+	r.mu.Lock()
+	if r.tunnels == nil {
+		r.tunnels = make(map[string]*Tunnel)
+	}
+	r.mu.Unlock()
+	conn := ctx.Value(ContextKeyConn).(*gossh.ServerConn)
+	switch req.Type {
+	case "tcpip-forward":
+		var reqPayload remoteForwardRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			return false, []byte{}
+		}
+		if !srv.FeatureEnabled(FeatureTunnels) {
+			return false, []byte(message(ctx, MessageFeatureDisabled, FeatureTunnels))
+		}
+		if srv.ReversePortForwardingCallback == nil || !srv.ReversePortForwardingCallback(ctx, reqPayload.BindAddr, reqPayload.BindPort) {
+			return false, []byte(message(ctx, MessageForwardDisabled))
+		}
+		if !permits(ctx.Permissions().PermitListen(), reqPayload.BindAddr, reqPayload.BindPort) {
+			return false, []byte(message(ctx, MessageForwardDisabled))
+		}
+		// nothing listens on the bind address, so there is no port to
+		// allocate and report for port 0
+		if reqPayload.BindPort == 0 {
+			return false, []byte(message(ctx, MessageTunnelPort))
+		}
+		t := &Tunnel{
+			Name:     r.name(ctx, reqPayload.BindAddr, reqPayload.BindPort),
+			BindHost: reqPayload.BindAddr,
+			BindPort: reqPayload.BindPort,
+			ctx:      ctx,
+			conn:     conn,
+		}
+		// names already taken are refused before an address is assigned
+		if _, exists := r.Lookup(t.Name); exists {
+			return false, []byte(message(ctx, MessageTunnelExists))
+		}
+		if r.PublicAddrCallback != nil {
+			host, port, ok := r.PublicAddrCallback(t)
+			if !ok {
+				return false, []byte(message(ctx, MessageTunnelRejected))
+			}
+			t.PublicHost, t.PublicPort = host, port
+			t.assigned = true
+		}
+		r.mu.Lock()
+		if _, exists := r.tunnels[t.Name]; exists {
+			r.mu.Unlock()
+			r.releaseAddr(t)
+			return false, []byte(message(ctx, MessageTunnelExists))
+		}
+		t.release = trackForward(ctx)
+		r.tunnels[t.Name] = t
+		r.mu.Unlock()
+		if t.PublicHost != "" {
+			conn.SendRequest(tunnelAddrRequestType, false, gossh.Marshal(&tunnelAddrRequest{
+				Name:       t.Name,
+				BindAddr:   t.BindHost,
+				BindPort:   t.BindPort,
+				PublicHost: t.PublicHost,
+				PublicPort: t.PublicPort,
+			}))
+		}
+		spawn(ctx, func() {
+			<-ctx.Done()
+			r.remove(t)
+		})
+		return true, gossh.Marshal(&remoteForwardSuccess{t.BindPort})
+
+	case "cancel-tcpip-forward":
+		var reqPayload remoteForwardCancelRequest
+		if err := gossh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			return false, []byte{}
+		}
+		var canceled []*Tunnel
+		r.mu.Lock()
+		for name, t := range r.tunnels {
+			if t.conn == conn && t.BindHost == reqPayload.BindAddr && t.BindPort == reqPayload.BindPort {
+				delete(r.tunnels, name)
+				canceled = append(canceled, t)
+			}
+		}
+		r.mu.Unlock()
+		for _, t := range canceled {
+			t.release()
+			r.releaseAddr(t)
+		}
+		return len(canceled) > 0, nil
+	default:
+		return false, nil
+	}
+}
+
+func (r *TunnelRegistry) name(ctx Context, bindHost string, bindPort uint32) string {
+	if r.NameCallback != nil {
+		return r.NameCallback(ctx, bindHost, bindPort)
+	}
+	return net.JoinHostPort(bindHost, strconv.FormatInt(int64(bindPort), 10))
+}
+
+func (r *TunnelRegistry) remove(t *Tunnel) {
+	r.mu.Lock()
+	removed := r.tunnels[t.Name] == t
+	if removed {
+		delete(r.tunnels, t.Name)
+	}
+	r.mu.Unlock()
+	if removed {
+		t.release()
+		r.releaseAddr(t)
+	}
+}
+
+// releaseAddr hands the public address of t back to ReleaseAddrCallback.
+func (r *TunnelRegistry) releaseAddr(t *Tunnel) {
+	if t.assigned && r.ReleaseAddrCallback != nil {
+		r.ReleaseAddrCallback(t)
+	}
+}
+
+// channelConn adapts a gossh.Channel to a net.Conn.
+type channelConn struct {
+	gossh.Channel
+	laddr, raddr net.Addr
+}
+
+func (c *channelConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *channelConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *channelConn) SetDeadline(t time.Time) error {
+	return errors.New("ssh: deadline not supported")
+}
+
+func (c *channelConn) SetReadDeadline(t time.Time) error {
+	return errors.New("ssh: deadline not supported")
+}
+
+func (c *channelConn) SetWriteDeadline(t time.Time) error {
+	return errors.New("ssh: deadline not supported")
+}
+
+// tunnelAddr is the net.Addr of either end of a tunneled connection.
+type tunnelAddr struct {
+	host string
+	port uint32
+}
+
+func newTunnelAddr(host string, port uint32) net.Addr {
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	return &tunnelAddr{host, port}
+}
+
+func (a *tunnelAddr) Network() string {
+	return "tcp"
+}
+
+func (a *tunnelAddr) String() string {
+	return net.JoinHostPort(a.host, strconv.FormatInt(int64(a.port), 10))
+}
@@ -0,0 +1,214 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestTunnelRegistryDial(t *testing.T) {
+	t.Parallel()
+
+	registry := &TunnelRegistry{
+		NameCallback: func(ctx Context, bindHost string, bindPort uint32) string {
+			return ctx.User()
+		},
+	}
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        registry.HandleSSHRequest,
+			"cancel-tcpip-forward": registry.HandleSSHRequest,
+		},
+	}, nil)
+	defer cleanup()
+
+	l, err := client.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(sampleServerResponse)
+		conn.Close()
+	}()
+
+	conn, err := registry.Dial("testuser", "203.0.113.1", 4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	result, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, sampleServerResponse) {
+		t.Fatalf("result = %#v; want %#v", result, sampleServerResponse)
+	}
+
+	if _, err := registry.Dial("nobody", "203.0.113.1", 4242); err != ErrTunnelNotFound {
+		t.Fatalf("err = %#v; want %#v", err, ErrTunnelNotFound)
+	}
+}
+
+func TestTunnelRegistryCallbacks(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var assigned []string
+	registry := &TunnelRegistry{
+		NameCallback: func(ctx Context, bindHost string, bindPort uint32) string {
+			return ctx.User() + "-" + strconv.Itoa(int(bindPort))
+		},
+		PublicAddrCallback: func(t *Tunnel) (string, uint32, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			assigned = append(assigned, t.Name)
+			return t.Name + ".example.com", 443, t.BindPort != 9000
+		},
+	}
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        registry.HandleSSHRequest,
+			"cancel-tcpip-forward": registry.HandleSSHRequest,
+		},
+	}, nil)
+	defer cleanup()
+
+	l, err := client.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tun, ok := registry.Lookup("testuser-8080")
+	if !ok {
+		t.Fatal("tunnel not registered under the name of NameCallback")
+	}
+	if addr := tun.PublicAddr(); addr != "testuser-8080.example.com:443" {
+		t.Fatalf("PublicAddr() = %#v; want %#v", addr, "testuser-8080.example.com:443")
+	}
+	// a duplicate name is refused without assigning an address
+	if _, err := client.Listen("tcp", "127.0.0.2:8080"); err == nil {
+		t.Fatal("duplicate tunnel registered")
+	}
+	// tunnels PublicAddrCallback rejects aren't registered
+	if _, err := client.Listen("tcp", "127.0.0.1:9000"); err == nil {
+		t.Fatal("rejected tunnel registered")
+	}
+	if _, ok := registry.Lookup("testuser-9000"); ok {
+		t.Fatal("rejected tunnel registered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"testuser-8080", "testuser-9000"}; !reflect.DeepEqual(assigned, want) {
+		t.Fatalf("PublicAddrCallback called for %#v; want %#v", assigned, want)
+	}
+}
+
+func TestTunnelRegistryReleaseAddr(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var released []string
+	releasedNames := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), released...)
+	}
+	var registry *TunnelRegistry
+	registry = &TunnelRegistry{
+		NameCallback: func(ctx Context, bindHost string, bindPort uint32) string {
+			return "tunnel-" + strconv.Itoa(int(bindPort))
+		},
+		PublicAddrCallback: func(t *Tunnel) (string, uint32, bool) {
+			if t.BindPort == 7000 {
+				// another connection registers the name meanwhile
+				registry.mu.Lock()
+				registry.tunnels[t.Name] = &Tunnel{Name: t.Name}
+				registry.mu.Unlock()
+			}
+			return t.Name + ".example.com", 443, true
+		},
+		ReleaseAddrCallback: func(t *Tunnel) {
+			mu.Lock()
+			defer mu.Unlock()
+			released = append(released, t.PublicAddr())
+		},
+	}
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        registry.HandleSSHRequest,
+			"cancel-tcpip-forward": registry.HandleSSHRequest,
+		},
+	}, nil)
+
+	l, err := client.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err := client.Listen("tcp", "127.0.0.1:7000"); err == nil {
+		t.Fatal("tunnel registered despite its name being taken")
+	}
+	if _, err := client.Listen("tcp", "127.0.0.1:8081"); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	want := []string{"tunnel-8080.example.com:443", "tunnel-7000.example.com:443", "tunnel-8081.example.com:443"}
+	for deadline := time.Now().Add(5 * time.Second); len(releasedNames()) < len(want) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := releasedNames(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("released = %#v; want %#v", got, want)
+	}
+}
+
+func TestTunnelRegistryRejects(t *testing.T) {
+	t.Parallel()
+
+	registry := &TunnelRegistry{}
+	_, client, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {},
+		ReversePortForwardingCallback: func(ctx Context, bindHost string, bindPort uint32) bool {
+			return true
+		},
+		RequestHandlers: map[string]RequestHandler{
+			"tcpip-forward":        registry.HandleSSHRequest,
+			"cancel-tcpip-forward": registry.HandleSSHRequest,
+		},
+	}, nil)
+	defer cleanup()
+
+	if l, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+		l.Close()
+		t.Fatal("tunnel registered for port 0")
+	}
+	ok, _, err := client.SendRequest("cancel-tcpip-forward", true, gossh.Marshal(&remoteForwardCancelRequest{"127.0.0.1", 8080}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("canceling a tunnel never registered succeeded")
+	}
+}
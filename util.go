@@ -1,19 +1,15 @@
 package ssh
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
 	"encoding/binary"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// generateSigner generates the host key of servers without HostSigners. It
+// is RSA for compatibility with older clients.
 func generateSigner() (ssh.Signer, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
-	}
-	return ssh.NewSignerFromKey(key)
+	return GenerateSigner(KeyTypeRSA, 2048)
 }
 
 func parsePtyRequest(s []byte) (pty Pty, ok bool) {
@@ -25,7 +21,7 @@ func parsePtyRequest(s []byte) (pty Pty, ok bool) {
 	if !ok {
 		return
 	}
-	height32, _, ok := parseUint32(s)
+	height32, s, ok := parseUint32(s)
 	if !ok {
 		return
 	}
@@ -36,9 +32,44 @@ func parsePtyRequest(s []byte) (pty Pty, ok bool) {
 			Height: int(height32),
 		},
 	}
+	// the pixel sizes are ignored, and so are the modes of clients that
+	// don't send them
+	if _, s, ok := parseUint32(s); ok {
+		if _, s, ok := parseUint32(s); ok {
+			if modes, _, ok := parseString(s); ok {
+				pty.modes = modes
+			}
+		}
+	}
 	return
 }
 
+// ttyOpEnd ends encoded terminal modes.
+const ttyOpEnd = 0
+
+// parseTerminalModes decodes the encoded terminal modes of RFC 4254, section
+// 8. Parsing stops at TTY_OP_END, at the first opcode without a defined
+// argument, or at truncated data, keeping the modes decoded until then.
+func parseTerminalModes(s []byte) ssh.TerminalModes {
+	var modes ssh.TerminalModes
+	for len(s) > 0 {
+		op := s[0]
+		if op == ttyOpEnd || op >= 160 {
+			break
+		}
+		arg, rest, ok := parseUint32(s[1:])
+		if !ok {
+			break
+		}
+		if modes == nil {
+			modes = make(ssh.TerminalModes)
+		}
+		modes[op] = arg
+		s = rest
+	}
+	return modes
+}
+
 func parseWinchRequest(s []byte) (win Window, ok bool) {
 	width32, s, ok := parseUint32(s)
 	if width32 < 1 {
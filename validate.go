@@ -0,0 +1,200 @@
+package ssh
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+	"time"
+)
+
+// ConfigError describes an inconsistency in the configuration of a Server.
+type ConfigError struct {
+	Field   string // name of the field at fault, such as "IdleTimeout"
+	Problem string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("ssh: invalid %s: %s", e.Field, e.Problem)
+}
+
+// ConfigErrors are all the problems found by Validate.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors, for use with errors.Is and errors.As.
+func (errs ConfigErrors) Unwrap() []error {
+	list := make([]error, len(errs))
+	for i, err := range errs {
+		list[i] = err
+	}
+	return list
+}
+
+// Validate checks the server for configuration that can't work as intended,
+// such as forwarding callbacks without the handlers they're called from or
+// timeouts that can never elapse, without serving anything. It returns nil
+// or ConfigErrors listing every problem found. To validate a change of a
+// running server, call it on the copy passed to the update function of
+// Reload.
+func (srv *Server) Validate() error {
+	var errs ConfigErrors
+	report := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Field: field, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	keyTypes := map[string]bool{}
+	for i, signer := range srv.HostSigners {
+		if signer == nil {
+			report("HostSigners", "signer %d is nil", i)
+			continue
+		}
+		keyType := signer.PublicKey().Type()
+		if keyTypes[keyType] {
+			report("HostSigners", "more than one %s key, only the last one is used", keyType)
+		}
+		keyTypes[keyType] = true
+	}
+
+	channelHandlers := srv.ChannelHandlers
+	if channelHandlers == nil {
+		channelHandlers = DefaultChannelHandlers
+	}
+	requestHandlers := srv.RequestHandlers
+	if requestHandlers == nil {
+		requestHandlers = DefaultRequestHandlers
+	}
+	if srv.LocalPortForwardingCallback != nil && channelHandlers["direct-tcpip"] == nil {
+		report("LocalPortForwardingCallback", "set without a direct-tcpip channel handler")
+	}
+	if srv.DirectTCPIPCallback != nil && channelHandlers["direct-tcpip"] == nil {
+		report("DirectTCPIPCallback", "set without a direct-tcpip channel handler")
+	}
+	if srv.ReversePortForwardingCallback != nil && requestHandlers["tcpip-forward"] == nil {
+		report("ReversePortForwardingCallback", "set without a tcpip-forward request handler")
+	}
+	if srv.LocalUnixForwardingCallback != nil && channelHandlers["direct-streamlocal@openssh.com"] == nil {
+		report("LocalUnixForwardingCallback", "set without a direct-streamlocal@openssh.com channel handler")
+	}
+	if srv.ReverseUnixForwardingCallback != nil && requestHandlers["streamlocal-forward@openssh.com"] == nil {
+		report("ReverseUnixForwardingCallback", "set without a streamlocal-forward@openssh.com request handler")
+	}
+	if srv.ReversePortForwardingListenerCallback != nil && srv.ReversePortForwardingCallback == nil && srv.ReverseUnixForwardingCallback == nil {
+		report("ReversePortForwardingListenerCallback", "set without ReversePortForwardingCallback, which denies all forwarding")
+	}
+	if srv.ReversePortForwardingBindCallback != nil && srv.ReversePortForwardingCallback == nil {
+		report("ReversePortForwardingBindCallback", "set without ReversePortForwardingCallback, which denies all forwarding")
+	}
+
+	if srv.DecoyUser != "" && srv.UserExistsCallback == nil {
+		report("DecoyUser", "set without UserExistsCallback")
+	}
+	for _, pattern := range srv.AcceptEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			report("AcceptEnv", "bad pattern %q", pattern)
+		}
+	}
+	if p := srv.ForwardAddressPolicy; p != nil {
+		for _, cidr := range append(append([]string(nil), p.Allow...), p.Deny...) {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				report("ForwardAddressPolicy", "bad CIDR range %q", cidr)
+			}
+		}
+	}
+
+	authHandlers := map[string]bool{
+		"publickey":            srv.PublicKeyHandler != nil,
+		"password":             srv.PasswordHandler != nil,
+		"keyboard-interactive": srv.KeyboardInteractiveHandler != nil,
+	}
+	for i, chain := range srv.AuthPolicy {
+		if len(chain) == 0 {
+			report("AuthPolicy", "sequence %d is empty", i)
+		}
+		for _, method := range chain {
+			if handler, known := authHandlers[method]; !known {
+				report("AuthPolicy", "unknown auth method %q", method)
+			} else if !handler {
+				report("AuthPolicy", "method %q has no handler", method)
+			}
+		}
+	}
+
+	for reqType, limit := range srv.GlobalRequestLimits {
+		if limit.Burst < 1 || limit.Rate < 0 {
+			report("GlobalRequestLimits", "limit of %q allows no requests", reqType)
+		}
+	}
+
+	if srv.MaxSessions < 0 {
+		report("MaxSessions", "negative limit %d", srv.MaxSessions)
+	}
+	if srv.MaxConns < 0 {
+		report("MaxConns", "negative limit %d", srv.MaxConns)
+	}
+	if srv.MaxConnsPerSource < 0 {
+		report("MaxConnsPerSource", "negative limit %d", srv.MaxConnsPerSource)
+	}
+	if srv.SourceBlockIPv4 < 0 || srv.SourceBlockIPv4 > 32 {
+		report("SourceBlockIPv4", "invalid prefix length %d", srv.SourceBlockIPv4)
+	}
+	if srv.SourceBlockIPv6 < 0 || srv.SourceBlockIPv6 > 128 {
+		report("SourceBlockIPv6", "invalid prefix length %d", srv.SourceBlockIPv6)
+	}
+
+	timeouts := []struct {
+		field string
+		value time.Duration
+	}{
+		{"IdleTimeout", srv.IdleTimeout},
+		{"MaxTimeout", srv.MaxTimeout},
+		{"HandshakeTimeout", srv.HandshakeTimeout},
+		{"RequestTimeout", srv.RequestTimeout},
+		{"AuthTimeout", srv.AuthTimeout},
+		{"SessionLinger", srv.SessionLinger},
+		{"SessionIdleTimeout", srv.SessionIdleTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value < 0 {
+			report(t.field, "negative duration %v", t.value)
+		}
+	}
+	if srv.MaxTimeout > 0 {
+		if srv.IdleTimeout >= srv.MaxTimeout {
+			report("IdleTimeout", "%v never elapses before MaxTimeout of %v", srv.IdleTimeout, srv.MaxTimeout)
+		}
+		if srv.AuthTimeout >= srv.MaxTimeout {
+			report("AuthTimeout", "%v never elapses before MaxTimeout of %v", srv.AuthTimeout, srv.MaxTimeout)
+		}
+	}
+
+	if srv.OutputBuffer < 0 {
+		report("OutputBuffer", "negative size %d", srv.OutputBuffer)
+	}
+	if srv.PrioritizeStderr && srv.OutputBuffer <= 0 {
+		report("PrioritizeStderr", "set without OutputBuffer")
+	}
+	if limits := srv.PayloadLimits; limits != nil {
+		if limits.MaxEnvName < 0 || limits.MaxEnvValue < 0 || limits.MaxEnvCount < 0 || limits.MaxEnvBytes < 0 || limits.MaxCommand < 0 || limits.MaxTerm < 0 {
+			report("PayloadLimits", "negative limit in %+v", *limits)
+		}
+	}
+	if srv.PtyRepeat < PtyRepeatReject || srv.PtyRepeat > PtyRepeatAllow {
+		report("PtyRepeat", "unknown policy %d", srv.PtyRepeat)
+	}
+	if srv.PostQuantumKex < PostQuantumDefault || srv.PostQuantumKex > PostQuantumRequire {
+		report("PostQuantumKex", "unknown policy %d", srv.PostQuantumKex)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateZeroServer(t *testing.T) {
+	t.Parallel()
+	if err := (&Server{}).Validate(); err != nil {
+		t.Fatalf("Validate() = %#v; want nil", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	key1, _ := GenerateSigner(KeyTypeEd25519, 0)
+	key2, _ := GenerateSigner(KeyTypeEd25519, 0)
+	srv := &Server{
+		HostSigners: []Signer{key1, nil, key2},
+		LocalPortForwardingCallback: func(ctx Context, host string, port uint32) bool {
+			return true
+		},
+		IdleTimeout:      time.Minute,
+		MaxTimeout:       time.Second,
+		RequestTimeout:   -time.Second,
+		PrioritizeStderr: true,
+		AuthPolicy:       AuthPolicy{{"password"}},
+	}
+	err := srv.Validate()
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() = %#v; want ConfigErrors", err)
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	want := []string{"HostSigners", "HostSigners", "LocalPortForwardingCallback", "AuthPolicy", "RequestTimeout", "IdleTimeout", "PrioritizeStderr"}
+	if len(fields) != len(want) {
+		t.Fatalf("fields = %#v; want %#v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Fatalf("fields = %#v; want %#v", fields, want)
+		}
+	}
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field != "HostSigners" {
+		t.Fatalf("errors.As(%#v) = %#v; want HostSigners error", err, configErr)
+	}
+
+	srv.ChannelHandlers = map[string]ChannelHandler{"direct-tcpip": DirectTCPIPHandler}
+	srv.HostSigners = []Signer{key1}
+	srv.MaxTimeout = 0
+	srv.RequestTimeout = 0
+	srv.OutputBuffer = 1024
+	srv.PasswordHandler = func(ctx Context, password string) bool { return false }
+	if err := srv.Validate(); err != nil {
+		t.Fatalf("Validate() = %#v; want nil", err)
+	}
+}
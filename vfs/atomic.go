@@ -0,0 +1,113 @@
+package vfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+)
+
+// Atomic returns an FS writing new and truncated files of fsys to a
+// temporary file in the same directory, which is renamed over the target
+// when it is closed successfully. Consumers watching the directory never see
+// partial uploads, and an interrupted upload leaves any previous version of
+// the file in place. Files opened for writing without truncation, such as
+// resumed uploads, are modified in place.
+//
+// tempName returns the name of the temporary file for an upload to name. If
+// nil, a hidden file with a random suffix is used, such as
+// ".report.csv.3f9a1c2e.part" for "report.csv".
+func Atomic(fsys FS, tempName func(name string) string) FS {
+	if tempName == nil {
+		tempName = defaultTempName
+	}
+	return atomicFS{fsys, tempName}
+}
+
+func defaultTempName(name string) string {
+	var b [4]byte
+	rand.Read(b[:])
+	dir, file := path.Split(name)
+	return dir + "." + file + "." + hex.EncodeToString(b[:]) + ".part"
+}
+
+type atomicFS struct {
+	FS
+	tempName func(name string) string
+}
+
+func (a atomicFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 || flag&os.O_APPEND != 0 {
+		return a.FS.OpenFile(name, flag, perm)
+	}
+	_, err := a.FS.Stat(name)
+	switch {
+	case err == nil && flag&os.O_EXCL != 0 && flag&os.O_CREATE != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err == nil && flag&os.O_TRUNC == 0:
+		return a.FS.OpenFile(name, flag, perm)
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+	mode := os.O_WRONLY
+	if flag&os.O_RDWR != 0 {
+		mode = os.O_RDWR
+	}
+	temp := a.tempName(name)
+	f, err := a.FS.OpenFile(temp, mode|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &atomicFile{File: f, fsys: a.FS, name: name, temp: temp}, nil
+}
+
+// atomicFile is a temporary file renamed to name when it is closed.
+type atomicFile struct {
+	File
+	fsys FS
+	name string
+	temp string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *atomicFile) Stat() (fs.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return renamedInfo{fi, path.Base(f.name)}, nil
+}
+
+func (f *atomicFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	err := f.File.Close()
+	if err == nil {
+		err = f.fsys.Rename(f.temp, f.name)
+	}
+	if err != nil {
+		f.fsys.Remove(f.temp)
+		return pathError("close", f.name, err)
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file, leaving the target untouched.
+func (f *atomicFile) Abort() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.File.Close()
+	return pathError("abort", f.name, f.fsys.Remove(f.temp))
+}
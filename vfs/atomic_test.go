@@ -0,0 +1,61 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomic(t *testing.T) {
+	dir := newTestDir(t)
+	fsys := Atomic(DirFS(dir), func(name string) string {
+		return name + ".part"
+	})
+	f, err := fsys.OpenFile("public.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "replaced")
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "public.txt")); string(data) != "hello" {
+		t.Fatalf("target = %#v before Close; want %#v", string(data), "hello")
+	}
+	if fi, _ := f.Stat(); fi.Name() != "public.txt" {
+		t.Fatalf("Stat name = %#v; want %#v", fi.Name(), "public.txt")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "public.txt")); string(data) != "replaced" {
+		t.Fatalf("target = %#v after Close; want %#v", string(data), "replaced")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "public.txt.part")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
+
+func TestAtomicScanRejected(t *testing.T) {
+	dir := newTestDir(t)
+	rejected := errors.New("rejected")
+	fsys := Scan(Atomic(DirFS(dir), nil), func(name string, r io.Reader) error {
+		return rejected
+	})
+	f, err := fsys.OpenFile("public.txt", os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("malware"))
+	if err := f.Close(); !errors.Is(err, rejected) {
+		t.Fatalf("Close err = %v; want %v", err, rejected)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "public.txt")); string(data) != "hello" {
+		t.Fatalf("target = %#v; want %#v", string(data), "hello")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Fatalf("len(entries) = %d; want 3", len(entries))
+	}
+}
@@ -0,0 +1,118 @@
+package vfs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ErrHashUnavailable is returned by a Hasher that has no checksum for the
+// requested range, so Hash falls back to reading the file.
+var ErrHashUnavailable = errors.New("vfs: hash not available")
+
+// ErrUnknownAlgorithm is returned by Hash for unsupported hash algorithms.
+var ErrUnknownAlgorithm = errors.New("vfs: unknown hash algorithm")
+
+// hashAlgorithms are the algorithms supported by Hash, named as in the SFTP
+// check-file extension.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// HashAlgorithms returns the names of the algorithms supported by Hash in
+// order of preference.
+func HashAlgorithms() []string {
+	return []string{"sha256", "sha512", "sha384", "sha224", "sha1", "md5"}
+}
+
+// Hasher is implemented by backends that can provide checksums without the
+// data being read through them, for example from a cache or from object
+// metadata. Hash is called with the same arguments as the Hash function and
+// returns ErrHashUnavailable to have the file read instead.
+type Hasher interface {
+	Hash(name, algorithm string, offset, length int64) ([]byte, error)
+}
+
+// Hash returns the checksum of length bytes of the named file starting at
+// offset, or of the rest of the file if length is 0, so clients can verify
+// transfers without downloading them again. Backends implementing Hasher are
+// asked first.
+func Hash(fsys FS, name, algorithm string, offset, length int64) ([]byte, error) {
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, &fs.PathError{Op: "hash", Path: name, Err: ErrUnknownAlgorithm}
+	}
+	if offset < 0 || length < 0 {
+		return nil, &fs.PathError{Op: "hash", Path: name, Err: fs.ErrInvalid}
+	}
+	if h, ok := fsys.(Hasher); ok {
+		sum, err := h.Hash(name, algorithm, offset, length)
+		if !errors.Is(err, ErrHashUnavailable) {
+			return sum, err
+		}
+	}
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = io.NewSectionReader(f, offset, 1<<63-1-offset)
+	if length > 0 {
+		r = io.LimitReader(r, length)
+	}
+	h := newHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// The wrappers forward hashing so backends implementing Hasher keep serving
+// checksums through them.
+
+func (r readOnlyFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	return Hash(r.FS, name, algorithm, offset, length)
+}
+
+func (m maskFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	if m.hidden(name) {
+		return nil, &fs.PathError{Op: "hash", Path: name, Err: fs.ErrNotExist}
+	}
+	return Hash(m.fsys, name, algorithm, offset, length)
+}
+
+func (h hooksFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	if h.hooks.OnOpen != nil {
+		if err := h.hooks.OnOpen(name, os.O_RDONLY); err != nil {
+			return nil, err
+		}
+	}
+	return Hash(h.FS, name, algorithm, offset, length)
+}
+
+func (s scanFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	return Hash(s.FS, name, algorithm, offset, length)
+}
+
+func (a atomicFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	return Hash(a.FS, name, algorithm, offset, length)
+}
+
+func (m *MountFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	fsys, rel := m.resolve(name)
+	if fsys == nil {
+		return nil, m.virtualError("hash", name)
+	}
+	return Hash(fsys, rel, algorithm, offset, length)
+}
@@ -0,0 +1,58 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+type cachedHashFS struct {
+	FS
+	sums map[string][]byte
+}
+
+func (c cachedHashFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	if sum, ok := c.sums[name]; ok && algorithm == "md5" && offset == 0 && length == 0 {
+		return sum, nil
+	}
+	return nil, ErrHashUnavailable
+}
+
+func TestHash(t *testing.T) {
+	cached := []byte("cached")
+	fsys := ReadOnly(cachedHashFS{DirFS(newTestDir(t)), map[string][]byte{"public.txt": cached}})
+
+	for _, tc := range []struct {
+		algorithm      string
+		offset, length int64
+		want           []byte
+	}{
+		{"md5", 0, 0, cached},
+		{"sha256", 0, 0, sha256Sum("hello")},
+		{"sha256", 1, 3, sha256Sum("ell")},
+		{"md5", 2, 0, md5Sum("llo")},
+	} {
+		sum, err := Hash(fsys, "public.txt", tc.algorithm, tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sum, tc.want) {
+			t.Fatalf("Hash(%s, %d, %d) = %x; want %x", tc.algorithm, tc.offset, tc.length, sum, tc.want)
+		}
+	}
+	if _, err := Hash(fsys, "public.txt", "crc32", 0, 0); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("Hash(crc32) err = %v; want %v", err, ErrUnknownAlgorithm)
+	}
+}
+
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+func md5Sum(s string) []byte {
+	sum := md5.Sum([]byte(s))
+	return sum[:]
+}
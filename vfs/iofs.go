@@ -0,0 +1,103 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// errNotSeekable is returned by files of FromFS for reads at an offset they
+// can't seek to.
+var errNotSeekable = errors.New("vfs: file does not support reads at an offset")
+
+// FromFS returns a read-only FS serving the files of fsys, such as an
+// embed.FS or os.DirFS, refusing every operation modifying it with
+// fs.ErrPermission. Files are read at offsets through io.ReaderAt or
+// io.Seeker when they implement one; others can only be read sequentially.
+func FromFS(fsys fs.FS) FS {
+	return ReadOnly(ioFS{fsys})
+}
+
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (i ioFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&writeFlags != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	f, err := i.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &ioFile{File: f}, nil
+}
+
+func (i ioFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(i.fsys, name)
+}
+
+func (i ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(i.fsys, name)
+}
+
+func (i ioFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+func (i ioFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (i ioFS) Rename(oldname, newname string) error {
+	return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrPermission}
+}
+
+// ioFile adapts an fs.File to File.
+type ioFile struct {
+	fs.File
+
+	mu  sync.Mutex
+	off int64 // offset of sequential reads
+}
+
+func (f *ioFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.File.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *ioFile) ReadAt(p []byte, off int64) (int, error) {
+	if ra, ok := f.File.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off != f.off {
+		s, ok := f.File.(io.Seeker)
+		if !ok {
+			return 0, errNotSeekable
+		}
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.off = off
+	}
+	n, err := io.ReadFull(f.File, p)
+	f.off += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *ioFile) Write(p []byte) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+func (f *ioFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, fs.ErrPermission
+}
@@ -0,0 +1,33 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFromFS(t *testing.T) {
+	fsys := FromFS(fstest.MapFS{
+		"dir/file.txt": {Data: []byte("hello world")},
+	})
+	f, err := fsys.OpenFile("dir/file.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 5)
+	if n, _ := f.ReadAt(buf, 6); string(buf[:n]) != "world" {
+		t.Fatalf("ReadAt = %#v; want %#v", string(buf[:n]), "world")
+	}
+	if entries, _ := fsys.ReadDir("dir"); len(entries) != 1 {
+		t.Fatalf("len(entries) = %d; want 1", len(entries))
+	}
+	if _, err := fsys.OpenFile("dir/file.txt", os.O_WRONLY|os.O_TRUNC, 0); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("OpenFile for writing err = %v; want %v", err, fs.ErrPermission)
+	}
+	if err := fsys.Mkdir("new", 0755); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Mkdir err = %v; want %v", err, fs.ErrPermission)
+	}
+}
@@ -0,0 +1,231 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrCrossMount is returned when renaming a file from one mounted backend to
+// another.
+var ErrCrossMount = errors.New("vfs: rename across mounts")
+
+// MountFS composes multiple backends into a single tree, for example a
+// user's home on local disk with /uploads backed by object storage and /logs
+// mounted read-only. Directories leading up to mount points that don't exist
+// in any backend are synthesized, so the zero value is a valid empty tree.
+type MountFS struct {
+	mounts []mount
+}
+
+type mount struct {
+	dir  string
+	fsys FS
+}
+
+// Mount attaches fsys at dir, which is "." for the root. A later mount at the
+// same dir replaces the previous one. Mount is not safe to call while the
+// MountFS is in use.
+func (m *MountFS) Mount(dir string, fsys FS) error {
+	if !fs.ValidPath(dir) {
+		return &fs.PathError{Op: "mount", Path: dir, Err: fs.ErrInvalid}
+	}
+	for i := range m.mounts {
+		if m.mounts[i].dir == dir {
+			m.mounts[i].fsys = fsys
+			return nil
+		}
+	}
+	m.mounts = append(m.mounts, mount{dir, fsys})
+	// deepest first, so resolve finds the most specific mount
+	sort.Slice(m.mounts, func(i, j int) bool {
+		return depth(m.mounts[i].dir) > depth(m.mounts[j].dir)
+	})
+	return nil
+}
+
+// depth returns the number of path elements of dir, which is 0 for ".".
+func depth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// resolve returns the backend serving name and the name relative to it. It
+// returns a nil FS if name is not inside any mount.
+func (m *MountFS) resolve(name string) (FS, string) {
+	for _, mnt := range m.mounts {
+		switch {
+		case mnt.dir == name:
+			return mnt.fsys, "."
+		case mnt.dir == ".":
+			return mnt.fsys, name
+		case strings.HasPrefix(name, mnt.dir+"/"):
+			return mnt.fsys, name[len(mnt.dir)+1:]
+		}
+	}
+	return nil, ""
+}
+
+// isMountPoint reports whether name is a mount point other than the root.
+func (m *MountFS) isMountPoint(name string) bool {
+	for _, mnt := range m.mounts {
+		if mnt.dir == name && name != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// children returns the names of the mount points and synthesized directories
+// directly inside dir.
+func (m *MountFS) children(dir string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, mnt := range m.mounts {
+		if mnt.dir == "." || mnt.dir == dir {
+			continue
+		}
+		rest := mnt.dir
+		if dir != "." {
+			if !strings.HasPrefix(mnt.dir, dir+"/") {
+				continue
+			}
+			rest = mnt.dir[len(dir)+1:]
+		}
+		child := strings.SplitN(rest, "/", 2)[0]
+		if !seen[child] {
+			seen[child] = true
+			names = append(names, child)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *MountFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	fsys, rel := m.resolve(name)
+	if fsys == nil {
+		return nil, m.virtualError("open", name)
+	}
+	return fsys.OpenFile(rel, flag, perm)
+}
+
+func (m *MountFS) Stat(name string) (fs.FileInfo, error) {
+	fsys, rel := m.resolve(name)
+	if fsys != nil {
+		fi, err := fsys.Stat(rel)
+		if err == nil && rel == "." {
+			fi = renamedInfo{fi, path.Base(name)}
+		}
+		if err == nil || len(m.children(name)) == 0 {
+			return fi, err
+		}
+	}
+	if name == "." || len(m.children(name)) > 0 {
+		return dirInfo(path.Base(name)), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	fsys, rel := m.resolve(name)
+	if fsys != nil {
+		var err error
+		entries, err = fsys.ReadDir(rel)
+		if err != nil && len(m.children(name)) == 0 {
+			return nil, err
+		}
+	} else if name != "." && len(m.children(name)) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	// mount points shadow entries of the parent backend
+	children := m.children(name)
+	merged := entries[:0]
+	for _, entry := range entries {
+		if i := sort.SearchStrings(children, entry.Name()); i == len(children) || children[i] != entry.Name() {
+			merged = append(merged, entry)
+		}
+	}
+	for _, child := range children {
+		merged = append(merged, fs.FileInfoToDirEntry(dirInfo(child)))
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name() < merged[j].Name()
+	})
+	return merged, nil
+}
+
+func (m *MountFS) Mkdir(name string, perm fs.FileMode) error {
+	if m.isMountPoint(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	fsys, rel := m.resolve(name)
+	if fsys == nil {
+		return m.virtualError("mkdir", name)
+	}
+	return fsys.Mkdir(rel, perm)
+}
+
+func (m *MountFS) Remove(name string) error {
+	if m.isMountPoint(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	fsys, rel := m.resolve(name)
+	if fsys == nil {
+		return m.virtualError("remove", name)
+	}
+	return fsys.Remove(rel)
+}
+
+func (m *MountFS) Rename(oldname, newname string) error {
+	if m.isMountPoint(oldname) || m.isMountPoint(newname) {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrPermission}
+	}
+	oldfs, oldrel := m.resolve(oldname)
+	newfs, newrel := m.resolve(newname)
+	if oldfs == nil {
+		return m.virtualError("rename", oldname)
+	}
+	if newfs == nil {
+		return m.virtualError("rename", newname)
+	}
+	if oldfs != newfs {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: ErrCrossMount}
+	}
+	return oldfs.Rename(oldrel, newrel)
+}
+
+// virtualError is the error for operations on names not served by any
+// backend: synthesized directories can't be modified and everything else
+// doesn't exist.
+func (m *MountFS) virtualError(op, name string) error {
+	if name == "." || len(m.children(name)) > 0 {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// dirInfo is the fs.FileInfo of a synthesized directory.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
+
+// renamedInfo reports a file under another name, such as the root of a
+// mounted backend under the name of its mount point.
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (r renamedInfo) Name() string { return r.name }
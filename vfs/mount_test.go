@@ -0,0 +1,55 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestMountFS(t *testing.T) {
+	var m MountFS
+	m.Mount(".", DirFS(newTestDir(t)))
+	m.Mount("logs/app", ReadOnly(DirFS(newTestDir(t))))
+
+	fi, err := m.Stat("logs")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("Stat(logs) = %v, %v; want synthesized directory", fi, err)
+	}
+	entries, err := m.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{".secret", "docs", "logs", "public.txt"}; len(names) != len(want) || names[2] != "logs" {
+		t.Fatalf("ReadDir(.) = %v; want %v", names, want)
+	}
+	if _, err := m.Stat("logs/app/docs/readme.txt"); err != nil {
+		t.Fatalf("Stat inside mount: %v", err)
+	}
+	if _, err := m.OpenFile("logs/app/new.txt", os.O_WRONLY|os.O_CREATE, 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("write to read-only mount: err = %v; want %v", err, fs.ErrPermission)
+	}
+	if err := m.Rename("public.txt", "logs/app/public.txt"); !errors.Is(err, ErrCrossMount) {
+		t.Fatalf("Rename across mounts: err = %v; want %v", err, ErrCrossMount)
+	}
+	if err := m.Remove("logs/app"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Remove(mount point): err = %v; want %v", err, fs.ErrPermission)
+	}
+}
+
+func TestMountFSOneLetterMount(t *testing.T) {
+	var m MountFS
+	m.Mount(".", DirFS(newTestDir(t)))
+	m.Mount("a", ReadOnly(DirFS(newTestDir(t))))
+
+	if _, err := m.OpenFile("a/new.txt", os.O_WRONLY|os.O_CREATE, 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("write to read-only mount: err = %v; want %v", err, fs.ErrPermission)
+	}
+	if _, err := m.Stat("a/docs/readme.txt"); err != nil {
+		t.Fatalf("Stat inside mount: %v", err)
+	}
+}
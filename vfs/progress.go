@@ -0,0 +1,270 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProgressInterval is the interval between progress events used when
+// Transfers.Interval is zero.
+const DefaultProgressInterval = time.Second
+
+// ErrTransferCanceled is returned for reads and writes of a transfer that
+// was canceled with Transfers.Cancel.
+var ErrTransferCanceled = errors.New("vfs: transfer canceled")
+
+// TransferProgress is a snapshot of a transfer.
+type TransferProgress struct {
+	ID      uint64
+	User    string
+	Name    string
+	Upload  bool
+	Started time.Time
+
+	// Bytes is the number of bytes read or written so far. Size is the size
+	// of the file for downloads and -1 for uploads, whose size isn't known
+	// in advance.
+	Bytes int64
+	Size  int64
+
+	// Rate is the average rate in bytes per second since the transfer
+	// started. ETA is the estimated time remaining, or 0 if unknown.
+	Rate float64
+	ETA  time.Duration
+
+	// Done is set in the final event sent when the file is closed, along
+	// with Err if the transfer was canceled or failed to close.
+	Done bool
+	Err  error
+}
+
+// Transfers tracks the files open on the filesystems it wraps, so dashboards
+// can show live transfer activity and admins can cancel transfers. A single
+// Transfers is usually shared by all sessions of a server.
+type Transfers struct {
+	// OnProgress is called when a transfer starts, at most once per Interval
+	// while data is moving, and when it is done. It is called synchronously
+	// from the transfer and should not block.
+	OnProgress func(p TransferProgress)
+
+	// Interval is the minimum time between progress events of a transfer.
+	// DefaultProgressInterval is used if zero.
+	Interval time.Duration
+
+	mu        sync.Mutex
+	nextID    uint64
+	transfers map[uint64]*transferFile
+}
+
+// FS returns an FS tracking the transfers of user on fsys. It should be the
+// outermost wrapper, so canceled uploads can be discarded by Atomic.
+func (t *Transfers) FS(fsys FS, user string) FS {
+	return transferFS{fsys, t, user}
+}
+
+// List returns the progress of all ongoing transfers, ordered by ID.
+func (t *Transfers) List() []TransferProgress {
+	t.mu.Lock()
+	files := make([]*transferFile, 0, len(t.transfers))
+	for _, f := range t.transfers {
+		files = append(files, f)
+	}
+	t.mu.Unlock()
+	list := make([]TransferProgress, 0, len(files))
+	for _, f := range files {
+		list = append(list, f.progress())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Cancel cancels the transfer with the given ID, failing its further reads
+// and writes with ErrTransferCanceled. Canceled uploads are discarded when
+// closed if the file implements Aborter. It reports whether the transfer was
+// found.
+func (t *Transfers) Cancel(id uint64) bool {
+	t.mu.Lock()
+	f, ok := t.transfers[id]
+	t.mu.Unlock()
+	if ok {
+		f.mu.Lock()
+		f.canceled = true
+		f.mu.Unlock()
+	}
+	return ok
+}
+
+func (t *Transfers) interval() time.Duration {
+	if t.Interval == 0 {
+		return DefaultProgressInterval
+	}
+	return t.Interval
+}
+
+func (t *Transfers) notify(p TransferProgress) {
+	if t.OnProgress != nil {
+		t.OnProgress(p)
+	}
+}
+
+type transferFS struct {
+	FS
+	t    *Transfers
+	user string
+}
+
+func (tfs transferFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := tfs.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	tf := &transferFile{
+		File:    f,
+		t:       tfs.t,
+		user:    tfs.user,
+		name:    name,
+		upload:  flag&writeFlags != 0,
+		started: time.Now(),
+		size:    -1,
+	}
+	if !tf.upload {
+		if fi, err := f.Stat(); err == nil {
+			tf.size = fi.Size()
+		}
+	}
+	t := tfs.t
+	t.mu.Lock()
+	t.nextID++
+	tf.id = t.nextID
+	if t.transfers == nil {
+		t.transfers = make(map[uint64]*transferFile)
+	}
+	t.transfers[tf.id] = tf
+	t.mu.Unlock()
+	tf.lastEvent = tf.started
+	t.notify(tf.progress())
+	return tf, nil
+}
+
+type transferFile struct {
+	File
+	t       *Transfers
+	id      uint64
+	user    string
+	name    string
+	upload  bool
+	started time.Time
+	size    int64
+
+	mu        sync.Mutex
+	bytes     int64
+	lastEvent time.Time
+	canceled  bool
+	closed    bool
+}
+
+func (f *transferFile) Read(p []byte) (int, error) {
+	return f.transfer("read", func() (int, error) { return f.File.Read(p) })
+}
+
+func (f *transferFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.transfer("read", func() (int, error) { return f.File.ReadAt(p, off) })
+}
+
+func (f *transferFile) Write(p []byte) (int, error) {
+	return f.transfer("write", func() (int, error) { return f.File.Write(p) })
+}
+
+func (f *transferFile) WriteAt(p []byte, off int64) (int, error) {
+	return f.transfer("write", func() (int, error) { return f.File.WriteAt(p, off) })
+}
+
+// transfer runs fn unless the transfer was canceled, counting the bytes it
+// moved and sending a progress event when one is due.
+func (f *transferFile) transfer(op string, fn func() (int, error)) (int, error) {
+	f.mu.Lock()
+	canceled := f.canceled
+	f.mu.Unlock()
+	if canceled {
+		return 0, &fs.PathError{Op: op, Path: f.name, Err: ErrTransferCanceled}
+	}
+	n, err := fn()
+	now := time.Now()
+	f.mu.Lock()
+	f.bytes += int64(n)
+	due := now.Sub(f.lastEvent) >= f.t.interval()
+	if due {
+		f.lastEvent = now
+	}
+	f.mu.Unlock()
+	if due {
+		f.t.notify(f.progress())
+	}
+	return n, err
+}
+
+func (f *transferFile) progress() TransferProgress {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := TransferProgress{
+		ID:      f.id,
+		User:    f.user,
+		Name:    f.name,
+		Upload:  f.upload,
+		Started: f.started,
+		Bytes:   f.bytes,
+		Size:    f.size,
+	}
+	if elapsed := time.Since(f.started).Seconds(); elapsed > 0 {
+		p.Rate = float64(f.bytes) / elapsed
+	}
+	if p.Rate > 0 && f.size > f.bytes {
+		p.ETA = time.Duration(float64(f.size-f.bytes) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+func (f *transferFile) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return fs.ErrClosed
+	}
+	f.closed = true
+	canceled := f.canceled
+	f.mu.Unlock()
+
+	f.t.mu.Lock()
+	delete(f.t.transfers, f.id)
+	f.t.mu.Unlock()
+
+	var err error
+	if a, ok := f.File.(Aborter); ok && canceled && f.upload {
+		a.Abort()
+	} else {
+		err = f.File.Close()
+	}
+	if canceled {
+		err = &fs.PathError{Op: "close", Path: f.name, Err: ErrTransferCanceled}
+	}
+	p := f.progress()
+	p.Done = true
+	p.Err = err
+	f.t.notify(p)
+	return err
+}
+
+func (f *transferFile) Sync() error {
+	return Sync(f.File)
+}
+
+func (tfs transferFS) Hash(name, algorithm string, offset, length int64) ([]byte, error) {
+	return Hash(tfs.FS, name, algorithm, offset, length)
+}
+
+func (tfs transferFS) StatFS(name string) (*FSStat, error) {
+	return StatFS(tfs.FS, name)
+}
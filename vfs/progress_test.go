@@ -0,0 +1,66 @@
+package vfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransfers(t *testing.T) {
+	var events []TransferProgress
+	transfers := &Transfers{
+		OnProgress: func(p TransferProgress) {
+			events = append(events, p)
+		},
+		Interval: -1, // report every read
+	}
+	fsys := transfers.FS(DirFS(newTestDir(t)), "alice")
+	f, err := fsys.OpenFile("public.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	f.Read(buf)
+	list := transfers.List()
+	if len(list) != 1 || list[0].User != "alice" || list[0].Bytes != 2 || list[0].Size != 5 {
+		t.Fatalf("List() = %+v; want alice reading 2 of 5 bytes", list)
+	}
+	f.Close()
+	if len(transfers.List()) != 0 {
+		t.Fatal("closed transfer still listed")
+	}
+	if len(events) != 3 {
+		t.Fatalf("len(events) = %d; want 3", len(events))
+	}
+	if last := events[2]; !last.Done || last.Bytes != 2 || last.Err != nil {
+		t.Fatalf("final event = %+v; want done after 2 bytes", last)
+	}
+}
+
+func TestTransfersCancel(t *testing.T) {
+	dir := newTestDir(t)
+	transfers := &Transfers{}
+	fsys := transfers.FS(Atomic(DirFS(dir), nil), "bob")
+	f, err := fsys.OpenFile("upload.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("partial"))
+	if !transfers.Cancel(transfers.List()[0].ID) {
+		t.Fatal("transfer not found")
+	}
+	if _, err := f.Write([]byte("more")); !errors.Is(err, ErrTransferCanceled) {
+		t.Fatalf("Write err = %v; want %v", err, ErrTransferCanceled)
+	}
+	if err := f.Close(); !errors.Is(err, ErrTransferCanceled) {
+		t.Fatalf("Close err = %v; want %v", err, ErrTransferCanceled)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 3 {
+		t.Fatalf("len(entries) = %d; want 3", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "upload.bin")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("canceled upload committed: %v", err)
+	}
+}
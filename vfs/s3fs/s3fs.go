@@ -0,0 +1,533 @@
+// Package s3fs implements a vfs.FS backed by an S3-compatible object storage
+// service, such as AWS S3 or MinIO, so the file transfer subsystems can serve
+// files straight from a bucket.
+//
+// Objects are mapped to files by key, using "/" as the directory separator.
+// Directories are implied by the keys below them; Mkdir creates an empty
+// "dir/" marker object so empty directories can exist. Files are written
+// sequentially and become visible when closed: small files are uploaded with a
+// single request, larger files with a multipart upload. Reads are streamed.
+// Renames copy and then delete the object and are limited to 5 GiB.
+package s3fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh/vfs"
+)
+
+// MinPartSize is the smallest part size accepted for multipart uploads.
+const MinPartSize = 5 * 1024 * 1024
+
+// DefaultPartSize is the part size used when Config.PartSize is zero.
+const DefaultPartSize = 16 * 1024 * 1024
+
+// ErrUnsupported is returned for operations object storage can't provide,
+// such as non-sequential writes or opening files for both reading and
+// writing.
+var ErrUnsupported = errors.New("s3fs: unsupported operation")
+
+// Config configures the object storage backend.
+type Config struct {
+	Endpoint string // service URL, such as "https://s3.us-east-1.amazonaws.com"
+	Region   string // signing region, such as "us-east-1"
+	Bucket   string
+	Prefix   string // key prefix of the filesystem root, such as "users/alice/"
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // optional, for temporary credentials
+
+	// PathStyle addresses the bucket as part of the URL path instead of the
+	// host name, as required by most self-hosted services.
+	PathStyle bool
+
+	// PartSize is the size of multipart upload parts. It also bounds the
+	// memory buffered per open file. DefaultPartSize is used if zero.
+	PartSize int64
+
+	// HTTPClient is used for requests to the service. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// FS is a vfs.FS backed by object storage.
+type FS struct {
+	c        *client
+	prefix   string
+	partSize int64
+}
+
+var (
+	_ vfs.FS      = (*FS)(nil)
+	_ vfs.Aborter = (*writer)(nil)
+)
+
+// New returns an FS for the bucket described by cfg.
+func New(cfg Config) (*FS, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3fs: endpoint and bucket are required")
+	}
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, err
+	}
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < MinPartSize {
+		return nil, errors.New("s3fs: part size below minimum of 5 MiB")
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &FS{
+		c:        &client{cfg: cfg, http: httpClient, now: time.Now},
+		prefix:   prefix,
+		partSize: partSize,
+	}, nil
+}
+
+// key returns the object key for name.
+func (f *FS) key(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return f.prefix, nil
+	}
+	return f.prefix + name, nil
+}
+
+func (f *FS) OpenFile(name string, flag int, perm fs.FileMode) (vfs.File, error) {
+	key, err := f.key("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case flag&(os.O_RDWR|os.O_APPEND) != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrUnsupported}
+	case flag&os.O_WRONLY != 0:
+		if flag&os.O_EXCL != 0 {
+			if _, err := f.Stat(name); err == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+			}
+		}
+		if flag&os.O_CREATE == 0 {
+			if _, err := f.Stat(name); err != nil {
+				return nil, err
+			}
+		}
+		return &writer{fsys: f, name: name, key: key}, nil
+	}
+	fi, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{fsys: f, name: name, key: key, info: fi}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	key, err := f.key("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return &fileInfo{name: ".", dir: true}, nil
+	}
+	resp, err := f.c.do("HEAD", key, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &fileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	// no object, but keys below it make it a directory
+	var res listBucketResult
+	query := url.Values{"list-type": {"2"}, "prefix": {key + "/"}, "max-keys": {"1"}}
+	if err := f.c.doXML("GET", "", query, nil, &res); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if len(res.Contents) == 0 && len(res.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	key, err := f.key("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	prefix := key
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var entries []fs.DirEntry
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	found := false
+	for {
+		var res listBucketResult
+		if err := f.c.doXML("GET", "", query, nil, &res); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		for _, p := range res.CommonPrefixes {
+			found = true
+			entries = append(entries, fs.FileInfoToDirEntry(&fileInfo{
+				name: path.Base(strings.TrimSuffix(p.Prefix, "/")),
+				dir:  true,
+			}))
+		}
+		for _, obj := range res.Contents {
+			found = true
+			if obj.Key == prefix {
+				// directory marker
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(&fileInfo{
+				name:    path.Base(obj.Key),
+				size:    obj.Size,
+				modTime: obj.LastModified,
+			}))
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", res.NextContinuationToken)
+	}
+	if !found && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+func (f *FS) Mkdir(name string, perm fs.FileMode) error {
+	key, err := f.key("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := f.c.doXML("PUT", key+"/", nil, bytes.NewReader(nil), nil); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (f *FS) Remove(name string) error {
+	key, err := f.key("remove", name)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := f.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		key += "/"
+	}
+	if err := f.c.doXML("DELETE", key, nil, nil, nil); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (f *FS) Rename(oldname, newname string) error {
+	oldkey, err := f.key("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newkey, err := f.key("rename", newname)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat(oldname)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: ErrUnsupported}
+	}
+	source := "/" + f.c.cfg.Bucket + "/" + uriEncode(oldkey, false)
+	resp, err := f.c.do("PUT", newkey, nil, http.Header{"X-Amz-Copy-Source": {source}}, nil)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err := f.c.doXML("DELETE", oldkey, nil, nil, nil); err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	return nil
+}
+
+// reader is a file opened for reading. Sequential reads share one streaming
+// GET request; reads at other offsets start a new ranged request.
+type reader struct {
+	fsys *FS
+	name string
+	key  string
+	info fs.FileInfo
+
+	mu     sync.Mutex
+	pos    int64 // offset of the next Read
+	body   io.ReadCloser
+	offset int64 // offset of body
+}
+
+func (r *reader) Stat() (fs.FileInfo, error) {
+	return r.info, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, err := r.readAtLocked(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		m, err := r.readAtLocked(p[n:], off+int64(n))
+		n += m
+		if err != nil && (err != io.EOF || n < len(p)) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (r *reader) readAtLocked(p []byte, off int64) (int, error) {
+	if off >= r.info.Size() {
+		return 0, io.EOF
+	}
+	if r.body != nil && off != r.offset {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(off, 10) + "-"}}
+		resp, err := r.fsys.c.do("GET", r.key, nil, header, nil)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: r.name, Err: err}
+		}
+		r.body = resp.Body
+		r.offset = off
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *reader) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: r.name, Err: fs.ErrPermission}
+}
+
+func (r *reader) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: r.name, Err: fs.ErrPermission}
+}
+
+func (r *reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	return nil
+}
+
+// writer is a file opened for writing. Data is buffered up to the part size;
+// once more is written a multipart upload is started. The object is created
+// when the file is closed.
+type writer struct {
+	fsys *FS
+	name string
+	key  string
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	size     int64
+	uploadID string
+	parts    []completedPart
+	err      error
+	closed   bool
+}
+
+func (w *writer) Stat() (fs.FileInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return &fileInfo{name: path.Base(w.name), size: w.size, modTime: time.Now()}, nil
+}
+
+func (w *writer) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: w.name, Err: fs.ErrPermission}
+}
+
+func (w *writer) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: w.name, Err: fs.ErrPermission}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeLocked(p)
+}
+
+// WriteAt only supports sequential writes, which is what file transfer
+// clients issue in practice.
+func (w *writer) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if off != w.size {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: ErrUnsupported}
+	}
+	return w.writeLocked(p)
+}
+
+func (w *writer) writeLocked(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	w.buf.Write(p)
+	w.size += int64(len(p))
+	for int64(w.buf.Len()) > w.fsys.partSize {
+		if err := w.uploadPart(w.buf.Next(int(w.fsys.partSize))); err != nil {
+			w.err = &fs.PathError{Op: "write", Path: w.name, Err: err}
+			w.abort()
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *writer) uploadPart(data []byte) error {
+	c := w.fsys.c
+	if w.uploadID == "" {
+		var res initiateMultipartUploadResult
+		if err := c.doXML("POST", w.key, url.Values{"uploads": {""}}, bytes.NewReader(nil), &res); err != nil {
+			return err
+		}
+		w.uploadID = res.UploadID
+	}
+	number := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}}
+	resp, err := c.do("PUT", w.key, query, nil, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (w *writer) abort() {
+	if w.uploadID != "" {
+		w.fsys.c.doXML("DELETE", w.key, url.Values{"uploadId": {w.uploadID}}, nil, nil)
+		w.uploadID = ""
+	}
+}
+
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	c := w.fsys.c
+	if w.uploadID == "" {
+		if err := c.doXML("PUT", w.key, nil, bytes.NewReader(w.buf.Bytes()), nil); err != nil {
+			return &fs.PathError{Op: "close", Path: w.name, Err: err}
+		}
+		return nil
+	}
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			w.abort()
+			return &fs.PathError{Op: "close", Path: w.name, Err: err}
+		}
+	}
+	body, err := xmlBody(&completeMultipartUpload{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	if err := c.doXML("POST", w.key, url.Values{"uploadId": {w.uploadID}}, body, nil); err != nil {
+		w.abort()
+		return &fs.PathError{Op: "close", Path: w.name, Err: err}
+	}
+	return nil
+}
+
+// Abort closes the file without creating the object.
+func (w *writer) Abort() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	w.abort()
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,119 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sync"
+)
+
+// ErrNotSequential is returned by scanned files for writes that don't
+// continue where the previous write ended, since the data couldn't be
+// streamed to the scanner in order.
+var ErrNotSequential = errors.New("vfs: scanned files must be written sequentially")
+
+// ScanFunc inspects an uploaded file. r yields the data as it is written and
+// returns io.EOF once the client closes the file. Returning a non-nil error
+// rejects the upload; the function may do so before reaching the end of r to
+// stop the transfer early.
+type ScanFunc func(name string, r io.Reader) error
+
+// Scan returns an FS streaming the contents of every file written to fsys
+// through scan, such as an antivirus or DLP scanner. Close waits for the
+// verdict and, when the upload is rejected, discards the file and returns the
+// scanner's error. Rejected files are aborted if they implement Aborter and
+// removed otherwise, so scanning should wrap Atomic when the target must
+// never be visible before it is accepted.
+func Scan(fsys FS, scan ScanFunc) FS {
+	return scanFS{fsys, scan}
+}
+
+type scanFS struct {
+	FS
+	scan ScanFunc
+}
+
+func (s scanFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := s.FS.OpenFile(name, flag, perm)
+	if err != nil || flag&writeFlags == 0 {
+		return f, err
+	}
+	pr, pw := io.Pipe()
+	sf := &scanFile{File: f, fsys: s.FS, name: name, pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := s.scan(name, pr)
+		if err == nil {
+			// accepted early, keep the writes flowing
+			io.Copy(ioutil.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		sf.done <- err
+	}()
+	return sf, nil
+}
+
+type scanFile struct {
+	File
+	fsys FS
+	name string
+
+	mu     sync.Mutex
+	pw     *io.PipeWriter
+	offset int64
+	done   chan error
+	closed bool
+}
+
+func (f *scanFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeLocked(p, f.File.Write)
+}
+
+func (f *scanFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off != f.offset {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: ErrNotSequential}
+	}
+	return f.writeLocked(p, func(p []byte) (int, error) {
+		return f.File.WriteAt(p, off)
+	})
+}
+
+func (f *scanFile) writeLocked(p []byte, write func([]byte) (int, error)) (int, error) {
+	if _, err := f.pw.Write(p); err != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	n, err := write(p)
+	f.offset += int64(n)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		// the scanner has seen data that wasn't written
+		f.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+func (f *scanFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.pw.Close()
+	if err := <-f.done; err != nil {
+		if a, ok := f.File.(Aborter); ok {
+			a.Abort()
+		} else {
+			f.File.Close()
+			f.fsys.Remove(f.name)
+		}
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return f.File.Close()
+}
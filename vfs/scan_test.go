@@ -0,0 +1,72 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	infected := errors.New("infected")
+	var scanned []byte
+	fsys := Scan(DirFS(newTestDir(t)), func(name string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		scanned = data
+		if bytes.Contains(data, []byte("EICAR")) {
+			return infected
+		}
+		return nil
+	})
+
+	for _, tc := range []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"clean.txt", "hello world", nil},
+		{"virus.txt", "X5O!P%@AP EICAR", infected},
+	} {
+		f, err := fsys.OpenFile(tc.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, tc.data); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); !errors.Is(err, tc.err) {
+			t.Fatalf("%s: Close err = %v; want %v", tc.name, err, tc.err)
+		}
+		if string(scanned) != tc.data {
+			t.Fatalf("%s: scanned = %#v; want %#v", tc.name, string(scanned), tc.data)
+		}
+		_, err = fsys.Stat(tc.name)
+		if exists := err == nil; exists != (tc.err == nil) {
+			t.Fatalf("%s: exists = %v after scan returned %v", tc.name, exists, tc.err)
+		}
+	}
+}
+
+func TestScanRejectEarly(t *testing.T) {
+	denied := errors.New("denied")
+	fsys := Scan(DirFS(newTestDir(t)), func(name string, r io.Reader) error {
+		return denied
+	})
+	f, err := fsys.OpenFile("upload.bin", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("data")); !errors.Is(err, denied) {
+		t.Fatalf("Write err = %v; want %v", err, denied)
+	}
+	f.Close()
+	if _, err := fsys.Stat("upload.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat err = %v; want %v", err, fs.ErrNotExist)
+	}
+}
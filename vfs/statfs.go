@@ -0,0 +1,117 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ErrUnsupported is returned for optional operations a backend doesn't
+// provide.
+var ErrUnsupported = errors.New("vfs: operation not supported")
+
+// Flags of FSStat, with the values used by the SFTP statvfs extension.
+const (
+	FSReadOnly = 1 << iota // mounted read-only
+	FSNoSUID               // setuid bits are ignored
+)
+
+// FSStat describes the filesystem containing a file, as reported by statvfs.
+type FSStat struct {
+	BlockSize    uint64 // preferred I/O block size
+	FragmentSize uint64 // unit of Blocks, BlocksFree and BlocksAvail
+	Blocks       uint64
+	BlocksFree   uint64
+	BlocksAvail  uint64 // free blocks available to unprivileged users
+	Files        uint64 // inodes
+	FilesFree    uint64
+	FilesAvail   uint64
+	FSID         uint64
+	Flags        uint64 // FSReadOnly, FSNoSUID
+	MaxNameLen   uint64
+}
+
+// FSStater is implemented by backends that can report filesystem usage.
+type FSStater interface {
+	StatFS(name string) (*FSStat, error)
+}
+
+// StatFS returns usage information for the filesystem containing the named
+// file, or ErrUnsupported if fsys doesn't implement FSStater.
+func StatFS(fsys FS, name string) (*FSStat, error) {
+	if s, ok := fsys.(FSStater); ok {
+		return s.StatFS(name)
+	}
+	return nil, &fs.PathError{Op: "statfs", Path: name, Err: ErrUnsupported}
+}
+
+// Syncer is implemented by files that can commit their contents to stable
+// storage, such as *os.File.
+type Syncer interface {
+	Sync() error
+}
+
+// Sync commits the contents of f to stable storage, or returns
+// ErrUnsupported if f doesn't implement Syncer.
+func Sync(f File) error {
+	if s, ok := f.(Syncer); ok {
+		return s.Sync()
+	}
+	return ErrUnsupported
+}
+
+func (dir dirFS) StatFS(name string) (*FSStat, error) {
+	full, err := dir.join("statfs", name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := statFS(full)
+	if err != nil {
+		return nil, pathError("statfs", name, err)
+	}
+	return st, nil
+}
+
+func (r readOnlyFS) StatFS(name string) (*FSStat, error) {
+	st, err := StatFS(r.FS, name)
+	if err != nil {
+		return nil, err
+	}
+	ro := *st
+	ro.Flags |= FSReadOnly
+	return &ro, nil
+}
+
+func (m maskFS) StatFS(name string) (*FSStat, error) {
+	if m.hidden(name) {
+		return nil, &fs.PathError{Op: "statfs", Path: name, Err: fs.ErrNotExist}
+	}
+	return StatFS(m.fsys, name)
+}
+
+func (h hooksFS) StatFS(name string) (*FSStat, error) {
+	return StatFS(h.FS, name)
+}
+
+func (s scanFS) StatFS(name string) (*FSStat, error) {
+	return StatFS(s.FS, name)
+}
+
+func (a atomicFS) StatFS(name string) (*FSStat, error) {
+	return StatFS(a.FS, name)
+}
+
+func (m *MountFS) StatFS(name string) (*FSStat, error) {
+	fsys, rel := m.resolve(name)
+	if fsys == nil {
+		return nil, m.virtualError("statfs", name)
+	}
+	return StatFS(fsys, rel)
+}
+
+func (f *scanFile) Sync() error {
+	return Sync(f.File)
+}
+
+func (f *atomicFile) Sync() error {
+	return Sync(f.File)
+}
@@ -0,0 +1,23 @@
+package vfs
+
+import "syscall"
+
+func statFS(path string) (*FSStat, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &FSStat{
+		BlockSize:    uint64(st.Bsize),
+		FragmentSize: uint64(st.Frsize),
+		Blocks:       st.Blocks,
+		BlocksFree:   st.Bfree,
+		BlocksAvail:  st.Bavail,
+		Files:        st.Files,
+		FilesFree:    st.Ffree,
+		FilesAvail:   st.Ffree,
+		FSID:         uint64(uint32(st.Fsid.X__val[0]))<<32 | uint64(uint32(st.Fsid.X__val[1])),
+		Flags:        uint64(st.Flags) & (FSReadOnly | FSNoSUID),
+		MaxNameLen:   uint64(st.Namelen),
+	}, nil
+}
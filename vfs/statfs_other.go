@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package vfs
+
+func statFS(path string) (*FSStat, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,37 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStatFS(t *testing.T) {
+	st, err := StatFS(ReadOnly(DirFS(newTestDir(t))), "docs")
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip("statfs not supported on this platform")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Blocks == 0 || st.FragmentSize == 0 {
+		t.Fatalf("StatFS = %+v; want block counts", st)
+	}
+	if st.Flags&FSReadOnly == 0 {
+		t.Fatal("read-only flag not set by ReadOnly")
+	}
+	if _, err := StatFS(DirFS(newTestDir(t)), "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("StatFS(missing) err = %v; want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestSync(t *testing.T) {
+	f, err := Atomic(DirFS(newTestDir(t)), nil).OpenFile("new.txt", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := Sync(f); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+// Package vfs defines the filesystem backend used by the file transfer
+// subsystems, along with wrappers for applying business rules to file
+// operations.
+//
+// Names follow the conventions of io/fs: they are slash-separated, unrooted
+// paths such as "uploads/report.csv", with "." naming the root of the
+// user's virtual filesystem.
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS is a writable filesystem backend.
+type FS interface {
+	// OpenFile opens the named file with flags from package os, such as
+	// os.O_RDONLY or os.O_WRONLY|os.O_CREATE|os.O_TRUNC.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+
+	// Rename renames oldname to newname, replacing newname if it exists as
+	// a file, like rename(2) and posix-rename@openssh.com.
+	Rename(oldname, newname string) error
+}
+
+// File is an open file of an FS.
+type File interface {
+	fs.File
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+}
+
+// Aborter is implemented by files whose writes can be discarded. Abort closes
+// the file without creating or modifying the file it was opened for.
+type Aborter interface {
+	Abort() error
+}
+
+// DirFS returns an FS for the tree of files rooted at the directory dir.
+// Like os.DirFS, it does not prevent symbolic links inside dir from pointing
+// outside of it.
+func DirFS(dir string) FS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(name)), nil
+}
+
+func (dir dirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	full, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(full, flag, perm)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return f, nil
+}
+
+func (dir dirFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := dir.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(full)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fi, nil
+}
+
+func (dir dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := dir.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+func (dir dirFS) Mkdir(name string, perm fs.FileMode) error {
+	full, err := dir.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return pathError("mkdir", name, os.Mkdir(full, perm))
+}
+
+func (dir dirFS) Remove(name string) error {
+	full, err := dir.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return pathError("remove", name, os.Remove(full))
+}
+
+func (dir dirFS) Rename(oldname, newname string) error {
+	oldfull, err := dir.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newfull, err := dir.join("rename", newname)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(oldfull, newfull); err != nil {
+		if le, ok := err.(*os.LinkError); ok {
+			err = le.Err
+		}
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	return nil
+}
+
+// pathError replaces the host path in errors returned by package os with the
+// virtual name, so backend locations aren't disclosed to clients.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if pe, ok := err.(*fs.PathError); ok {
+		err = pe.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
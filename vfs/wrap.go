@@ -0,0 +1,169 @@
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// writeFlags are the OpenFile flags that require write access.
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// ReadOnly returns an FS that refuses every operation modifying fsys with
+// fs.ErrPermission.
+func ReadOnly(fsys FS) FS {
+	return readOnlyFS{fsys}
+}
+
+type readOnlyFS struct {
+	FS
+}
+
+func (r readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&writeFlags != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return r.FS.OpenFile(name, flag, perm)
+}
+
+func (r readOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+func (r readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (r readOnlyFS) Rename(oldname, newname string) error {
+	return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrPermission}
+}
+
+// Mask returns an FS hiding the files of fsys matched by any of the
+// path.Match patterns. A pattern matches a file if it matches its full name
+// or the name of any of its path elements, so ".*" hides all dot files and
+// everything inside dot directories. Hidden files don't exist as far as
+// clients are concerned, and can't be created.
+func Mask(fsys FS, patterns ...string) FS {
+	return maskFS{fsys, patterns}
+}
+
+type maskFS struct {
+	fsys     FS
+	patterns []string
+}
+
+func (m maskFS) hidden(name string) bool {
+	if name == "." {
+		return false
+	}
+	for _, pattern := range m.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		for _, elem := range strings.Split(name, "/") {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m maskFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if m.hidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return m.fsys.OpenFile(name, flag, perm)
+}
+
+func (m maskFS) Stat(name string) (fs.FileInfo, error) {
+	if m.hidden(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return m.fsys.Stat(name)
+}
+
+func (m maskFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if m.hidden(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := m.fsys.ReadDir(name)
+	visible := entries[:0]
+	for _, entry := range entries {
+		if !m.hidden(path.Join(name, entry.Name())) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible, err
+}
+
+func (m maskFS) Mkdir(name string, perm fs.FileMode) error {
+	if m.hidden(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+	}
+	return m.fsys.Mkdir(name, perm)
+}
+
+func (m maskFS) Remove(name string) error {
+	if m.hidden(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	return m.fsys.Remove(name)
+}
+
+func (m maskFS) Rename(oldname, newname string) error {
+	if m.hidden(oldname) {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if m.hidden(newname) {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrPermission}
+	}
+	return m.fsys.Rename(oldname, newname)
+}
+
+// Hooks are callbacks run before file operations. Returning a non-nil error
+// aborts the operation with that error.
+type Hooks struct {
+	OnOpen   func(name string, flag int) error
+	OnRename func(oldname, newname string) error
+	OnRemove func(name string) error
+}
+
+// WithHooks returns an FS running the hooks before the corresponding
+// operations on fsys.
+func WithHooks(fsys FS, hooks Hooks) FS {
+	return hooksFS{fsys, hooks}
+}
+
+type hooksFS struct {
+	FS
+	hooks Hooks
+}
+
+func (h hooksFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if h.hooks.OnOpen != nil {
+		if err := h.hooks.OnOpen(name, flag); err != nil {
+			return nil, err
+		}
+	}
+	return h.FS.OpenFile(name, flag, perm)
+}
+
+func (h hooksFS) Rename(oldname, newname string) error {
+	if h.hooks.OnRename != nil {
+		if err := h.hooks.OnRename(oldname, newname); err != nil {
+			return err
+		}
+	}
+	return h.FS.Rename(oldname, newname)
+}
+
+func (h hooksFS) Remove(name string) error {
+	if h.hooks.OnRemove != nil {
+		if err := h.hooks.OnRemove(name); err != nil {
+			return err
+		}
+	}
+	return h.FS.Remove(name)
+}
@@ -0,0 +1,80 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDir(t *testing.T) string {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"public.txt":      "hello",
+		".secret":         "hunter2",
+		"docs/readme.txt": "read me",
+	} {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(full), 0755)
+		if err := ioutil.WriteFile(full, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadOnly(t *testing.T) {
+	fsys := ReadOnly(DirFS(newTestDir(t)))
+	f, err := fsys.OpenFile("public.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := fsys.OpenFile("public.txt", os.O_WRONLY|os.O_TRUNC, 0); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("OpenFile for writing: err = %v; want %v", err, fs.ErrPermission)
+	}
+	if err := fsys.Remove("public.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Remove: err = %v; want %v", err, fs.ErrPermission)
+	}
+}
+
+func TestMask(t *testing.T) {
+	fsys := Mask(DirFS(newTestDir(t)), ".*")
+	if _, err := fsys.Stat(".secret"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat: err = %v; want %v", err, fs.ErrNotExist)
+	}
+	entries, err := fsys.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".secret" {
+			t.Fatal("hidden file listed by ReadDir")
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d; want 2", len(entries))
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	denied := errors.New("denied")
+	var removed string
+	fsys := WithHooks(DirFS(newTestDir(t)), Hooks{
+		OnRemove: func(name string) error {
+			removed = name
+			return denied
+		},
+	})
+	if err := fsys.Remove("public.txt"); err != denied {
+		t.Fatalf("Remove: err = %v; want %v", err, denied)
+	}
+	if removed != "public.txt" {
+		t.Fatalf("OnRemove name = %#v; want %#v", removed, "public.txt")
+	}
+	if _, err := fsys.Stat("public.txt"); err != nil {
+		t.Fatalf("file removed despite hook error: %v", err)
+	}
+}
@@ -10,7 +10,8 @@ type PublicKey interface {
 // The Permissions type holds fine-grained permissions that are specific to a
 // user or a specific authentication method for a user. Permissions, except for
 // "source-address", must be enforced in the server application layer, after
-// successful authentication.
+// successful authentication. Prefer the typed accessors, such as ForceCommand
+// and Namespace, to the raw CriticalOptions and Extensions maps.
 type Permissions struct {
 	*gossh.Permissions
 }